@@ -0,0 +1,211 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+func init() {
+    subcommands["add"] = subcommand{"Add a module that has never been pinned to the makefile and tag its first version (v1.0.0).", add}
+}
+
+// add onboards a module: its first version is tagged on the default branch and an entry pinning it
+// is added to the site file (in a makefile, a projects[] entry with its type, download type, url and tag)
+func add(args []string) error {
+    module, err := getModule()
+    if err != nil {
+        return err
+    }
+
+    if len(args) > 0 && args[0] != module {
+        return &pushError{"Asked to add '" + args[0] + "' but the module repo is '" + module + "'. Use --module to point at the right repo."}
+    }
+
+    makefile, err := getMakefile(module)
+    if err != nil {
+        return err
+    }
+
+    if _, ok := siteUpdaterFor(makefile).(composerUpdater); ok {
+        return withCode(exitOptions, &pushError{"add can't require packages in composer.json. Require the module's package with composer require in the site repo instead."})
+    }
+
+    entries, err := pushit.ParseMakefile(makefile, siteRepoOpt)
+    if err != nil {
+        return withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    for _, e := range entries {
+        if len(e.Keys) > 1 && e.Keys[0] == "projects" && e.Keys[1] == module {
+            return withCode(exitMakefile, &pushError{fmt.Sprintf("%s already has an entry in %s:%d. Release it as usual instead.", module, e.File, e.Line+1)})
+        }
+    }
+
+    if autostashOpt {
+        restore, err := autostash(cwd, siteRepoOpt)
+        defer restore()
+
+        if err != nil {
+            return err
+        }
+    }
+
+    if err = runChecks("environment", environmentChecks()); err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err == nil {
+        err = updateModuleRepo()
+    }
+
+    if err != nil {
+        return err
+    }
+    topicOpt = branchOpt
+
+    // version tags (fetched from the remote with the rest) mean it has been released before, pinned or not
+    out, err := gitTry(gitc{"tag", "--list", tagScheme().Pattern()}, cwd)
+    if err != nil {
+        return &pushError{"Could not list the tags of " + module + ":\n" + strings.TrimSpace(string(out))}
+    }
+
+    if tag, _, _ := tagScheme().Latest(strings.Fields(string(out)), parseVersion); tag != "" {
+        return withCode(exitOptions, &pushError{module + " already has version tags (the latest is " + tag + "), so it has been released before.\nAdd its entry to the makefile pinning " + tag + " by hand, then release it as usual."})
+    }
+
+    first := firstVersion()
+
+    checks := append(releaseChecks(makefile, module, "", first), compatibilityChecks(makefile, map[string]string{module: first})...)
+
+    if err = runChecks("release", checks); err != nil {
+        return err
+    }
+
+    summary.Module, summary.NewVersion, summary.Topic = module, first, "add"
+    defer recordHistory()
+
+    outFile, err := getUpdatedMakefile(makefile, module, first, "")
+    if err != nil {
+        return err
+    }
+
+    previewMakefile(makefile, outFile)
+    fmt.Printf("%s will be tagged with its first version %s and added to the makefile.\n", module, tagName(first))
+
+    if prompt("Are you sure you want to add this module? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return errAborted
+    }
+
+    state = pushState{Time: time.Now(), Module: module, ModuleDir: cwd, Remote: remoteFor(cwd), Tag: tagName(first), SiteRepo: siteRepoOpt, SiteBranch: siteBranch}
+
+    rel := &release{
+        module:     module,
+        version:    first,
+        tag:        tagName(first),
+        annotation: "First release: " + module + " was added to the makefile.",
+        commitMsg:  "\nADDED: " + module + " -> " + first + " (first release)",
+        updateMakefile: func(makefile string) ([]string, error) {
+            return getUpdatedMakefile(makefile, module, first, "")
+        },
+    }
+
+    if err = rel.run(makefile); err != nil {
+        return err
+    }
+
+    summary.Outcome = "added"
+
+    fmt.Printf("\n%s has been added to the makefile (first version %s).\n", module, tagName(first))
+    summary.print()
+
+    return nil
+}
+
+// firstMakefileEntry returns the makefile with an entry added for a module's first release, as long
+// as it has none yet
+func firstMakefileEntry(makefile, module, first string) ([]string, error) {
+    entries, err := pushit.ParseMakefile(makefile, siteRepoOpt)
+    if err != nil {
+        return nil, withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    for _, e := range entries {
+        if len(e.Keys) > 1 && e.Keys[0] == "projects" && e.Keys[1] == module {
+            return nil, withCode(exitMakefile, &pushError{fmt.Sprintf("%s has no version tags yet but already has an entry in %s:%d.\nPin %s there by hand, or remove the entry to have it added.",
+                module, e.File, e.Line+1, tagName(first))})
+        }
+    }
+
+    url, err := remoteURL(cwd)
+    if err != nil {
+        return nil, err
+    }
+
+    return addMakefileEntry(makefile, module, url, first)
+}
+
+// addMakefileEntry returns the makefile with an entry for the module appended: in an INI makefile a
+// block of projects[] lines at the end, in a YAML makefile a key at the end of projects:
+func addMakefileEntry(makefile, module, url, first string) ([]string, error) {
+    lines, err := readLines(makefile)
+    if err != nil {
+        return nil, withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    if !pushit.IsYAMLMakefile(makefile) {
+        // keep one blank line between the blocks
+        for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+            lines = lines[:len(lines)-1]
+        }
+
+        prefix := "projects[" + module + "]"
+
+        return append(lines, "",
+            prefix+`[type] = "module"`,
+            prefix+`[download][type] = "git"`,
+            prefix+`[download][url] = "`+url+`"`,
+            prefix+`[download][tag] = "`+tagName(first)+`"`), nil
+    }
+
+    // follow the indentation of the entries already there, and add after the last of them
+    last, indent, step := -1, "  ", "  "
+
+    for _, e := range pushit.ParseMakeLines(makefile, lines) {
+        if e.Keys[0] != "projects" {
+            continue
+        }
+
+        line := lines[e.Line]
+        lead := line[:len(line)-len(strings.TrimLeft(line, " "))]
+
+        switch len(e.Keys) {
+        case 2:
+            indent = lead
+        case 3:
+            if len(lead) > len(indent) {
+                step = lead[len(indent):]
+            }
+        }
+        last = e.Line
+    }
+
+    block := []string{
+        indent + module + ":",
+        indent + step + "type: module",
+        indent + step + "download:",
+        indent + step + step + "type: git",
+        indent + step + step + "url: \"" + url + "\"",
+        indent + step + step + "tag: \"" + tagName(first) + "\"",
+    }
+
+    if last == -1 {
+        return append(append(lines, "projects:"), block...), nil
+    }
+
+    return append(lines[:last+1], append(block, lines[last+1:]...)...), nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// unpinned takes the module out of the fixture's makefile and checks out its default branch, as add
+// expects of a new module
+func (f *fixture) unpinned() {
+    f.t.Helper()
+
+    f.write(filepath.Join(f.site, "barcelona.make"), "core = 7.x\napi = 2\n")
+    f.git(f.site, "commit", "-qam", "Unpin ncaa_scores")
+    f.git(f.site, "push", "-q", "origin", "main")
+
+    f.git(f.module, "checkout", "-q", "-B", "main", "origin/main")
+}
+
+func TestAddTagsTheFirstVersion(t *testing.T) {
+    f := newFixture(t)
+    f.unpinned()
+
+    f.git(f.module, "tag", "-d", "v1.2.3")
+    f.git(f.module, "push", "-q", "origin", ":refs/tags/v1.2.3")
+
+    f.mustRun("add", "ncaa_scores")
+
+    if pin := f.pinned(); pin != "v1.0.0" {
+        t.Errorf("makefile pins %s, want v1.0.0", pin)
+    }
+}
+
+func TestAddRefusesATaggedModule(t *testing.T) {
+    f := newFixture(t)
+    f.unpinned()
+
+    out, code := f.run("add", "ncaa_scores")
+    if code != exitOptions || !strings.Contains(out, "already has version tags (the latest is v1.2.3)") {
+        t.Fatalf("add of a tagged module exited %d:\n%s", code, out)
+    }
+
+    if tags := f.remoteTags(); len(tags) != 1 {
+        t.Errorf("add tagged the module anyway: %v", tags)
+    }
+
+    if pin := f.pinned(); pin != "" {
+        t.Errorf("add pinned the module to %s", pin)
+    }
+}
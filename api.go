@@ -0,0 +1,69 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "net/http"
+    "strings"
+    "time"
+)
+
+var apiClient = &http.Client{Timeout: 15 * time.Second}
+
+// callAPI sends in (if any) as JSON to a web API and decodes the JSON response into out (if any).
+// auth adds credentials to the request and may be nil.
+func callAPI(method, url string, auth func(*http.Request), in, out interface{}) error {
+    var body []byte
+
+    if in != nil {
+        body, _ = json.Marshal(in)
+    }
+
+    req, err := http.NewRequest(method, url, bytes.NewReader(body))
+    if err != nil {
+        return &pushError{"Invalid URL " + url}
+    }
+
+    req.Header.Set("Accept", "application/json")
+    if in != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    if auth != nil {
+        auth(req)
+    }
+
+    slog.Debug(method+" "+url)
+
+    resp, err := apiClient.Do(req)
+    if err != nil {
+        return &pushError{"Could not reach " + url + ": " + err.Error()}
+    }
+    defer resp.Body.Close()
+
+    contents, _ := ioutil.ReadAll(resp.Body)
+
+    if resp.StatusCode >= 300 {
+        return &pushError{fmt.Sprintf("%s %s responded %s: %s", method, url, resp.Status, strings.TrimSpace(string(contents)))}
+    }
+
+    if out != nil && len(bytes.TrimSpace(contents)) > 0 {
+        if err = json.Unmarshal(contents, out); err != nil {
+            return &pushError{"Could not read the response from " + url + ": " + err.Error()}
+        }
+    }
+
+    return nil
+}
+
+// bearerAuth authenticates requests with a bearer token (when there is one)
+func bearerAuth(token string) func(*http.Request) {
+    return func(req *http.Request) {
+        if token != "" {
+            req.Header.Set("Authorization", "Bearer "+token)
+        }
+    }
+}
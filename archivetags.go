@@ -0,0 +1,204 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+func init() {
+    subcommands["archive-tags"] = subcommand{"List the module's old version tags the retention policy no longer keeps, bundle them into the archive directory and, with --delete, delete them.", archiveTags}
+}
+
+// retentionConfig is how many of a module's version tags are kept on its remote, and where the rest
+// are archived to
+type retentionConfig struct {
+    Keep    int    `json:"keep"`    // newest versions kept, 50 when empty; pinned versions are always kept
+    Archive string `json:"archive"` // directory the bundles are written to, eg. a cold storage mount
+}
+
+// tagsDeletedAtOnce is how many tags one push deletes from the remote
+const tagsDeletedAtOnce = 100
+
+// pinnedEverywhere lists every version of the module the site makefile pins or any snapshot on the
+// site repo's origin recorded
+func pinnedEverywhere(makefile, module string) (map[string]bool, error) {
+    pinned := map[string]bool{}
+
+    current, err := pinSet(makefile)
+    if err != nil {
+        return nil, err
+    }
+
+    if _, err = git(gitc{"fetch", remoteFor(siteRepoOpt), "+refs/snapshots/*:refs/snapshots/*"}, siteRepoOpt); err != nil {
+        return nil, err
+    }
+
+    out, err := gitCheck(gitc{"for-each-ref", "--format=%(refname)", "refs/snapshots/"}, siteRepoOpt)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, ref := range strings.Fields(out) {
+        blob, err := git(gitc{"cat-file", "blob", ref}, siteRepoOpt)
+        if err != nil {
+            return nil, err
+        }
+
+        pins, err := parseVersions("snapshot "+strings.TrimPrefix(ref, "refs/snapshots/"), strings.Split(strings.TrimSpace(string(blob)), "\n"))
+        if err != nil {
+            return nil, err
+        }
+
+        current = append(current, pins...)
+    }
+
+    for _, p := range current {
+        if p.module == module {
+            pinned[tagName(strings.TrimPrefix(p.version, "v"))] = true
+        }
+    }
+
+    return pinned, nil
+}
+
+// archiveTags finds the module's version tags older than the newest the policy keeps (and not pinned
+// anywhere), bundles them into the archive directory and, with --delete, deletes them from the
+// module's remote and the local repo
+func archiveTags(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit archive-tags [--delete] [options]"})
+    }
+
+    module, err := getModule()
+    if err != nil {
+        return err
+    }
+
+    cfg := config.TagRetention
+    if cfg.Keep == 0 {
+        cfg.Keep = 50
+    }
+
+    if deleteOpt && cfg.Archive == "" {
+        return withCode(exitOptions, &pushError{"--delete only deletes tags that were archived first; set tagRetention.archive in the config file."})
+    }
+
+    makefile, err := getMakefile()
+    if err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err == nil {
+        err = updateModuleRepo()
+    }
+
+    if err != nil {
+        return err
+    }
+
+    pinned, err := pinnedEverywhere(makefile, module)
+    if err != nil {
+        return err
+    }
+
+    // ** every version tag counts, not just those on the default branch, so hotfix tags age out too
+    out, err := gitCheck(gitc{"tag", "--list", tagPrefix() + "*"}, cwd)
+    if err != nil {
+        return err
+    }
+
+    var tags []string
+    versions := map[string]version{}
+
+    for _, tag := range strings.Fields(out) {
+        if v, err := parseVersion(strings.TrimPrefix(tag, tagPrefix())); err == nil {
+            tags = append(tags, tag)
+            versions[tag] = v
+        }
+    }
+
+    sort.SliceStable(tags, func(i, j int) bool { return versions[tags[i]].Compare(versions[tags[j]]) > 0 })
+
+    var eligible []string
+    for i, tag := range tags {
+        if i >= cfg.Keep && !pinned[tag] {
+            eligible = append(eligible, tag)
+        }
+    }
+
+    if len(eligible) == 0 {
+        fmt.Printf("\n%s has %d version tag(s); the newest %d and every pinned version are kept, so there is nothing to archive.\n", module, len(tags), cfg.Keep)
+        return nil
+    }
+
+    fmt.Printf("\n%d of %s's %d version tags are older than the newest %d and not pinned anywhere: %s ... %s\n",
+        len(eligible), module, len(tags), cfg.Keep, eligible[len(eligible)-1], eligible[0])
+
+    if cfg.Archive == "" {
+        fmt.Println("Set tagRetention.archive in the config file to bundle them.")
+        return nil
+    }
+
+    // ** bundle them (with everything they point to) so they can be fetched back from cold storage
+    if err = os.MkdirAll(cfg.Archive, 0755); err != nil {
+        return &pushError{"Could not create the archive directory @ " + cfg.Archive + ": " + err.Error()}
+    }
+
+    bundle := filepath.Join(cfg.Archive, fmt.Sprintf("%s-tags-%s.bundle", module, time.Now().In(displayZone()).Format("2006-01-02-1504")))
+
+    refs := gitc{"bundle", "create", bundle}
+    for _, tag := range eligible {
+        refs = append(refs, "refs/tags/"+tag)
+    }
+
+    if _, err = git(refs, cwd); err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"bundle", "verify", bundle}, cwd); err != nil {
+        return err
+    }
+
+    fmt.Printf("Archived them in %s; fetch them back with: git fetch %s 'refs/tags/*:refs/tags/*'\n", bundle, bundle)
+
+    if !deleteOpt {
+        return nil
+    }
+
+    remote := remoteFor(cwd)
+
+    if prompt(fmt.Sprintf("\nDelete the %d archived tags from %s and the local repo? (y/n): ", len(eligible), remote)) != "y" {
+        fmt.Println("Aborting...")
+        return errAborted
+    }
+
+    for start := 0; start < len(eligible); start += tagsDeletedAtOnce {
+        end := start + tagsDeletedAtOnce
+        if end > len(eligible) {
+            end = len(eligible)
+        }
+
+        push := gitc{"push", remote, "--delete"}
+        local := gitc{"tag", "-d"}
+        for _, tag := range eligible[start:end] {
+            push = append(push, "refs/tags/"+tag)
+            local = append(local, tag)
+        }
+
+        if _, err = git(push, cwd); err != nil {
+            return withCode(exitRejected, err)
+        }
+
+        if _, err = git(local, cwd); err != nil {
+            return err
+        }
+    }
+
+    fmt.Printf("Deleted %d tags from %s and the local repo.\n", len(eligible), remote)
+
+    return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+    "crypto"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/base64"
+    "encoding/json"
+    "math/big"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// authConfig selects how the server tells who is calling its queue endpoints
+type authConfig struct {
+    Provider string   `json:"provider"` // token (the default: serve.token, shared by everyone) or oidc
+    Issuer   string   `json:"issuer"`   // oidc: the provider's issuer URL, where its discovery document is
+    ClientID string   `json:"clientId"` // oidc: the audience ID tokens must be issued for
+    Claim    string   `json:"claim"`    // oidc: the claim naming the employee, email when empty
+    Allowed  []string `json:"allowed"`  // who may approve and reject: identities, or @domain for a whole domain; required with oidc
+}
+
+// authProvider works out who made a request to the server
+type authProvider interface {
+    Identify(r *http.Request) (string, error)
+}
+
+// newAuthProvider returns the provider selected in the config file
+func newAuthProvider() (authProvider, error) {
+    cfg := config.Serve.Auth
+
+    switch cfg.Provider {
+    case "", "token":
+        return &tokenAuth{config.Serve.Token}, nil
+    case "oidc":
+        if cfg.Issuer == "" || cfg.ClientID == "" {
+            return nil, &pushError{"The oidc auth provider requires an \"issuer\" and a \"clientId\" under serve.auth in the config file"}
+        }
+        if len(cfg.Allowed) == 0 {
+            return nil, &pushError{"The oidc auth provider requires the identities allowed to approve releases (or @domain) under serve.auth.allowed in the config file"}
+        }
+        return &oidcAuth{cfg: cfg}, nil
+    }
+
+    return nil, &pushError{"Unknown auth provider '" + cfg.Provider + "' under serve.auth in the config file (expected token or oidc)"}
+}
+
+// bearerToken returns the token a request carries, "" when there is none
+func bearerToken(r *http.Request) string {
+    header := r.Header.Get("Authorization")
+    if !strings.HasPrefix(header, "Bearer ") {
+        return ""
+    }
+
+    return strings.TrimPrefix(header, "Bearer ")
+}
+
+// tokenAuth lets in whoever has the shared token (no one, when there isn't one). It can't tell
+// callers apart.
+type tokenAuth struct {
+    token string
+}
+
+func (a *tokenAuth) Identify(r *http.Request) (string, error) {
+    if a.token == "" {
+        return "", &pushError{"the server has no token set, so its queue can't be used"}
+    }
+
+    if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(a.token)) != 1 {
+        return "", &pushError{"the request does not carry the server's token"}
+    }
+
+    return "token holder", nil
+}
+
+// oidcAuth takes ID tokens (RS256 JWTs) issued by the corporate OIDC provider for the server, checked
+// against the provider's published keys
+type oidcAuth struct {
+    cfg authConfig
+
+    sync.Mutex
+    keys    map[string]*rsa.PublicKey // key ID -> key
+    fetched time.Time                 // when the keys were fetched
+    tried   time.Time                 // when they were last fetched, or tried to be
+}
+
+// publicKeys fetches the provider's signing keys through its discovery document
+func (a *oidcAuth) publicKeys() (map[string]*rsa.PublicKey, error) {
+    var discovery struct {
+        JWKSURI string `json:"jwks_uri"`
+    }
+
+    if err := callAPI("GET", strings.TrimSuffix(a.cfg.Issuer, "/")+"/.well-known/openid-configuration", nil, nil, &discovery); err != nil {
+        return nil, err
+    }
+
+    var jwks struct {
+        Keys []struct {
+            Kid string `json:"kid"`
+            Kty string `json:"kty"`
+            N   string `json:"n"`
+            E   string `json:"e"`
+        } `json:"keys"`
+    }
+
+    if err := callAPI("GET", discovery.JWKSURI, nil, nil, &jwks); err != nil {
+        return nil, err
+    }
+
+    keys := map[string]*rsa.PublicKey{}
+
+    for _, key := range jwks.Keys {
+        n, nErr := base64.RawURLEncoding.DecodeString(key.N)
+        e, eErr := base64.RawURLEncoding.DecodeString(key.E)
+        if key.Kty != "RSA" || nErr != nil || eErr != nil {
+            continue
+        }
+
+        keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+    }
+
+    return keys, nil
+}
+
+// key returns the signing key with the ID, fetching the keys again when it is new (the provider
+// rotates them) or they are an hour old. They are fetched at most once a minute, however many tokens
+// come in signed with keys the server hasn't seen, and without holding up the requests that have a key.
+func (a *oidcAuth) key(kid string) (*rsa.PublicKey, error) {
+    unknown := &pushError{"the token is signed with a key the provider doesn't publish"}
+
+    a.Lock()
+    key, known := a.keys[kid]
+
+    if (known && time.Since(a.fetched) < time.Hour) || time.Since(a.tried) < time.Minute {
+        a.Unlock()
+
+        if known {
+            return key, nil
+        }
+        return nil, unknown
+    }
+
+    a.tried = time.Now()
+    a.Unlock()
+
+    keys, err := a.publicKeys()
+    if err != nil {
+        return nil, err
+    }
+
+    a.Lock()
+    a.keys, a.fetched = keys, time.Now()
+    a.Unlock()
+
+    if key, ok := keys[kid]; ok {
+        return key, nil
+    }
+
+    return nil, unknown
+}
+
+func (a *oidcAuth) Identify(r *http.Request) (string, error) {
+    parts := strings.Split(bearerToken(r), ".")
+    if len(parts) != 3 {
+        return "", &pushError{"the request does not carry an ID token"}
+    }
+
+    var header struct {
+        Alg string `json:"alg"`
+        Kid string `json:"kid"`
+    }
+
+    decoded, err := base64.RawURLEncoding.DecodeString(parts[0])
+    if err != nil || json.Unmarshal(decoded, &header) != nil {
+        return "", &pushError{"the ID token's header can't be read"}
+    }
+
+    if header.Alg != "RS256" {
+        return "", &pushError{"the ID token is signed with " + header.Alg + ", not RS256"}
+    }
+
+    key, err := a.key(header.Kid)
+    if err != nil {
+        return "", err
+    }
+
+    signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return "", &pushError{"the ID token's signature can't be read"}
+    }
+
+    digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+    if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+        return "", &pushError{"the ID token's signature is not valid"}
+    }
+
+    var claims map[string]interface{}
+
+    decoded, err = base64.RawURLEncoding.DecodeString(parts[1])
+    if err != nil || json.Unmarshal(decoded, &claims) != nil {
+        return "", &pushError{"the ID token's claims can't be read"}
+    }
+
+    if claims["iss"] != a.cfg.Issuer {
+        return "", &pushError{"the ID token was not issued by " + a.cfg.Issuer}
+    }
+
+    audience := false
+    switch aud := claims["aud"].(type) {
+    case string:
+        audience = aud == a.cfg.ClientID
+    case []interface{}:
+        for _, each := range aud {
+            audience = audience || each == a.cfg.ClientID
+        }
+    }
+
+    if !audience {
+        return "", &pushError{"the ID token was not issued for " + a.cfg.ClientID}
+    }
+
+    if exp, ok := claims["exp"].(float64); !ok || time.Now().After(time.Unix(int64(exp), 0)) {
+        return "", &pushError{"the ID token has expired"}
+    }
+
+    if nbf, ok := claims["nbf"].(float64); ok && time.Now().Before(time.Unix(int64(nbf), 0)) {
+        return "", &pushError{"the ID token is not valid yet"}
+    }
+
+    claim := a.cfg.Claim
+    if claim == "" {
+        claim = "email"
+    }
+
+    identity, _ := claims[claim].(string)
+    if identity == "" {
+        return "", &pushError{"the ID token has no " + claim + " claim"}
+    }
+
+    // anyone can put an address they don't own on their account; only one the provider has checked
+    // says who they are (some providers send the flag as a string)
+    if claim == "email" && claims["email_verified"] != true && claims["email_verified"] != "true" {
+        return "", &pushError{"the ID token's email address has not been verified"}
+    }
+
+    return identity, nil
+}
+
+// allowedToDecide says whether the identity may approve and reject releases. With the shared token
+// holding it is what allows it, unless the allowlist says otherwise.
+func allowedToDecide(identity string) bool {
+    allowed := config.Serve.Auth.Allowed
+    if len(allowed) == 0 {
+        return identity == "token holder"
+    }
+
+    for _, who := range allowed {
+        if strings.EqualFold(who, identity) || (strings.HasPrefix(who, "@") && strings.HasSuffix(strings.ToLower(identity), strings.ToLower(who))) {
+            return true
+        }
+    }
+
+    return false
+}
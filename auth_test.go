@@ -0,0 +1,185 @@
+package main
+
+import (
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "math/big"
+    "net/http"
+    "net/http/httptest"
+    "os/user"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+// oidcProvider is a stand-in for the corporate OIDC provider, publishing one signing key
+type oidcProvider struct {
+    *httptest.Server
+    key     *rsa.PrivateKey
+    kid     string
+    keyHits int32 // how many times its keys were fetched
+}
+
+func newOIDCProvider(t *testing.T) *oidcProvider {
+    key, err := rsa.GenerateKey(rand.Reader, 2048)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    p := &oidcProvider{key: key, kid: "key-1"}
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+        json.NewEncoder(w).Encode(map[string]string{"issuer": p.URL, "jwks_uri": p.URL + "/keys"})
+    })
+    mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&p.keyHits, 1)
+
+        json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+            "kid": p.kid,
+            "kty": "RSA",
+            "n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+            "e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+        }}})
+    })
+
+    p.Server = httptest.NewServer(mux)
+    t.Cleanup(p.Close)
+
+    return p
+}
+
+// token issues an ID token for the server, signed with the key ID given and with the claims added to
+// (or, when nil, dropped from) the valid ones
+func (p *oidcProvider) token(t *testing.T, kid string, claims map[string]interface{}) string {
+    all := map[string]interface{}{
+        "iss":            p.URL,
+        "aud":            "ncaapushit",
+        "exp":            time.Now().Add(time.Hour).Unix(),
+        "nbf":            time.Now().Add(-time.Minute).Unix(),
+        "email":          "jsmith@turner.com",
+        "email_verified": true,
+    }
+    for name, value := range claims {
+        if value == nil {
+            delete(all, name)
+            continue
+        }
+        all[name] = value
+    }
+
+    header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+    body, _ := json.Marshal(all)
+
+    signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+    digest := sha256.Sum256([]byte(signed))
+
+    signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// queueAs lists the server's queue with the token, returning the status of the answer
+func (f *fixture) queueAs(url, token string) (int, string) {
+    f.t.Helper()
+    return f.request(http.MethodGet, url+"/queue", nil, map[string]string{"Authorization": "Bearer " + token})
+}
+
+func TestOIDCTokens(t *testing.T) {
+    f := newFixture(t)
+    p := newOIDCProvider(t)
+
+    url := f.serve(fmt.Sprintf(`{"serve": {"repos": ["NCAA/ncaa_scores"], "secret": "s3cret", "auth": {"provider": "oidc", "issuer": %q, "clientId": "ncaapushit", "allowed": ["@turner.com"]}}}`, p.URL))
+
+    if code, answer := f.queueAs(url, p.token(t, p.kid, nil)); code != http.StatusOK {
+        t.Fatalf("a valid ID token = %d: %s", code, answer)
+    }
+
+    for name, claims := range map[string]map[string]interface{}{
+        "expired":             {"exp": time.Now().Add(-time.Minute).Unix()},
+        "not valid yet":       {"nbf": time.Now().Add(time.Hour).Unix()},
+        "for another client":  {"aud": "elsewhere"},
+        "unverified email":    {"email_verified": false},
+        "no email_verified":   {"email_verified": nil},
+        "from another issuer": {"iss": "https://sso.example.com"},
+    } {
+        if code, answer := f.queueAs(url, p.token(t, p.kid, claims)); code != http.StatusUnauthorized {
+            t.Errorf("an ID token %s = %d (%s), want it refused", name, code, answer)
+        }
+    }
+
+    if hits := atomic.LoadInt32(&p.keyHits); hits != 1 {
+        t.Errorf("the keys were fetched %d times for tokens signed with a known key, want once", hits)
+    }
+}
+
+func TestOIDCUnknownKeys(t *testing.T) {
+    f := newFixture(t)
+    p := newOIDCProvider(t)
+
+    url := f.serve(fmt.Sprintf(`{"serve": {"repos": ["NCAA/ncaa_scores"], "secret": "s3cret", "auth": {"provider": "oidc", "issuer": %q, "clientId": "ncaapushit", "allowed": ["@turner.com"]}}}`, p.URL))
+
+    // a flood of tokens signed with keys the provider never published
+    for i := 0; i < 5; i++ {
+        if code, answer := f.queueAs(url, p.token(t, fmt.Sprintf("forged-%d", i), nil)); code != http.StatusUnauthorized {
+            t.Errorf("an ID token signed with an unknown key = %d (%s), want it refused", code, answer)
+        }
+    }
+
+    if hits := atomic.LoadInt32(&p.keyHits); hits != 1 {
+        t.Errorf("the keys were fetched %d times in a minute, want once", hits)
+    }
+
+    // the key it does publish is still taken, without fetching them again
+    if code, answer := f.queueAs(url, p.token(t, p.kid, nil)); code != http.StatusOK {
+        t.Errorf("a valid ID token after the flood = %d: %s", code, answer)
+    }
+
+    if hits := atomic.LoadInt32(&p.keyHits); hits != 1 {
+        t.Errorf("the keys were fetched %d times, want once", hits)
+    }
+}
+
+func TestApproverIsRecorded(t *testing.T) {
+    f := newFixture(t)
+    p := newOIDCProvider(t)
+
+    url := f.serve(fmt.Sprintf(`{"serve": {"repos": ["NCAA/ncaa_scores"], "secret": "s3cret", "auth": {"provider": "oidc", "issuer": %q, "clientId": "ncaapushit", "allowed": ["@turner.com"]}}}`, p.URL))
+
+    body := mergeEvent("NCAA-1")
+    if code, answer := f.request(http.MethodPost, url+"/webhook", body, map[string]string{"X-Event-Key": "pr:merged", "X-Hub-Signature": signature("s3cret", body)}); code != http.StatusAccepted {
+        t.Fatalf("webhook = %d: %s", code, answer)
+    }
+
+    token := p.token(t, p.kid, nil)
+    if code, answer := f.request(http.MethodPost, url+"/queue/1/approve", nil, map[string]string{"Authorization": "Bearer " + token}); code != http.StatusOK {
+        t.Fatalf("approve = %d: %s", code, answer)
+    }
+
+    if item := f.settled(url, token, 1); item.Status != "released" || item.By != "jsmith@turner.com" {
+        t.Fatalf("release #1 = %s by %s:\n%s", item.Status, item.By, item.Output)
+    }
+
+    var record historyRecord
+    if err := json.Unmarshal([]byte(strings.TrimSpace(f.mustRun("history", "--json"))), &record); err != nil {
+        t.Fatal(err)
+    }
+
+    account, err := user.Current()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if record.User != "jsmith@turner.com" || record.RunAs != account.Username {
+        t.Errorf("the release was recorded as made by %q as %q, want jsmith@turner.com as %q", record.User, record.RunAs, account.Username)
+    }
+}
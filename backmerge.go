@@ -0,0 +1,81 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "strings"
+)
+
+// backMerge is where a profile's site branch is merged back into after a push (eg. a prod release
+// branch back into develop), so the environments' makefiles don't drift apart
+type backMerge struct {
+    Branch      string `json:"branch"`
+    PullRequest bool   `json:"pullRequest"` // open a pull request for it instead of merging and pushing
+}
+
+// backMergeTo is the back-merge of the selected profile, if it has one
+var backMergeTo backMerge
+
+// mergeBack merges the site branch, now holding the release, back into the profile's back-merge
+// branch. The release is out by the time this runs, so a back-merge that can't be done becomes a
+// follow-up rather than a failure.
+func mergeBack(released string) {
+    target := backMergeTo.Branch
+
+    if target == "" || target == siteBranch {
+        return
+    }
+
+    title := "Back-merge " + siteBranch + " into " + target + " after " + released
+
+    if backMergeTo.PullRequest {
+        url, err := openPullRequest(siteBranch, target, title, "Keeps "+target+" in step with "+siteBranch+".")
+        if err != nil {
+            slog.Warn("could not open the back-merge pull request: " + strings.TrimSpace(err.Error()))
+            summary.followUp("Merge " + siteBranch + " back into " + target + " in " + siteRepoOpt + " by hand.")
+            return
+        }
+
+        fmt.Println("Site repo: opened the back-merge into " + target + ": " + url)
+        summary.Notified = append(summary.Notified, "bitbucket (back-merge pull request)")
+        return
+    }
+
+    if err := mergeBackLocally(target, title); err != nil {
+        slog.Warn("could not back-merge " + siteBranch + " into " + target + ": " + strings.TrimSpace(err.Error()))
+        summary.followUp("Merge " + siteBranch + " back into " + target + " in " + siteRepoOpt + " by hand.")
+        return
+    }
+
+    fmt.Printf("Site repo: merged %s back into %s and pushed it.\n", siteBranch, target)
+}
+
+// mergeBackLocally merges the site branch into target and pushes it, leaving the site repo back on
+// the site branch either way
+func mergeBackLocally(target, message string) (err error) {
+    defer func() {
+        if _, checkoutErr := git(gitc{"checkout", siteBranch}, siteRepoOpt); err == nil {
+            err = checkoutErr
+        }
+    }()
+
+    if err = updateRepo(siteRepoOpt, target); err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"checkout", target}, siteRepoOpt); err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"merge", "--no-ff", "-m", message, siteBranch}, siteRepoOpt); err != nil {
+        gitTry(gitc{"merge", "--abort"}, siteRepoOpt)
+        return err
+    }
+
+    if out, pushErr := gitTry(gitc{"push", remoteFor(siteRepoOpt), target}, siteRepoOpt); pushErr != nil {
+        gitTry(gitc{"reset", "--keep", "HEAD~1"}, siteRepoOpt)
+        return &pushError{"Could not push " + target + ":\n" + strings.TrimSpace(string(out))}
+    }
+
+    return nil
+}
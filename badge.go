@@ -0,0 +1,127 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+)
+
+// badgeConfig controls the optional per-module version badges our dashboards embed
+type badgeConfig struct {
+    Dir    string `json:"dir"`    // written here (relative to the site repo unless absolute)
+    Format string `json:"format"` // json, svg or both (the default)
+    S3     string `json:"s3"`     // eg. s3://ncaa-dashboards/badges, uploaded with the aws cli
+}
+
+const badgeSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="%[3]d" height="20" role="img" aria-label="%[1]s: %[2]s">
+  <rect width="%[4]d" height="20" fill="#555"/>
+  <rect x="%[4]d" width="%[5]d" height="20" fill="#007ec6"/>
+  <g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,sans-serif" font-size="11">
+    <text x="%[6]d" y="14">%[1]s</text>
+    <text x="%[7]d" y="14">%[2]s</text>
+  </g>
+</svg>
+`
+
+// badgeContents renders the badge files for a module keyed by filename
+func badgeContents(module, version string) map[string][]byte {
+    files := map[string][]byte{}
+    format := config.Badges.Format
+    message := versionPrefix(module) + version
+
+    if format == "" || format == "both" || format == "json" {
+        // shields.io endpoint format, so the dashboards can also render it through shields
+        contents, _ := json.MarshalIndent(map[string]interface{}{
+            "schemaVersion": 1,
+            "label":         module,
+            "message":       message,
+            "color":         "blue",
+        }, "", "  ")
+        files[module+".json"] = append(contents, '\n')
+    }
+
+    if format == "" || format == "both" || format == "svg" {
+        // roughly 7px per character at 11px Verdana plus padding
+        labelWidth := 7*len(module) + 10
+        messageWidth := 7*len(message) + 10
+        files[module+".svg"] = []byte(fmt.Sprintf(badgeSVG, module, message,
+            labelWidth+messageWidth, labelWidth, messageWidth, labelWidth/2, labelWidth+messageWidth/2))
+    }
+
+    return files
+}
+
+// writeBadges writes the module's badge files to the configured directory. Any files that
+// land inside the site repo are returned (relative to it) so they go out with the makefile commit.
+func writeBadges(module, version string) ([]string, error) {
+    var siteFiles []string
+
+    if config.Badges.Dir == "" {
+        return siteFiles, nil
+    }
+
+    dir := config.Badges.Dir
+    if !filepath.IsAbs(dir) {
+        dir = filepath.Join(siteRepoOpt, dir)
+    }
+
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return siteFiles, &pushError{"Could not create badge directory @ " + dir}
+    }
+
+    for name, contents := range badgeContents(module, version) {
+        path := filepath.Join(dir, name)
+
+        if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+            return siteFiles, &pushError{"Could not write badge @ " + path}
+        }
+
+        if rel, err := filepath.Rel(siteRepoOpt, path); err == nil && !strings.HasPrefix(rel, "..") {
+            siteFiles = append(siteFiles, rel)
+        }
+    }
+
+    return siteFiles, nil
+}
+
+// uploadBadges copies the module's badge files to S3 when a bucket is configured
+func uploadBadges(module, version string) error {
+    if config.Badges.S3 == "" {
+        return nil
+    }
+
+    tmp, err := ioutil.TempDir("", "ncaapushit-badges")
+    if err != nil {
+        return &pushError{"Could not create a temporary directory for badges"}
+    }
+    defer os.RemoveAll(tmp)
+
+    for name, contents := range badgeContents(module, version) {
+        path := filepath.Join(tmp, name)
+        contentType := "application/json"
+
+        if strings.HasSuffix(name, ".svg") {
+            contentType = "image/svg+xml"
+        }
+
+        if err = ioutil.WriteFile(path, contents, 0644); err != nil {
+            return &pushError{"Could not write badge @ " + path}
+        }
+
+        dest := strings.TrimSuffix(config.Badges.S3, "/") + "/" + name
+        slog.Debug("aws s3 cp "+path+" "+dest)
+
+        out, err := exec.Command("aws", "s3", "cp", path, dest, "--content-type", contentType, "--cache-control", "no-cache").CombinedOutput()
+
+        if err != nil {
+            return &pushError{"Could not upload badge to " + dest + ":\n" + string(out)}
+        }
+    }
+
+    return nil
+}
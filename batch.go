@@ -0,0 +1,466 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "strings"
+    "time"
+)
+
+// stringList is an option that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+    return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+    *l = append(*l, value)
+    return nil
+}
+
+// batchEntry is one module of a batch release (--module given more than once, or --manifest)
+type batchEntry struct {
+    path   string // module repo, as given
+    bump   string // semver column, --bump unless the manifest says otherwise
+    topic  string // topic branch, the checked out branch unless the manifest says otherwise
+    module string
+    dir    string
+    branch string
+    mono   string // monorepoModule for this entry
+
+    changes impact
+    notes   map[string]string // release notes by audience
+    rel     *release
+}
+
+// batchMode says whether several modules are being released together
+func batchMode() bool {
+    return len(modulesOpt) > 1 || manifestOpt != ""
+}
+
+// activate points the globals the single module code works from at this entry
+func (e *batchEntry) activate() {
+    moduleOpt, cwd, branchOpt, topicOpt, bumpOpt = e.path, e.dir, e.branch, e.topic, e.bump
+    monorepoModule = e.mono
+}
+
+// batchEntries returns the modules to release from --module and --manifest. Manifest lines are
+// "path [bump] [topic]"; blank lines and lines starting with # are skipped.
+func batchEntries() ([]*batchEntry, error) {
+    var entries []*batchEntry
+
+    for _, path := range modulesOpt {
+        entries = append(entries, &batchEntry{path: path, bump: bumpOpt})
+    }
+
+    if manifestOpt == "" {
+        return entries, nil
+    }
+
+    file, err := os.Open(manifestOpt)
+    if err != nil {
+        return entries, &pushError{"There was a problem reading the manifest @ " + manifestOpt}
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for line := 1; scanner.Scan(); line++ {
+        fields := strings.Fields(scanner.Text())
+
+        if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+            continue
+        }
+
+        if len(fields) > 3 {
+            return entries, &pushError{fmt.Sprintf("%s:%d: expected \"path [bump] [topic]\"", manifestOpt, line)}
+        }
+
+        entry := &batchEntry{path: expandPath(fields[0]), bump: bumpOpt}
+        if len(fields) > 1 {
+            entry.bump = fields[1]
+        }
+        if len(fields) > 2 {
+            entry.topic = fields[2]
+        }
+
+        if allowed := "|" + optionsMap["bump"]["enum"] + "|"; !strings.Contains(allowed, "|"+entry.bump+"|") {
+            return entries, &pushError{fmt.Sprintf("%s:%d: unknown bump '%s' (expected %s)", manifestOpt, line, entry.bump, strings.Replace(optionsMap["bump"]["enum"], "|", ", ", -1))}
+        }
+
+        entries = append(entries, entry)
+    }
+
+    if len(entries) == 0 {
+        return entries, &pushError{"The manifest @ " + manifestOpt + " does not list any modules"}
+    }
+
+    return entries, nil
+}
+
+// pushBatch bumps and tags every module given and pins all of the new versions with one site commit
+func pushBatch() {
+    defer func() { finish(recover()) }()
+
+    cleanup, err := setup()
+    defer cleanup()
+
+    if err != nil {
+        summary.fail(err)
+        return
+    }
+
+    entries, err := batchEntries()
+    if err != nil {
+        summary.fail(err)
+        return
+    }
+
+    releaseBatch(entries)
+}
+
+// releaseBatch releases the entries (and the group partners they bring in) once setup is done, for
+// pushBatch and for a push whose module has to ship with others
+func releaseBatch(entries []*batchEntry) {
+    // ** every module and the makefile must be found before anything else happens
+    defaultBranch := branchOpt
+    dirs := []string{siteRepoOpt}
+    var modules []string
+
+    entries, err := resolveEntries(entries)
+    if err != nil {
+        summary.fail(err)
+        return
+    }
+
+    for _, e := range entries {
+        dirs = append(dirs, e.dir)
+        modules = append(modules, e.module)
+    }
+
+    summary.Module = strings.Join(modules, ", ")
+
+    makefile, err := getMakefile(modules...)
+    if err != nil {
+        summary.fail(err)
+        return
+    }
+
+    if autostashOpt {
+        restore, err := autostash(dirs...)
+        defer restore()
+
+        if err != nil {
+            summary.fail(err)
+            return
+        }
+    }
+
+    for _, e := range entries {
+        e.activate()
+        if err = runChecks("environment: "+e.module, environmentChecks()); err != nil {
+            summary.fail(err)
+            return
+        }
+    }
+
+    // ** work out each new version and make sure every release can go through
+    if err = updateSiteRepo(); err != nil {
+        summary.fail(err)
+        return
+    }
+
+    for _, e := range entries {
+        e.branch = defaultBranch
+        e.activate()
+        fmt.Println("\nModule repo:", e.module)
+
+        newVersion, latest, err := getVersions()
+        e.branch, e.topic, e.bump = branchOpt, topicOpt, bumpOpt
+
+        if err == nil {
+            err = runChecks("release: "+e.module, releaseChecks(makefile, e.module, latest, newVersion))
+        }
+
+        if err == nil {
+            err = runModuleChecks(e.module)
+        }
+
+        if err != nil {
+            summary.fail(err)
+            return
+        }
+
+        summary.Releases = append(summary.Releases, releaseSummary{e.module, latest, newVersion, e.topic})
+        warnFeatures(e.module)
+
+        if e.changes, err = analyzeImpact(latestRef(latest)); err == nil {
+            e.notes, err = releaseNotes(e.module, latestRef(latest), newVersion)
+        }
+
+        if err != nil {
+            summary.fail(err)
+            return
+        }
+
+        e.rel = &release{
+            module:  e.module,
+            latest:  latest,
+            version: newVersion,
+            tag:     tagName(newVersion),
+            notes:   e.notes["technical"],
+        }
+    }
+
+    // ** the pins have to be compatible with each other once every new version is in
+    pinned := map[string]string{}
+    for _, e := range entries {
+        pinned[e.module] = e.rel.version
+    }
+
+    if checks := compatibilityChecks(makefile, pinned); len(checks) > 0 {
+        if err = runChecks("compatibility", checks); err != nil {
+            summary.fail(err)
+            return
+        }
+    }
+
+    // ** confirm the whole batch at once
+    fmt.Printf("\nNew versions:\n")
+    for _, e := range entries {
+        fmt.Printf("  %-20s %s -> %s  (impact: %s)\n", e.module, e.rel.latest, e.rel.version, e.changes)
+    }
+
+    // ** show exactly what will be committed to the site repo (each module changes its own line)
+    var released, details []string
+
+    for _, e := range entries {
+        e.activate()
+        released = append(released, fmt.Sprintf("%s %s -> %s", e.module, e.rel.latest, e.rel.version))
+        details = append(details, formatCommitMsg(e.module, e.rel.latest, e.rel.version))
+
+        if outFile, err := getUpdatedMakefile(makefile, e.module, e.rel.version, e.rel.latest); err == nil {
+            previewMakefile(makefile, outFile)
+        }
+    }
+    previewCommitMsg(coalescedCommitMsg(released, details))
+    previewBuild(makefile, modules...)
+
+    for _, e := range entries {
+        warnUpdateHooks(e.changes)
+    }
+
+    for _, e := range entries {
+        e.activate()
+        reportHealth(e.module)
+    }
+
+    if prompt("\nAre you sure you want to tag these versions and push them to staging? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return
+    }
+
+    for _, e := range entries {
+        if !confirmImpact(e.module, e.changes) {
+            fmt.Println("Aborting...")
+            summary.Outcome = "aborted"
+            return
+        }
+    }
+
+    var tags []string
+    state = pushState{Time: time.Now(), Module: summary.Module, SiteRepo: siteRepoOpt, SiteBranch: siteBranch}
+
+    for _, e := range entries {
+        tags = append(tags, e.rel.tag)
+        state.Batch = append(state.Batch, taggedModule{Module: e.module, ModuleDir: e.dir, Remote: remoteFor(e.dir), Tag: e.rel.tag})
+    }
+    state.Tag = strings.Join(tags, ", ")
+
+    if err = runBatch(entries, makefile); err != nil {
+        summary.fail(err)
+        return
+    }
+
+    for _, e := range entries {
+        e.activate()
+
+        if err = uploadBadges(e.module, e.rel.version); err != nil {
+            slog.Warn(strings.TrimSpace(err.Error()))
+            summary.followUp("Upload the version badge for " + e.module + " to " + config.Badges.S3 + " by hand.")
+        }
+
+        publishNotes(e.module, e.rel.version, e.notes)
+        notifyOutsideRelease(e.module, e.rel.latest, e.rel.version)
+
+        remindUpdb(e.changes)
+    }
+
+    summary.Outcome = "success"
+
+    var versions []string
+    for _, e := range entries {
+        versions = append(versions, e.module+" "+e.rel.version)
+    }
+    mergeBack(strings.Join(versions, ", "))
+
+    fmt.Printf("\nPush completed successfully!\nYour %d new versions will build to the staging environment momentarily.\n", len(entries))
+}
+
+// runBatch stages every tag and the single site commit locally, then pushes the tags and the site
+// commit. If any step fails, everything done so far is undone.
+func runBatch(entries []*batchEntry, makefile string) (err error) {
+    siteCommitted := false
+
+    var modules []string
+    for _, e := range entries {
+        modules = append(modules, e.module)
+    }
+
+    // keep other runs off the site repo until the batch has pushed (or undone) its commit
+    unlock, err := lockSite(strings.Join(modules, ", "))
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    defer func() {
+        if p := recover(); p != nil {
+            err = &pushError{fmt.Sprint(p)}
+        }
+
+        if err == nil {
+            return
+        }
+
+        defer cleaningUp()()
+
+        if siteCommitted {
+            if _, resetErr := gitTry(gitc{"reset", "--keep", "HEAD~1"}, siteRepoOpt); resetErr == nil {
+                fmt.Println("\nSite repo: dropped the local makefile commit.")
+                summary.CommitSHA = ""
+            }
+        } else {
+            gitTry(gitc{"checkout", "--", siteMakeOpt}, siteRepoOpt)
+        }
+
+        for i := len(entries) - 1; i >= 0; i-- {
+            if entries[i].rel != nil {
+                entries[i].activate()
+                entries[i].rel.undo()
+            }
+        }
+    }()
+
+    if err = updateRepo(siteRepoOpt, siteBranch); err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"checkout", siteBranch}, siteRepoOpt); err != nil {
+        return err
+    }
+
+    // ** pin every new version (each update reads the makefile the previous one wrote)
+    for _, e := range entries {
+        e.activate()
+
+        outFile, err := getUpdatedMakefile(makefile, e.module, e.rel.version, e.rel.latest)
+        if err == nil {
+            err = ioutil.WriteFile(makefile, []byte(strings.Join(outFile, "\n")), 0644)
+        }
+
+        if err != nil {
+            return err
+        }
+    }
+
+    // ** stage every tag, then the one site commit
+    for _, e := range entries {
+        e.activate()
+
+        if err = runHooks("pre-tag", e.module, e.rel.latest, e.rel.version); err == nil {
+            if err = e.rel.stageTag(); err == nil {
+                err = runHooks("post-tag", e.module, e.rel.latest, e.rel.version)
+            }
+        }
+
+        if err != nil {
+            return err
+        }
+    }
+
+    var released, details []string
+
+    otherFiles, err := siteUpdaterFor(siteMakeOpt).commitFiles(modules...)
+    if err != nil {
+        return err
+    }
+    commitFiles := append(gitc{siteMakeOpt}, otherFiles...)
+
+    for _, e := range entries {
+        e.activate()
+        released = append(released, fmt.Sprintf("%s %s -> %s", e.module, e.rel.latest, e.rel.version))
+        details = append(details, formatCommitMsg(e.module, e.rel.latest, e.rel.version))
+
+        badgeFiles, err := writeBadges(e.module, e.rel.version)
+        if err != nil {
+            slog.Warn(strings.TrimSpace(err.Error()))
+            summary.followUp("Update the version badge for " + e.module + " by hand.")
+        } else if len(badgeFiles) > 0 {
+            if _, err = git(append(gitc{"add", "--"}, badgeFiles...), siteRepoOpt); err != nil {
+                return err
+            }
+            commitFiles = append(commitFiles, badgeFiles...)
+        }
+    }
+
+    if _, err = git(append(gitc{"commit", "-m", coalescedCommitMsg(released, details), "--"}, commitFiles...), siteRepoOpt); err != nil {
+        return err
+    }
+    siteCommitted = true
+
+    head, err := git(gitCommands["head"], siteRepoOpt)
+    if err != nil {
+        return err
+    }
+    summary.CommitSHA = strings.Trim(string(head), " \n\t\r")
+    fmt.Printf("Site repo: committed %d new versions in %s.\n", len(entries), summary.CommitSHA)
+
+    for _, e := range entries {
+        e.activate()
+        if err = runHooks("post-makefile", e.module, e.rel.latest, e.rel.version); err != nil {
+            return err
+        }
+    }
+
+    // ** and only then push it all
+    for i, e := range entries {
+        e.activate()
+        if err = e.rel.publishTag(); err != nil {
+            return err
+        }
+
+        state.Batch[i].TagPushed = true
+        state.save()
+    }
+
+    if out, err := pushSite(); err != nil {
+        return withCode(exitRejected, &pushError{"Could not push the makefile changes to the site repo:\n" + strings.TrimSpace(string(out))})
+    }
+
+    state.CommitSHA = summary.CommitSHA
+    state.SitePushed = true
+    state.save()
+
+    for _, e := range entries {
+        e.activate()
+        e.rel.cleanupTopic()
+        runHooks("post-push", e.module, e.rel.latest, e.rel.version)
+    }
+
+    return nil
+}
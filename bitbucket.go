@@ -0,0 +1,217 @@
+package main
+
+import (
+    "encoding/base64"
+    "net/http"
+    "path"
+    "strconv"
+    "strings"
+)
+
+// bitbucketConfig says where site pull requests are opened with --via-pr
+type bitbucketConfig struct {
+    BaseURL   string   `json:"baseUrl"`   // eg. https://bitbucket.turner.com
+    Project   string   `json:"project"`   // project key of the site repo, read from its origin URL when empty
+    Repo      string   `json:"repo"`      // slug of the site repo, read from its origin URL when empty
+    User      string   `json:"user"`      // with token, uses basic auth
+    Token     string   `json:"token"`     // on its own, used as a personal access token
+    Reviewers []string `json:"reviewers"` // usernames added as reviewers on every pull request
+}
+
+// bitbucketRef is a branch as the Bitbucket Server pull request API refers to it
+type bitbucketRef struct {
+    ID string `json:"id"`
+}
+
+// bitbucketPR is the part of the Bitbucket Server pull request API we use
+type bitbucketPR struct {
+    Title       string               `json:"title"`
+    Description string               `json:"description"`
+    FromRef     bitbucketRef         `json:"fromRef"`
+    ToRef       bitbucketRef         `json:"toRef"`
+    Reviewers   []map[string]userRef `json:"reviewers"`
+}
+
+type userRef struct {
+    Name string `json:"name"`
+}
+
+func (c bitbucketConfig) auth(req *http.Request) {
+    if c.User != "" {
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Token)))
+    } else if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.Token)
+    }
+}
+
+// siteProjectRepo returns the project key and repo slug of the site repo, from the config file or
+// the last two parts of its origin URL (eg. ssh://git@bitbucket.turner.com:7999/ncaa/barcelona.git)
+func siteProjectRepo() (string, string, error) {
+    cfg := config.Bitbucket
+
+    if cfg.Project != "" && cfg.Repo != "" {
+        return cfg.Project, cfg.Repo, nil
+    }
+
+    project, repo := originProjectRepo(siteRepoOpt)
+    if project == "" {
+        return "", "", &pushError{"Could not work out the Bitbucket project and repo of the site repo; set \"project\" and \"repo\" under \"bitbucket\" in the config file."}
+    }
+
+    return project, repo, nil
+}
+
+// originProjectRepo reads the project key and repo slug from the last two parts of a repo's remote URL
+// (origin, or the module's remote), empty when it doesn't have them
+func originProjectRepo(dir string) (string, string) {
+    out, err := remoteURL(dir)
+    remote := strings.TrimSuffix(out, ".git")
+    parts := strings.FieldsFunc(remote, func(r rune) bool { return r == '/' || r == ':' })
+
+    if err != nil || len(parts) < 2 {
+        return "", ""
+    }
+
+    return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// branchRestriction is a branch permission as the Bitbucket Server branch permissions API returns it
+type branchRestriction struct {
+    Type    string `json:"type"` // read-only, no-deletes, fast-forward-only or pull-request-only
+    Matcher struct {
+        ID   string `json:"id"` // eg. refs/heads/master, or a pattern such as release/*
+        Type struct {
+            ID string `json:"id"` // BRANCH or PATTERN (branching model matchers aren't resolved)
+        } `json:"type"`
+    } `json:"matcher"`
+    Users  []userRef `json:"users"`  // exempt from the restriction
+    Groups []string  `json:"groups"` // exempt too, though we can't tell whether the operator is in them
+}
+
+// matches says whether the restriction covers a ref (eg. refs/heads/master or refs/tags/v1.2.4)
+func (b branchRestriction) matches(ref string) bool {
+    switch b.Matcher.Type.ID {
+    case "BRANCH":
+        return b.Matcher.ID == ref
+    case "PATTERN":
+        short := strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+        pattern := strings.Replace(b.Matcher.ID, "**", "*", -1)
+
+        for _, name := range []string{ref, short} {
+            if matched, _ := path.Match(pattern, name); matched || name == b.Matcher.ID {
+                return true
+            }
+        }
+    }
+
+    return false
+}
+
+// exempt says whether the operator might not be held to the restriction. Users are matched by name;
+// anyone could be in an exempt group, so those restrictions are left for the push to enforce.
+func (b branchRestriction) exempt() bool {
+    name := config.Bitbucket.User
+    if name == "" {
+        name = usr.Username
+    }
+
+    for _, user := range b.Users {
+        if user.Name == name {
+            return true
+        }
+    }
+
+    return len(b.Groups) > 0
+}
+
+// moduleLocked looks for branch permissions on the module repo that would reject the release: the
+// default branch (or the tag) being read-only, as it is during a freeze, or the default branch only
+// taking pull requests when --changelog pushes to it
+func moduleLocked(tag string) error {
+    cfg := config.Bitbucket
+
+    project, repo := originProjectRepo(cwd)
+    if project == "" {
+        return &pushError{"Could not work out the Bitbucket project and repo of the module repo from its origin URL"}
+    }
+
+    api := strings.TrimSuffix(cfg.BaseURL, "/") + "/rest/branch-permissions/2.0/projects/" + project + "/repos/" + repo + "/restrictions?limit=1000"
+
+    var restrictions struct {
+        Values []branchRestriction `json:"values"`
+    }
+
+    if err := callAPI("GET", api, cfg.auth, nil, &restrictions); err != nil {
+        return err
+    }
+
+    var problems []string
+
+    for _, r := range restrictions.Values {
+        if r.exempt() {
+            continue
+        }
+
+        switch {
+        case r.Type == "read-only" && r.matches("refs/heads/"+branchOpt):
+            problems = append(problems, branchOpt+" is locked (read-only, set on "+r.Matcher.ID+")")
+        case r.Type == "read-only" && r.matches("refs/tags/"+tag):
+            problems = append(problems, "tags like "+tag+" are read-only (set on "+r.Matcher.ID+")")
+        case r.Type == "pull-request-only" && (changelogOpt || syncPackageJSON()) && r.matches("refs/heads/"+branchOpt):
+            problems = append(problems, branchOpt+" only takes pull requests, so the CHANGELOG.md/package.json commit can't be pushed to it")
+        }
+    }
+
+    if len(problems) > 0 {
+        return &pushError{strings.Join(problems, "; ") + " in " + project + "/" + repo + " on Bitbucket"}
+    }
+
+    return nil
+}
+
+// openPullRequest opens a pull request of branch into target in the site repo and returns its URL
+func openPullRequest(branch, target, title, description string) (string, error) {
+    cfg := config.Bitbucket
+
+    if cfg.BaseURL == "" {
+        return "", &pushError{"Opening a pull request requires a \"bitbucket\" section with a baseUrl in the config file."}
+    }
+
+    project, repo, err := siteProjectRepo()
+    if err != nil {
+        return "", err
+    }
+
+    pr := bitbucketPR{
+        Title:       title,
+        Description: description,
+        FromRef:     bitbucketRef{"refs/heads/" + branch},
+        ToRef:       bitbucketRef{"refs/heads/" + target},
+        Reviewers:   []map[string]userRef{},
+    }
+
+    for _, reviewer := range cfg.Reviewers {
+        pr.Reviewers = append(pr.Reviewers, map[string]userRef{"user": {reviewer}})
+    }
+
+    api := strings.TrimSuffix(cfg.BaseURL, "/") + "/rest/api/1.0/projects/" + project + "/repos/" + repo + "/pull-requests"
+
+    var opened struct {
+        ID    int `json:"id"`
+        Links struct {
+            Self []struct {
+                Href string `json:"href"`
+            } `json:"self"`
+        } `json:"links"`
+    }
+
+    if err = callAPI("POST", api, cfg.auth, pr, &opened); err != nil {
+        return "", err
+    }
+
+    if len(opened.Links.Self) > 0 {
+        return opened.Links.Self[0].Href, nil
+    }
+
+    return strings.TrimSuffix(cfg.BaseURL, "/") + "/projects/" + project + "/repos/" + repo + "/pull-requests/" + strconv.Itoa(opened.ID), nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// buildConfig describes how the staging pipeline decides what a site push rebuilds, so the push can
+// say what it sets off before it is confirmed. Without it, only the modules whose pins change are
+// counted.
+type buildConfig struct {
+    Full             []string            `json:"full"`             // site files (globs, eg. "composer.lock") that rebuild every module when they change
+    Dependents       map[string][]string `json:"dependents"`       // module -> the modules the pipeline rebuilds with it
+    MinutesPerModule float64             `json:"minutesPerModule"` // how long the pipeline takes per module, to estimate the build's length
+}
+
+// buildEstimate is what the staging build set off by a site commit is expected to rebuild
+type buildEstimate struct {
+    modules []string // rebuilt, sorted
+    total   int      // pinned in the site file
+    full    string   // the changed file that rebuilds everything, if one does
+}
+
+// estimateBuild works out what the staging build will rebuild once the pins of the given modules
+// change: everything when a changed site file is one the pipeline rebuilds everything for, otherwise
+// the modules and (transitively) their dependents
+func estimateBuild(makefile string, modules ...string) buildEstimate {
+    var estimate buildEstimate
+
+    pins, _ := siteUpdaterFor(makefile).pins(makefile)
+    pinned := map[string]bool{}
+    for _, p := range pins {
+        pinned[p.module] = true
+    }
+    estimate.total = len(pinned)
+
+    // the commit changes the site file, and the lock file with it for composer
+    files := []string{siteMakeOpt}
+    if _, ok := siteUpdaterFor(makefile).(composerUpdater); ok {
+        files = append(files, filepath.Join(filepath.Dir(siteMakeOpt), "composer.lock"))
+    }
+
+    for _, file := range files {
+        for _, pattern := range config.Build.Full {
+            if matched, _ := filepath.Match(pattern, file); matched || pattern == filepath.Base(file) {
+                estimate.full = file
+            }
+        }
+    }
+
+    if estimate.full != "" {
+        for module := range pinned {
+            estimate.modules = append(estimate.modules, module)
+        }
+        sort.Strings(estimate.modules)
+
+        return estimate
+    }
+
+    rebuilt := map[string]bool{}
+    queue := append([]string{}, modules...)
+
+    for len(queue) > 0 {
+        module := queue[0]
+        queue = queue[1:]
+
+        if rebuilt[module] {
+            continue
+        }
+        rebuilt[module] = true
+
+        // a dependent the site doesn't build isn't rebuilt either
+        for _, dependent := range config.Build.Dependents[module] {
+            if pinned[dependent] {
+                queue = append(queue, dependent)
+            }
+        }
+    }
+
+    for module := range rebuilt {
+        estimate.modules = append(estimate.modules, module)
+    }
+    sort.Strings(estimate.modules)
+
+    // a first release isn't pinned yet
+    if estimate.total < len(estimate.modules) {
+        estimate.total = len(estimate.modules)
+    }
+
+    return estimate
+}
+
+func (b buildEstimate) String() string {
+    size := fmt.Sprintf("rebuilds %d of %d modules", len(b.modules), b.total)
+    if b.full != "" {
+        size = fmt.Sprintf("%s changes, which rebuilds all %d modules", b.full, b.total)
+    }
+
+    if minutes := config.Build.MinutesPerModule; minutes > 0 {
+        size += fmt.Sprintf(" (about %.0f minutes)", minutes*float64(len(b.modules)))
+    }
+
+    // a full rebuild's list would only repeat the makefile
+    if b.full == "" {
+        size += ": " + strings.Join(b.modules, ", ")
+    }
+
+    return size
+}
+
+// previewBuild shows what the staging build set off by the push will rebuild
+func previewBuild(makefile string, modules ...string) {
+    fmt.Println("Staging build: " + estimateBuild(makefile, modules...).String())
+    fmt.Println()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+func init() {
+    subcommands["canary"] = subcommand{"List the modules pinned ahead in the canary makefile and promote those that have soaked (or each --module now) to the site makefile, in one site commit.", canary}
+}
+
+// canaryConfig is the makefile risky releases are pinned in first, to soak before they reach the
+// site makefile
+type canaryConfig struct {
+    Makefile string   `json:"makefile"` // in the site repo, on the site branch, eg. canary.make
+    Soak     string   `json:"soak"`     // how long a pin stays in the canary before it is promoted, 24h when empty
+    Modules  []string `json:"modules"`  // modules always released to the canary first; others only with --canary
+}
+
+// canaryMakefile returns the makefile the module's release is pinned in first, "" when it goes
+// straight to the site makefile
+func canaryMakefile(module string) (string, error) {
+    cfg := config.Canary
+
+    canaried := canaryOpt
+    for _, m := range cfg.Modules {
+        canaried = canaried || m == module
+    }
+
+    if !canaried {
+        return "", nil
+    }
+
+    if cfg.Makefile == "" {
+        return "", withCode(exitOptions, &pushError{"--canary requires a canary makefile; set canary.makefile in the config file."})
+    }
+
+    return cfg.Makefile, nil
+}
+
+// canarySoak returns how long canary pins soak
+func canarySoak() (time.Duration, error) {
+    if config.Canary.Soak == "" {
+        return 24 * time.Hour, nil
+    }
+
+    soak, err := time.ParseDuration(config.Canary.Soak)
+    if err != nil {
+        return 0, withCode(exitOptions, &pushError{"The canary soak '" + config.Canary.Soak + "' in the config file is not a duration like 24h"})
+    }
+
+    return soak, nil
+}
+
+// canaryPinned returns when the module's pin last changed in the canary makefile
+func canaryPinned(module string) (time.Time, error) {
+    out, err := gitCheck(gitc{"log", "-1", "--format=%ct", "-G", regexp.QuoteMeta(module), siteBranch, "--", config.Canary.Makefile}, siteRepoOpt)
+    if err != nil {
+        return time.Time{}, err
+    }
+
+    seconds, err := strconv.ParseInt(out, 10, 64)
+    if err != nil {
+        return time.Time{}, &pushError{"Could not find when " + module + " was pinned in " + config.Canary.Makefile}
+    }
+
+    return time.Unix(seconds, 0), nil
+}
+
+// canary promotes the canary makefile's pins that are ahead of the site makefile's once they have
+// soaked, or right away for each module named with --module
+func canary(args []string) error {
+    if len(args) > 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit canary [--module <module>...] [options]"})
+    }
+
+    if config.Canary.Makefile == "" {
+        return withCode(exitOptions, &pushError{"No canary makefile is configured; set canary.makefile in the config file."})
+    }
+
+    soak, err := canarySoak()
+    if err != nil {
+        return err
+    }
+
+    forced := map[string]bool{}
+    for _, module := range modulesOpt {
+        forced[filepath.Base(module)] = true
+    }
+
+    makefile, err := getMakefile()
+    if err != nil {
+        return err
+    }
+
+    unlock, err := lockSite("promote canary pins")
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if err = checkoutSiteBranch(); err != nil {
+        return err
+    }
+
+    canaryPins, err := pinSet(filepath.Join(siteRepoOpt, config.Canary.Makefile))
+    if err != nil {
+        return err
+    }
+
+    sitePins, err := pinSet(makefile)
+    if err != nil {
+        return err
+    }
+
+    pinned := map[string]string{}
+    for _, p := range sitePins {
+        pinned[p.module] = p.version
+    }
+
+    for module := range forced {
+        if _, ok := pinned[module]; !ok {
+            return withCode(exitMakefile, &pushError{module + " is not pinned in " + siteMakeOpt + "."})
+        }
+    }
+
+    var (
+        requests []pinRequest
+        promoted []string
+        ahead    int
+    )
+
+    for _, p := range canaryPins {
+        current, ok := pinned[p.module]
+        if !ok || current == p.version {
+            continue
+        }
+
+        if ahead++; ahead == 1 {
+            fmt.Printf("\n%-24s %-14s %-14s %s\n", "MODULE", "CANARY", "SITE", "SOAK")
+        }
+
+        since, err := canaryPinned(p.module)
+        if err != nil {
+            return err
+        }
+
+        soaked := time.Since(since)
+
+        state := "soaked " + soaked.Round(time.Minute).String()
+        switch {
+        case forced[p.module]:
+            state = "promoting now (--module)"
+        case soaked < soak:
+            state = (soak - soaked).Round(time.Minute).String() + " to go"
+        }
+
+        fmt.Printf("%-24s %-14s %-14s %s\n", p.module, moduleTagName(p.module, p.version), moduleTagName(p.module, current), state)
+
+        if forced[p.module] || soaked >= soak {
+            requests = append(requests, pinRequest{module: p.module, version: p.version})
+            promoted = append(promoted, p.module)
+        }
+    }
+
+    if ahead == 0 {
+        fmt.Println("\nNothing in " + config.Canary.Makefile + " is ahead of " + siteMakeOpt + ".")
+        return nil
+    }
+
+    if len(requests) == 0 {
+        fmt.Println("\nNothing has soaked for " + soak.String() + " yet.")
+        return nil
+    }
+
+    return applyPins(makefile, requests, "promote", []string{"Promotes " + strings.Join(promoted, ", ") + " from the canary (" + config.Canary.Makefile + ", soak " + soak.String() + ")."})
+}
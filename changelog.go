@@ -0,0 +1,136 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// changelog sections in the order they are written, keyed by conventional commit type
+var changelogSections = []struct{ title, kind string }{
+    {"Breaking changes", "breaking"},
+    {"Features", "feat"},
+    {"Fixes", "fix"},
+    {"Other changes", ""},
+}
+
+// noteSection is one section of a version's release notes: its tickets (commits without one are
+// listed under an empty key, last) in the order they are written
+type noteSection struct {
+    Title   string
+    Tickets []noteTicket
+}
+
+// noteTicket is a ticket and the commits made for it
+type noteTicket struct {
+    Key     string
+    Title   string // the ticket's summary (see ticketTitle), filled in for the notes that show it
+    Commits []commit
+}
+
+// noteSections groups the commits since the latest tag by commit type and then by ticket
+func noteSections(latestTag string) ([]noteSection, error) {
+    grouped := map[string]map[string][]commit{}
+
+    commits, err := commitsSince(latestTag)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, c := range commits {
+        kind := c.Type
+        if c.Breaking {
+            kind = "breaking"
+        } else if kind != "feat" && kind != "fix" {
+            kind = ""
+        }
+
+        if grouped[kind] == nil {
+            grouped[kind] = map[string][]commit{}
+        }
+        grouped[kind][c.Ticket] = append(grouped[kind][c.Ticket], c)
+    }
+
+    var sections []noteSection
+
+    for _, section := range changelogSections {
+        tickets := grouped[section.kind]
+        if len(tickets) == 0 {
+            continue
+        }
+
+        var keys []string
+        for ticket := range tickets {
+            keys = append(keys, ticket)
+        }
+
+        // commits without a ticket go last
+        sort.Slice(keys, func(i, j int) bool {
+            if keys[i] == "" || keys[j] == "" {
+                return keys[j] == ""
+            }
+            return keys[i] < keys[j]
+        })
+
+        s := noteSection{Title: section.title}
+        for _, key := range keys {
+            s.Tickets = append(s.Tickets, noteTicket{Key: key, Commits: tickets[key]})
+        }
+        sections = append(sections, s)
+    }
+
+    return sections, nil
+}
+
+// technicalNotes is the CHANGELOG.md entry for a new version, for engineers: every commit since the
+// latest tag with its hash, grouped by commit type and then by ticket
+func technicalNotes(latestTag, newVersion string, sections []noteSection) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "## %s (%s)\n", tagName(newVersion), displayDate(time.Now()))
+
+    if len(sections) == 0 {
+        b.WriteString("\nNo changes since " + latestTag + ".\n")
+    }
+
+    for _, section := range sections {
+        fmt.Fprintf(&b, "\n### %s\n\n", section.Title)
+
+        for _, ticket := range section.Tickets {
+            key := ticket.Key
+            if key == "" {
+                key = "No ticket"
+            }
+            fmt.Fprintf(&b, "- %s\n", key)
+
+            for _, c := range ticket.Commits {
+                fmt.Fprintf(&b, "  - %s (%s)\n", c.Description, c.Hash)
+            }
+        }
+    }
+
+    return b.String()
+}
+
+// writeChangelog adds the entry to the top of CHANGELOG.md in the module repo, creating it if needed
+func writeChangelog(entry string) error {
+    path := filepath.Join(cwd, "CHANGELOG.md")
+    header := "# Changelog\n\n"
+
+    existing, err := ioutil.ReadFile(path)
+    if err != nil && !os.IsNotExist(err) {
+        return &pushError{"Could not read " + path}
+    }
+
+    contents := strings.TrimPrefix(string(existing), header)
+    contents = header + linkTickets(entry, "markdown") + "\n" + contents
+
+    if err = ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+        return &pushError{"Could not write " + path + ". Check permissions and try again."}
+    }
+
+    return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+    _ "time/tzdata" // build agents don't all have a zoneinfo database
+    "fmt"
+    "log/slog"
+    "time"
+)
+
+// timeConfig sets how times are shown in notifications and output, which otherwise would be in the
+// machine's own zone (UTC on the build agents)
+type timeConfig struct {
+    Zone   string `json:"zone"`   // IANA zone name, America/New_York (ET, which the broadcast schedule runs on) when empty
+    Format string `json:"format"` // Go time layout, "Jan 2 2006 15:04 MST" when empty
+}
+
+const (
+    defaultZone       = "America/New_York"
+    defaultTimeFormat = "Jan 2 2006 15:04 MST"
+)
+
+var zone *time.Location
+
+// displayZone is the zone times are shown in. An unknown zone is warned about once and the
+// machine's own zone is used instead.
+func displayZone() *time.Location {
+    if zone != nil {
+        return zone
+    }
+
+    name := config.Time.Zone
+    if name == "" {
+        name = defaultZone
+    }
+
+    loaded, err := time.LoadLocation(name)
+    if err != nil {
+        slog.Warn(fmt.Sprintf("unknown time zone %q in the config file, showing times in the local zone.", name))
+        loaded = time.Local
+    }
+
+    zone = loaded
+    return zone
+}
+
+// displayTime renders a time in the configured zone and format
+func displayTime(t time.Time) string {
+    format := config.Time.Format
+    if format == "" {
+        format = defaultTimeFormat
+    }
+
+    return t.In(displayZone()).Format(format)
+}
+
+// displayDate renders the day a time falls on in the configured zone, eg. 2024-03-09
+func displayDate(t time.Time) string {
+    return t.In(displayZone()).Format("2006-01-02")
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+    "bytes"
+    "flag"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+)
+
+// subcommand is a command other than the default push, run as `ncaapushit <name> [options] [args]`
+type subcommand struct {
+    usage string
+    run   func(args []string) error
+}
+
+var subcommands = map[string]subcommand{}
+
+func init() {
+    subcommands["rollback"] = subcommand{"Undo the last push: revert the makefile commit and delete the new tag.", rollback}
+
+    flag.Usage = func() {
+        var names []string
+        for name := range subcommands {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+
+        fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [command] [options]\n\nWithout a command, bumps, tags and pins a new version of the module.\n\nCommands:\n", os.Args[0])
+        for _, name := range names {
+            fmt.Fprintf(flag.CommandLine.Output(), "  %-12s %s\n", name, subcommands[name].usage)
+        }
+        fmt.Fprintf(flag.CommandLine.Output(), "\nOptions:\n")
+        flag.PrintDefaults()
+    }
+}
+
+// parseOptions parses the command-line options and returns the command's arguments. Options may
+// come before, between or after the arguments (eg. pin release-day.csv --workspace ~/Repos); only
+// "--" ends them. The flag package's own complaint about an unknown option is followed by the whole
+// usage, so it is replaced with a shorter one naming the nearest option.
+func parseOptions(args []string) ([]string, error) {
+    var (
+        output     bytes.Buffer
+        positional []string
+        err        error
+    )
+
+    flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+    flag.CommandLine.SetOutput(&output)
+
+    // the flag package stops at the first argument, so parsing picks up again after each one
+    for {
+        if err = flag.CommandLine.Parse(args); err != nil {
+            break
+        }
+
+        rest := flag.Args()
+        if len(rest) == 0 {
+            break
+        }
+
+        if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+            positional = append(positional, rest...)
+            break
+        }
+
+        positional, args = append(positional, rest[0]), rest[1:]
+    }
+
+    flag.CommandLine.SetOutput(os.Stderr)
+
+    if err == flag.ErrHelp {
+        fmt.Fprint(os.Stderr, output.String())
+        os.Exit(0)
+    }
+
+    if err == nil {
+        return positional, nil
+    }
+
+    problem := err.Error()
+
+    if name := strings.TrimPrefix(problem, "flag provided but not defined: "); name != problem {
+        name = strings.TrimLeft(name, "-")
+
+        problem = "Unknown option --" + name + "."
+        if suggestion := suggestFlag(name); suggestion != "" {
+            problem += " Did you mean " + suggestion + "?"
+        }
+    }
+
+    return nil, withCode(exitOptions, &pushError{problem + "\nRun with -h to list the options."})
+}
+
+// runSubcommand sets up and runs the named command, turning a panic into an ordinary error
+func runSubcommand(name string, args []string) (err error) {
+    // doctor reports what setup would stop at, so it does without it
+    if name == "doctor" {
+        return subcommands[name].run(args)
+    }
+
+    cleanup, err := setup()
+    defer cleanup()
+    defer restoreBranches()
+
+    if err != nil {
+        return err
+    }
+
+    defer func() {
+        if r := recover(); r != nil {
+            err = &pushError{fmt.Sprint(r)}
+        }
+    }()
+
+    return subcommands[name].run(args)
+}
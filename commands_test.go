@@ -0,0 +1,42 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseOptionsAroundArguments(t *testing.T) {
+    defer func(profile, workspace string) { profileOpt, workspaceOpt = profile, workspace }(profileOpt, workspaceOpt)
+
+    tests := []struct {
+        args      []string
+        want      []string
+        profile   string
+        workspace string
+    }{
+        {[]string{"--profile", "qa", "release-day.csv"}, []string{"release-day.csv"}, "qa", ""},
+        {[]string{"release-day.csv", "--workspace", "/tmp/Repos"}, []string{"release-day.csv"}, "", "/tmp/Repos"},
+        {[]string{"ncaa_scores", "--profile=qa", "ncaa_teams", "--workspace", "/tmp/Repos"}, []string{"ncaa_scores", "ncaa_teams"}, "qa", "/tmp/Repos"},
+        {[]string{"--profile", "qa", "--", "--workspace", "x"}, []string{"--workspace", "x"}, "qa", ""},
+        {[]string{"a", "--", "b", "--profile", "qa"}, []string{"a", "b", "--profile", "qa"}, "", ""},
+        {nil, nil, "", ""},
+    }
+
+    for _, tt := range tests {
+        profileOpt, workspaceOpt = "", ""
+
+        got, err := parseOptions(tt.args)
+        if err != nil {
+            t.Errorf("parseOptions(%q) error = %v", tt.args, err)
+            continue
+        }
+
+        if !reflect.DeepEqual(got, tt.want) || profileOpt != tt.profile || workspaceOpt != tt.workspace {
+            t.Errorf("parseOptions(%q) = %q with --profile %q --workspace %q; want %q with %q, %q", tt.args, got, profileOpt, workspaceOpt, tt.want, tt.profile, tt.workspace)
+        }
+    }
+
+    if _, err := parseOptions([]string{"release-day.csv", "--workspce", "/tmp/Repos"}); err == nil {
+        t.Error("parseOptions accepted an unknown option after an argument")
+    }
+}
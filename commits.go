@@ -0,0 +1,115 @@
+package main
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+)
+
+// commit is a single module commit, with its conventional commit parts when it follows the convention
+type commit struct {
+    Hash        string
+    Subject     string
+    Body        string
+    Ticket      string // eg. NCAA-1234 (empty when the subject doesn't mention one)
+    Type        string // feat, fix, chore... (empty when not a conventional commit)
+    Scope       string
+    Breaking    bool
+    Description string // the subject without its ticket and type prefixes
+}
+
+var (
+    // optionally prefixed with a ticket key, as in "NCAA-1234 feat(scores): live updates"
+    conventionalPattern = regexp.MustCompile(`^(?:[A-Z][A-Z0-9]+-\d+:?\s+)?([a-z]+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+    ticketPattern       = regexp.MustCompile(`\b[A-Z][A-Z0-9]+-\d+\b`)
+    ticketPrefixPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]+-\d+:?\s+`)
+)
+
+// commitsSince lists the non-merge commits on the module's default branch since the given tag (only
+// those touching the module's own directory in a monorepo), or all of them when the tag is empty
+func commitsSince(tag string) ([]commit, error) {
+    var commits []commit
+
+    revisions := tag + ".." + branchOpt
+    if tag == "" {
+        revisions = branchOpt
+    }
+
+    command := gitc{"log", "--no-merges", "--format=%h%x1f%s%x1f%b%x1e", revisions}
+    if monorepoModule != "" {
+        command = append(command, "--", ".")
+    }
+
+    out, err := git(command, cwd)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, record := range strings.Split(string(out), "\x1e") {
+        fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+        if len(fields) < 3 {
+            continue
+        }
+
+        c := commit{Hash: fields[0], Subject: fields[1], Body: strings.TrimSpace(fields[2])}
+        c.Ticket = ticketPattern.FindString(c.Subject)
+        c.Description = ticketPrefixPattern.ReplaceAllString(c.Subject, "")
+
+        if parts := conventionalPattern.FindStringSubmatch(c.Subject); parts != nil {
+            c.Type, c.Scope, c.Breaking, c.Description = parts[1], parts[2], parts[3] == "!", parts[4]
+        }
+
+        if strings.Contains(c.Body, "BREAKING CHANGE") || strings.Contains(c.Body, "BREAKING-CHANGE") {
+            c.Breaking = true
+        }
+
+        commits = append(commits, c)
+    }
+
+    return commits, nil
+}
+
+// detectBump picks major, minor or patch from the conventional commits since the latest tag and
+// prints the reasoning so the operator can sanity check it before confirming
+func detectBump(latestTag string) (string, error) {
+    var breaking, features, fixes, others []commit
+
+    commits, err := commitsSince(latestTag)
+    if err != nil {
+        return "", err
+    }
+
+    for _, c := range commits {
+        switch {
+        case c.Breaking:
+            breaking = append(breaking, c)
+        case c.Type == "feat":
+            features = append(features, c)
+        case c.Type == "fix":
+            fixes = append(fixes, c)
+        default:
+            others = append(others, c)
+        }
+    }
+
+    bump, because := "patch", append(fixes, others...)
+    switch {
+    case len(breaking) > 0:
+        bump, because = "major", breaking
+    case len(features) > 0:
+        bump, because = "minor", features
+    }
+
+    fmt.Printf("Auto bump: %s (%d breaking, %d feat, %d fix, %d other commits since %s)\n",
+        bump, len(breaking), len(features), len(fixes), len(others), latestTag)
+
+    for i, c := range because {
+        if i == 5 {
+            fmt.Printf("  ... and %d more\n", len(because)-i)
+            break
+        }
+        fmt.Printf("  %s %s\n", c.Hash, c.Subject)
+    }
+
+    return bump, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+// compatRule is one entry of the compatibility matrix the site's pins have to satisfy. A rule is one
+// of: modules pinned at matching versions (Modules, Match), a range a module must not be pinned in
+// (Module, Forbid), or the ranges other modules must be pinned in alongside a module (Module, When,
+// Requires). Ranges are space separated comparisons, eg. ">=2.1.0 <2.1.3".
+type compatRule struct {
+    Modules  []string          `json:"modules"`
+    Match    string            `json:"match"`    // major (the default) or minor: how much of their versions the modules must share
+    Module   string            `json:"module"`
+    Forbid   string            `json:"forbid"`   // eg. a range with a known bug
+    When     string            `json:"when"`     // Requires only applies while Module is pinned in this range, always when empty
+    Requires map[string]string `json:"requires"` // module -> the range it must be pinned in
+    Reason   string            `json:"reason"`   // shown when the rule is broken, eg. a link to the incident
+}
+
+// inRange says whether the module's version is in the range, eg. ">=2.1.0 <2.1.3". A bare version
+// matches only itself.
+func inRange(module string, v version, spec string) (bool, error) {
+    for _, comparison := range strings.Fields(spec) {
+        op := ""
+        for _, known := range []string{">=", "<=", ">", "<", "="} {
+            if strings.HasPrefix(comparison, known) {
+                op = known
+                break
+            }
+        }
+
+        bound, err := parseModuleVersion(module, strings.TrimPrefix(strings.TrimPrefix(comparison, op), "v"))
+        if err != nil {
+            return false, &pushError{"'" + spec + "' is not a version range: " + strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ")}
+        }
+
+        c := v.Compare(bound)
+
+        var ok bool
+        switch op {
+        case ">=":
+            ok = c >= 0
+        case ">":
+            ok = c > 0
+        case "<=":
+            ok = c <= 0
+        case "<":
+            ok = c < 0
+        default:
+            ok = c == 0
+        }
+
+        if !ok {
+            return false, nil
+        }
+    }
+
+    return true, nil
+}
+
+// mentions says whether the rule is about any of the modules
+func (r compatRule) mentions(modules map[string]string) bool {
+    if _, ok := modules[r.Module]; ok && r.Module != "" {
+        return true
+    }
+
+    for _, module := range r.Modules {
+        if _, ok := modules[module]; ok {
+            return true
+        }
+    }
+
+    for module := range r.Requires {
+        if _, ok := modules[module]; ok {
+            return true
+        }
+    }
+
+    return false
+}
+
+// name describes the rule as a check
+func (r compatRule) name() string {
+    switch {
+    case len(r.Modules) > 0:
+        match := r.Match
+        if match == "" {
+            match = "major"
+        }
+        return strings.Join(r.Modules, ", ") + " share a " + match + " version"
+    case r.Forbid != "":
+        return r.Module + " is not pinned " + r.Forbid
+    default:
+        var required []string
+        for module, spec := range r.Requires {
+            required = append(required, module+" "+spec)
+        }
+        sort.Strings(required)
+
+        name := r.Module
+        if r.When != "" {
+            name += " " + r.When
+        }
+        return name + " needs " + strings.Join(required, ", ")
+    }
+}
+
+// check tests the rule against the pins the site file will have
+func (r compatRule) check(pins map[string]string) error {
+    versions := map[string]version{}
+
+    for module, pinned := range pins {
+        v, err := parseModuleVersion(module, strings.TrimPrefix(pinned, "v"))
+        if err == nil {
+            versions[module] = v
+        }
+    }
+
+    broken := func(problem string) error {
+        if r.Reason != "" {
+            problem += " (" + r.Reason + ")"
+        }
+        return &pushError{problem}
+    }
+
+    switch {
+    case len(r.Modules) > 0:
+        var first string
+
+        for _, module := range r.Modules {
+            v, ok := versions[module]
+            if !ok {
+                continue // a module the site doesn't build can't be out of step
+            }
+
+            if first == "" {
+                first = module
+            } else if other := versions[first]; other.Major != v.Major || (r.Match == "minor" && other.Minor != v.Minor) {
+                return broken(fmt.Sprintf("%s %s and %s %s would be pinned together", first, pins[first], module, pins[module]))
+            }
+        }
+
+    case r.Forbid != "":
+        v, ok := versions[r.Module]
+        if !ok {
+            return nil
+        }
+
+        forbidden, err := inRange(r.Module, v, r.Forbid)
+        if err != nil {
+            return err
+        }
+
+        if forbidden {
+            return broken(fmt.Sprintf("%s %s is in the forbidden range %s", r.Module, pins[r.Module], r.Forbid))
+        }
+
+    default:
+        v, ok := versions[r.Module]
+        if !ok {
+            return nil
+        }
+
+        if r.When != "" {
+            applies, err := inRange(r.Module, v, r.When)
+            if err != nil || !applies {
+                return err
+            }
+        }
+
+        var modules []string
+        for module := range r.Requires {
+            modules = append(modules, module)
+        }
+        sort.Strings(modules)
+
+        for _, module := range modules {
+            required, ok := versions[module]
+            if !ok {
+                return broken(fmt.Sprintf("%s %s needs %s %s, which is not pinned", r.Module, pins[r.Module], module, r.Requires[module]))
+            }
+
+            met, err := inRange(module, required, r.Requires[module])
+            if err != nil {
+                return err
+            }
+
+            if !met {
+                return broken(fmt.Sprintf("%s %s needs %s %s, but it would be pinned to %s", r.Module, pins[r.Module], module, r.Requires[module], pins[module]))
+            }
+        }
+    }
+
+    return nil
+}
+
+// compatibilityChecks checks the pins the site file will have once the changes (module -> new
+// version) are made against every rule of the compatibility matrix that involves a changed module
+func compatibilityChecks(makefile string, changes map[string]string) []check {
+    if len(config.Compatibility) == 0 {
+        return nil
+    }
+
+    pins := map[string]string{}
+
+    current, err := siteUpdaterFor(makefile).pins(makefile)
+    if err != nil {
+        return []check{{"the makefile's pins can be read", func() error { return err }}}
+    }
+
+    for _, p := range current {
+        pins[p.module] = p.version
+    }
+
+    for module, v := range changes {
+        pins[module] = v
+    }
+
+    var checks []check
+
+    for _, rule := range config.Compatibility {
+        if !rule.mentions(changes) {
+            continue
+        }
+
+        rule := rule
+        checks = append(checks, check{rule.name(), func() error { return rule.check(pins) }})
+    }
+
+    return checks
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+    "flag"
+    "fmt"
+    "sort"
+    "strings"
+)
+
+func init() {
+    subcommands["completion"] = subcommand{"Print the shell completion script for bash, zsh or fish (eg. source <(ncaapushit completion bash)).", completion}
+}
+
+// optionValues says what an option's value is completed with
+var optionValues = map[string]string{
+    "profile":       "profiles",
+    "module":        "modules",
+    "config":        "files",
+    "manifest":      "files",
+    "summary-out":   "files",
+    "site-makefile": "files",
+    "from":          "files",
+    "to":            "files",
+    "site-repo":     "dirs",
+    "workspace":     "dirs",
+}
+
+// completionOption is an option as the completion scripts see it
+type completionOption struct {
+    name    string
+    long    string // the option a shorthand stands for, "" when it isn't one
+    usage   string
+    boolean bool
+    values  string // profiles, modules, files, dirs or the option's allowed values
+}
+
+// completionOptions lists every option with how its value is completed
+func completionOptions() []completionOption {
+    var options []completionOption
+
+    flag.VisitAll(func(f *flag.Flag) {
+        o := completionOption{name: f.Name, usage: f.Usage}
+
+        if long := strings.TrimPrefix(f.Usage, "shorthand for --"); long != f.Usage {
+            o.long, o.usage = long, flag.Lookup(long).Usage
+        }
+
+        option := o.name
+        if o.long != "" {
+            option = o.long
+        }
+
+        if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+            o.boolean = true
+        }
+
+        o.values = optionValues[option]
+        if enum := optionsMap[option]["enum"]; enum != "" {
+            o.values = strings.Replace(enum, "|", " ", -1)
+        }
+
+        options = append(options, o)
+    })
+
+    return options
+}
+
+// completionWords lists the subcommands, and the options (with their dashes) for bash
+func completionWords() (commands, options string) {
+    var names []string
+    for name := range subcommands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var flags []string
+    for _, o := range completionOptions() {
+        if len(o.name) == 1 {
+            flags = append(flags, "-"+o.name)
+        } else {
+            flags = append(flags, "--"+o.name)
+        }
+    }
+
+    return strings.Join(names, " "), strings.Join(flags, " ")
+}
+
+// bashCompletion is the bash script, which zsh loads through bashcompinit
+func bashCompletion() string {
+    commands, flags := completionWords()
+
+    var cases []string
+    for _, o := range completionOptions() {
+        if o.boolean {
+            continue
+        }
+
+        dash := "--"
+        if len(o.name) == 1 {
+            dash = "-"
+        }
+
+        var reply string
+        switch o.values {
+        case "profiles":
+            reply = `COMPREPLY=($(compgen -W "$(ncaapushit completion profiles 2>/dev/null)" -- "$cur"))`
+        case "modules":
+            reply = `COMPREPLY=($(compgen -d -W "$(ncaapushit completion modules 2>/dev/null)" -- "$cur"))`
+        case "files":
+            reply = `COMPREPLY=($(compgen -f -- "$cur"))`
+        case "dirs":
+            reply = `COMPREPLY=($(compgen -d -- "$cur"))`
+        case "":
+            reply = `COMPREPLY=()`
+        default:
+            reply = `COMPREPLY=($(compgen -W "` + o.values + `" -- "$cur"))`
+        }
+
+        cases = append(cases, fmt.Sprintf("        %s%s) %s; return ;;", dash, o.name, reply))
+    }
+
+    return `_ncaapushit() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}"
+
+    case "$prev" in
+` + strings.Join(cases, "\n") + `
+    esac
+
+    if [[ $cur == -* ]]; then
+        COMPREPLY=($(compgen -W "` + flags + `" -- "$cur"))
+    elif [[ $COMP_CWORD == 1 ]]; then
+        COMPREPLY=($(compgen -W "` + commands + `" -- "$cur"))
+    else
+        COMPREPLY=($(compgen -W "$(ncaapushit completion modules 2>/dev/null)" -- "$cur"))
+    fi
+}
+
+complete -o default -F _ncaapushit ncaapushit
+`
+}
+
+// fishCompletion is the fish script
+func fishCompletion() string {
+    quote := func(s string) string {
+        return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+    }
+
+    var names []string
+    for name := range subcommands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    lines := []string{"complete -c ncaapushit -f"}
+
+    for _, name := range names {
+        lines = append(lines, "complete -c ncaapushit -n __fish_use_subcommand -a "+name+" -d "+quote(subcommands[name].usage))
+    }
+
+    for _, o := range completionOptions() {
+        if o.long != "" {
+            continue // fish lists a shorthand alongside its option
+        }
+
+        line := "complete -c ncaapushit -l " + o.name
+        for _, short := range completionOptions() {
+            if short.long == o.name {
+                line += " -o " + short.name
+            }
+        }
+
+        if !o.boolean {
+            line += " -r"
+        }
+
+        switch o.values {
+        case "profiles":
+            line += " -a '(ncaapushit completion profiles 2>/dev/null)'"
+        case "modules":
+            line += " -a '(ncaapushit completion modules 2>/dev/null; __fish_complete_directories)'"
+        case "files":
+            line += " -F"
+        case "dirs":
+            line += " -a '(__fish_complete_directories)'"
+        case "":
+        default:
+            line += " -a " + quote(o.values)
+        }
+
+        lines = append(lines, line+" -d "+quote(o.usage))
+    }
+
+    lines = append(lines, "complete -c ncaapushit -n 'not __fish_use_subcommand' -a '(ncaapushit completion modules 2>/dev/null)'")
+
+    return strings.Join(lines, "\n") + "\n"
+}
+
+// completion prints a shell's completion script, or (as the scripts ask for them) the profiles in
+// the config file or the modules the site makefile pins
+func completion(args []string) error {
+    if len(args) != 1 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit completion bash|zsh|fish"})
+    }
+
+    switch args[0] {
+    case "bash":
+        fmt.Print(bashCompletion())
+    case "zsh":
+        fmt.Print("autoload -U +X bashcompinit && bashcompinit\n\n" + bashCompletion())
+    case "fish":
+        fmt.Print(fishCompletion())
+    case "profiles":
+        var names []string
+        for name := range config.Profiles {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+
+        for _, name := range names {
+            fmt.Println(name)
+        }
+    case "modules":
+        // nothing to offer is better than an error in the middle of the command-line
+        makefile, err := getMakefile()
+        if err != nil {
+            return nil
+        }
+
+        pins, _ := pinSet(makefile)
+        for _, p := range pins {
+            fmt.Println(p.module)
+        }
+    default:
+        return withCode(exitOptions, &pushError{"Unknown shell '" + args[0] + "'. Shells: bash, zsh, fish"})
+    }
+
+    return nil
+}
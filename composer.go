@@ -0,0 +1,122 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// composerRequire matches a package line of composer.json, eg.   "ncaa/ncaa_scores": "^1.2.3",
+var composerRequire = regexp.MustCompile(`^\s*"([^"/]+/([^"]+))"\s*:\s*"([^"]*)"`)
+
+// composerConstraint splits a version constraint into its operator, its v and the version (^, v, 1.2.3)
+var composerConstraint = regexp.MustCompile(`^(\^|~|>=|==|=)?(v?)(\d+\.\d+\.\d+\S*)$`)
+
+// isComposerFile says whether the site pins its modules in composer.json instead of a drush makefile,
+// which is the case when --site-makefile (or a profile's siteMakefile) points at one
+func isComposerFile(path string) bool {
+    return filepath.Base(path) == "composer.json"
+}
+
+// composerPin is a module's package line in composer.json
+type composerPin struct {
+    line       int
+    pkg        string // eg. ncaa/ncaa_scores
+    constraint string
+    at         int // where the constraint starts in the line
+}
+
+// composerPins finds the lines requiring the module's package (vendor/<module>), in require or
+// require-dev
+func composerPins(lines []string, module string) []composerPin {
+    var pins []composerPin
+
+    for i, line := range lines {
+        match := composerRequire.FindStringSubmatchIndex(line)
+        if match == nil || line[match[4]:match[5]] != module {
+            continue
+        }
+
+        pins = append(pins, composerPin{i, line[match[2]:match[3]], line[match[6]:match[7]], match[6]})
+    }
+
+    return pins
+}
+
+// getUpdatedComposer is getUpdatedMakefile for composer.json: the module's version constraint is
+// moved from the latest version to the new one, keeping its operator (eg. ^1.2.3 -> ^1.2.4)
+func getUpdatedComposer(file, module, newVersion, latest string) ([]string, error) {
+    outFile, err := readLines(file)
+    if err != nil {
+        return nil, withCode(exitMakefile, &pushError{"Could not read " + file + ": " + err.Error()})
+    }
+
+    pins := composerPins(outFile, module)
+
+    switch {
+    case latest == "":
+        return outFile, withCode(exitMakefile, &pushError{"This is the first release of '" + module + "'. Require its package with composer require in the site repo first."})
+    case len(pins) == 0:
+        return outFile, withCode(exitMakefile, &pushError{"No package for the module '" + module + "' (eg. vendor/" + module + ") is required in " + siteMakeOpt + ".\nMake sure your site repo is up-to-date before using this utility."})
+    case len(pins) > 1:
+        return outFile, withCode(exitMakefile, &pushError{"The module '" + module + "' is required more than once in " + siteMakeOpt + ".\nRemove all but one of its packages so it's clear which version the site builds."})
+    }
+
+    pin := pins[0]
+    match := composerConstraint.FindStringSubmatch(pin.constraint)
+
+    if match == nil || match[3] != latest {
+        return outFile, withCode(exitMakefile, &pushError{fmt.Sprintf("%s requires %s %q (line %d), not the latest version %s.\nMake sure your site repo is up-to-date before using this utility.",
+            siteMakeOpt, pin.pkg, pin.constraint, pin.line+1, latest)})
+    }
+
+    line := outFile[pin.line]
+    outFile[pin.line] = line[:pin.at] + match[1] + match[2] + newVersion + line[pin.at+len(pin.constraint):]
+
+    return outFile, nil
+}
+
+// updateComposerLock runs composer update for the modules' packages with --composer-update, so that
+// composer.lock goes out with the new constraints. It returns the files to commit along with composer.json.
+func updateComposerLock(modules ...string) (gitc, error) {
+    if !composerOpt {
+        return nil, nil
+    }
+
+    lines, err := readLines(filepath.Join(siteRepoOpt, siteMakeOpt))
+    if err != nil {
+        return nil, &pushError{"Could not read " + siteMakeOpt + ": " + err.Error()}
+    }
+
+    args := []string{"update"}
+    for _, module := range modules {
+        for _, pin := range composerPins(lines, module) {
+            args = append(args, pin.pkg)
+        }
+    }
+    args = append(args, "--lock")
+
+    lock := filepath.Join(filepath.Dir(siteMakeOpt), "composer.lock")
+
+    fmt.Printf("Running composer %s... ", strings.Join(args, " "))
+
+    cmd := exec.Command("composer", args...)
+    cmd.Dir = filepath.Join(siteRepoOpt, filepath.Dir(siteMakeOpt))
+    slog.Debug("composer "+strings.Join(args, " "), "dir", cmd.Dir)
+
+    if out, err := cmd.CombinedOutput(); err != nil {
+        fmt.Println("failed")
+        gitTry(gitc{"checkout", "--", lock}, siteRepoOpt)
+        return nil, withCode(exitMakefile, &pushError{"composer " + strings.Join(args, " ") + " failed: " + strings.TrimSpace(string(out))})
+    }
+    fmt.Println("complete")
+
+    if _, err := git(gitc{"add", "--", lock}, siteRepoOpt); err != nil {
+        return nil, err
+    }
+
+    return gitc{lock}, nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "io/ioutil"
+    "os"
+    "regexp"
+    "sort"
+    "strings"
+)
+
+// profile describes one environment the utility can push to (eg. staging, qa, prod)
+type profile struct {
+    SiteRepo     string    `json:"siteRepo"`
+    SiteMakefile string    `json:"siteMakefile"`
+    SiteBranch   string    `json:"siteBranch"`
+    SiteUpdater  string    `json:"siteUpdater"` // drush, composer, manifest, json or kubernetes, detected from the site makefile when empty
+    CommitFormat string    `json:"commitFormat"`
+    TagNamespace string    `json:"tagNamespace"`
+    BackMerge    backMerge `json:"backMerge"`   // eg. prod's release branch back into develop after each push
+}
+
+// identity is who commits and tags are made as when git is isolated
+type identity struct {
+    Name  string `json:"name"`
+    Email string `json:"email"`
+}
+
+// signing configures the release tags made with --sign
+type signing struct {
+    Key     string `json:"key"`     // key ID passed to git tag -u, user.signingkey (or your default key) when empty
+    Message string `json:"message"` // tag message format, default "Release {module} {version}"
+}
+
+// pushConfig is the shape of the JSON config file (~/.ncaapushit.json by default)
+type pushConfig struct {
+    Profiles      map[string]profile      `json:"profiles"`
+    Badges        badgeConfig             `json:"badges"`
+    Identity      identity                `json:"identity"`
+    Git           gitConfig               `json:"git"`
+    Lock          lockConfig              `json:"lock"`
+    Signing       signing                 `json:"signing"`
+    History       historyConfig           `json:"history"`
+    Confluence    confluenceConfig        `json:"confluence"`
+    Bitbucket     bitbucketConfig         `json:"bitbucket"`
+    Jira          jiraConfig              `json:"jira"`
+    SMTP          smtpConfig              `json:"smtp"`
+    Slack         slackConfig             `json:"slack"`
+    ReleaseNotes  releaseNotesConfig      `json:"releaseNotes"`
+    Features      featuresConfig          `json:"features"`
+    Time          timeConfig              `json:"time"`
+    Serve         serveConfig             `json:"serve"`
+    Build         buildConfig             `json:"build"`
+    FeatureEnvs   featureEnvConfig        `json:"featureEnvs"`
+    Digest        digestConfig            `json:"digest"`
+    TagRetention  retentionConfig         `json:"tagRetention"`
+    Maintenance   maintenanceConfig       `json:"maintenance"`
+    Canary        canaryConfig            `json:"canary"`
+    Hooks         map[string][]string     `json:"hooks"`         // stage (eg. post-push) -> commands, run with sh -c in the module repo
+    Owners        map[string][]string     `json:"owners"`        // module -> owner email addresses
+    Remotes       map[string]remoteConfig `json:"remotes"`       // module (or "*" for every module) -> the remote it is tagged on
+    Tags          map[string]tagConfig    `json:"tags"`          // module (or "*" for every module) -> how its version tags are named
+    Groups        map[string]groupConfig  `json:"groups"`        // name -> modules that are always released together
+    Compatibility []compatRule            `json:"compatibility"` // rules the site's pins must satisfy after every change
+    FirstVersion  string                  `json:"firstVersion"`  // what a module without version tags is first released as, 1.0.0 when empty
+    StaleDays     int                     `json:"staleDays"`     // a patch release this long after the last release is warned about, 90 when empty, -1 for never
+    SiteRemote    string                  `json:"siteRemote"`    // the site repo's remote, origin when empty
+}
+
+var config pushConfig
+
+// configPath returns the config file to read: --config, then $NCAA_PUSHIT_CONFIG, then the default.
+// explicit says whether it was asked for rather than defaulted to.
+func configPath() (path string, explicit bool) {
+    path = configOpt
+    explicit = path != optionsMap["config"]["default"]
+
+    if !explicit {
+        if envConfig := os.Getenv("NCAA_PUSHIT_CONFIG"); envConfig != "" {
+            path, explicit = expandPath(envConfig), true
+        }
+    }
+
+    return path, explicit
+}
+
+// loadConfig reads the config file if there is one. A missing file is only an error
+// when the path was given explicitly.
+func loadConfig() error {
+    path, explicit := configPath()
+
+    contents, err := ioutil.ReadFile(path)
+
+    if err != nil {
+        if os.IsNotExist(err) && !explicit {
+            return nil
+        }
+        return &pushError{"There was a problem reading the config file @ " + path}
+    }
+
+    if err = json.Unmarshal(contents, &config); err != nil {
+        return &pushError{"The config file @ " + path + " is not valid JSON: " + err.Error()}
+    }
+
+    for module, remote := range config.Remotes {
+        if _, err := regexp.Compile(remote.URL); err != nil {
+            return &pushError{"The url of the " + module + " remote in the config file @ " + path + " is not a valid regular expression: " + err.Error()}
+        }
+    }
+
+    for module, tags := range config.Tags {
+        if _, err := regexp.Compile(tags.Policy); err != nil {
+            return &pushError{"The tag policy of " + module + " in the config file @ " + path + " is not a valid regular expression: " + err.Error()}
+        }
+    }
+
+    notes := config.ReleaseNotes
+    named := []string{notes.Confluence, notes.Slack, notes.Email.Audience}
+    for audience := range notes.Templates {
+        named = append(named, audience)
+    }
+
+    for _, audience := range named {
+        if audience != "" && closest(audience, audiences) != audience {
+            return &pushError{"Unknown release notes audience '" + audience + "' in the config file @ " + path + ". Audiences: " + strings.Join(audiences, ", ")}
+        }
+    }
+
+    for stage := range config.Hooks {
+        if known := closest(stage, hookStages); known != stage {
+            problem := "Unknown hook stage '" + stage + "' in the config file @ " + path + "."
+            if known != "" {
+                problem += " Did you mean '" + known + "'?"
+            }
+            return &pushError{problem + " Stages: " + strings.Join(hookStages, ", ")}
+        }
+    }
+
+    switch config.Git.Backend {
+    case "", "binary", "go-git":
+    default:
+        return &pushError{"Unknown git backend '" + config.Git.Backend + "' in the config file @ " + path + ". Use binary or go-git."}
+    }
+
+    return nil
+}
+
+// flagsSet returns the names of the options that were explicitly passed on the command-line
+func flagsSet() map[string]bool {
+    set := map[string]bool{}
+    flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+    return set
+}
+
+// applyProfile overrides site options with the values from the selected profile. Options
+// passed explicitly on the command-line still win.
+func applyProfile() error {
+    if profileOpt == "" {
+        return nil
+    }
+
+    selected, ok := config.Profiles[profileOpt]
+
+    if !ok {
+        var names []string
+        for name := range config.Profiles {
+            names = append(names, name)
+        }
+        sort.Strings(names)
+
+        if len(names) == 0 {
+            return &pushError{"Unknown profile '" + profileOpt + "'. No profiles are defined in your config file."}
+        }
+        problem := "Unknown profile '" + profileOpt + "'."
+        if suggestion := closest(profileOpt, byUsage(names)); suggestion != "" {
+            problem += " Did you mean '" + suggestion + "'?"
+        }
+        return &pushError{problem + " Available profiles: " + strings.Join(names, ", ")}
+    }
+
+    set := flagsSet()
+
+    if selected.SiteRepo != "" && !set["site-repo"] && !set["r"] {
+        siteRepoOpt = expandPath(selected.SiteRepo)
+    }
+
+    if selected.SiteMakefile != "" && !set["site-makefile"] {
+        siteMakeOpt = selected.SiteMakefile
+    }
+
+    if selected.SiteBranch != "" && !set["site-branch"] {
+        siteBranch = selected.SiteBranch
+    }
+
+    if selected.SiteUpdater != "" && !set["site-updater"] {
+        if _, ok := siteUpdaters[selected.SiteUpdater]; !ok {
+            return &pushError{"Unknown siteUpdater '" + selected.SiteUpdater + "' in profile '" + profileOpt + "'. Use drush, composer, manifest, json or kubernetes."}
+        }
+        siteUpdaterOpt = selected.SiteUpdater
+    }
+
+    if selected.CommitFormat != "" {
+        commitFormat = selected.CommitFormat
+    }
+
+    tagNamespace = strings.Trim(selected.TagNamespace, "/")
+    backMergeTo = selected.BackMerge
+
+    return nil
+}
+
+// formatTagMsg renders the message of a signed tag from the configured format
+func formatTagMsg(module, latest, newVersion string) string {
+    format := config.Signing.Message
+    if format == "" {
+        format = "Release {module} {version}"
+    }
+
+    return strings.NewReplacer(
+        "{topic}", topicOpt,
+        "{module}", module,
+        "{old}", latest,
+        "{version}", newVersion,
+        "{tag}", tagName(newVersion),
+    ).Replace(format)
+}
+
+// formatCommitMsg renders the site repo commit message from the configured format
+func formatCommitMsg(module, latest, newVersion string) string {
+    return strings.NewReplacer(
+        "{topic}", topicOpt,
+        "{module}", module,
+        "{old}", latest,
+        "{version}", newVersion,
+    ).Replace(commitFormat)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+    "encoding/base64"
+    "html"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// confluenceConfig says where release notes are published (nothing is published without a baseUrl)
+type confluenceConfig struct {
+    BaseURL  string `json:"baseUrl"`  // eg. https://wiki.turner.com
+    Space    string `json:"space"`    // space key, eg. NCAA
+    ParentID string `json:"parentId"` // page new release notes pages are created under
+    Title    string `json:"title"`    // page title format, default "{module} release notes"
+    User     string `json:"user"`     // with token, uses basic auth (Confluence Cloud)
+    Token    string `json:"token"`    // on its own, used as a personal access token
+}
+
+// confluencePage is the part of the Confluence content API we use
+type confluencePage struct {
+    ID        string                 `json:"id,omitempty"`
+    Type      string                 `json:"type"`
+    Title     string                 `json:"title"`
+    Space     map[string]string      `json:"space,omitempty"`
+    Ancestors []map[string]string    `json:"ancestors,omitempty"`
+    Version   *confluenceVersion     `json:"version,omitempty"`
+    Body      map[string]storageBody `json:"body"`
+}
+
+type confluenceVersion struct {
+    Number int `json:"number"`
+}
+
+type storageBody struct {
+    Value          string `json:"value"`
+    Representation string `json:"representation"`
+}
+
+func (c confluenceConfig) auth(req *http.Request) {
+    if c.User != "" {
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Token)))
+    } else if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.Token)
+    }
+}
+
+// publishReleaseNotes adds the notes to the top of the module's release notes page, creating the
+// page under the configured parent the first time
+func publishReleaseNotes(module, notes string) error {
+    cfg := config.Confluence
+
+    if cfg.BaseURL == "" {
+        return nil
+    }
+
+    title := strings.Replace(cfg.Title, "{module}", module, -1)
+    if cfg.Title == "" {
+        title = module + " release notes"
+    }
+
+    api := strings.TrimSuffix(cfg.BaseURL, "/") + "/rest/api/content"
+    body := markdownToStorage(notes)
+
+    var found struct {
+        Results []confluencePage `json:"results"`
+    }
+
+    query := url.Values{"spaceKey": {cfg.Space}, "title": {title}, "expand": {"body.storage,version"}}
+    if err := callAPI("GET", api+"?"+query.Encode(), cfg.auth, nil, &found); err != nil {
+        return err
+    }
+
+    if len(found.Results) == 0 {
+        page := confluencePage{
+            Type:  "page",
+            Title: title,
+            Space: map[string]string{"key": cfg.Space},
+            Body:  map[string]storageBody{"storage": {body, "storage"}},
+        }
+
+        if cfg.ParentID != "" {
+            page.Ancestors = []map[string]string{{"id": cfg.ParentID}}
+        }
+
+        if err := callAPI("POST", api, cfg.auth, page, nil); err != nil {
+            return err
+        }
+    } else {
+        page := found.Results[0]
+        number := 1
+
+        if page.Version != nil {
+            number = page.Version.Number + 1
+        }
+
+        page.Version = &confluenceVersion{number}
+        page.Body = map[string]storageBody{"storage": {body + page.Body["storage"].Value, "storage"}}
+        page.Space, page.Ancestors = nil, nil
+
+        if err := callAPI("PUT", api+"/"+page.ID, cfg.auth, page, nil); err != nil {
+            return err
+        }
+    }
+
+    summary.Notified = append(summary.Notified, "confluence ("+title+")")
+
+    return nil
+}
+
+// markdownToStorage converts the small subset of markdown our release notes use (headings and
+// nested lists) to Confluence storage format
+func markdownToStorage(markdown string) string {
+    var (
+        b     strings.Builder
+        depth int
+    )
+
+    closeLists := func(to int) {
+        for ; depth > to; depth-- {
+            b.WriteString("</li></ul>")
+        }
+    }
+
+    for _, line := range strings.Split(markdown, "\n") {
+        trimmed := strings.TrimLeft(line, " ")
+
+        switch {
+        case strings.HasPrefix(trimmed, "- "):
+            level := (len(line)-len(trimmed))/2 + 1
+
+            if level > depth {
+                for ; depth < level; depth++ {
+                    b.WriteString("<ul><li>")
+                }
+            } else {
+                closeLists(level)
+                b.WriteString("</li><li>")
+            }
+
+            b.WriteString(linkTickets(html.EscapeString(strings.TrimPrefix(trimmed, "- ")), "html"))
+        case strings.HasPrefix(trimmed, "#"):
+            closeLists(0)
+            level := len(trimmed) - len(strings.TrimLeft(trimmed, "#"))
+            tag := "h" + string(rune('0'+level))
+            b.WriteString("<" + tag + ">" + html.EscapeString(strings.TrimSpace(trimmed[level:])) + "</" + tag + ">")
+        case trimmed != "":
+            closeLists(0)
+            b.WriteString("<p>" + linkTickets(html.EscapeString(trimmed), "html") + "</p>")
+        }
+    }
+
+    closeLists(0)
+
+    return b.String()
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
+)
+
+// debounceQueue is shared by runs against the same site repo while one of them (the holder)
+// holds the site push open for others to join
+type debounceQueue struct {
+    Holder  int       `json:"holder"`
+    Until   time.Time `json:"until"`
+    Entries []string  `json:"entries"` // eg. "ncaa_scores 1.2.3 -> 1.2.4"
+}
+
+// debounceResult is how a held push went, for the runs that joined it
+type debounceResult struct {
+    Pushed bool   `json:"pushed"`
+    Error  string `json:"error,omitempty"`
+}
+
+func queuePath() string {
+    return filepath.Join(siteRepoOpt, ".git", "ncaapushit-debounce.json")
+}
+
+// resultPath is where the holder of the queue leaves its result
+func (q debounceQueue) resultPath() string {
+    return filepath.Join(siteRepoOpt, ".git", fmt.Sprintf("ncaapushit-debounce-%d-%d.json", q.Holder, q.Until.UnixNano()))
+}
+
+// holderAlive says whether the run holding the queue is still running
+func (q debounceQueue) holderAlive() bool {
+    holder, err := os.FindProcess(q.Holder)
+    return err == nil && holder.Signal(syscall.Signal(0)) == nil
+}
+
+// loadQueue reads the queue and says whether a live holder is still waiting on it
+func loadQueue() (debounceQueue, bool) {
+    var q debounceQueue
+
+    contents, err := ioutil.ReadFile(queuePath())
+    if err != nil || json.Unmarshal(contents, &q) != nil {
+        return q, false
+    }
+
+    return q, q.holderAlive() && time.Now().Before(q.Until.Add(time.Minute))
+}
+
+func (q debounceQueue) save() error {
+    contents, _ := json.MarshalIndent(q, "", "  ")
+    return ioutil.WriteFile(queuePath(), contents, 0644)
+}
+
+// finish leaves the result of the held push for the runs that joined it, renamed into place so they
+// never read half of it. A result over a day old was read long ago and is cleared away.
+func (q debounceQueue) finish(err error) {
+    result := debounceResult{Pushed: err == nil}
+    if err != nil {
+        result.Error = strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ")
+    }
+
+    old, _ := filepath.Glob(filepath.Join(siteRepoOpt, ".git", "ncaapushit-debounce-*.json"))
+    for _, path := range old {
+        if info, statErr := os.Stat(path); statErr == nil && time.Since(info.ModTime()) > 24*time.Hour {
+            os.Remove(path)
+        }
+    }
+
+    contents, _ := json.Marshal(result)
+    tmp := q.resultPath() + ".tmp"
+
+    if writeErr := ioutil.WriteFile(tmp, contents, 0644); writeErr != nil || os.Rename(tmp, q.resultPath()) != nil {
+        os.Remove(tmp)
+        slog.Warn("could not tell the runs that joined the site push how it went; they will report it as failed")
+    }
+}
+
+// awaitResult waits for the holder of the queue to push, or fail to. A holder that ends without
+// leaving a result (or takes far longer than it should) failed as far as the runs waiting on it know.
+func (q debounceQueue) awaitResult() debounceResult {
+    deadline := q.Until.Add(15 * time.Minute)
+
+    for {
+        var result debounceResult
+
+        contents, err := ioutil.ReadFile(q.resultPath())
+        if err == nil && json.Unmarshal(contents, &result) == nil {
+            return result
+        }
+
+        switch {
+        case !q.holderAlive():
+            // it may have finished between the two checks
+            if contents, err = ioutil.ReadFile(q.resultPath()); err == nil && json.Unmarshal(contents, &result) == nil {
+                return result
+            }
+            return debounceResult{Error: fmt.Sprintf("pid %d ended without saying whether it pushed", q.Holder)}
+        case time.Now().After(deadline):
+            return debounceResult{Error: fmt.Sprintf("pid %d still hadn't pushed by %s", q.Holder, deadline.Format("15:04:05"))}
+        }
+
+        time.Sleep(500 * time.Millisecond)
+    }
+}
+
+// publishDebounced either joins a site push that another run is holding open and waits for it, or
+// holds one open itself for the debounce window and then pushes every release that joined it at once.
+// Once a release is in a held push, a failure leaves its tag and site commit for the push by hand.
+func (r *release) publishDebounced() error {
+    entry := fmt.Sprintf("%s %s -> %s", r.module, r.latest, r.version)
+
+    if q, active := loadQueue(); active {
+        return r.joinDebounced(q, entry)
+    }
+
+    q := debounceQueue{Holder: os.Getpid(), Until: time.Now().Add(debounceOpt), Entries: []string{entry}}
+
+    if err := q.save(); err != nil {
+        return &pushError{"Could not create the site push queue @ " + queuePath()}
+    }
+
+    fmt.Printf("Holding the site push for %s (until %s) so other releases can join it...\n", debounceOpt, q.Until.Format("15:04:05"))
+    resume := pauseSiteLock(r.module)
+    time.Sleep(debounceOpt)
+
+    // other releases may have committed on top of ours, so the site branch must never be reset from here on
+    r.siteCommitted, r.heldPush = false, true
+
+    if err := resume(); err != nil {
+        q.finish(err)
+        summary.followUp("Push " + siteBranch + " in " + siteRepoOpt + " by hand once the site repo is unlocked.")
+        return err
+    }
+
+    // close the queue before pushing: anything that joined has already committed, anything later holds its own push
+    q, _ = loadQueue()
+    os.Remove(queuePath())
+
+    // CI describes a build by its tip commit, so finish with one that names every release in the push
+    if len(q.Entries) > 1 {
+        if _, err := git(gitc{"commit", "--allow-empty", "-m", coalescedCommitMsg(q.Entries, nil)}, siteRepoOpt); err != nil {
+            slog.Warn("could not add the commit naming every release, pushing without it: " + strings.TrimSpace(err.Error()))
+        }
+    }
+
+    if out, err := pushSite(); err != nil {
+        err = withCode(exitRejected, &pushError{"Could not push the makefile changes to the site repo:\n" + strings.TrimSpace(string(out))})
+        q.finish(err)
+        summary.followUp("Push " + siteBranch + " in " + siteRepoOpt + " by hand; it holds these releases: " + strings.Join(q.Entries, ", "))
+        return err
+    }
+
+    q.finish(nil)
+
+    fmt.Printf("Pushed %d release(s) to the site repo in one push:\n", len(q.Entries))
+    for _, e := range q.Entries {
+        fmt.Println("  -", e)
+    }
+
+    return nil
+}
+
+// joinDebounced adds the release to the site push another run is holding open, then lets go of the
+// site repo (so the holder can take it back to push) until the holder says how the push went
+func (r *release) joinDebounced(q debounceQueue, entry string) error {
+    q.Entries = append(q.Entries, entry)
+
+    if err := q.save(); err != nil {
+        return &pushError{"Could not join the queued site push @ " + queuePath()}
+    }
+
+    // the commit is the holder's to push from here on, whatever happens
+    r.siteCommitted, r.heldPush = false, true
+
+    fmt.Printf("Queued: the site push is being held by another run (pid %d) until %s; waiting for it to push this change...\n", q.Holder, q.Until.Format("15:04:05"))
+
+    resume := pauseSiteLock(r.module)
+    result := q.awaitResult()
+
+    if err := resume(); err != nil {
+        slog.Warn("could not take the site repo lock back: " + strings.TrimSpace(err.Error()))
+    }
+
+    if !result.Pushed {
+        summary.followUp("Push " + siteBranch + " in " + siteRepoOpt + " by hand; it holds " + entry + " (commit " + summary.CommitSHA + ") along with the other releases held with it.")
+        return withCode(exitRejected, &pushError{fmt.Sprintf("The run holding the site push (pid %d) did not push it: %s\n%s stays tagged, and its makefile commit waits in %s to be pushed by hand.", q.Holder, result.Error, r.tag, siteRepoOpt)})
+    }
+
+    fmt.Printf("The site push held by pid %d went through with this change.\n", q.Holder)
+    return nil
+}
+
+// coalescedCommitMsg describes a push holding several releases (entries, with any details below the
+// subject), with a Released-Module trailer for each so the pipeline can list them all rather than
+// "and 4 more commits"
+func coalescedCommitMsg(entries, details []string) string {
+    var modules []string
+
+    for _, e := range entries {
+        modules = append(modules, strings.SplitN(e, " ", 2)[0])
+    }
+
+    msg := fmt.Sprintf("Release %d modules: %s\n\n", len(entries), strings.Join(modules, ", "))
+
+    if len(details) > 0 {
+        msg += strings.Join(details, "\n") + "\n\n"
+    }
+
+    for _, e := range entries {
+        msg += "Released-Module: " + e + "\n"
+    }
+
+    return msg
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+    "time"
+)
+
+// ranTo is what a run printed and its exit code
+type ranTo struct {
+    out  string
+    code int
+}
+
+// heldRelease starts releasing NCAA-1 with --debounce in the background and waits for it to hold the
+// site push open. How it ended comes out of the channel.
+func (f *fixture) heldRelease() <-chan ranTo {
+    f.t.Helper()
+
+    done := make(chan ranTo, 1)
+    go func() {
+        out, code := f.run("--topic", "NCAA-1", "--debounce", "4s")
+        done <- ranTo{out, code}
+    }()
+
+    queue := filepath.Join(f.site, ".git", "ncaapushit-debounce.json")
+    for start := time.Now(); time.Since(start) < 20*time.Second; time.Sleep(50 * time.Millisecond) {
+        if _, err := os.Stat(queue); err == nil {
+            return done
+        }
+
+        select {
+        case result := <-done:
+            f.t.Fatalf("the holder ended (exit %d) before holding the site push:\n%s", result.code, result.out)
+        default:
+        }
+    }
+
+    f.t.Fatal("the holder never held the site push")
+    return nil
+}
+
+func TestDebounceJoinerWaitsForThePush(t *testing.T) {
+    f := newFixture(t)
+    holder := f.heldRelease()
+
+    f.merge("NCAA-2", "NCAA-2 fix: bracket refresh")
+    out := f.mustRun("--topic", "NCAA-2", "--debounce", "4s")
+
+    if !strings.Contains(out, "went through with this change") {
+        t.Errorf("the joiner didn't wait for the push:\n%s", out)
+    }
+
+    if result := <-holder; result.code != 0 {
+        t.Fatalf("the holder exited %d:\n%s", result.code, result.out)
+    }
+
+    if pinned := f.pinned(); pinned != "v1.2.5" {
+        t.Errorf("the makefile pins %s, want v1.2.5", pinned)
+    }
+}
+
+func TestDebounceHolderFails(t *testing.T) {
+    f := newFixture(t)
+    holder := f.heldRelease()
+
+    f.merge("NCAA-2", "NCAA-2 fix: bracket refresh")
+
+    // the site's origin refuses the held push
+    f.write(filepath.Join(f.dir, "site.git", "hooks", "pre-receive"), "#!/bin/sh\necho 'staging is frozen'\nexit 1\n")
+    if err := os.Chmod(filepath.Join(f.dir, "site.git", "hooks", "pre-receive"), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    out, code := f.run("--topic", "NCAA-2", "--debounce", "4s")
+    if code != exitRejected || !strings.Contains(out, "did not push it") {
+        t.Errorf("the joiner exited %d, want %d for the failed push:\n%s", code, exitRejected, out)
+    }
+
+    if result := <-holder; result.code != exitRejected {
+        t.Errorf("the holder exited %d, want %d:\n%s", result.code, exitRejected, result.out)
+    }
+
+    // nothing was undone: both releases are left for the push by hand
+    tags := strings.Join(f.remoteTags(), " ")
+    if !strings.Contains(tags, "v1.2.4") || !strings.Contains(tags, "v1.2.5") {
+        t.Errorf("the module's tags on origin are %s, want v1.2.4 and v1.2.5 kept", tags)
+    }
+
+    // the two makefile commits and the one naming both releases
+    if unpushed := f.git(f.site, "rev-list", "--count", "origin/main..main"); unpushed != "3" {
+        t.Errorf("%s commits wait in the site repo, want 3", unpushed)
+    }
+
+    if pinned := f.pinned(); pinned != "v1.2.3" {
+        t.Errorf("the makefile on origin pins %s, want v1.2.3", pinned)
+    }
+}
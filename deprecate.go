@@ -0,0 +1,167 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+func init() {
+    subcommands["deprecate"] = subcommand{"Tag a final version of a module and mark (or, with --remove-entry, remove) its makefile entry.", deprecate}
+}
+
+// deprecate tags a final version of the module, marks its makefile entry as deprecated (or removes
+// it), records it in the history and lets the owners know
+func deprecate(args []string) error {
+    module, err := getModule()
+    if err != nil {
+        return err
+    }
+
+    if len(args) > 0 && args[0] != module {
+        return &pushError{"Asked to deprecate '" + args[0] + "' but the module repo is '" + module + "'. Use --module to point at the right repo."}
+    }
+
+    makefile, err := getMakefile(module)
+    if err != nil {
+        return err
+    }
+
+    // only drush makefile entries can be marked or removed; other site files just get the final version
+    _, marked := siteUpdaterFor(makefile).(drushUpdater)
+
+    if removeEntryOpt && !marked {
+        return withCode(exitOptions, &pushError{"--remove-entry only works with drush makefiles. Deprecate the module to pin its final version, then remove it from " + siteMakeOpt + " by hand (or with composer remove)."})
+    }
+
+    if autostashOpt {
+        restore, err := autostash(cwd, siteRepoOpt)
+        defer restore()
+
+        if err != nil {
+            return err
+        }
+    }
+
+    if err = runChecks("environment", environmentChecks()); err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err == nil {
+        err = updateModuleRepo()
+    }
+
+    if err != nil {
+        return err
+    }
+    topicOpt = branchOpt
+
+    final, latest, err := bumpLatest(true)
+    if err != nil {
+        return err
+    }
+
+    if latest == "" {
+        return &pushError{module + " has never been released, so there is nothing to deprecate."}
+    }
+
+    if err = runChecks("release", releaseChecks(makefile, module, latest, final)); err != nil {
+        return err
+    }
+
+    summary.Module, summary.OldVersion, summary.NewVersion, summary.Topic = module, latest, final, "deprecate"
+    defer recordHistory()
+
+    action := "marked as deprecated and pinned to the final version"
+    switch {
+    case removeEntryOpt:
+        action = "removed"
+    case !marked:
+        action = "pinned to the final version (only drush makefile entries are marked)"
+    }
+
+    fmt.Printf("\n%s will be tagged with a final version %s and its makefile entry %s.\n", module, tagName(final), action)
+
+    if prompt("Are you sure you want to deprecate this module? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return errAborted
+    }
+
+    date := displayDate(time.Now())
+
+    state = pushState{Time: time.Now(), Module: module, ModuleDir: cwd, Remote: remoteFor(cwd), Tag: tagName(final), SiteRepo: siteRepoOpt, SiteBranch: siteBranch}
+
+    rel := &release{
+        module:     module,
+        latest:     latest,
+        version:    final,
+        tag:        tagName(final),
+        annotation: "Final release: " + module + " was deprecated on " + date + ".",
+        commitMsg:  "\nDEPRECATED: " + module + " -> " + final + " (final)",
+        updateMakefile: func(makefile string) ([]string, error) {
+            return deprecateMakefile(makefile, module, latest, final, date)
+        },
+    }
+
+    if err = rel.run(makefile); err != nil {
+        return err
+    }
+
+    summary.Outcome = "deprecated"
+
+    notifyOwners(module, module+" has been deprecated",
+        fmt.Sprintf("%s was deprecated by %s on %s.\n\nThe final version is %s and its makefile entry in %s was %s.",
+            module, usr.Username, date, tagName(final), siteMakeOpt, action))
+
+    fmt.Printf("\n%s has been deprecated (final version %s).\n", module, tagName(final))
+    summary.print()
+
+    return nil
+}
+
+// deprecateMakefile pins the module to its final version and marks its entry with a DEPRECATED
+// comment, or with --remove-entry drops the entry and leaves a comment in its place
+func deprecateMakefile(makefile, module, latest, final, date string) ([]string, error) {
+    lines, err := getUpdatedMakefile(makefile, module, final, latest)
+    if _, marked := siteUpdaterFor(makefile).(drushUpdater); err != nil || !marked {
+        return lines, err
+    }
+
+    // the lines of the module's entry (in YAML, its key and everything nested under it)
+    entry := map[int]bool{}
+    for _, e := range pushit.ParseMakeLines(makefile, lines) {
+        if len(e.Keys) > 1 && e.Keys[0] == "projects" && e.Keys[1] == module {
+            entry[e.Line] = true
+        }
+    }
+
+    var outFile []string
+    marked := false
+    comment := pushit.MakeComment(makefile)
+
+    for i, line := range lines {
+        isEntry := entry[i]
+
+        if isEntry && !marked {
+            indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+
+            if removeEntryOpt {
+                outFile = append(outFile, fmt.Sprintf("%s%s %s was deprecated on %s (final version %s) and removed from the makefile.", indent, comment, module, date, tagName(final)))
+            } else {
+                outFile = append(outFile, fmt.Sprintf("%s%s DEPRECATED: %s was deprecated on %s. %s is its final version.", indent, comment, module, date, tagName(final)))
+            }
+            marked = true
+        }
+
+        if isEntry && removeEntryOpt {
+            continue
+        }
+
+        outFile = append(outFile, line)
+    }
+
+    return outFile, nil
+}
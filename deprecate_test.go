@@ -0,0 +1,54 @@
+package main
+
+import (
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestDeprecateIdleModule(t *testing.T) {
+    f := newFixture(t)
+
+    // released, so nothing has been merged since its latest version: the usual retired module
+    f.mustRun()
+    f.git(f.module, "checkout", "-q", "main")
+    f.git(f.module, "pull", "-q", "--ff-only")
+
+    f.mustRun("deprecate", "ncaa_scores")
+
+    if pin := f.pinned(); pin != "v1.2.5" {
+        t.Errorf("makefile pins %s, want the final version v1.2.5", pin)
+    }
+
+    makefile := f.git(f.site, "show", "origin/main:barcelona.make")
+    if !strings.Contains(makefile, "; DEPRECATED: ncaa_scores was deprecated on") || !strings.Contains(makefile, "v1.2.5 is its final version.") {
+        t.Errorf("the makefile entry is not marked as deprecated:\n%s", makefile)
+    }
+
+    tags := f.remoteTags()
+    if tags[len(tags)-1] != "v1.2.5" {
+        t.Errorf("tags on origin = %v, want the final v1.2.5", tags)
+    }
+}
+
+func TestDeprecateNamespacedModule(t *testing.T) {
+    f := newFixture(t)
+    f.write(f.config, `{"profiles": {"staging": {"tagNamespace": "staging"}}}`+"\n")
+
+    f.git(f.module, "tag", "staging/v1.2.3", "v1.2.3")
+    f.git(f.module, "push", "-q", "origin", "staging/v1.2.3")
+    f.write(filepath.Join(f.site, "barcelona.make"), strings.Replace(fixtureMakefile, `"v1.2.3"`, `"staging/v1.2.3"`, 1))
+    f.git(f.site, "commit", "-qam", "Pin the staging tag")
+    f.git(f.site, "push", "-q", "origin", "main")
+
+    out := f.mustRun("deprecate", "ncaa_scores", "--profile", "staging")
+
+    if !strings.Contains(out, "final version staging/v1.2.4") {
+        t.Errorf("deprecate doesn't name the final tag staging/v1.2.4:\n%s", out)
+    }
+
+    makefile := f.git(f.site, "show", "origin/main:barcelona.make")
+    if !strings.Contains(makefile, "staging/v1.2.4 is its final version.") {
+        t.Errorf("the makefile comment doesn't name the final tag staging/v1.2.4:\n%s", makefile)
+    }
+}
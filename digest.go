@@ -0,0 +1,141 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+)
+
+func init() {
+    subcommands["digest"] = subcommand{"Send the digest of the last day's releases (from the history store) to the configured Slack channel and email addresses, eg. from cron.", digest}
+}
+
+// digestConfig is where the daily digest of releases is sent
+type digestConfig struct {
+    WebhookURL string   `json:"webhookUrl"` // Slack incoming webhook, the slack one when empty
+    Channel    string   `json:"channel"`    // overrides the webhook's default channel, eg. #ncaa-release-digest
+    Email      []string `json:"email"`      // addresses the digest is emailed to (through the smtp server)
+    At         string   `json:"at"`         // time of day (eg. 09:00, in the configured zone) ncaapushit serve sends it at, never when empty
+}
+
+// released says whether a history record is a release that reached the site, rather than an attempt
+func released(record historyRecord) bool {
+    switch record.Outcome {
+    case "success", "added", "deprecated", "finalized":
+        return true
+    }
+
+    return false
+}
+
+// digestText lists the releases recorded since the time, a line for each with the operator and
+// environment, grouped by environment
+func digestText(records []historyRecord, since time.Time) string {
+    byEnvironment := map[string][]string{}
+
+    for _, r := range records {
+        if r.Time.Before(since) || !released(r) {
+            continue
+        }
+
+        environment := r.Profile
+        if environment == "" {
+            environment = "default"
+        }
+
+        line := fmt.Sprintf("%s  %s %s -> %s by %s", displayTime(r.Time), r.Module, valueOrNone(r.OldVersion), r.NewVersion, r.User)
+        if r.Topic != "" {
+            line += " (" + r.Topic + ")"
+        }
+        if r.Outcome != "success" {
+            line += " [" + r.Outcome + "]"
+        }
+
+        byEnvironment[environment] = append(byEnvironment[environment], line)
+    }
+
+    text := fmt.Sprintf("Releases since %s:\n", displayTime(since))
+
+    if len(byEnvironment) == 0 {
+        return text + "\nNothing was released."
+    }
+
+    var environments []string
+    for environment := range byEnvironment {
+        environments = append(environments, environment)
+    }
+    sort.Strings(environments)
+
+    for _, environment := range environments {
+        text += "\n" + environment + ":\n  " + strings.Join(byEnvironment[environment], "\n  ") + "\n"
+    }
+
+    return text
+}
+
+// sendDigest sends the digest of the day up to now to everywhere it is configured to go
+func sendDigest() error {
+    cfg := config.Digest
+
+    webhook := cfg.WebhookURL
+    if webhook == "" {
+        webhook = config.Slack.WebhookURL
+    }
+
+    if webhook == "" && len(cfg.Email) == 0 {
+        return withCode(exitOptions, &pushError{"Nowhere to send the digest; set digest.webhookUrl (or slack.webhookUrl) or digest.email in the config file."})
+    }
+
+    store, err := newHistoryStore()
+    if err != nil {
+        return err
+    }
+
+    records, err := store.List()
+    if err != nil {
+        return err
+    }
+
+    now := time.Now()
+    text := digestText(records, now.Add(-24*time.Hour))
+
+    var failed []string
+
+    if webhook != "" {
+        payload := map[string]string{"text": text}
+        if cfg.Channel != "" {
+            payload["channel"] = cfg.Channel
+        }
+
+        if err := callAPI("POST", webhook, nil, payload, nil); err != nil {
+            failed = append(failed, "Slack: "+strings.TrimSpace(err.Error()))
+        }
+    }
+
+    if len(cfg.Email) > 0 {
+        if err := sendEmail(cfg.Email, "ncaapushit: releases up to "+displayDate(now), text); err != nil {
+            failed = append(failed, strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: "))
+        }
+    }
+
+    if len(failed) > 0 {
+        return &pushError{"Could not send the release digest:\n  " + strings.Join(failed, "\n  ")}
+    }
+
+    return nil
+}
+
+// digest sends the digest once, for running from cron
+func digest(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit digest [options]"})
+    }
+
+    if err := sendDigest(); err != nil {
+        return err
+    }
+
+    fmt.Println("Sent the release digest.")
+    return nil
+}
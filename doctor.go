@@ -0,0 +1,286 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+func init() {
+    subcommands["doctor"] = subcommand{"Check that this machine can release: git, the remotes, the site repo and makefile, write permissions and the config file, with a fix for each problem.", doctor}
+}
+
+// diagnosis is the outcome of one of the doctor's checks
+type diagnosis struct {
+    check  string
+    status string // ok, fail or skip
+    detail string
+    fix    string // what to do about a failure (or a skip)
+}
+
+// doctorPassed, doctorFailed and doctorSkipped make the diagnosis of a check
+func doctorPassed(check, detail string) diagnosis {
+    return diagnosis{check, "ok", detail, ""}
+}
+
+func doctorFailed(check, detail, fix string) diagnosis {
+    return diagnosis{check, "fail", detail, fix}
+}
+
+func doctorSkipped(check, detail, fix string) diagnosis {
+    return diagnosis{check, "skip", detail, fix}
+}
+
+// errorLine returns the first line of an error, without the fatal: prefix
+func errorLine(err error) string {
+    return strings.SplitN(strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: "), "\n", 2)[0]
+}
+
+// doctorConfig loads the config file and applies the profile, as setup does, and says how that went
+func doctorConfig() diagnosis {
+    path, explicit := configPath()
+
+    if _, err := os.Stat(path); os.IsNotExist(err) && !explicit {
+        return doctorPassed("config file", "none at "+path+", so the defaults are used")
+    }
+
+    if err := loadConfig(); err != nil {
+        return doctorFailed("config file", errorLine(err), "Correct "+path+" (it must be valid JSON, in the shape described in the README), or point --config (or $NCAA_PUSHIT_CONFIG) at the right file.")
+    }
+
+    if err := applyProfile(); err != nil {
+        return doctorFailed("config file", errorLine(err), "Pick one of the profiles in "+path+" with --profile, or add the profile to it.")
+    }
+
+    if profileOpt != "" {
+        return doctorPassed("config file", path+", profile "+profileOpt)
+    }
+
+    return doctorPassed("config file", path)
+}
+
+// doctorGit checks the git client's version
+func doctorGit() diagnosis {
+    if err := requireGit(); err != nil {
+        return doctorFailed("git", errorLine(err), fmt.Sprintf("Install git %d.%d or newer (eg. brew upgrade git, or your package manager) or point git.path in the config file at one.", minGitVersion[0], minGitVersion[1]))
+    }
+
+    out, _ := gitCheck(gitc{"version"}, "")
+
+    return doctorPassed("git", fmt.Sprintf("%s (%d.%d or newer is needed)", out, minGitVersion[0], minGitVersion[1]))
+}
+
+// doctorRemote checks that the repo's remote can be reached with the credentials at hand, without
+// waiting on a password or passphrase prompt
+func doctorRemote(check, dir string) diagnosis {
+    url, err := remoteURL(dir)
+    if err != nil {
+        return doctorFailed(check, errorLine(err), "Add the remote with git remote add "+remoteFor(dir)+" <url> in "+dir+".")
+    }
+
+    // once, without gitTry's retries: an answer now is what matters
+    out, err := runGit(gitc{"ls-remote", "--heads", remoteFor(dir)}, dir)
+    if err == nil {
+        return doctorPassed(check, remoteFor(dir)+" is "+url)
+    }
+
+    detail := remoteFor(dir) + " (" + url + ") can't be reached: " + firstLine(out)
+
+    if strings.HasPrefix(url, "ssh://") || (strings.Contains(url, "@") && !strings.Contains(url, "://")) {
+        host := url
+        if i := strings.Index(host, "://"); i >= 0 {
+            host = host[i+3:]
+        }
+        host = strings.SplitN(strings.SplitN(host, ":", 2)[0], "/", 2)[0]
+
+        return doctorFailed(check, detail, "Load your SSH key (ssh-add ~/.ssh/id_rsa) and make sure it is added to your account on the server; ssh -T "+host+" tells whether it is accepted.")
+    }
+
+    return doctorFailed(check, detail, "Check the URL and your credentials for it (a credential helper or access token), and that you can reach the server from this machine.")
+}
+
+// doctorUp checks the site branch can be brought up to date the way every release starts: the fetch
+// and fast-forward that replaced the `git up` alias
+func doctorUp() diagnosis {
+    remote := remoteFor(siteRepoOpt)
+
+    if _, err := gitCheck(gitc{"rev-parse", "--verify", "--quiet", "refs/remotes/" + remote + "/" + siteBranch}, siteRepoOpt); err != nil {
+        return doctorFailed("git up", remote+"/"+siteBranch+" is not in the site repo",
+            "Run git fetch "+remote+" in "+siteRepoOpt+", or set the site branch (siteBranch in the profile, or $NCAA_BARCA_SITE_BRANCH) to one that exists.")
+    }
+
+    if _, err := gitCheck(gitc{"rev-parse", "--verify", "--quiet", "refs/heads/" + siteBranch}, siteRepoOpt); err != nil {
+        return doctorPassed("git up", siteBranch+" will be created from "+remote+"/"+siteBranch+" (the git up alias isn't needed)")
+    }
+
+    out, err := gitCheck(gitc{"rev-list", "--left-right", "--count", siteBranch + "..." + remote + "/" + siteBranch}, siteRepoOpt)
+    if err != nil {
+        return doctorFailed("git up", errorLine(err), "Check the site repo with git status in "+siteRepoOpt+".")
+    }
+
+    if counts := strings.Fields(out); len(counts) == 2 && counts[0] != "0" {
+        return doctorFailed("git up", siteBranch+" has "+counts[0]+" commit(s) that aren't on "+remote+", so it can't be fast-forwarded",
+            "Push them, or drop them with git reset --keep "+remote+"/"+siteBranch+" (on "+siteBranch+") in "+siteRepoOpt+".")
+    }
+
+    return doctorPassed("git up", siteBranch+" fast-forwards to "+remote+"/"+siteBranch+" (the git up alias isn't needed)")
+}
+
+// writable says whether files can be created in the directory
+func writable(dir string) error {
+    f, err := ioutil.TempFile(dir, ".ncaapushit-doctor-")
+    if err != nil {
+        return err
+    }
+
+    f.Close()
+    return os.Remove(f.Name())
+}
+
+// doctorWritable checks every place a release writes to: the repos' git directories (commits, tags
+// and the site lock's checkout), the makefile and the home directory (the push state and history)
+func doctorWritable(moduleDir, makefile string) diagnosis {
+    var problems []string
+
+    dirs := []string{filepath.Join(siteRepoOpt, ".git"), filepath.Dir(statePath())}
+    if moduleDir != "" {
+        dirs = append(dirs, filepath.Join(moduleDir, ".git"))
+    }
+
+    // what doesn't exist has failed its own check already
+    for _, dir := range dirs {
+        if _, err := os.Stat(dir); err == nil && writable(dir) != nil {
+            problems = append(problems, dir)
+        }
+    }
+
+    if f, err := os.OpenFile(makefile, os.O_WRONLY, 0); err == nil {
+        f.Close()
+    } else if !os.IsNotExist(err) {
+        problems = append(problems, makefile)
+    }
+
+    if len(problems) > 0 {
+        return doctorFailed("permissions", "can't write to "+strings.Join(problems, ", "),
+            "Give "+usr.Username+" write access (eg. sudo chown -R "+usr.Username+" <path>), or clone the repos somewhere you own.")
+    }
+
+    return doctorPassed("permissions", "the repos, the makefile and "+filepath.Dir(statePath())+" are writable")
+}
+
+// doctor checks everything a release depends on and prints what to do about each problem. Unlike
+// the other commands it is run without setup, so a broken config file or git is reported rather
+// than stopping it.
+func doctor(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit doctor [options]"})
+    }
+
+    setupLogging()
+    expandPathOptions()
+
+    // ** a remote that asks for a password or passphrase fails the check instead of waiting on it
+    os.Setenv("GIT_TERMINAL_PROMPT", "0")
+    if os.Getenv("GIT_SSH_COMMAND") == "" {
+        os.Setenv("GIT_SSH_COMMAND", "ssh -o BatchMode=yes -o ConnectTimeout=10")
+    }
+
+    var results []diagnosis
+
+    if err := validateOptions(); err != nil {
+        results = append(results, doctorFailed("options", errorLine(err), "Correct the options given on the command-line."))
+    }
+
+    results = append(results, doctorConfig())
+
+    applyEnvOptions()
+    applyGitConfig()
+
+    git := doctorGit()
+    results = append(results, git)
+
+    if git.status != "ok" {
+        return reportDiagnoses(results)
+    }
+
+    // ** the site repo and makefile
+    siteRepo := true
+
+    if top, err := gitCheck(gitc{"rev-parse", "--show-toplevel"}, siteRepoOpt); err != nil || !sameDir(top, siteRepoOpt) {
+        siteRepo = false
+        results = append(results, doctorFailed("site repo", siteRepoOpt+" is not the top of a git repo",
+            "Clone the site repo there, or point --site-repo (or $NCAA_BARCA_SITE_REPO_PATH, or siteRepo in the profile) at your clone."))
+    } else {
+        results = append(results, doctorPassed("site repo", siteRepoOpt))
+    }
+
+    makefile := filepath.Join(siteRepoOpt, siteMakeOpt)
+
+    if info, err := os.Stat(makefile); err != nil || info.IsDir() {
+        results = append(results, doctorFailed("site makefile", makefile+" does not exist",
+            "Point --site-makefile (or $NCAA_BARCA_SITE_MAKEFILE, or siteMakefile in the profile) at the makefile, relative to the site repo."))
+    } else if pins, err := pinSet(makefile); err != nil {
+        results = append(results, doctorFailed("site makefile", errorLine(err), "Check "+makefile+" is the makefile the modules are pinned in."))
+    } else {
+        results = append(results, doctorPassed("site makefile", fmt.Sprintf("%s pins %d module(s)", siteMakeOpt, len(pins))))
+    }
+
+    // ** the module repo, when run from one (or pointed at one with --module)
+    moduleDir := moduleOpt
+    if moduleDir == "$PWD" {
+        moduleDir, _ = os.Getwd()
+    }
+
+    if top, err := gitCheck(gitc{"rev-parse", "--show-toplevel"}, moduleDir); err != nil || (siteRepo && sameDir(top, siteRepoOpt)) {
+        moduleDir = ""
+        results = append(results, doctorSkipped("module remote", "not run from a module repo", "Run doctor from a module repo (or with --module) to check its remote too."))
+    } else {
+        moduleDir = top
+        results = append(results, doctorRemote("module remote", moduleDir))
+    }
+
+    if siteRepo {
+        if siteBranch == "" {
+            siteBranch = detectDefaultBranch(siteRepoOpt)
+        }
+
+        results = append(results, doctorRemote("site remote", siteRepoOpt), doctorUp())
+    }
+
+    results = append(results, doctorWritable(moduleDir, makefile))
+
+    return reportDiagnoses(results)
+}
+
+// reportDiagnoses prints a line for each check and the fixes for those that failed
+func reportDiagnoses(results []diagnosis) error {
+    failed := 0
+
+    fmt.Println()
+    for _, d := range results {
+        status := colorize(colorGreen, "ok  ")
+        switch d.status {
+        case "fail":
+            status = colorize(colorRed, "FAIL")
+            failed++
+        case "skip":
+            status = "skip"
+        }
+
+        fmt.Printf("%s  %-14s %s\n", status, d.check, d.detail)
+        if d.fix != "" && d.status == "fail" {
+            fmt.Printf("      %-14s fix: %s\n", "", d.fix)
+        } else if d.fix != "" {
+            fmt.Printf("      %-14s %s\n", "", d.fix)
+        }
+    }
+
+    if failed > 0 {
+        return withCode(exitChecks, &pushError{fmt.Sprintf("%d check(s) failed; fix them and run doctor again.", failed)})
+    }
+
+    fmt.Println("\nEverything a release needs is in place.")
+    return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestDoctorPipedIsPlain(t *testing.T) {
+    f := newFixture(t)
+
+    out, code := f.run("doctor")
+    if code != 0 {
+        t.Fatalf("doctor exited %d:\n%s", code, out)
+    }
+
+    if strings.Contains(out, "\033[") {
+        t.Errorf("doctor colored its output into a pipe:\n%q", out)
+    }
+
+    if !strings.Contains(out, "ok    site remote") {
+        t.Errorf("doctor doesn't report the site remote:\n%s", out)
+    }
+}
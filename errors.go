@@ -0,0 +1,83 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// exit codes, so scripts and CI pipelines wrapping the utility can tell why a run failed
+const (
+    exitFailed   = 1 // anything without a more specific code
+    exitOptions  = 2 // invalid options, config file or profile
+    exitGit      = 3 // a git command failed
+    exitMakefile = 4 // the makefile is missing or doesn't pin the version being replaced
+    exitRejected = 5 // origin rejected a push
+    exitAborted  = 6 // the release was not confirmed
+    exitChecks   = 7 // pre-flight checks failed
+)
+
+// errAborted ends a command the operator chose not to go through with (already reported as "Aborting...")
+var errAborted = &codedError{exitAborted, &pushError{"aborted"}}
+
+// errUpToDate ends a release that an earlier run already finished (already reported)
+var errUpToDate = &pushError{"up to date"}
+
+// unchangedError is a release of a module with nothing new since its latest version, eg. a re-run of
+// a release that already pushed its tag
+type unchangedError struct {
+    latest string
+    *pushError
+}
+
+// codedError is an error that ends the run with a specific exit code
+type codedError struct {
+    code int
+    err  error
+}
+
+func (e *codedError) Error() string {
+    return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+    return e.err
+}
+
+// withCode gives an error the exit code the run should end with (nil stays nil)
+func withCode(code int, err error) error {
+    if err == nil {
+        return nil
+    }
+    return &codedError{code, err}
+}
+
+// gitError is a git command that failed, with what it printed
+type gitError struct {
+    command gitc
+    out     string
+}
+
+func (e *gitError) Error() string {
+    return fmt.Sprintf("\nfatal: there was a problem running the git command '%s':\n%s", strings.Join(e.command, " "), e.out)
+}
+
+// exitCode returns the exit code for an error: its own code if it has one, exitGit for a failed
+// git command and exitFailed otherwise
+func exitCode(err error) int {
+    var (
+        coded  *codedError
+        gitErr *gitError
+    )
+
+    switch {
+    case err == nil:
+        return 0
+    case errors.As(err, &coded):
+        return coded.code
+    case errors.As(err, &gitErr):
+        return exitGit
+    }
+
+    return exitFailed
+}
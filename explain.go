@@ -0,0 +1,268 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+func init() {
+    subcommands["explain"] = subcommand{"Describe what releasing a module would do (config, versions, makefile change, commit, notifications) without changing anything.", explain}
+}
+
+// optionSource says where the value of a site option came from, in the order setup layers them:
+// command line, then profile, then environment, then default
+func optionSource(value string, flags []string, fromProfile, envVar string) string {
+    set := flagsSet()
+
+    for _, name := range flags {
+        if set[name] {
+            return "--" + flags[0]
+        }
+    }
+
+    if fromProfile != "" && value == fromProfile {
+        return "profile " + profileOpt
+    }
+
+    if envVar != "" && os.Getenv(envVar) == value {
+        return "$" + envVar
+    }
+
+    return "default"
+}
+
+// explainLine prints one labelled line of the explanation, with where the value came from (if known)
+func explainLine(label, value, source string) {
+    if value == "" {
+        value = "(none)"
+    }
+
+    if source != "" {
+        value += "  (" + source + ")"
+    }
+
+    fmt.Printf("  %-16s %s\n", label+":", value)
+}
+
+// explainModule points --module at the module named on the command-line: a path, or the name of a
+// repo under --workspace
+func explainModule(args []string) {
+    if len(args) == 0 || flagsSet()["module"] {
+        return
+    }
+
+    moduleOpt = filepath.Join(workspaceOpt, args[0])
+
+    if _, err := os.Stat(args[0]); err == nil {
+        moduleOpt, _ = filepath.Abs(args[0])
+    }
+}
+
+// explain resolves everything a push of the module would use and prints what the release would do.
+// Only local refs are read (as of the last fetch), so nothing is fetched, tagged, committed or sent.
+func explain(args []string) error {
+    explainModule(args)
+
+    module, err := getModule()
+    if err != nil {
+        return err
+    }
+
+    makefile, err := getMakefile(module)
+    if err != nil {
+        return err
+    }
+
+    selected := config.Profiles[profileOpt]
+
+    // ** configuration, and which layer each value came from
+    fmt.Println("\n---------------- explain ----------------")
+    fmt.Println("configuration")
+
+    path, explicit := configPath()
+    if _, err := os.Stat(path); err != nil {
+        path += " (not found, defaults apply)"
+    } else if !explicit {
+        path += " (default)"
+    }
+    explainLine("config file", path, "")
+    explainLine("profile", profileOpt, "")
+    explainLine("site repo", siteRepoOpt, optionSource(siteRepoOpt, []string{"site-repo", "r"}, selected.SiteRepo, "NCAA_BARCA_SITE_REPO_PATH"))
+    explainLine("site makefile", siteMakeOpt, optionSource(siteMakeOpt, []string{"site-makefile"}, selected.SiteMakefile, "NCAA_BARCA_SITE_MAKEFILE"))
+
+    branchSource := optionSource(siteBranch, []string{"site-branch"}, selected.SiteBranch, "NCAA_BARCA_SITE_BRANCH")
+    if siteBranch == "" {
+        siteBranch, branchSource = detectDefaultBranch(siteRepoOpt), "detected from " + remoteFor(siteRepoOpt)
+    }
+    explainLine("site branch", siteBranch, branchSource)
+
+    explainLine("commit format", commitFormat, optionSource(commitFormat, nil, selected.CommitFormat, ""))
+    explainLine("tag prefix", tagPrefix(), "")
+    explainLine("git", strings.TrimSpace(gitBinary+" "+strings.Join(gitArgs, " ")), "")
+
+    // ** where the versions come from
+    fmt.Println("\nmodule")
+
+    explainLine("module", module, "")
+    explainLine("repo", cwd, monorepoModule)
+
+    branchSource = "--default-branch"
+    if branchOpt == "" {
+        branchOpt, branchSource = detectDefaultBranch(cwd), "detected from origin"
+    }
+    explainLine("default branch", branchOpt, branchSource)
+
+    topicSource := "--topic"
+    if topicOpt == "" {
+        out, err := git(gitCommands["branch"], cwd)
+        if err != nil {
+            return err
+        }
+
+        topicOpt = strings.TrimSpace(string(out))
+        topicSource = "current branch"
+
+        if topicOpt == branchOpt {
+            topicSource = "on the default branch, so --topic is needed to push"
+        }
+    }
+    explainLine("topic", topicOpt, topicSource)
+
+    tag, current, err := latestTag(cwd, branchOpt)
+    if err != nil {
+        return err
+    }
+    latest := strings.TrimPrefix(tag, tagPrefix())
+    explainLine("latest tag", tag, "highest version merged into local "+branchOpt)
+
+    var next version
+    versionSource := "--set-version"
+
+    if tag == "" {
+        next, _ = parseVersion(firstVersion())
+        versionSource = "first release, as there are no version tags yet"
+    } else if setVersionOpt != "" {
+        next, _ = parseVersion(strings.TrimPrefix(setVersionOpt, "v"))
+        if next.Compare(current) <= 0 {
+            return &pushError{"--set-version " + next.String() + " must be higher than the latest version " + latest + "."}
+        }
+    } else {
+        column := bumpOpt
+        if column == "auto" {
+            if column, err = detectBump(tag); err != nil {
+                return err
+            }
+        }
+
+        if next, err = bumpVersion(current, column, preOpt); err != nil {
+            return err
+        }
+
+        versionSource = "--bump " + column
+        if preOpt != "" {
+            versionSource += " --pre " + preOpt
+        }
+    }
+
+    newVersion := next.String()
+    explainLine("new version", newVersion, versionSource)
+
+    changes, err := analyzeImpact(tag)
+    if err != nil {
+        return err
+    }
+    explainLine("impact", changes.String(), "")
+
+    // ** the tag
+    fmt.Println("\ntag")
+
+    kind := "lightweight"
+    switch {
+    case signOpt && config.Signing.Key != "":
+        kind = "annotated, signed with key " + config.Signing.Key
+    case signOpt:
+        kind = "annotated, signed with your default key"
+    case changelogOpt:
+        kind = "annotated with the changelog entry"
+    }
+    explainLine(tagName(newVersion), kind, "")
+
+    if signOpt {
+        explainLine("message", formatTagMsg(module, latest, newVersion), "")
+    }
+
+    // ** the site repo change
+    fmt.Println("\nsite repo")
+
+    outFile, err := getUpdatedMakefile(makefile, module, newVersion, latest)
+    if err != nil {
+        return err
+    }
+
+    contents, _ := ioutil.ReadFile(makefile)
+    for i, line := range strings.Split(string(contents), "\n") {
+        if i < len(outFile) && line != outFile[i] {
+            fmt.Printf("  %s:%d\n  - %s\n  + %s\n", makefile, i+1, line, outFile[i])
+            break
+        }
+    }
+
+    fmt.Println("  commit message:")
+    for _, line := range strings.Split(formatCommitMsg(module, latest, newVersion)+"\n\nImpact: "+changes.Level, "\n") {
+        fmt.Println("    " + line)
+    }
+
+    switch {
+    case viaPROpt:
+        explainLine("published", "as a pull request from release/"+module+"-"+newVersion+" into "+siteBranch, "--via-pr")
+    case debounceOpt > 0:
+        explainLine("published", "by the held push to "+remoteFor(siteRepoOpt)+" "+siteBranch+" (joined or held for "+debounceOpt.String()+")", "--debounce")
+    default:
+        explainLine("published", "pushed to "+remoteFor(siteRepoOpt)+" "+siteBranch, "")
+    }
+
+    // ** who hears about it
+    fmt.Println("\nnotifications")
+
+    notConfigured := "not configured"
+
+    slack := notConfigured
+    if config.Slack.WebhookURL != "" {
+        slack = "webhook"
+        if config.Slack.Channel != "" {
+            slack += " to " + config.Slack.Channel
+        }
+    }
+    explainLine("slack", slack, "")
+
+    confluence := notConfigured
+    if config.Confluence.BaseURL != "" {
+        confluence = config.Confluence.BaseURL + " space " + config.Confluence.Space
+    }
+    explainLine("confluence", confluence, "")
+
+    badges := notConfigured
+    if config.Badges.Dir != "" || config.Badges.S3 != "" {
+        badges = strings.TrimSpace(config.Badges.Dir + " " + config.Badges.S3)
+    }
+    explainLine("badges", badges, "")
+
+    history := config.History.Backend
+    if history == "" {
+        history = "file"
+    }
+    explainLine("history", history, "")
+
+    jira := "ticket keys are not linked"
+    if config.Jira.BaseURL != "" {
+        jira = "ticket keys link to " + config.Jira.BaseURL
+    }
+    explainLine("jira", jira, "")
+
+    fmt.Println("-----------------------------------------")
+
+    return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestExplainWithTheProfileAfterTheModule(t *testing.T) {
+    f := newFixture(t)
+    f.write(f.config, `{"profiles": {"qa": {"siteBranch": "main", "commitFormat": "QA {module} {version}"}}}`+"\n")
+
+    // as in the README, with the options after the module's name
+    out := f.mustRun("explain", "ncaa_scores", "--profile", "qa", "--workspace", f.dir)
+
+    // the columns' padding aside
+    shown := strings.Join(strings.Fields(out), " ")
+
+    for _, want := range []string{"profile: qa", "site branch: main (profile qa)", "commit format: QA {module} {version} (profile qa)"} {
+        if !strings.Contains(shown, want) {
+            t.Errorf("explain doesn't show %q:\n%s", want, out)
+        }
+    }
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+func init() {
+    subcommands["feature-env"] = subcommand{"Pin the module to its topic branch's HEAD (not a tag) in a makefile overlay on the ticket's feature environment branch of the site repo, for ad-hoc QA. `feature-env cleanup <ticket>` removes the environment.", featureEnv}
+}
+
+// featureEnvConfig says where per-ticket feature environments build from in the site repo. {topic}
+// is replaced with the topic branch (eg. NCAA-42).
+type featureEnvConfig struct {
+    Branch       string `json:"branch"`       // the site branch the environment builds, "feature/{topic}" when empty
+    Overlay      string `json:"overlay"`      // the makefile it builds, which includes the site makefile, "feature-envs/{topic}.make" when empty
+    Orchestrator string `json:"orchestrator"` // URL told (with a JSON POST) when an environment is updated or cleaned up, so it can build or tear it down
+    Token        string `json:"token"`        // bearer token for the orchestrator
+}
+
+// featureEnvEvent is what the orchestrator is sent
+type featureEnvEvent struct {
+    Action  string `json:"action"` // update or cleanup
+    Topic   string `json:"topic"`
+    Branch  string `json:"branch"`
+    Overlay string `json:"overlay"`
+    Module  string `json:"module,omitempty"`
+    Commit  string `json:"commit,omitempty"` // the module commit the environment builds (update)
+}
+
+// notifyOrchestrator tells the environment orchestrator about a change, if one is configured. It
+// can't undo what was pushed, so the caller only reports a failure.
+func notifyOrchestrator(event featureEnvEvent) error {
+    url := config.FeatureEnvs.Orchestrator
+    if url == "" {
+        return nil
+    }
+
+    if err := callAPI("POST", url, bearerAuth(config.FeatureEnvs.Token), event, nil); err != nil {
+        return &pushError{"Could not tell the environment orchestrator about the " + event.Topic + " " + event.Action + ": " + strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ")}
+    }
+
+    summary.Notified = append(summary.Notified, "environment orchestrator")
+    return nil
+}
+
+// featureEnvPaths are the site branch and overlay makefile of the topic's feature environment
+func featureEnvPaths(topic string) (string, string) {
+    branch, overlay := config.FeatureEnvs.Branch, config.FeatureEnvs.Overlay
+
+    if branch == "" {
+        branch = "feature/{topic}"
+    }
+    if overlay == "" {
+        overlay = "feature-envs/{topic}.make"
+    }
+
+    return strings.Replace(branch, "{topic}", topic, -1), strings.Replace(overlay, "{topic}", topic, -1)
+}
+
+// featureEnv points the ticket's feature environment at the module's topic branch: the overlay
+// makefile on the environment's site branch (created from the site branch the first time) pins the
+// module to the branch and the commit at its HEAD, and is pushed for the environment to build.
+// Other modules already pinned in the overlay (for the same ticket) are left as they are.
+func featureEnv(args []string) error {
+    if len(args) > 0 && args[0] == "cleanup" {
+        return featureEnvCleanup(args[1:])
+    }
+
+    if len(args) > 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit feature-env [options], or ncaapushit feature-env cleanup <ticket>"})
+    }
+
+    module, err := getModule()
+    if err != nil {
+        return err
+    }
+
+    makefile, err := getMakefile(module)
+    if err != nil {
+        return err
+    }
+
+    if _, ok := siteUpdaterFor(makefile).(drushUpdater); !ok || pushit.IsYAMLMakefile(makefile) {
+        return withCode(exitOptions, &pushError{"Feature environments are built from a makefile overlay, which needs an INI drush makefile as the site file."})
+    }
+
+    if err = runChecks("environment", environmentChecks()); err != nil {
+        return err
+    }
+
+    if err = updateModuleRepo(); err != nil {
+        return err
+    }
+
+    out, err := git(gitCommands["branch"], cwd)
+    if err != nil {
+        return err
+    }
+
+    topic := topicOpt
+    if topic == "" {
+        topic = strings.TrimSpace(string(out))
+    }
+
+    if topic == branchOpt || topic == "HEAD" {
+        return withCode(exitOptions, &pushError{"Feature environments are for topic branches. Check out the ticket's branch (or pass it with --topic) and re-run."})
+    }
+
+    // the environment's build fetches the commit, so it has to be on the module's remote
+    head, err := git(gitc{"rev-parse", "refs/heads/" + topic}, cwd)
+    if err != nil {
+        return err
+    }
+    sha := strings.TrimSpace(string(head))
+
+    remote := remoteFor(cwd)
+    pushed, err := gitCheck(gitc{"ls-remote", "--heads", remote, "refs/heads/" + topic}, cwd)
+    if err != nil {
+        return err
+    }
+
+    if !strings.HasPrefix(pushed, sha) {
+        return withCode(exitChecks, &pushError{topic + " at " + sha[:7] + " has not been pushed to " + remote + ". Push it first (git push " + remote + " " + topic + ") so the environment can build it."})
+    }
+
+    url, err := remoteURL(cwd)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := lockSite(module)
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if err = updateSiteRepo(); err != nil {
+        return err
+    }
+
+    envBranch, overlay := featureEnvPaths(topic)
+
+    // carry on from the environment's branch if it has been pushed before, otherwise start it from the site branch
+    start := siteBranch
+    if _, err := gitTry(gitc{"rev-parse", "--verify", "-q", "refs/remotes/" + remoteFor(siteRepoOpt) + "/" + envBranch}, siteRepoOpt); err == nil {
+        start = remoteFor(siteRepoOpt) + "/" + envBranch
+    }
+
+    if _, err = git(gitc{"checkout", "-B", envBranch, start}, siteRepoOpt); err != nil {
+        return err
+    }
+
+    // the environment's branch lives on origin; locally the site branch is checked out again
+    defer func() {
+        defer cleaningUp()()
+
+        if _, err := gitTry(gitc{"checkout", "-f", siteBranch}, siteRepoOpt); err == nil {
+            gitTry(gitc{"branch", "-D", envBranch}, siteRepoOpt)
+        }
+    }()
+
+    path := filepath.Join(siteRepoOpt, overlay)
+
+    current, err := readLines(path)
+    before := current
+
+    if os.IsNotExist(err) {
+        include, _ := filepath.Rel(filepath.Dir(path), makefile)
+        current = []string{
+            "; Feature environment for " + topic + " (managed by ncaapushit feature-env)",
+            "api = 2",
+            "includes[] = \"" + filepath.ToSlash(include) + "\"",
+        }
+    } else if err != nil {
+        return withCode(exitMakefile, &pushError{"Could not read the overlay @ '" + path + "': " + err.Error()})
+    }
+
+    outFile := overlayPins(current, module, url, topic, sha)
+
+    fmt.Printf("\nFeature environment: %s on the site repo's %s\n", overlay, envBranch)
+    fmt.Print(unifiedDiff(overlay, before, outFile))
+
+    commitMsg := fmt.Sprintf("%s %s -> %s@%s (feature environment)", topic, module, topic, sha[:7])
+    previewCommitMsg(commitMsg)
+
+    summary.Module, summary.Topic = module, topic
+    defer recordHistory()
+
+    if prompt("Are you sure you want to push this to " + envBranch + "? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return errAborted
+    }
+
+    if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+        err = ioutil.WriteFile(path, []byte(strings.Join(outFile, "\n")+"\n"), 0644)
+    }
+
+    if err != nil {
+        return &pushError{"Could not write the overlay @ '" + path + "'. Check permissions and try again."}
+    }
+
+    if _, err = git(gitc{"add", "--", overlay}, siteRepoOpt); err == nil {
+        _, err = git(gitc{"commit", "-m", commitMsg, "--", overlay}, siteRepoOpt)
+    }
+
+    if err != nil {
+        gitTry(gitc{"reset", "-q", "--", overlay}, siteRepoOpt)
+        gitTry(gitc{"checkout", "--", overlay}, siteRepoOpt)
+        return err
+    }
+
+    if head, err := git(gitCommands["head"], siteRepoOpt); err == nil {
+        summary.CommitSHA = strings.TrimSpace(string(head))
+    }
+
+    if out, err := gitTry(gitc{"push", remoteFor(siteRepoOpt), envBranch}, siteRepoOpt); err != nil {
+        gitTry(gitc{"reset", "--keep", "HEAD~1"}, siteRepoOpt)
+        summary.CommitSHA = ""
+        return withCode(exitRejected, &pushError{"Could not push " + envBranch + " to the site repo (the local commit was dropped):\n" + strings.TrimSpace(string(out))})
+    }
+
+    summary.Outcome = "success"
+
+    fmt.Printf("\nPushed %s: the %s feature environment now builds %s from %s (%s).\n", envBranch, topic, module, topic, sha[:7])
+    if err := notifyOrchestrator(featureEnvEvent{"update", topic, envBranch, overlay, module, sha}); err != nil {
+        summary.followUp(strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ") + ". Have it build " + envBranch + " by hand.")
+    }
+    summary.print()
+
+    return nil
+}
+
+// overlayPins returns the overlay with the module pinned to the branch and commit, in place of any
+// lines it had for the module before
+func overlayPins(lines []string, module, url, branch, sha string) []string {
+    prefix := "projects[" + module + "]"
+
+    var out []string
+    for _, line := range lines {
+        if !strings.HasPrefix(strings.TrimSpace(line), prefix) {
+            out = append(out, line)
+        }
+    }
+
+    for len(out) > 0 && strings.TrimSpace(out[len(out)-1]) == "" {
+        out = out[:len(out)-1]
+    }
+
+    return append(out, "",
+        prefix+`[type] = "module"`,
+        prefix+`[download][type] = "git"`,
+        prefix+`[download][url] = "`+url+`"`,
+        prefix+`[download][branch] = "`+branch+`"`,
+        prefix+`[download][revision] = "`+sha+`"`)
+}
+
+// featureEnvCleanup removes a ticket's feature environment once it is no longer needed: its branch
+// is deleted from the site repo's origin, or, when environments share a branch, its overlay is
+// removed from it. The orchestrator is then told so it can tear the environment down.
+func featureEnvCleanup(args []string) error {
+    if len(args) != 1 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit feature-env cleanup <ticket>"})
+    }
+    topic := args[0]
+
+    if err := runChecks("environment", siteChecks()); err != nil {
+        return err
+    }
+
+    unlock, err := lockSite("cleanup " + topic)
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if err = updateSiteRepo(); err != nil {
+        return err
+    }
+
+    envBranch, overlay := featureEnvPaths(topic)
+
+    if _, err := gitTry(gitc{"rev-parse", "--verify", "-q", "refs/remotes/" + remoteFor(siteRepoOpt) + "/" + envBranch}, siteRepoOpt); err != nil {
+        return withCode(exitOptions, &pushError{"There is no feature environment for " + topic + ": " + envBranch + " is not on the site repo's " + remoteFor(siteRepoOpt) + "."})
+    }
+
+    // a branch of its own goes with the environment; a shared one only loses its overlay
+    shared := !strings.Contains(config.FeatureEnvs.Branch, "{topic}") && config.FeatureEnvs.Branch != ""
+
+    if shared {
+        fmt.Printf("Feature environment %s: remove %s from %s on the site repo's origin\n", topic, overlay, envBranch)
+    } else {
+        fmt.Printf("Feature environment %s: delete %s (and %s with it) from the site repo's origin\n", topic, envBranch, overlay)
+    }
+
+    if prompt("Are you sure you want to clean up this environment? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        return errAborted
+    }
+
+    if !shared {
+        if out, err := gitTry(gitc{"push", remoteFor(siteRepoOpt), ":refs/heads/" + envBranch}, siteRepoOpt); err != nil {
+            return withCode(exitRejected, &pushError{"Could not delete " + envBranch + " from the site repo:\n" + strings.TrimSpace(string(out))})
+        }
+        gitTry(gitc{"branch", "-D", envBranch}, siteRepoOpt)
+
+        fmt.Printf("\nDeleted %s from the site repo.\n", envBranch)
+    } else {
+        if _, err = git(gitc{"checkout", "-B", envBranch, remoteFor(siteRepoOpt) + "/" + envBranch}, siteRepoOpt); err != nil {
+            return err
+        }
+
+        defer func() {
+            defer cleaningUp()()
+
+            if _, err := gitTry(gitc{"checkout", "-f", siteBranch}, siteRepoOpt); err == nil {
+                gitTry(gitc{"branch", "-D", envBranch}, siteRepoOpt)
+            }
+        }()
+
+        if _, err := os.Stat(filepath.Join(siteRepoOpt, overlay)); err != nil {
+            return withCode(exitOptions, &pushError{"There is no feature environment for " + topic + ": " + overlay + " is not on " + envBranch + "."})
+        }
+
+        if _, err = git(gitc{"rm", "-q", "--", overlay}, siteRepoOpt); err == nil {
+            _, err = git(gitc{"commit", "-m", topic + " feature environment cleaned up", "--", overlay}, siteRepoOpt)
+        }
+
+        if err != nil {
+            gitTry(gitc{"reset", "-q", "--hard"}, siteRepoOpt)
+            return err
+        }
+
+        if out, err := gitTry(gitc{"push", remoteFor(siteRepoOpt), envBranch}, siteRepoOpt); err != nil {
+            return withCode(exitRejected, &pushError{"Could not push the removal of " + overlay + " to " + envBranch + ":\n" + strings.TrimSpace(string(out))})
+        }
+
+        fmt.Printf("\nRemoved %s from %s.\n", overlay, envBranch)
+    }
+
+    if err := notifyOrchestrator(featureEnvEvent{Action: "cleanup", Topic: topic, Branch: envBranch, Overlay: overlay}); err != nil {
+        slog.Warn(strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ") + "; tear the environment down by hand")
+    } else if config.FeatureEnvs.Orchestrator != "" {
+        fmt.Println("The environment orchestrator was told to tear it down.")
+    }
+
+    return nil
+}
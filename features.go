@@ -0,0 +1,55 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os/exec"
+    "strings"
+)
+
+// featuresConfig says how to diff a Features module against the site's active config
+type featuresConfig struct {
+    Command string `json:"command"` // run in the module repo with {module} replaced, eg. "drush @ncaa.local features-diff {module}"
+}
+
+// isFeature says whether the module is managed with the Features module (on the default branch,
+// which is what gets tagged)
+func isFeature(module string) bool {
+    _, err := gitTry(gitc{"cat-file", "-e", branchOpt + ":" + module + ".features.inc"}, cwd)
+    return err == nil
+}
+
+// warnFeatures diffs a Features module before it is tagged. Tagging with unexported config ships a
+// version that shows as overridden the moment it is deployed.
+func warnFeatures(module string) {
+    command := config.Features.Command
+
+    if command == "" || !isFeature(module) {
+        return
+    }
+
+    cmd := exec.Command("sh", "-c", strings.Replace(command, "{module}", module, -1))
+    cmd.Dir = cwd
+    out, err := cmd.CombinedOutput()
+    diff := strings.TrimSpace(string(out))
+
+    if err != nil {
+        slog.Warn("could not diff the feature: " + strings.SplitN(diff, "\n", 2)[0])
+        summary.followUp("Check " + module + " for overridden or unexported components by hand.")
+        return
+    }
+
+    // drush reports a clean feature as being in its default state
+    if diff == "" || strings.Contains(diff, "default state") {
+        return
+    }
+
+    lines := strings.Split(diff, "\n")
+    if len(lines) > 20 {
+        lines = append(lines[:20], fmt.Sprintf("... (%d more lines)", len(lines)-20))
+    }
+
+    fmt.Println("\nwarning: " + module + " has overridden or unexported components. Tagging now ships a version")
+    fmt.Println("that shows as overridden as soon as it is deployed; export them first with drush features-update.")
+    fmt.Println("\n  " + strings.Join(lines, "\n  ") + "\n")
+}
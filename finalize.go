@@ -0,0 +1,122 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "time"
+)
+
+func init() {
+    subcommands["finalize"] = subcommand{"Promote the latest release candidate to its final version: tag the same commit and move the makefile pin over.", finalize}
+}
+
+// finalize completes the release candidate workflow. The final version (eg. v2.1.0 for v2.1.0-rc.2)
+// is tagged on the commit the candidate was, so what was tested is exactly what ships, and the
+// makefile is moved from the candidate's pin to the final one.
+func finalize(args []string) error {
+    module, err := getModule()
+    if err != nil {
+        return err
+    }
+
+    if len(args) > 0 && args[0] != module {
+        return &pushError{"Asked to finalize '" + args[0] + "' but the module repo is '" + module + "'. Use --module to point at the right repo."}
+    }
+
+    // the changelog commit would move the default branch on, past what the candidate tagged
+    if changelogOpt {
+        return withCode(exitOptions, &pushError{"--changelog can't be used with finalize, which tags the candidate's commit as it is."})
+    }
+
+    makefile, err := getMakefile(module)
+    if err != nil {
+        return err
+    }
+
+    if autostashOpt {
+        restore, err := autostash(cwd, siteRepoOpt)
+        defer restore()
+
+        if err != nil {
+            return err
+        }
+    }
+
+    if err = runChecks("environment", environmentChecks()); err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err == nil {
+        err = updateModuleRepo()
+    }
+
+    if err != nil {
+        return err
+    }
+    topicOpt = branchOpt
+
+    candidate, current, err := latestTag(cwd, branchOpt)
+    if err != nil {
+        return err
+    }
+
+    if candidate == "" || current.Pre == "" {
+        return &pushError{"The latest version of " + module + " (" + candidate + ") is not a release candidate, so there is nothing to finalize."}
+    }
+
+    latest := strings.TrimPrefix(candidate, tagPrefix())
+    final, _ := bumpVersion(current, "release", "")
+
+    fmt.Printf("Current version: %s\n", latest)
+
+    checks := append(releaseChecks(makefile, module, latest, final.String()), compatibilityChecks(makefile, map[string]string{module: final.String()})...)
+
+    if err = runChecks("release", checks); err != nil {
+        return err
+    }
+
+    summary.Module, summary.OldVersion, summary.NewVersion, summary.Topic = module, latest, final.String(), "finalize"
+    defer recordHistory()
+
+    commit, err := git(gitc{"rev-list", "-n", "1", candidate}, cwd)
+    if err != nil {
+        return err
+    }
+    sha := strings.TrimSpace(string(commit))
+
+    fmt.Printf("\n%s will be tagged on %s, the commit %s was tagged on, and the makefile moved from %s to it.\n", tagName(final.String()), sha[:7], candidate, candidate)
+
+    // anything merged since the candidate waits for the next release
+    if out, err := gitTry(gitc{"rev-list", "--count", "--no-merges", candidate + ".." + branchOpt}, cwd); err == nil && strings.TrimSpace(string(out)) != "0" {
+        fmt.Printf("note: the %s commit(s) on %s since %s are not part of %s.\n", strings.TrimSpace(string(out)), branchOpt, candidate, tagName(final.String()))
+    }
+
+    if prompt("Are you sure you want to finalize this release? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return errAborted
+    }
+
+    state = pushState{Time: time.Now(), Module: module, ModuleDir: cwd, Remote: remoteFor(cwd), Tag: tagName(final.String()), SiteRepo: siteRepoOpt, SiteBranch: siteBranch}
+
+    rel := &release{
+        module:     module,
+        latest:     latest,
+        version:    final.String(),
+        tag:        tagName(final.String()),
+        target:     sha,
+        annotation: "Promoted from " + candidate + ".",
+        commitMsg:  "FINALIZED: " + module + " " + latest + " -> " + final.String(),
+    }
+
+    if err = rel.run(makefile); err != nil {
+        return err
+    }
+
+    summary.Outcome = "finalized"
+
+    fmt.Printf("\n%s has been finalized as %s.\n", candidate, tagName(final.String()))
+    summary.print()
+
+    return nil
+}
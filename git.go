@@ -0,0 +1,461 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type gitc []string
+
+var gitCommands = map[string]gitc{
+    "branch":   {"rev-parse", "--abbrev-ref", "HEAD"},
+    "head":     {"rev-parse", "--short", "HEAD"},
+}
+
+// the git executable, and the environment and leading arguments for every git command (set up by
+// applyGitConfig and isolateGit)
+var (
+    gitBinary = "git"
+    gitEnv    []string
+    gitArgs   gitc
+)
+
+// gitConfig lets locked-down build agents use a git other than the one on PATH, or pass it extra
+// global arguments (eg. ["-c", "http.proxy=http://proxy.turner.com:8080"]), sets how long a git
+// command may take before it is stopped (eg. "2m", 10 minutes when empty), how often a fetch or
+// push that failed on the network is tried again, and whether go-git answers the read-only commands
+type gitConfig struct {
+    Path       string   `json:"path"`
+    Args       []string `json:"args"`
+    Timeout    string   `json:"timeout"`
+    Retries    int      `json:"retries"`    // 2 when empty, -1 to never retry
+    RetryDelay string   `json:"retryDelay"` // before the first retry (eg. "5s", 2 seconds when empty), doubled for each one after
+    Backend    string   `json:"backend"`    // binary (the default) or go-git
+}
+
+// defaultGitTimeout is how long a git command may run when the config file doesn't say
+const defaultGitTimeout = 10 * time.Minute
+
+// gitRetries is how many more times a fetch or push that failed on the network is tried
+func gitRetries() int {
+    switch {
+    case config.Git.Retries < 0:
+        return 0
+    case config.Git.Retries > 0:
+        return config.Git.Retries
+    }
+
+    return 2
+}
+
+// gitRetryDelay is how long to wait before the first retry
+func gitRetryDelay() time.Duration {
+    if delay, err := time.ParseDuration(config.Git.RetryDelay); err == nil && delay > 0 {
+        return delay
+    }
+
+    return 2 * time.Second
+}
+
+// transientGitError matches what git prints when the network, not the remote, failed a command
+var transientGitError = regexp.MustCompile(`(?i)could not resolve host|connection (timed out|reset|refused)|operation timed out|` +
+    `the remote end hung up unexpectedly|early eof|rpc failed|unable to access|ssh: connect to host|` +
+    `could not read from remote repository|temporary failure in name resolution|gnutls_handshake|tls connection`)
+
+// networkCommands are the git commands that talk to a remote, and so are retried
+var networkCommands = map[string]bool{"fetch": true, "push": true, "pull": true, "ls-remote": true}
+
+// gitTimeout is how long a git command may run
+func gitTimeout() time.Duration {
+    if timeout, err := time.ParseDuration(config.Git.Timeout); err == nil && timeout > 0 {
+        return timeout
+    }
+
+    return defaultGitTimeout
+}
+
+// applyGitConfig points every git command at the configured executable and arguments
+func applyGitConfig() {
+    if config.Git.Path != "" {
+        gitBinary = config.Git.Path
+    }
+
+    gitArgs = append(gitc{}, config.Git.Args...)
+}
+
+// minGitVersion is the oldest git with everything this utility runs: stash push (2.13),
+// core.hooksPath (2.9), ls-remote --symref (2.8) and tag --merged (2.7)
+var (
+    minGitVersion     = [2]int{2, 13}
+    gitVersionPattern = regexp.MustCompile(`git version (\d+)\.(\d+)`)
+)
+
+// requireGit makes sure the git client can run every command a release needs, so an old git fails
+// here rather than halfway through a release with an unknown option
+func requireGit() error {
+    if _, err := exec.LookPath(gitBinary); err != nil {
+        if gitBinary != "git" {
+            return &pushError{"The configured git (" + gitBinary + ") could not be run."}
+        }
+        return &pushError{"git was not found on your PATH."}
+    }
+
+    out, err := exec.Command(gitBinary, "version").Output()
+    parts := gitVersionPattern.FindStringSubmatch(string(out))
+    if err != nil || parts == nil {
+        return &pushError{"Could not tell which version of git " + gitBinary + " is."}
+    }
+
+    major, _ := strconv.Atoi(parts[1])
+    minor, _ := strconv.Atoi(parts[2])
+
+    if major < minGitVersion[0] || (major == minGitVersion[0] && minor < minGitVersion[1]) {
+        return &pushError{fmt.Sprintf("This utility needs git %d.%d or newer, but %s is %s.%s. Upgrade git (or point git.path in the config file at a newer one) and re-run.",
+            minGitVersion[0], minGitVersion[1], gitBinary, parts[1], parts[2])}
+    }
+
+    return nil
+}
+
+// emptyTree is the id of git's empty tree, which a first release is diffed against
+func emptyTree() string {
+    out, err := gitTry(gitc{"hash-object", "-t", "tree", "/dev/null"}, cwd)
+    if err != nil {
+        return "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+    }
+    return strings.TrimSpace(string(out))
+}
+
+// gitTry runs a git command in given directory and hands back any failure to the caller. The command
+// is stopped when it runs longer than the git timeout, or when the run is interrupted. A fetch or push
+// that failed on the network is tried again (see gitRetries), waiting twice as long each time.
+func gitTry(command gitc, dir string) ([]byte, error) {
+    out, err := runGit(command, dir)
+
+    if len(command) == 0 || !networkCommands[command[0]] {
+        return out, err
+    }
+
+    delay := gitRetryDelay()
+
+    for retry := 1; err != nil && err != errInterrupted && retry <= gitRetries() && transientGitError.Match(out); retry++ {
+        slog.Warn(fmt.Sprintf("git %s failed (%s), trying again in %s (%d of %d)", command[0], firstLine(out), delay, retry, gitRetries()))
+
+        select {
+        case <-interrupted:
+        case <-time.After(delay):
+        }
+
+        if stopped() {
+            return out, errInterrupted
+        }
+
+        delay *= 2
+        out, err = runGit(command, dir)
+    }
+
+    return out, err
+}
+
+// firstLine is the first line git printed that says what went wrong
+func firstLine(out []byte) string {
+    for _, line := range strings.Split(string(out), "\n") {
+        if line = strings.TrimSpace(line); transientGitError.MatchString(line) {
+            return strings.TrimPrefix(strings.TrimPrefix(line, "fatal: "), "error: ")
+        }
+    }
+
+    return strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+}
+
+// runGit runs a git command once, for gitTry
+func runGit(command gitc, dir string) ([]byte, error) {
+    if stopped() {
+        return nil, errInterrupted
+    }
+
+    if useGoGit() {
+        start := time.Now()
+        if out, handled, err := goGit(command, dir); handled {
+            slog.Debug("go-git "+strings.Join(command, " "), "dir", dir)
+            record("git", strings.Join(command, " "), time.Since(start))
+            return out, err
+        }
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), gitTimeout())
+    defer cancel()
+
+    go func() {
+        select {
+        case <-interrupted:
+            if stopped() {
+                cancel()
+            }
+        case <-ctx.Done():
+        }
+    }()
+
+    cmd := exec.CommandContext(ctx, gitBinary, append(append(gitc{}, gitArgs...), command...)...)
+    cmd.Dir = dir
+
+    if gitEnv != nil {
+        cmd.Env = gitEnv
+    }
+
+    slog.Debug("git "+strings.Join(command, " "), "dir", dir)
+
+    start := time.Now()
+    out, err := cmd.CombinedOutput()
+    took := time.Since(start)
+    record("git", strings.Join(command, " "), took)
+
+    if slog.Default().Enabled(ctx, levelTrace) && cmd.ProcessState != nil {
+        slog.Log(ctx, levelTrace, "git "+command[0]+" finished", "took", took.Round(time.Millisecond), "exit", cmd.ProcessState.ExitCode(), "output", string(out))
+    }
+
+    switch {
+    case err == nil:
+    case stopped():
+        err = errInterrupted
+    case ctx.Err() == context.DeadlineExceeded:
+        out = append(out, []byte(fmt.Sprintf("\ngit %s timed out after %s (set git.timeout in the config file to allow longer)", strings.Join(command, " "), gitTimeout()))...)
+    }
+
+    return out, err
+}
+
+// gnupgHome is where gpg keeps its keys, which --sign needs even with a temporary HOME
+func gnupgHome() string {
+    if home := os.Getenv("GNUPGHOME"); home != "" {
+        return home
+    }
+    return filepath.Join(usr.HomeDir, ".gnupg")
+}
+
+// isolateGit points every git command at a temporary HOME with a minimal git config, so user and
+// system config (aliases, hooks, odd defaults) can't change how a release behaves. It returns a
+// function that removes the temporary HOME.
+func isolateGit() (func(), error) {
+    identity := config.Identity
+
+    if identity.Name == "" || identity.Email == "" {
+        return nil, &pushError{"--isolated requires an identity in the config file, eg. \"identity\": {\"name\": \"...\", \"email\": \"...\"}"}
+    }
+
+    home, err := ioutil.TempDir("", "ncaapushit-home")
+    if err != nil {
+        return nil, &pushError{"Could not create a temporary HOME for --isolated"}
+    }
+
+    hooks := filepath.Join(home, "hooks")
+    gitconfig := fmt.Sprintf("[user]\n\tname = %s\n\temail = %s\n[advice]\n\tdetachedHead = false\n", identity.Name, identity.Email)
+
+    if err = os.Mkdir(hooks, 0700); err == nil {
+        err = ioutil.WriteFile(filepath.Join(home, ".gitconfig"), []byte(gitconfig), 0600)
+    }
+
+    if err != nil {
+        os.RemoveAll(home)
+        return nil, &pushError{"Could not write the isolated git config @ " + home}
+    }
+
+    // keep the environment apart from anything that would change git's behavior
+    for _, kv := range os.Environ() {
+        if strings.HasPrefix(kv, "GIT_") || strings.HasPrefix(kv, "HOME=") || strings.HasPrefix(kv, "XDG_CONFIG_HOME=") {
+            continue
+        }
+        gitEnv = append(gitEnv, kv)
+    }
+
+    gitEnv = append(gitEnv,
+        "HOME="+home,
+        "XDG_CONFIG_HOME="+home,
+        "GIT_CONFIG_NOSYSTEM=1",
+        "GIT_TERMINAL_PROMPT=0",
+        "GNUPGHOME="+gnupgHome(),
+        "GIT_AUTHOR_NAME="+identity.Name,
+        "GIT_AUTHOR_EMAIL="+identity.Email,
+        "GIT_COMMITTER_NAME="+identity.Name,
+        "GIT_COMMITTER_EMAIL="+identity.Email,
+    )
+
+    // command-line config beats repo config, so repo-level hooks are skipped too
+    gitArgs = append(gitArgs, "-c", "core.hooksPath="+hooks)
+
+    return func() { os.RemoveAll(home) }, nil
+}
+
+// git runs a git command in given directory, returning what it printed as a gitError if it fails
+func git(command gitc, dir string) ([]byte, error) {
+    out, err := gitTry(command, dir)
+
+    if err == errInterrupted {
+        return out, err
+    }
+
+    if err != nil {
+        return out, &gitError{command, strings.TrimSpace(string(out))}
+    }
+
+    return out, nil
+}
+
+// fetchRemote is the command fetching the repo's remote (see remoteFor), tags included
+func fetchRemote(dir string) gitc {
+    return gitc{"fetch", "--prune", "--tags", remoteFor(dir)}
+}
+
+// updateRepo fetches from the repo's remote and fast-forwards the given branch to match it,
+// whether or not it is the branch currently checked out. This replaces the `git up` alias
+// the utility used to rely on.
+func updateRepo(dir, branch string) error {
+    remote := remoteFor(dir)
+    rememberBranch(dir)
+
+    if _, err := git(fetchRemote(dir), dir); err != nil {
+        return err
+    }
+
+    current, err := git(gitCommands["branch"], dir)
+    if err != nil {
+        return err
+    }
+
+    if strings.TrimSpace(string(current)) == branch {
+        _, err = git(gitc{"merge", "--ff-only", remote + "/" + branch}, dir)
+    } else {
+        _, err = git(gitc{"fetch", remote, branch + ":" + branch}, dir)
+    }
+
+    return err
+}
+
+// rejectedNonFastForward matches a push refused because the remote branch moved on since it was fetched
+var rejectedNonFastForward = regexp.MustCompile(`\[rejected\].*\((fetch first|non-fast-forward)\)`)
+
+// pushSite pushes the site branch. When someone else pushed to it first, the local commits are rebased
+// onto theirs and the push is tried again (up to gitRetries times); if they can't be rebased cleanly
+// the rejection is handed back as it was.
+func pushSite() ([]byte, error) {
+    remote := remoteFor(siteRepoOpt)
+
+    for retry := 0; ; retry++ {
+        out, err := gitTry(gitc{"push", remote, siteBranch}, siteRepoOpt)
+
+        if err == nil || err == errInterrupted || retry >= gitRetries() || !rejectedNonFastForward.Match(out) {
+            return out, err
+        }
+
+        slog.Warn("the site repo's " + siteBranch + " moved on since it was fetched; rebasing onto it and pushing again")
+
+        // the site commit is found again after the rebase by how far it is from the tip
+        var behind string
+        if summary.CommitSHA != "" {
+            count, _ := gitTry(gitc{"rev-list", "--count", summary.CommitSHA + "..HEAD"}, siteRepoOpt)
+            behind = strings.TrimSpace(string(count))
+        }
+
+        if _, fetchErr := gitTry(gitc{"fetch", remote, siteBranch}, siteRepoOpt); fetchErr != nil {
+            return out, err
+        }
+
+        if rebased, rebaseErr := gitTry(gitc{"rebase", remote + "/" + siteBranch}, siteRepoOpt); rebaseErr != nil {
+            gitTry(gitc{"rebase", "--abort"}, siteRepoOpt)
+
+            // the conflicts say it all; the rest is advice on finishing the rebase by hand
+            problem := strings.TrimSpace(string(rebased))
+            if conflicts := regexp.MustCompile(`(?m)^CONFLICT.*$`).FindAllString(problem, -1); conflicts != nil {
+                problem = strings.Join(conflicts, "\n")
+            }
+            return append(out, []byte("\nCould not rebase onto it:\n"+problem)...), err
+        }
+
+        if behind != "" {
+            if head, err := gitTry(gitc{"rev-parse", "--short", "HEAD~" + behind}, siteRepoOpt); err == nil {
+                summary.CommitSHA = strings.TrimSpace(string(head))
+            }
+        }
+    }
+}
+
+// detectDefaultBranch works out the integration branch of a repo from origin/HEAD (or the HEAD of
+// its other remote), asking the remote directly if the local clone doesn't know it, and falling
+// back to master
+func detectDefaultBranch(dir string) string {
+    remote := remoteFor(dir)
+
+    if out, err := gitTry(gitc{"symbolic-ref", "--short", "refs/remotes/" + remote + "/HEAD"}, dir); err == nil {
+        return strings.TrimPrefix(strings.Trim(string(out), " \n\t\r"), remote+"/")
+    }
+
+    if out, err := gitTry(gitc{"ls-remote", "--symref", remote, "HEAD"}, dir); err == nil {
+        // eg. "ref: refs/heads/main\tHEAD"
+        for _, line := range strings.Split(string(out), "\n") {
+            if strings.HasPrefix(line, "ref: refs/heads/") {
+                return strings.Fields(strings.TrimPrefix(line, "ref: refs/heads/"))[0]
+            }
+        }
+    }
+
+    return "master"
+}
+
+// autostash stashes uncommitted changes in each of the repos so they can't be mixed into the release
+// or clobbered by a checkout. The returned function puts them back and is always safe to call.
+func autostash(dirs ...string) (func(), error) {
+    stashed := map[string]string{}
+
+    restore := func() {
+        defer cleaningUp()()
+        restoreBranches()
+
+        for dir, sha := range stashed {
+            top, _ := gitTry(gitc{"rev-parse", "-q", "--verify", "stash@{0}"}, dir)
+
+            if strings.TrimSpace(string(top)) == sha {
+                if _, err := gitTry(gitc{"stash", "pop", "--index"}, dir); err == nil {
+                    fmt.Printf("Autostash: restored your changes in %s.\n", dir)
+                    continue
+                }
+                gitTry(gitc{"reset", "--hard", "-q"}, dir)
+            }
+
+            summary.followUp("Your uncommitted changes in " + dir + " could not be restored automatically; they are kept in stash " + sha[:7] + " (git stash apply " + sha[:7] + ").")
+        }
+    }
+
+    for _, dir := range dirs {
+        out, err := gitTry(gitc{"status", "--porcelain", "--untracked-files=no"}, dir)
+        if err != nil {
+            return restore, &pushError{"Could not check " + dir + " for uncommitted changes:\n" + strings.TrimSpace(string(out))}
+        }
+
+        if len(strings.TrimSpace(string(out))) == 0 {
+            continue
+        }
+
+        if out, err = gitTry(gitc{"stash", "push", "-m", "ncaapushit autostash"}, dir); err != nil {
+            return restore, &pushError{"Could not stash the uncommitted changes in " + dir + ":\n" + strings.TrimSpace(string(out))}
+        }
+
+        sha, err := git(gitc{"rev-parse", "stash@{0}"}, dir)
+        if err != nil {
+            return restore, err
+        }
+
+        stashed[dir] = strings.TrimSpace(string(sha))
+        fmt.Printf("Autostash: stashed your uncommitted changes in %s.\n", dir)
+    }
+
+    return restore, nil
+}
@@ -0,0 +1,561 @@
+package main
+
+import (
+    "errors"
+    "io/ioutil"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    gogit "github.com/go-git/go-git/v5"
+    gitconfig "github.com/go-git/go-git/v5/config"
+    "github.com/go-git/go-git/v5/plumbing"
+    "github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// With "backend": "go-git" under git in the config file, the read-only commands a release runs most
+// (looking up revisions, tags, remotes and ancestry) are answered in-process by go-git, printing what
+// git would, instead of starting git for each one. Everything else, and anything go-git can't answer
+// the way git would (eg. stash@{0}, FETCH_HEAD or a revision that doesn't exist, which git explains
+// best), still runs the git binary.
+
+var (
+    goObjectID = regexp.MustCompile(`^[0-9a-f]{40}$`)
+    goRevSteps = regexp.MustCompile(`^([~^]\d*)*$`)
+    goRevStep  = regexp.MustCompile(`([~^])(\d*)`)
+)
+
+// goGitCommands answer a git command (without the command's name) in a repo, saying whether they could
+var goGitCommands = map[string]func(repo *gogit.Repository, args []string) ([]byte, bool, error){
+    "rev-parse":    goRevParse,
+    "tag":          goTagList,
+    "symbolic-ref": goSymbolicRef,
+    "remote":       goRemoteURL,
+    "cat-file":     goCatFile,
+    "rev-list":     goRevListCount,
+}
+
+// useGoGit says whether go-git answers the commands it can. Arguments for git in the config file can
+// change any command's answer, so with them every command runs git.
+func useGoGit() bool {
+    return config.Git.Backend == "go-git" && len(config.Git.Args) == 0
+}
+
+// goGit answers a git command run in dir with go-git, saying whether it could
+func goGit(command gitc, dir string) ([]byte, bool, error) {
+    if len(command) == 0 || goGitCommands[command[0]] == nil {
+        return nil, false, nil
+    }
+
+    repo, err := gogit.PlainOpenWithOptions(dir, &gogit.PlainOpenOptions{DetectDotGit: true})
+    if err != nil {
+        return nil, false, nil
+    }
+
+    return goGitCommands[command[0]](repo, command[1:])
+}
+
+// goResolve finds the object a revision names the way git does: HEAD, a ref name or a full object ID,
+// followed by any ~n and ^n steps
+func goResolve(repo *gogit.Repository, rev string) (plumbing.Hash, bool) {
+    base, steps := rev, ""
+    if at := strings.IndexAny(rev, "~^"); at > 0 {
+        base, steps = rev[:at], rev[at:]
+    }
+
+    if !goRevSteps.MatchString(steps) {
+        return plumbing.ZeroHash, false
+    }
+
+    var (
+        hash  plumbing.Hash
+        found bool
+    )
+
+    if goObjectID.MatchString(base) {
+        hash = plumbing.NewHash(base)
+        _, err := repo.Storer.EncodedObject(plumbing.AnyObject, hash)
+        found = err == nil
+    } else if name, ok := goRefName(repo, base); ok {
+        if ref, err := repo.Reference(name, true); err == nil {
+            hash, found = ref.Hash(), true
+        }
+    }
+
+    if !found {
+        return plumbing.ZeroHash, false
+    }
+
+    // the steps walk from the commit the revision points at (through an annotated tag)
+    for _, step := range goRevStep.FindAllStringSubmatch(steps, -1) {
+        commit, err := goCommit(repo, hash)
+        if err != nil {
+            return plumbing.ZeroHash, false
+        }
+
+        n := 1
+        if step[2] != "" {
+            n, _ = strconv.Atoi(step[2])
+        }
+
+        switch {
+        case step[1] == "^" && n == 0:
+        case step[1] == "^":
+            if n > commit.NumParents() {
+                return plumbing.ZeroHash, false
+            }
+            commit, err = commit.Parent(n - 1)
+        default:
+            for ; n > 0 && err == nil; n-- {
+                commit, err = commit.Parent(0)
+            }
+        }
+
+        if err != nil {
+            return plumbing.ZeroHash, false
+        }
+        hash = commit.Hash
+    }
+
+    return hash, true
+}
+
+// goRefName is the ref a name stands for, which is the first of refs/<name>, refs/tags/<name>,
+// refs/heads/<name>, refs/remotes/<name> and refs/remotes/<name>/HEAD there is
+func goRefName(repo *gogit.Repository, name string) (plumbing.ReferenceName, bool) {
+    names := []string{"refs/" + name, "refs/tags/" + name, "refs/heads/" + name, "refs/remotes/" + name, "refs/remotes/" + name + "/HEAD"}
+
+    // other names in .git (FETCH_HEAD, ORIG_HEAD) aren't refs go-git can read
+    if name == "HEAD" || strings.HasPrefix(name, "refs/") {
+        names = append([]string{name}, names...)
+    }
+
+    for _, name := range names {
+        if _, err := repo.Reference(plumbing.ReferenceName(name), true); err == nil {
+            return plumbing.ReferenceName(name), true
+        }
+    }
+
+    return "", false
+}
+
+// goShort is the short name git gives a ref, when it stands for that ref and no other (git lengthens
+// an ambiguous one, eg. heads/main when there is a tag named main too)
+func goShort(repo *gogit.Repository, ref plumbing.ReferenceName) (string, bool) {
+    short := ref.Short()
+    if name, ok := goRefName(repo, short); !ok || name != ref {
+        return "", false
+    }
+
+    return short, true
+}
+
+// goCommit is the commit an object is, or that an annotated tag points at
+func goCommit(repo *gogit.Repository, hash plumbing.Hash) (*object.Commit, error) {
+    obj, err := repo.Object(plumbing.AnyObject, hash)
+    if err != nil {
+        return nil, err
+    }
+
+    switch o := obj.(type) {
+    case *object.Commit:
+        return o, nil
+    case *object.Tag:
+        return o.Commit()
+    }
+
+    return nil, plumbing.ErrObjectNotFound
+}
+
+// goAncestors is every commit reachable from the revision, itself included
+func goAncestors(repo *gogit.Repository, rev string) (map[plumbing.Hash]*object.Commit, bool) {
+    hash, ok := goResolve(repo, rev)
+    if !ok {
+        return nil, false
+    }
+
+    commit, err := goCommit(repo, hash)
+    if err != nil {
+        return nil, false
+    }
+
+    reachable := map[plumbing.Hash]*object.Commit{}
+
+    // a shallow clone ends in commits that aren't there, which git knows how to count and go-git doesn't
+    err = object.NewCommitPreorderIter(commit, nil, nil).ForEach(func(c *object.Commit) error {
+        reachable[c.Hash] = c
+        return nil
+    })
+
+    return reachable, err == nil
+}
+
+// goConfigs are the repo's config, the user's and the system's, for the settings that change what git
+// prints (go-git's merged config keeps only the repo's when both set one)
+func goConfigs(repo *gogit.Repository) ([]*gitconfig.Config, bool) {
+    local, err := repo.Config()
+    if err != nil {
+        return nil, false
+    }
+
+    configs := []*gitconfig.Config{local}
+
+    for _, scope := range []gitconfig.Scope{gitconfig.GlobalScope, gitconfig.SystemScope} {
+        cfg, err := gitconfig.LoadConfig(scope)
+        if err != nil {
+            return nil, false
+        }
+        configs = append(configs, cfg)
+    }
+
+    return configs, true
+}
+
+// goRevParse answers rev-parse [--verify] [-q] <rev>, rev-parse --abbrev-ref HEAD and rev-parse
+// --show-toplevel
+func goRevParse(repo *gogit.Repository, args []string) ([]byte, bool, error) {
+    if len(args) == 1 && args[0] == "--show-toplevel" {
+        worktree, err := repo.Worktree()
+        if err != nil {
+            return nil, false, nil
+        }
+
+        top, err := filepath.EvalSymlinks(worktree.Filesystem.Root())
+        if err != nil {
+            return nil, false, nil
+        }
+
+        return []byte(top + "\n"), true, nil
+    }
+
+    var (
+        abbrevRef bool
+        revs      []string
+    )
+
+    for _, arg := range args {
+        switch {
+        case arg == "--abbrev-ref":
+            abbrevRef = true
+        case arg == "--verify" || arg == "-q" || arg == "--quiet":
+        case strings.HasPrefix(arg, "-"):
+            return nil, false, nil
+        default:
+            revs = append(revs, arg)
+        }
+    }
+
+    if len(revs) != 1 {
+        return nil, false, nil
+    }
+
+    if abbrevRef {
+        return goAbbrevHead(repo, revs[0])
+    }
+
+    hash, ok := goResolve(repo, revs[0])
+    if !ok {
+        return nil, false, nil
+    }
+
+    return []byte(hash.String() + "\n"), true, nil
+}
+
+// goAbbrevHead answers rev-parse --abbrev-ref HEAD: the branch checked out, or HEAD when detached
+func goAbbrevHead(repo *gogit.Repository, rev string) ([]byte, bool, error) {
+    if rev != "HEAD" {
+        return nil, false, nil
+    }
+
+    head, err := repo.Reference(plumbing.HEAD, false)
+    if err != nil {
+        return nil, false, nil
+    }
+
+    if head.Type() != plumbing.SymbolicReference {
+        return []byte("HEAD\n"), true, nil
+    }
+
+    // a branch with no commits yet is git's to describe
+    branch, ok := goShort(repo, head.Target())
+    if !ok {
+        return nil, false, nil
+    }
+
+    return []byte(branch + "\n"), true, nil
+}
+
+// goGlob is the regexp for a tag --list pattern, which git matches without treating / specially
+func goGlob(pattern string) (*regexp.Regexp, error) {
+    var re strings.Builder
+    re.WriteString("^")
+
+    for i := 0; i < len(pattern); i++ {
+        switch c := pattern[i]; c {
+        case '*':
+            re.WriteString(".*")
+        case '?':
+            re.WriteString(".")
+        case '[':
+            end := strings.IndexByte(pattern[i+1:], ']')
+            if end < 0 {
+                return nil, errors.New("unterminated character class")
+            }
+
+            class := pattern[i+1 : i+1+end]
+            if strings.HasPrefix(class, "!") {
+                class = "^" + class[1:]
+            }
+
+            re.WriteString("[" + class + "]")
+            i += end + 1
+        default:
+            re.WriteString(regexp.QuoteMeta(string(c)))
+        }
+    }
+
+    re.WriteString("$")
+    return regexp.Compile(re.String())
+}
+
+// goTagList answers tag --list <pattern> [--merged <rev>]: the matching tags in refname order
+func goTagList(repo *gogit.Repository, args []string) ([]byte, bool, error) {
+    if len(args) != 2 && (len(args) != 4 || args[2] != "--merged") || args[0] != "--list" {
+        return nil, false, nil
+    }
+
+    // tag.sort changes the order git lists them in
+    configs, ok := goConfigs(repo)
+    if !ok {
+        return nil, false, nil
+    }
+
+    for _, cfg := range configs {
+        if cfg.Raw.Section("tag").Option("sort") != "" {
+            return nil, false, nil
+        }
+    }
+
+    match, err := goGlob(args[1])
+    if err != nil {
+        return nil, false, nil
+    }
+
+    var reachable map[plumbing.Hash]*object.Commit
+    if len(args) == 4 {
+        if reachable, ok = goAncestors(repo, args[3]); !ok {
+            return nil, false, nil
+        }
+    }
+
+    refs, err := repo.Tags()
+    if err != nil {
+        return nil, false, nil
+    }
+
+    var names []string
+
+    err = refs.ForEach(func(ref *plumbing.Reference) error {
+        name := strings.TrimPrefix(ref.Name().String(), "refs/tags/")
+        if !match.MatchString(name) {
+            return nil
+        }
+
+        if reachable != nil {
+            commit, err := goCommit(repo, ref.Hash())
+            if err != nil || reachable[commit.Hash] == nil {
+                return nil
+            }
+        }
+
+        names = append(names, name)
+        return nil
+    })
+
+    if err != nil {
+        return nil, false, nil
+    }
+
+    sort.Strings(names)
+
+    var out strings.Builder
+    for _, name := range names {
+        out.WriteString(name + "\n")
+    }
+
+    return []byte(out.String()), true, nil
+}
+
+// goSymbolicRef answers symbolic-ref --short <ref>
+func goSymbolicRef(repo *gogit.Repository, args []string) ([]byte, bool, error) {
+    if len(args) != 2 || args[0] != "--short" {
+        return nil, false, nil
+    }
+
+    ref, err := repo.Storer.Reference(plumbing.ReferenceName(args[1]))
+    if err != nil || ref.Type() != plumbing.SymbolicReference {
+        return nil, false, nil
+    }
+
+    short, ok := goShort(repo, ref.Target())
+    if !ok {
+        return nil, false, nil
+    }
+
+    return []byte(short + "\n"), true, nil
+}
+
+// goRemoteURL answers remote get-url <remote>
+func goRemoteURL(repo *gogit.Repository, args []string) ([]byte, bool, error) {
+    if len(args) != 2 || args[0] != "get-url" {
+        return nil, false, nil
+    }
+
+    configs, ok := goConfigs(repo)
+    if !ok {
+        return nil, false, nil
+    }
+
+    // url.<base>.insteadOf rewrites the URL git prints
+    for _, cfg := range configs {
+        if len(cfg.URLs) > 0 {
+            return nil, false, nil
+        }
+    }
+
+    remote, ok := configs[0].Remotes[args[1]]
+    if !ok || len(remote.URLs) == 0 {
+        return nil, false, nil
+    }
+
+    return []byte(remote.URLs[0] + "\n"), true, nil
+}
+
+// goCatFile answers cat-file -e <object> and cat-file blob <object>, where the object is a revision
+// or <revision>:<path>
+func goCatFile(repo *gogit.Repository, args []string) ([]byte, bool, error) {
+    if len(args) != 2 || (args[0] != "-e" && args[0] != "blob") {
+        return nil, false, nil
+    }
+
+    var (
+        blob *object.Blob
+        err  error
+    )
+
+    if at := strings.Index(args[1], ":"); at > 0 {
+        hash, ok := goResolve(repo, args[1][:at])
+        if !ok {
+            return nil, false, nil
+        }
+
+        commit, err := goCommit(repo, hash)
+        if err != nil {
+            return nil, false, nil
+        }
+
+        tree, err := commit.Tree()
+        if err != nil {
+            return nil, false, nil
+        }
+
+        entry, err := tree.FindEntry(args[1][at+1:])
+        if err != nil {
+            return nil, false, nil
+        }
+
+        if args[0] == "-e" {
+            return nil, true, nil
+        }
+
+        blob, err = repo.BlobObject(entry.Hash)
+        if err != nil {
+            return nil, false, nil
+        }
+    } else {
+        hash, ok := goResolve(repo, args[1])
+        if !ok {
+            return nil, false, nil
+        }
+
+        if args[0] == "-e" {
+            return nil, true, nil
+        }
+
+        if blob, err = repo.BlobObject(hash); err != nil {
+            return nil, false, nil
+        }
+    }
+
+    reader, err := blob.Reader()
+    if err != nil {
+        return nil, false, nil
+    }
+    defer reader.Close()
+
+    contents, err := ioutil.ReadAll(reader)
+    if err != nil {
+        return nil, false, nil
+    }
+
+    return contents, true, nil
+}
+
+// goRevListCount answers rev-list --count [--no-merges] <rev> and the same for <from>..<to>
+func goRevListCount(repo *gogit.Repository, args []string) ([]byte, bool, error) {
+    if len(args) < 2 || args[0] != "--count" {
+        return nil, false, nil
+    }
+
+    noMerges := false
+    var specs []string
+
+    for _, arg := range args[1:] {
+        switch {
+        case arg == "--no-merges":
+            noMerges = true
+        case strings.HasPrefix(arg, "-"):
+            return nil, false, nil
+        default:
+            specs = append(specs, arg)
+        }
+    }
+
+    if len(specs) != 1 || strings.Contains(specs[0], "...") {
+        return nil, false, nil
+    }
+
+    from, to := "", specs[0]
+    if parts := strings.SplitN(specs[0], "..", 2); len(parts) == 2 {
+        from, to = parts[0], parts[1]
+        if from == "" {
+            from = "HEAD"
+        }
+        if to == "" {
+            to = "HEAD"
+        }
+    }
+
+    included, ok := goAncestors(repo, to)
+    if !ok {
+        return nil, false, nil
+    }
+
+    excluded := map[plumbing.Hash]*object.Commit{}
+    if from != "" {
+        if excluded, ok = goAncestors(repo, from); !ok {
+            return nil, false, nil
+        }
+    }
+
+    count := 0
+    for hash, commit := range included {
+        if excluded[hash] == nil && (!noMerges || commit.NumParents() < 2) {
+            count++
+        }
+    }
+
+    return []byte(strconv.Itoa(count) + "\n"), true, nil
+}
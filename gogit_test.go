@@ -0,0 +1,106 @@
+package main
+
+import (
+    "os/exec"
+    "strings"
+    "testing"
+)
+
+// gitAnswers runs a git command in a directory of the fixture with the git binary, the way runGit does
+func (f *fixture) gitAnswers(dir string, command gitc) (string, error) {
+    cli := exec.Command("git", command...)
+    cli.Dir, cli.Env = dir, f.env()
+
+    out, err := cli.CombinedOutput()
+    return string(out), err
+}
+
+func TestGoGitAnswersLikeGit(t *testing.T) {
+    f := newFixture(t)
+    t.Setenv("HOME", f.home)
+
+    f.git(f.module, "fetch", "-q", "origin")
+    f.merge("NCAA-2", "NCAA-2 fix: bracket refresh")
+    f.git(f.module, "fetch", "-q", "origin")
+    f.git(f.module, "tag", "-a", "-m", "Release candidate", "v1.2.4-rc1", "origin/main~1")
+    f.git(f.module, "tag", "release/v2.0.0", "NCAA-2")
+    f.git(f.module, "remote", "set-head", "origin", "main")
+
+    for _, command := range []gitc{
+        {"rev-parse", "HEAD"},
+        {"rev-parse", "--verify", "-q", "origin/main~1"},
+        {"rev-parse", "origin/main^2"},
+        {"rev-parse", "origin/main~2^0"},
+        {"rev-parse", "v1.2.4-rc1"},
+        {"rev-parse", "v1.2.4-rc1~0"},
+        {"rev-parse", "origin"},
+        {"rev-parse", "refs/remotes/origin/HEAD"},
+        {"rev-parse", "--abbrev-ref", "HEAD"},
+        {"rev-parse", "--show-toplevel"},
+        {"tag", "--list", "v*"},
+        {"tag", "--list", "*"},
+        {"tag", "--list", "v1.2.[34]*"},
+        {"tag", "--list", "v*", "--merged", "origin/main~1"},
+        {"tag", "--list", "*", "--merged", "NCAA-2"},
+        {"symbolic-ref", "--short", "refs/remotes/origin/HEAD"},
+        {"remote", "get-url", "origin"},
+        {"cat-file", "-e", "v1.2.3:ncaa_scores.module"},
+        {"cat-file", "blob", "origin/main:ncaa_scores.module"},
+        {"rev-list", "--count", "origin/main"},
+        {"rev-list", "--count", "--no-merges", "v1.2.3..origin/main"},
+        {"rev-list", "--count", "v1.2.4-rc1.."},
+    } {
+        out, handled, err := goGit(command, f.module)
+        if !handled {
+            t.Errorf("go-git didn't answer git %s", strings.Join(command, " "))
+            continue
+        }
+
+        want, wantErr := f.gitAnswers(f.module, command)
+        if string(out) != want || (err == nil) != (wantErr == nil) {
+            t.Errorf("git %s: go-git answered %q (%v), git %q (%v)", strings.Join(command, " "), out, err, want, wantErr)
+        }
+    }
+
+    // the ones git answers best
+    f.git(f.module, "tag", "NCAA-2")
+    f.git(f.module, "checkout", "-q", "NCAA-2")
+
+    for _, command := range []gitc{
+        {"rev-parse", "--verify", "-q", "v9.9.9"},
+        {"rev-parse", "FETCH_HEAD"},
+        {"rev-parse", "HEAD@{1}"},
+        {"rev-parse", "--short", "HEAD"},
+        {"rev-parse", "--abbrev-ref", "HEAD"},
+        {"rev-list", "--left-right", "--count", "main...origin/main"},
+        {"cat-file", "-e", "HEAD:missing.txt"},
+        {"status", "--porcelain"},
+    } {
+        if out, handled, _ := goGit(command, f.module); handled {
+            t.Errorf("go-git answered git %s with %q, want it left to git", strings.Join(command, " "), out)
+        }
+    }
+}
+
+func TestGoGitBackendReleases(t *testing.T) {
+    f := newFixture(t)
+    f.write(f.config, `{"git": {"backend": "go-git"}}`)
+
+    out := f.mustRun("--topic", "NCAA-1", "--verbose")
+    if !strings.Contains(out, "go-git tag --list") {
+        t.Errorf("go-git didn't list the tags:\n%s", out)
+    }
+
+    if pinned := f.pinned(); pinned != "v1.2.4" {
+        t.Errorf("the makefile pins %s, want v1.2.4", pinned)
+    }
+
+    if tags := strings.Join(f.remoteTags(), " "); !strings.Contains(tags, "v1.2.4") {
+        t.Errorf("the module's tags on origin are %s, want v1.2.4", tags)
+    }
+
+    f.write(f.config, `{"git": {"backend": "libgit2"}}`)
+    if out, code := f.run("--topic", "NCAA-1"); code == 0 || !strings.Contains(out, "Unknown git backend 'libgit2'") {
+        t.Errorf("an unknown backend exited %d:\n%s", code, out)
+    }
+}
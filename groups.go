@@ -0,0 +1,129 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// groupConfig is a set of modules that must always ship together (eg. a module and the API it talks
+// to), so that staging never pins a mismatched pair
+type groupConfig struct {
+    Modules []string `json:"modules"`
+    Force   bool     `json:"force"` // release the others without asking
+}
+
+// partners that were asked about and left out, so they aren't asked about twice in one run
+var declinedPartners = map[string]bool{}
+
+// groupOf returns the name and config of the group the module belongs to, if any
+func groupOf(module string) (string, groupConfig, bool) {
+    var names []string
+    for name := range config.Groups {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        for _, member := range config.Groups[name].Modules {
+            if member == module {
+                return name, config.Groups[name], true
+            }
+        }
+    }
+
+    return "", groupConfig{}, false
+}
+
+// partnerPath is where the repo of a group partner is found: next to the module that brought it in,
+// otherwise in --workspace
+func partnerPath(e *batchEntry, partner string) string {
+    if sibling := filepath.Join(filepath.Dir(e.dir), partner); isDir(sibling) {
+        return sibling
+    }
+
+    return filepath.Join(workspaceOpt, partner)
+}
+
+// groupEntries returns the group partners of the (resolved) entries that aren't being released with
+// them. A forced group brings its partners in without asking; otherwise each group is asked about,
+// and leaving its partners out is warned about and left as a follow-up.
+func groupEntries(entries []*batchEntry) []*batchEntry {
+    releasing := map[string]bool{}
+    for _, e := range entries {
+        releasing[e.module] = true
+    }
+
+    var added []*batchEntry
+
+    for _, e := range entries {
+        name, group, ok := groupOf(e.module)
+        if !ok {
+            continue
+        }
+
+        var missing []string
+        for _, member := range group.Modules {
+            if !releasing[member] && !declinedPartners[member] {
+                missing = append(missing, member)
+            }
+        }
+
+        if len(missing) == 0 {
+            continue
+        }
+
+        partners := strings.Join(missing, ", ")
+
+        if group.Force {
+            fmt.Printf("\n%s is always released with %s (group %s), so they are released in this run too.\n", e.module, partners, name)
+        } else if prompt(fmt.Sprintf("\n%s ships together with %s (group %s). Release them in this run too? (y/n): ", e.module, partners, name)) != "y" {
+            slog.Warn(e.module + " is meant to ship with " + partners + "; releasing it without them")
+            summary.followUp("Release " + partners + " to match " + e.module + ".")
+
+            for _, member := range missing {
+                declinedPartners[member] = true
+            }
+            continue
+        }
+
+        for _, member := range missing {
+            releasing[member] = true
+            added = append(added, &batchEntry{path: partnerPath(e, member), bump: bumpOpt})
+        }
+    }
+
+    return added
+}
+
+// resolveEntries finds the module repo of every entry, bringing in the group partners of the modules
+// being released (and theirs) as it goes
+func resolveEntries(entries []*batchEntry) ([]*batchEntry, error) {
+    for i := 0; i < len(entries); i++ {
+        e := entries[i]
+
+        moduleOpt = e.path
+        module, err := getModule()
+        if err != nil {
+            return entries, err
+        }
+
+        e.module, e.dir, e.mono = module, cwd, monorepoModule
+
+        // once every module asked for is known, add the partners they are missing
+        if i == len(entries)-1 {
+            entries = append(entries, groupEntries(entries)...)
+        }
+    }
+
+    return entries, nil
+}
+
+// isDir says whether the path is an existing directory
+func isDir(path string) bool {
+    info, err := os.Stat(path)
+    return err == nil && info.IsDir()
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// TestMain lets the fixtures run the utility as a child process of the test binary, the way an
+// operator runs it, with its own options, exit code and home directory
+func TestMain(m *testing.M) {
+    if os.Getenv("NCAA_PUSHIT_TEST_CLI") == "1" {
+        usr.HomeDir = os.Getenv("HOME")
+        userHistoryPath = usr.HomeDir + "/.ncaapushit_history.jsonl"
+
+        main()
+        os.Exit(0)
+    }
+
+    os.Exit(m.Run())
+}
+
+// fixture is a disposable release setup: bare origins for a module and the site, a clone of each and
+// a site makefile pinning the module's v1.2.3. The module's topic branch NCAA-1 has been merged into
+// main on origin and is checked out, ready to release.
+type fixture struct {
+    t      *testing.T
+    dir    string
+    home   string
+    module string // the module's clone
+    site   string // the site repo's clone
+    config string
+
+    siteRemote string // the site clone's remote the release pushes to
+}
+
+// fixtureEnv is the environment of every git command and run: a home of its own and a fixed identity,
+// so the user's git config can't change the outcome
+func (f *fixture) env() []string {
+    return append(os.Environ(),
+        "HOME="+f.home,
+        "GIT_CONFIG_NOSYSTEM=1",
+        "GIT_AUTHOR_NAME=Fixture", "GIT_AUTHOR_EMAIL=fixture@example.com",
+        "GIT_COMMITTER_NAME=Fixture", "GIT_COMMITTER_EMAIL=fixture@example.com",
+        "NCAA_PUSHIT_CONFIG=", "NCAA_BARCA_SITE_REPO_PATH=", "NCAA_BARCA_SITE_MAKEFILE=", "NCAA_BARCA_SITE_BRANCH=")
+}
+
+// git runs git in a directory of the fixture, failing the test when it fails
+func (f *fixture) git(dir string, args ...string) string {
+    f.t.Helper()
+
+    command := exec.Command("git", args...)
+    command.Dir, command.Env = dir, f.env()
+
+    out, err := command.CombinedOutput()
+    if err != nil {
+        f.t.Fatalf("git %s in %s: %s\n%s", strings.Join(args, " "), dir, err, out)
+    }
+
+    return strings.TrimSpace(string(out))
+}
+
+// write puts a file in place
+func (f *fixture) write(path, contents string) {
+    f.t.Helper()
+
+    if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+        f.t.Fatal(err)
+    }
+}
+
+const fixtureMakefile = `core = 7.x
+api = 2
+
+projects[ncaa_scores][type] = "module"
+projects[ncaa_scores][download][type] = "git"
+projects[ncaa_scores][download][url] = "git@bitbucket.org:ncaa/ncaa_scores.git"
+projects[ncaa_scores][download][tag] = "v1.2.3"
+`
+
+// newFixture builds the repos in a temporary directory, removed when the test ends
+func newFixture(t *testing.T) *fixture {
+    dir := t.TempDir()
+
+    f := &fixture{t: t, dir: dir, home: filepath.Join(dir, "home"), module: filepath.Join(dir, "ncaa_scores"), site: filepath.Join(dir, "site"), config: filepath.Join(dir, "config.json"), siteRemote: "origin"}
+
+    if err := os.Mkdir(f.home, 0755); err != nil {
+        t.Fatal(err)
+    }
+    f.write(f.config, "{}\n")
+
+    f.git(dir, "init", "-q", "--bare", "-b", "main", "mod.git")
+    f.git(dir, "init", "-q", "--bare", "-b", "main", "site.git")
+
+    f.git(dir, "clone", "-q", "mod.git", f.module)
+    f.write(filepath.Join(f.module, "ncaa_scores.module"), "name = NCAA Scores\n")
+    f.git(f.module, "add", ".")
+    f.git(f.module, "commit", "-qm", "Initial commit")
+    f.git(f.module, "tag", "v1.2.3")
+    f.git(f.module, "push", "-q", "origin", "main", "--tags")
+
+    f.merge("NCAA-1", "NCAA-1 fix: scores refresh")
+
+    f.git(dir, "clone", "-q", "site.git", f.site)
+    f.write(filepath.Join(f.site, "barcelona.make"), fixtureMakefile)
+    f.git(f.site, "add", ".")
+    f.git(f.site, "commit", "-qm", "Initial commit")
+    f.git(f.site, "push", "-q", "origin", "main")
+
+    return f
+}
+
+// merge commits to a topic branch of the module, merges it into main on origin (as a pull request
+// would be) and leaves the topic branch checked out
+func (f *fixture) merge(topic, message string) {
+    f.t.Helper()
+
+    f.git(f.module, "checkout", "-q", "-B", topic, "origin/main")
+    contents, _ := ioutil.ReadFile(filepath.Join(f.module, "ncaa_scores.module"))
+    f.write(filepath.Join(f.module, "ncaa_scores.module"), string(contents)+"; "+message+"\n")
+    f.git(f.module, "commit", "-qam", message)
+    f.git(f.module, "push", "-q", "origin", topic)
+
+    merger := filepath.Join(f.dir, "merger")
+    os.RemoveAll(merger)
+    f.git(f.dir, "clone", "-q", "mod.git", merger)
+    f.git(merger, "merge", "-q", "--no-ff", "origin/"+topic, "-m", "Merge "+topic)
+    f.git(merger, "push", "-q", "origin", "main")
+}
+
+// run runs ncaapushit in the module repo against the fixture's site repo and config, answering yes,
+// and returns what it printed and its exit code
+func (f *fixture) run(args ...string) (string, int) {
+    f.t.Helper()
+
+    self, err := os.Executable()
+    if err != nil {
+        f.t.Fatal(err)
+    }
+
+    // a subcommand comes first, ahead of the options
+    var command []string
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        command, args = args[:1], args[1:]
+    }
+    command = append(command, "--config", f.config, "--site-repo", f.site, "--site-makefile", "barcelona.make", "--yes")
+
+    cli := exec.Command(self, append(command, args...)...)
+    cli.Dir, cli.Env = f.module, append(f.env(), "NCAA_PUSHIT_TEST_CLI=1")
+
+    out, _ := cli.CombinedOutput()
+    return string(out), cli.ProcessState.ExitCode()
+}
+
+// mustRun runs ncaapushit and fails the test unless it succeeds
+func (f *fixture) mustRun(args ...string) string {
+    f.t.Helper()
+
+    out, code := f.run(args...)
+    if code != 0 {
+        f.t.Fatalf("ncaapushit %s exited %d:\n%s", strings.Join(args, " "), code, out)
+    }
+
+    return out
+}
+
+// pinned returns the tag the makefile on the site's remote pins the module to
+func (f *fixture) pinned() string {
+    f.t.Helper()
+
+    f.git(f.site, "fetch", "-q", f.siteRemote)
+    makefile := f.git(f.site, "show", f.siteRemote+"/main:barcelona.make")
+
+    for _, line := range strings.Split(makefile, "\n") {
+        if strings.HasPrefix(line, "projects[ncaa_scores][download][tag]") {
+            return strings.Trim(strings.TrimSpace(strings.SplitN(line, "=", 2)[1]), `"`)
+        }
+    }
+
+    return ""
+}
+
+// remoteTags returns the module's tags on origin
+func (f *fixture) remoteTags() []string {
+    f.t.Helper()
+
+    var tags []string
+    for _, line := range strings.Split(f.git(f.module, "ls-remote", "--tags", "--refs", "origin"), "\n") {
+        if fields := strings.Fields(line); len(fields) == 2 {
+            tags = append(tags, strings.TrimPrefix(fields[1], "refs/tags/"))
+        }
+    }
+
+    return tags
+}
+
+// summaryOf runs ncaapushit with --summary-out and returns the run's summary and exit code
+func (f *fixture) summaryOf(args ...string) (runSummary, string, int) {
+    f.t.Helper()
+
+    path := filepath.Join(f.dir, "summary.json")
+    os.Remove(path)
+
+    out, code := f.run(append(args, "--summary-out", path)...)
+
+    var s runSummary
+    contents, err := ioutil.ReadFile(path)
+    if err != nil || json.Unmarshal(contents, &s) != nil {
+        f.t.Fatalf("ncaapushit %s wrote no summary (exit %d):\n%s", strings.Join(args, " "), code, out)
+    }
+
+    return s, out, code
+}
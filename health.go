@@ -0,0 +1,145 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/url"
+    "strings"
+)
+
+// healthPR is the part of a Bitbucket Server pull request the health report uses
+type healthPR struct {
+    ID      int    `json:"id"`
+    Title   string `json:"title"`
+    FromRef struct {
+        DisplayID string `json:"displayId"`
+    } `json:"fromRef"`
+    Author struct {
+        User struct {
+            Name string `json:"name"`
+        } `json:"user"`
+    } `json:"author"`
+    Properties struct {
+        OpenTaskCount int `json:"openTaskCount"`
+    } `json:"properties"`
+}
+
+// buildStatus is a build result the Bitbucket Server build status API reports for a commit
+type buildStatus struct {
+    State string `json:"state"` // SUCCESSFUL, FAILED or INPROGRESS
+    Key   string `json:"key"`
+    Name  string `json:"name"`
+    URL   string `json:"url"`
+}
+
+// reportHealth prints what Bitbucket knows about the module repo before the release is confirmed:
+// pull requests still open into the default branch (which won't be in the release), the default
+// branch's latest build, and tasks left open on the pull request that merged the topic branch. It
+// only informs the go/no-go; nothing here stops the release.
+func reportHealth(module string) {
+    cfg := config.Bitbucket
+
+    if cfg.BaseURL == "" {
+        return
+    }
+
+    project, repo := originProjectRepo(cwd)
+    if project == "" {
+        slog.Warn("could not check " + module + "'s health: its Bitbucket project and repo can't be read from its origin URL")
+        return
+    }
+
+    api := strings.TrimSuffix(cfg.BaseURL, "/") + "/rest/api/1.0/projects/" + project + "/repos/" + repo + "/pull-requests?direction=INCOMING&at=" + url.QueryEscape("refs/heads/"+branchOpt)
+
+    var lines []string
+    concerns := 0
+
+    // ** pull requests that are still open won't be in this release
+    var open struct {
+        Values []healthPR `json:"values"`
+    }
+
+    if err := callAPI("GET", api+"&state=OPEN&limit=100", cfg.auth, nil, &open); err != nil {
+        slog.Warn("could not list " + module + "'s open pull requests: " + healthError(err))
+    } else {
+        lines = append(lines, fmt.Sprintf("open pull requests into %s: %d", branchOpt, len(open.Values)))
+
+        for _, pr := range open.Values {
+            lines = append(lines, fmt.Sprintf("  #%d %s (%s, from %s)", pr.ID, pr.Title, pr.Author.User.Name, pr.FromRef.DisplayID))
+        }
+    }
+
+    // ** the default branch's latest build, one line per build plan
+    if sha, err := git(gitc{"rev-parse", branchOpt}, cwd); err == nil {
+        var builds struct {
+            Values []buildStatus `json:"values"`
+        }
+
+        status := strings.TrimSuffix(cfg.BaseURL, "/") + "/rest/build-status/1.0/commits/" + strings.TrimSpace(string(sha))
+
+        if err := callAPI("GET", status, cfg.auth, nil, &builds); err != nil {
+            slog.Warn("could not read the build status of " + module + "'s " + branchOpt + ": " + healthError(err))
+        } else if len(builds.Values) == 0 {
+            lines = append(lines, branchOpt+" build: none reported")
+        } else {
+            seen := map[string]bool{}
+
+            // newest first, so the first of each key is its latest result
+            for _, b := range builds.Values {
+                if seen[b.Key] {
+                    continue
+                }
+                seen[b.Key] = true
+
+                if b.State != "SUCCESSFUL" {
+                    concerns++
+                }
+                lines = append(lines, strings.TrimSpace(fmt.Sprintf("%s build: %s (%s) %s", branchOpt, b.State, b.Name, b.URL)))
+            }
+        }
+    }
+
+    // ** tasks reviewers left open on the pull request that merged the topic branch (if there was one)
+    var merged struct {
+        Values []healthPR `json:"values"`
+    }
+
+    if topicOpt == "" {
+        // nothing was merged for this release
+    } else if err := callAPI("GET", api+"&state=MERGED&order=NEWEST&limit=25", cfg.auth, nil, &merged); err != nil {
+        slog.Warn("could not find the pull request that merged " + topicOpt + ": " + healthError(err))
+    } else {
+        for _, pr := range merged.Values {
+            if pr.FromRef.DisplayID != topicOpt {
+                continue
+            }
+
+            tasks := fmt.Sprintf("merged pull request #%d: %d open task(s)", pr.ID, pr.Properties.OpenTaskCount)
+            if pr.Properties.OpenTaskCount > 0 {
+                concerns++
+                tasks += ", left unresolved by its review"
+            }
+            lines = append(lines, tasks)
+            break
+        }
+    }
+
+    if len(lines) == 0 {
+        return
+    }
+
+    fmt.Printf("\nHealth of %s (%s/%s on Bitbucket):\n", module, project, repo)
+    for _, line := range lines {
+        fmt.Println("  " + line)
+    }
+
+    if concerns > 0 {
+        fmt.Println()
+        slog.Warn("check the failing build or open tasks above before going ahead with this release")
+    }
+}
+
+// healthError is the reason an API call failed, without its "fatal: " prefix
+func healthError(err error) string {
+    return strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ")
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+    "bufio"
+    "database/sql"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "strings"
+    "time"
+
+    _ "modernc.org/sqlite" // the sqlite history backend, without cgo
+)
+
+func init() {
+    subcommands["history"] = subcommand{"List past release attempts from the history store, optionally for one module.", history}
+}
+
+// historyRecord is one release attempt as kept in the history store
+type historyRecord struct {
+    Time       time.Time `json:"time"`
+    User       string    `json:"user"`
+    Module     string    `json:"module"`
+    OldVersion string    `json:"oldVersion"`
+    NewVersion string    `json:"newVersion"`
+    Topic      string    `json:"topic"`
+    Profile    string    `json:"profile,omitempty"`
+    CommitSHA  string    `json:"commitSha"`
+    Outcome    string    `json:"outcome"`
+    RunAs      string    `json:"runAs,omitempty"` // the account the run was made as, when User approved it
+}
+
+// historyStore keeps the release history. Individual users default to a local file while the team
+// can point everyone at a shared service.
+type historyStore interface {
+    Append(record historyRecord) error
+    List() ([]historyRecord, error)
+}
+
+// historyConfig selects and configures the history store
+type historyConfig struct {
+    Backend string `json:"backend"` // file (the default), sqlite or http
+    Path    string `json:"path"`    // file and sqlite backends
+    URL     string `json:"url"`     // http backend
+    Token   string `json:"token"`   // http backend, sent as a bearer token
+}
+
+// approver is whoever approved the run, when the server started it
+var approver string
+
+// readApprover reads who approved the run from the pipe the server handed it (--approver-fd). The
+// environment won't do: it is passed on to every hook and git command, and anyone can set it.
+func readApprover() error {
+    if approverFdOpt <= 0 {
+        return nil
+    }
+
+    pipe := os.NewFile(uintptr(approverFdOpt), "approver")
+    defer pipe.Close()
+
+    contents, err := ioutil.ReadAll(io.LimitReader(pipe, 320))
+    if err != nil {
+        return withCode(exitOptions, &pushError{fmt.Sprintf("Could not read who approved the release from descriptor %d: %s", approverFdOpt, err)})
+    }
+
+    approver = strings.TrimSpace(string(contents))
+    return nil
+}
+
+// operator is who the run is made for: whoever approved it when the server started it, otherwise the
+// user running it
+func operator() string {
+    if approver != "" {
+        return approver
+    }
+
+    return usr.Username
+}
+
+// runAs is the account the run is made as, when that isn't the operator
+func runAs() string {
+    if approver != "" {
+        return usr.Username
+    }
+
+    return ""
+}
+
+// userHistoryPath is the operator's own history file, which every run is recorded to
+var userHistoryPath = usr.HomeDir + "/.ncaapushit_history.jsonl"
+
+// newHistoryStore returns the store selected in the config file
+func newHistoryStore() (historyStore, error) {
+    cfg := config.History
+
+    switch cfg.Backend {
+    case "", "file":
+        if cfg.Path == "" {
+            cfg.Path = userHistoryPath
+        }
+        return &fileHistory{cfg.Path}, nil
+    case "sqlite":
+        if cfg.Path == "" {
+            cfg.Path = usr.HomeDir + "/.ncaapushit_history.db"
+        }
+        return &sqliteHistory{cfg.Path}, nil
+    case "http":
+        if cfg.URL == "" {
+            return nil, &pushError{"The http history backend requires a \"url\" in the config file"}
+        }
+        return &httpHistory{cfg.URL, cfg.Token}, nil
+    }
+
+    return nil, &pushError{"Unknown history backend '" + cfg.Backend + "' (expected file, sqlite or http)"}
+}
+
+// recordHistory appends the outcome of this run to the operator's own history file and to the
+// configured history store, when that is somewhere else (eg. shared by the team). Runs that failed
+// before a version was worked out are recorded too. Failing to record is reported but never fails
+// the run itself.
+func recordHistory() {
+    releases := summary.releases()
+
+    if len(releases) == 0 && summary.Module == "" {
+        return
+    }
+
+    if len(releases) == 0 {
+        releases = []releaseSummary{{summary.Module, summary.OldVersion, summary.NewVersion, summary.Topic}}
+    }
+
+    stores := []historyStore{&fileHistory{userHistoryPath}}
+
+    if store, err := newHistoryStore(); err != nil {
+        slog.Warn("could not record release history: " + strings.TrimSpace(err.Error()))
+    } else if file, ok := store.(*fileHistory); !ok || file.path != userHistoryPath {
+        stores = append(stores, store)
+    }
+
+    for _, store := range stores {
+        var err error
+
+        for _, rel := range releases {
+            if err != nil {
+                break
+            }
+
+            err = store.Append(historyRecord{
+                Time:       time.Now(),
+                User:       operator(),
+                Module:     rel.Module,
+                OldVersion: rel.OldVersion,
+                NewVersion: rel.NewVersion,
+                Topic:      rel.Topic,
+                Profile:    profileOpt,
+                CommitSHA:  summary.CommitSHA,
+                Outcome:    summary.Outcome,
+                RunAs:      runAs(),
+            })
+        }
+
+        if err != nil {
+            slog.Warn("could not record release history: " + strings.TrimSpace(err.Error()))
+        }
+    }
+}
+
+// fileHistory stores one JSON record per line
+type fileHistory struct {
+    path string
+}
+
+func (h *fileHistory) Append(record historyRecord) error {
+    line, _ := json.Marshal(record)
+
+    file, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+    if err != nil {
+        return &pushError{"Could not open history file @ " + h.path}
+    }
+    defer file.Close()
+
+    if _, err = file.Write(append(line, '\n')); err != nil {
+        return &pushError{"Could not write to history file @ " + h.path}
+    }
+
+    return nil
+}
+
+func (h *fileHistory) List() ([]historyRecord, error) {
+    var records []historyRecord
+
+    file, err := os.Open(h.path)
+    if os.IsNotExist(err) {
+        return records, nil
+    } else if err != nil {
+        return records, &pushError{"Could not open history file @ " + h.path}
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        var record historyRecord
+        if json.Unmarshal(scanner.Bytes(), &record) == nil {
+            records = append(records, record)
+        }
+    }
+
+    return records, nil
+}
+
+// sqliteHistory stores records in a SQLite database
+type sqliteHistory struct {
+    path string
+}
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS releases (
+    time TEXT, user TEXT, module TEXT, old_version TEXT, new_version TEXT,
+    topic TEXT, profile TEXT, commit_sha TEXT, outcome TEXT, run_as TEXT
+)`
+
+// open opens the database, creating the table the first time (and adding the columns that came later
+// to one made before them)
+func (h *sqliteHistory) open() (*sql.DB, error) {
+    db, err := sql.Open("sqlite", h.path)
+    if err == nil {
+        _, err = db.Exec(sqliteSchema)
+    }
+
+    if err == nil {
+        var columns int
+        if err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('releases') WHERE name = 'run_as'").Scan(&columns); err == nil && columns == 0 {
+            _, err = db.Exec("ALTER TABLE releases ADD COLUMN run_as TEXT")
+        }
+    }
+
+    if err != nil {
+        if db != nil {
+            db.Close()
+        }
+        return nil, &pushError{"Could not open the history database " + h.path + ": " + err.Error()}
+    }
+
+    return db, nil
+}
+
+func (h *sqliteHistory) Append(record historyRecord) error {
+    db, err := h.open()
+    if err != nil {
+        return err
+    }
+    defer db.Close()
+
+    _, err = db.Exec("INSERT INTO releases (time, user, module, old_version, new_version, topic, profile, commit_sha, outcome, run_as) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+        record.Time.Format(time.RFC3339), record.User, record.Module, record.OldVersion, record.NewVersion,
+        record.Topic, record.Profile, record.CommitSHA, record.Outcome, record.RunAs)
+    if err != nil {
+        return &pushError{"Could not record history in " + h.path + ": " + err.Error()}
+    }
+
+    return nil
+}
+
+func (h *sqliteHistory) List() ([]historyRecord, error) {
+    var records []historyRecord
+
+    db, err := h.open()
+    if err != nil {
+        return records, err
+    }
+    defer db.Close()
+
+    rows, err := db.Query("SELECT time, user, module, old_version, new_version, topic, profile, commit_sha, outcome, COALESCE(run_as, '') FROM releases ORDER BY time")
+    if err != nil {
+        return records, &pushError{"Could not read history from " + h.path + ": " + err.Error()}
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var (
+            recorded string
+            record   historyRecord
+        )
+
+        if err = rows.Scan(&recorded, &record.User, &record.Module, &record.OldVersion, &record.NewVersion,
+            &record.Topic, &record.Profile, &record.CommitSHA, &record.Outcome, &record.RunAs); err != nil {
+            return records, &pushError{"Could not read history from " + h.path + ": " + err.Error()}
+        }
+
+        record.Time, _ = time.Parse(time.RFC3339, recorded)
+        records = append(records, record)
+    }
+
+    if err = rows.Err(); err != nil {
+        return records, &pushError{"Could not read history from " + h.path + ": " + err.Error()}
+    }
+
+    return records, nil
+}
+
+// httpHistory posts records to (and reads them back from) a shared history service
+type httpHistory struct {
+    url   string
+    token string
+}
+
+func (h *httpHistory) Append(record historyRecord) error {
+    return callAPI("POST", h.url, bearerAuth(h.token), record, nil)
+}
+
+func (h *httpHistory) List() ([]historyRecord, error) {
+    var records []historyRecord
+
+    err := callAPI("GET", h.url, bearerAuth(h.token), nil, &records)
+    return records, err
+}
+
+// history prints the newest records from the history store (the team's, when one is configured),
+// only those for the module named on the command-line when there is one
+func history(args []string) error {
+    store, err := newHistoryStore()
+    if err != nil {
+        return err
+    }
+
+    records, err := store.List()
+    if err != nil {
+        return err
+    }
+
+    var matched []historyRecord
+    for _, record := range records {
+        if len(args) == 0 || record.Module == args[0] {
+            matched = append(matched, record)
+        }
+    }
+
+    if limitOpt > 0 && len(matched) > limitOpt {
+        matched = matched[len(matched)-limitOpt:]
+    }
+
+    if jsonOpt {
+        for _, record := range matched {
+            line, _ := json.Marshal(record)
+            fmt.Println(string(line))
+        }
+        return nil
+    }
+
+    if len(matched) == 0 {
+        fmt.Println("No releases have been recorded yet.")
+        return nil
+    }
+
+    fmt.Printf("%-21s %-12s %-20s %-20s %-14s %-9s %s\n", "TIME", "USER", "MODULE", "VERSION", "TOPIC", "OUTCOME", "COMMIT")
+    for _, r := range matched {
+        version := r.OldVersion + " -> " + r.NewVersion
+        if r.NewVersion == "" {
+            version = r.OldVersion
+        }
+
+        user := r.User
+        if r.RunAs != "" {
+            user += " (as " + r.RunAs + ")"
+        }
+
+        fmt.Printf("%-21s %-12s %-20s %-20s %-14s %-9s %s\n", displayTime(r.Time),
+            user, r.Module, version, r.Topic, r.Outcome, r.CommitSHA)
+    }
+
+    return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestSQLiteHistory(t *testing.T) {
+    f := newFixture(t)
+
+    db := filepath.Join(f.dir, "history.db")
+    f.write(f.config, fmt.Sprintf(`{"history": {"backend": "sqlite", "path": %q}}`, db))
+
+    f.mustRun("--topic", "NCAA-1")
+
+    // a quote in a value is stored as it is, not run as SQL
+    store := &sqliteHistory{db}
+    if err := store.Append(historyRecord{User: "o'brien", Module: "ncaa_teams", Topic: "NCAA-2'); DROP TABLE releases; --", Outcome: "failed"}); err != nil {
+        t.Fatal(err)
+    }
+
+    var records []historyRecord
+    for _, line := range strings.Split(strings.TrimSpace(f.mustRun("history", "--json")), "\n") {
+        var record historyRecord
+        if err := json.Unmarshal([]byte(line), &record); err != nil {
+            t.Fatalf("history --json printed %q: %v", line, err)
+        }
+        records = append(records, record)
+    }
+
+    if len(records) != 2 {
+        t.Fatalf("history lists %d releases, want 2: %+v", len(records), records)
+    }
+
+    if r := records[1]; r.Module != "ncaa_scores" || r.OldVersion != "1.2.3" || r.NewVersion != "1.2.4" || r.Topic != "NCAA-1" || r.Outcome != "success" {
+        t.Errorf("the release was recorded as %+v", r)
+    }
+
+    if r := records[0]; r.User != "o'brien" || r.Topic != "NCAA-2'); DROP TABLE releases; --" {
+        t.Errorf("the quoted record was read back as %+v", r)
+    }
+}
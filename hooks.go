@@ -0,0 +1,122 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// hookStages are the points in a release where hooks run, in order. Hooks up to post-makefile run
+// before anything is pushed, so one failing stops (and undoes) the release; a failing post-push hook
+// is only reported, since the release is out by then.
+var hookStages = []string{"pre-bump", "pre-tag", "post-tag", "post-makefile", "post-push"}
+
+const hookTimeout = 5 * time.Minute
+
+// hookCommands lists what runs at a stage: the module repo's executable .ncaapushit/hooks/<stage>
+// (or <stage>.*, eg. post-push.sh) scripts in name order, then the config file's commands for it
+func hookCommands(stage string) []string {
+    var commands []string
+
+    dir := filepath.Join(cwd, ".ncaapushit", "hooks")
+    files, _ := ioutil.ReadDir(dir)
+    sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+    for _, file := range files {
+        name := file.Name()
+        if name != stage && !strings.HasPrefix(name, stage+".") {
+            continue
+        }
+
+        if file.IsDir() || file.Mode()&0111 == 0 {
+            slog.Warn(fmt.Sprintf("skipping hook %s, which is not executable.", filepath.Join(dir, name)))
+            continue
+        }
+
+        commands = append(commands, shellQuote(filepath.Join(dir, name)))
+    }
+
+    return append(commands, config.Hooks[stage]...)
+}
+
+// shellQuote quotes a path for sh -c
+func shellQuote(s string) string {
+    return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// hookEnv is the release context hooks receive, on top of the environment
+func hookEnv(stage, module, latest, newVersion string) []string {
+    env := []string{
+        "NCAA_PUSHIT_HOOK=" + stage,
+        "NCAA_PUSHIT_MODULE=" + module,
+        "NCAA_PUSHIT_MODULE_DIR=" + cwd,
+        "NCAA_PUSHIT_OLD_VERSION=" + latest,
+        "NCAA_PUSHIT_NEW_VERSION=" + newVersion,
+        "NCAA_PUSHIT_TOPIC=" + topicOpt,
+        "NCAA_PUSHIT_BRANCH=" + branchOpt,
+        "NCAA_PUSHIT_SITE_REPO=" + siteRepoOpt,
+        "NCAA_PUSHIT_SITE_BRANCH=" + siteBranch,
+        "NCAA_PUSHIT_MAKEFILE=" + siteMakeOpt,
+        "NCAA_PUSHIT_PROFILE=" + profileOpt,
+        "NCAA_PUSHIT_SITE_COMMIT=" + summary.CommitSHA,
+    }
+
+    if newVersion != "" {
+        env = append(env, "NCAA_PUSHIT_TAG="+tagName(newVersion))
+    }
+
+    return env
+}
+
+// runHooks runs the hooks for a stage of the module's release, in the module repo, one at a time.
+// Their output is shown as they run.
+func runHooks(stage, module, latest, newVersion string) error {
+    commands := hookCommands(stage)
+    if len(commands) == 0 {
+        return nil
+    }
+
+    done := phase("hooks: " + stage)
+    defer done()
+
+    for _, command := range commands {
+        fmt.Printf("Hook (%s): %s\n", stage, command)
+
+        ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+
+        cmd := exec.CommandContext(ctx, "sh", "-c", command)
+        cmd.Dir = cwd
+        cmd.Env = append(os.Environ(), hookEnv(stage, module, latest, newVersion)...)
+        cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+        slog.Debug("sh -c "+command, "dir", cwd, "timeout", hookTimeout)
+
+        err := cmd.Run()
+        timedOut := ctx.Err() == context.DeadlineExceeded
+        cancel()
+
+        if timedOut {
+            err = fmt.Errorf("timed out after %s", hookTimeout)
+        }
+
+        if err == nil {
+            continue
+        }
+
+        if stage == "post-push" {
+            slog.Warn(fmt.Sprintf("the %s hook %s failed: %s", stage, command, err))
+            summary.followUp("Run the " + stage + " hook " + command + " for " + module + " by hand.")
+            continue
+        }
+
+        return &pushError{"The " + stage + " hook " + command + " failed (" + err.Error() + "), so the release was stopped."}
+    }
+
+    return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// impact rates how risky a release is to deploy from the paths it changes
+type impact struct {
+    Level  string   // low (assets only), medium (code) or high (schema/update hooks)
+    Reason string
+    Files  []string // the files that decided the level
+    Hooks  []string // update hooks added since the tag, eg. ncaa_scores_update_7001
+}
+
+// updateHookPattern matches an added hook_update_N implementation in a diff
+var updateHookPattern = regexp.MustCompile(`(?m)^\+\s*function\s+(\w+_update_\d+)\s*\(`)
+
+// assetExtensions are safe to deploy on their own: no code runs differently because of them
+var assetExtensions = map[string]bool{
+    ".css": true, ".scss": true, ".less": true, ".js": true, ".map": true,
+    ".png": true, ".jpg": true, ".gif": true, ".svg": true, ".ico": true,
+    ".woff": true, ".woff2": true, ".ttf": true, ".eot": true, ".md": true, ".txt": true,
+}
+
+// analyzeImpact rates the changes on the module's default branch since the given tag (everything on it
+// for a first release, when the tag is empty). *.install files hold the schema and the update hooks
+// (hook_update_N), which need coordinated deployment steps.
+func analyzeImpact(tag string) (impact, error) {
+    var schema, code, assets []string
+
+    if tag == "" {
+        tag = emptyTree()
+    }
+
+    // in a monorepo only the module's own directory counts (and paths are relative to it)
+    command := gitc{"diff", "--name-only", tag + ".." + branchOpt}
+    if monorepoModule != "" {
+        command = append(command, "--relative")
+    }
+
+    out, err := git(command, cwd)
+    if err != nil {
+        return impact{}, err
+    }
+
+    for _, file := range strings.Fields(string(out)) {
+        switch ext := filepath.Ext(file); {
+        case ext == ".install":
+            schema = append(schema, file)
+        case assetExtensions[ext]:
+            assets = append(assets, file)
+        default:
+            code = append(code, file)
+        }
+    }
+
+    switch {
+    case len(schema) > 0:
+        i := impact{Level: "high", Reason: "schema/update hooks", Files: schema}
+        diff, err := git(append(gitc{"diff", "-U0", tag + ".." + branchOpt, "--"}, schema...), cwd)
+        if err != nil {
+            return i, err
+        }
+
+        for _, match := range updateHookPattern.FindAllStringSubmatch(string(diff), -1) {
+            i.Hooks = append(i.Hooks, match[1])
+        }
+
+        return i, nil
+    case len(code) > 0:
+        return impact{Level: "medium", Reason: "code", Files: code}, nil
+    }
+
+    return impact{Level: "low", Reason: "assets only", Files: assets}, nil
+}
+
+func (i impact) String() string {
+    files := strings.Join(i.Files, ", ")
+
+    if len(i.Files) > 3 {
+        files = fmt.Sprintf("%s and %d more", strings.Join(i.Files[:3], ", "), len(i.Files)-3)
+    } else if files == "" {
+        files = "no files changed"
+    }
+
+    return fmt.Sprintf("%s (%s: %s)", i.Level, i.Reason, files)
+}
+
+// confirmImpact asks for the module name to be typed out before a high impact release goes ahead
+func confirmImpact(module string, i impact) bool {
+    if i.Level != "high" {
+        return true
+    }
+
+    fmt.Println("\nThis release changes the schema or update hooks (" + strings.Join(i.Files, ", ") + ").")
+    fmt.Println("Make sure the deployment steps are coordinated before it reaches staging.")
+
+    return prompt("Type the module name to confirm: ") == module
+}
+
+// warnUpdateHooks makes new update hooks impossible to miss before the release is confirmed
+func warnUpdateHooks(i impact) {
+    if len(i.Hooks) == 0 {
+        return
+    }
+
+    fmt.Println("\n" + strings.Repeat("!", 72))
+    fmt.Println("!! This release adds database updates: " + strings.Join(i.Hooks, ", "))
+    fmt.Println("!! drush updb must be run on staging once it has built.")
+    fmt.Println(strings.Repeat("!", 72) + "\n")
+}
+
+// remindUpdb makes running drush updb on staging a follow-up of a release that adds update hooks,
+// since a missed updb is the most common way a release breaks staging
+func remindUpdb(i impact) {
+    if len(i.Hooks) == 0 {
+        return
+    }
+
+    summary.followUp("Run `drush updb` on staging once the build finishes (new update hooks: " + strings.Join(i.Hooks, ", ") + ").")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "os/signal"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "syscall"
+)
+
+// errInterrupted ends a run stopped with Ctrl-C (or SIGTERM) once what it had done is undone
+var errInterrupted = &codedError{exitAborted, &pushError{"Interrupted. The steps that had completed were undone."}}
+
+var (
+    // interrupted is closed by the first Ctrl-C
+    interrupted   = make(chan struct{})
+    interruptOnce sync.Once
+    // how many cleanups are running; their git commands still run after an interrupt
+    cleanups int32
+    // the branch each repo had checked out before the run first updated it
+    startBranches = map[string]string{}
+    branchesMu    sync.Mutex
+)
+
+// handleInterrupts makes the first Ctrl-C stop the run at the git command it is in (or the question
+// it is asking) and undo what it did, rather than leave the repos half changed. A second one exits.
+func handleInterrupts() {
+    signals := make(chan os.Signal, 2)
+    signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+    go func() {
+        <-signals
+        fmt.Println("\nInterrupted: stopping and undoing what was done (Ctrl-C again to exit right away)...")
+        interruptOnce.Do(func() { close(interrupted) })
+
+        <-signals
+        fmt.Println("\nInterrupted again: exiting without cleaning up. Check the module and site repos by hand.")
+        os.Exit(exitAborted)
+    }()
+}
+
+// isInterrupted says whether the run has been interrupted
+func isInterrupted() bool {
+    select {
+    case <-interrupted:
+        return true
+    default:
+        return false
+    }
+}
+
+// cleaningUp lets git commands run after an interrupt until the function it returns is called, for
+// the code undoing a run
+func cleaningUp() func() {
+    atomic.AddInt32(&cleanups, 1)
+    return func() { atomic.AddInt32(&cleanups, -1) }
+}
+
+// stopped says whether a git command must not run (or keep running) because of an interrupt
+func stopped() bool {
+    return isInterrupted() && atomic.LoadInt32(&cleanups) == 0
+}
+
+// rememberBranch records the branch a repo has checked out, the first time it is asked
+func rememberBranch(dir string) {
+    branchesMu.Lock()
+    defer branchesMu.Unlock()
+
+    if _, ok := startBranches[dir]; ok {
+        return
+    }
+
+    if out, err := gitTry(gitCommands["branch"], dir); err == nil {
+        startBranches[dir] = strings.TrimSpace(string(out))
+    }
+}
+
+// restoreBranches checks out the branches the repos started on again, after an interrupt
+func restoreBranches() {
+    if !isInterrupted() {
+        return
+    }
+
+    defer cleaningUp()()
+
+    branchesMu.Lock()
+    defer branchesMu.Unlock()
+
+    for dir, branch := range startBranches {
+        current, err := gitTry(gitCommands["branch"], dir)
+        if err != nil || branch == "HEAD" || strings.TrimSpace(string(current)) == branch {
+            continue
+        }
+
+        if out, err := gitTry(gitc{"checkout", branch}, dir); err != nil {
+            slog.Warn(fmt.Sprintf("could not check out %s again in %s:\n%s", branch, dir, strings.TrimSpace(string(out))))
+            continue
+        }
+
+        fmt.Printf("Checked out %s again in %s.\n", branch, dir)
+    }
+}
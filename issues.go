@@ -0,0 +1,115 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "os"
+    "strings"
+    "time"
+)
+
+// halfReleased returns the tags this run pushed, when it failed before the site makefile change was
+// pushed (or opened as a pull request)
+func halfReleased() []string {
+    if summary.Outcome != "failed" && summary.Outcome != "interrupted" {
+        return nil
+    }
+
+    if state.SitePushed || state.PR != "" {
+        return nil
+    }
+
+    var tags []string
+    for _, t := range state.tagged() {
+        if t.TagPushed {
+            tags = append(tags, t.Module+" "+t.Tag)
+        }
+    }
+
+    return tags
+}
+
+// recoveryChecklist lists what the operator does to finish, or undo, the half-release
+func recoveryChecklist(tags []string, host string) []string {
+    makefile := siteRepoOpt + "/" + siteMakeOpt
+
+    checklist := []string{"Find out why the release failed from the error and transcript below, and fix it."}
+
+    // a single release picks up where it stopped when it is run again
+    if len(state.Batch) == 0 {
+        checklist = append(checklist, fmt.Sprintf("Run the release again from %s: it picks up where this one stopped and only pins %s in %s (or run it with --pin-only).", state.ModuleDir, state.Tag, makefile))
+    } else {
+        checklist = append(checklist, fmt.Sprintf("Pin %s in %s by hand, in one site commit.", strings.Join(tags, ", "), makefile))
+    }
+
+    return append(checklist,
+        "Or, if the release should not go out, run ncaapushit rollback as "+usr.Username+" on "+host+" to delete the tags.",
+        "Check that the site branch "+siteBranch+" builds with the new pins, then close this issue.")
+}
+
+// fileHalfRelease opens an issue in JIRA, assigned to the operator, for a release that pushed its tag
+// but not its makefile change, so the half-release is not left behind unnoticed
+func fileHalfRelease() {
+    cfg := config.Jira
+
+    if cfg.BaseURL == "" || cfg.Project == "" {
+        return
+    }
+
+    tags := halfReleased()
+    if len(tags) == 0 {
+        return
+    }
+
+    issueType := cfg.IssueType
+    if issueType == "" {
+        issueType = "Bug"
+    }
+
+    assignee := operator()
+    if name, ok := cfg.Assignees[assignee]; ok {
+        assignee = name
+    }
+
+    host, _ := os.Hostname()
+
+    var checklist []string
+    for _, step := range recoveryChecklist(tags, host) {
+        checklist = append(checklist, "# "+step)
+    }
+
+    description := strings.Join([]string{
+        fmt.Sprintf("%s's release of %s failed at %s (on %s) after the tag was pushed: %s does not pin it yet.", operator(), strings.Join(tags, ", "), displayTime(time.Now()), host, siteMakeOpt),
+        "",
+        "h3. Recovery checklist",
+        strings.Join(checklist, "\n"),
+        "",
+        "h3. Error",
+        "{noformat}\n" + strings.TrimPrefix(summary.Error, "fatal: ") + "\n{noformat}",
+        "",
+        "h3. Transcript",
+        "{noformat}\n" + strings.Join(transcript, "\n") + "\n{noformat}",
+    }, "\n")
+
+    issue := map[string]interface{}{
+        "fields": map[string]interface{}{
+            "project":     map[string]string{"key": cfg.Project},
+            "issuetype":   map[string]string{"name": issueType},
+            "summary":     "Half-released: " + strings.Join(tags, ", ") + " is tagged but not pinned in " + siteMakeOpt,
+            "description": description,
+            "assignee":    map[string]string{"name": assignee},
+        },
+    }
+
+    var created struct {
+        Key string `json:"key"`
+    }
+
+    if err := callAPI("POST", strings.TrimSuffix(cfg.BaseURL, "/")+"/rest/api/2/issue", cfg.auth, issue, &created); err != nil {
+        slog.Warn("could not file a JIRA issue for the half-release: " + strings.TrimSpace(err.Error()))
+        summary.followUp("No JIRA issue could be filed for the half-release of " + strings.Join(tags, ", ") + "; file one by hand so it isn't forgotten.")
+        return
+    }
+
+    summary.Notified = append(summary.Notified, "jira ("+created.Key+", assigned to "+assignee+")")
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "syscall"
+    "time"
+)
+
+// lockConfig says how runs against the same site repo keep out of each other's way. Every run takes
+// a lock file in the site repo's .git before editing it; with remote, runs from other machines are
+// kept out too, by a lock ref on the site repo's origin.
+type lockConfig struct {
+    Remote bool   `json:"remote"` // also hold refs/ncaapushit/lock on the site repo's origin
+    Wait   string `json:"wait"`   // how long to wait for another run's lock, default 2m
+    Stale  string `json:"stale"`  // a lock older than this is taken over, default 1h
+}
+
+const remoteLockRef = "refs/ncaapushit/lock"
+
+// siteLock is who holds the site repo lock and what for
+type siteLock struct {
+    User   string    `json:"user"`
+    Host   string    `json:"host"`
+    PID    int       `json:"pid"`
+    Module string    `json:"module"` // what it is held for: the module being released, or the pins being changed
+    Time   time.Time `json:"time"`
+}
+
+func (l siteLock) String() string {
+    if l.PID == 0 {
+        return "a run that left a lock file that can't be read (since " + displayTime(l.Time) + ")"
+    }
+
+    return fmt.Sprintf("%s (pid %d on %s, for %s since %s)", l.User, l.PID, l.Host, l.Module, displayTime(l.Time))
+}
+
+// held says whether the lock is still held: its run is alive (when it is on this machine) and it
+// isn't older than the stale limit
+func (l siteLock) held() bool {
+    if time.Since(l.Time) > lockDuration(config.Lock.Stale, time.Hour) {
+        return false
+    }
+
+    if host, _ := os.Hostname(); host == l.Host {
+        holder, err := os.FindProcess(l.PID)
+        return err == nil && holder.Signal(syscall.Signal(0)) == nil
+    }
+
+    return true
+}
+
+// lockDuration parses a duration from the lock config, or uses the default
+func lockDuration(value string, fallback time.Duration) time.Duration {
+    if d, err := time.ParseDuration(value); err == nil && value != "" {
+        return d
+    }
+    return fallback
+}
+
+var (
+    // how many times this run has taken the lock, which it releases when the last one lets go
+    lockDepth int
+    // the lock blob this run pushed to the site repo's origin
+    remoteLockBlob string
+)
+
+func lockPath() string {
+    return filepath.Join(siteRepoOpt, ".git", "ncaapushit.lock")
+}
+
+// lockSite takes the site repo lock before the site repo is edited, waiting for another run to finish
+// with it (up to lock.wait). Call the returned function once the site push is done to release it.
+func lockSite(module string) (func(), error) {
+    if lockDepth > 0 {
+        lockDepth++
+        return unlockSite, nil
+    }
+
+    host, _ := os.Hostname()
+    me := siteLock{usr.Username, host, os.Getpid(), module, time.Now()}
+
+    deadline := time.Now().Add(lockDuration(config.Lock.Wait, 2*time.Minute))
+    waiting := false
+
+    for {
+        holder, err := tryLock(me)
+        if err != nil || holder == nil {
+            if err == nil {
+                lockDepth = 1
+            }
+            return unlockSite, err
+        }
+
+        if time.Now().After(deadline) {
+            return unlockSite, withCode(exitChecks, &pushError{"The site repo is locked by " + holder.String() + ".\nTry again once that run is done. If it is gone, delete " + lockPath() + (map[bool]string{true: " and " + remoteLockRef + " on " + remoteFor(siteRepoOpt), false: ""})[config.Lock.Remote] + "."})
+        }
+
+        if !waiting {
+            fmt.Printf("Waiting for the site repo lock held by %s...\n", holder)
+            waiting = true
+        }
+
+        select {
+        case <-interrupted:
+            return unlockSite, errInterrupted
+        case <-time.After(2 * time.Second):
+        }
+    }
+}
+
+// tryLock takes the local lock and then the remote one (if configured), returning who holds it when
+// it is taken. Locks that are no longer held are taken over.
+func tryLock(me siteLock) (*siteLock, error) {
+    contents, _ := json.MarshalIndent(me, "", "  ")
+
+    for {
+        holder, err := tryLocalLock(contents)
+        if err != nil {
+            return nil, err
+        }
+
+        if holder == nil {
+            break
+        }
+
+        if holder.held() {
+            return holder, nil
+        }
+
+        fmt.Printf("Taking over the site repo lock left by %s.\n", holder)
+        if err = removeStaleLock(holder); err != nil {
+            return nil, err
+        }
+    }
+
+    if !config.Lock.Remote {
+        return nil, nil
+    }
+
+    holder, err := tryRemoteLock(contents)
+    if holder != nil || err != nil {
+        removeOwnLock()
+    }
+
+    return holder, err
+}
+
+// tryLocalLock creates the lock file with the contents given, returning who holds it when it is there
+// already. The contents are written to a temporary file that is then linked into place, so the lock
+// file is never seen half written and only one of several runs linking it at once gets it.
+func tryLocalLock(contents []byte) (*siteLock, error) {
+    file, err := ioutil.TempFile(filepath.Dir(lockPath()), "ncaapushit.lock.")
+    if err != nil {
+        return nil, &pushError{"Could not lock the site repo @ " + lockPath() + ": " + err.Error()}
+    }
+    defer os.Remove(file.Name())
+
+    _, err = file.Write(contents)
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+
+    for err == nil {
+        if err = os.Link(file.Name(), lockPath()); err == nil {
+            return nil, nil
+        }
+
+        if os.IsExist(err) {
+            if holder := readLock(lockPath()); holder != nil {
+                return holder, nil
+            }
+            // it was let go of in the meantime
+            err = nil
+        }
+    }
+
+    return nil, &pushError{"Could not lock the site repo @ " + lockPath() + ": " + err.Error()}
+}
+
+// readLock reads who holds a lock file, nil when there is none. One that can't be read is held by an
+// unknown run from the time it was last written, so it is only taken over once it is older than
+// lock.stale.
+func readLock(path string) *siteLock {
+    info, err := os.Stat(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+
+    var holder siteLock
+
+    existing, readErr := ioutil.ReadFile(path)
+    if err != nil || readErr != nil || json.Unmarshal(existing, &holder) != nil || holder.PID == 0 {
+        holder = siteLock{Time: time.Now()}
+        if info != nil {
+            holder.Time = info.ModTime()
+        }
+    }
+
+    return &holder
+}
+
+// removeStaleLock deletes the lock file left by a run that no longer holds it. It is moved aside
+// first, so that a lock another run has just taken in its place is put back rather than deleted.
+func removeStaleLock(stale *siteLock) error {
+    aside := fmt.Sprintf("%s.stale.%d", lockPath(), os.Getpid())
+
+    if err := os.Rename(lockPath(), aside); err != nil {
+        if os.IsNotExist(err) {
+            return nil
+        }
+        return &pushError{"Could not take over the site repo lock @ " + lockPath() + ": " + err.Error()}
+    }
+    defer os.Remove(aside)
+
+    if moved := readLock(aside); moved != nil && (moved.PID != stale.PID || moved.Host != stale.Host || !moved.Time.Equal(stale.Time)) {
+        // someone else's fresh lock: back it goes, unless yet another run has locked since
+        os.Link(aside, lockPath())
+    }
+
+    return nil
+}
+
+// removeOwnLock deletes the lock file if this run holds it
+func removeOwnLock() {
+    holder := readLock(lockPath())
+    host, _ := os.Hostname()
+
+    if holder != nil && holder.PID == os.Getpid() && holder.Host == host {
+        os.Remove(lockPath())
+    }
+}
+
+// tryRemoteLock pushes the lock ref to the site repo's origin, which only succeeds when it isn't there
+// (or is there but no longer held)
+func tryRemoteLock(contents []byte) (*siteLock, error) {
+    file, err := ioutil.TempFile("", "ncaapushit-lock")
+    if err != nil {
+        return nil, &pushError{"Could not write the site repo lock: " + err.Error()}
+    }
+    defer os.Remove(file.Name())
+
+    file.Write(contents)
+    file.Close()
+
+    blob, err := git(gitc{"hash-object", "-w", file.Name()}, siteRepoOpt)
+    if err != nil {
+        return nil, err
+    }
+    sha := strings.TrimSpace(string(blob))
+
+    // expect the ref not to exist, or to still be the stale lock being taken over
+    expect := ""
+
+    for attempt := 0; attempt < 2; attempt++ {
+        if _, err := gitTry(gitc{"push", "--force-with-lease=" + remoteLockRef + ":" + expect, remoteFor(siteRepoOpt), sha + ":" + remoteLockRef}, siteRepoOpt); err == nil {
+            remoteLockBlob = sha
+            return nil, nil
+        }
+
+        // someone has it: see who, and whether they still do
+        if _, err := gitTry(gitc{"fetch", remoteFor(siteRepoOpt), "+" + remoteLockRef + ":" + remoteLockRef}, siteRepoOpt); err != nil {
+            return nil, withCode(exitRejected, &pushError{"Could not take the lock on the site repo's origin (" + remoteLockRef + ")."})
+        }
+
+        out, err := git(gitc{"cat-file", "blob", remoteLockRef}, siteRepoOpt)
+        if err != nil {
+            return nil, err
+        }
+
+        var holder siteLock
+        if json.Unmarshal(out, &holder) == nil && holder.held() {
+            return &holder, nil
+        }
+
+        held, err := git(gitc{"rev-parse", remoteLockRef}, siteRepoOpt)
+        if err != nil {
+            return nil, err
+        }
+        expect = strings.TrimSpace(string(held))
+
+        fmt.Printf("Taking over the lock on the site repo's origin left by %s.\n", holder)
+    }
+
+    return nil, withCode(exitRejected, &pushError{"Could not take the lock on the site repo's origin (" + remoteLockRef + ")."})
+}
+
+// unlockSite releases the site repo lock once this run's last user of it is done
+func unlockSite() {
+    if lockDepth == 0 {
+        return
+    }
+
+    if lockDepth--; lockDepth > 0 {
+        return
+    }
+
+    defer cleaningUp()()
+
+    if remoteLockBlob != "" {
+        // only if it is still ours
+        gitTry(gitc{"push", "--force-with-lease=" + remoteLockRef + ":" + remoteLockBlob, remoteFor(siteRepoOpt), ":" + remoteLockRef}, siteRepoOpt)
+        gitTry(gitc{"update-ref", "-d", remoteLockRef}, siteRepoOpt)
+        remoteLockBlob = ""
+    }
+
+    removeOwnLock()
+}
+
+// pauseSiteLock lets go of the site repo lock for a while (eg. so other runs can commit to a held
+// --debounce push), returning the function that takes it back
+func pauseSiteLock(module string) func() error {
+    depth := lockDepth
+    if depth == 0 {
+        return func() error { return nil }
+    }
+
+    lockDepth = 1
+    unlockSite()
+
+    return func() error {
+        if _, err := lockSite(module); err != nil {
+            return err
+        }
+        lockDepth = depth
+        return nil
+    }
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// lockedSite points the lock at a site repo of its own for the test, with the lock settings given
+func lockedSite(t *testing.T, lock lockConfig) {
+    site := t.TempDir()
+    if err := os.Mkdir(filepath.Join(site, ".git"), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    repo, settings, depth := siteRepoOpt, config.Lock, lockDepth
+    t.Cleanup(func() { siteRepoOpt, config.Lock, lockDepth = repo, settings, depth })
+
+    siteRepoOpt, config.Lock, lockDepth = site, lock, 0
+}
+
+func TestUnreadableLockIsHeldUntilStale(t *testing.T) {
+    lockedSite(t, lockConfig{Wait: "0s", Stale: "1h"})
+
+    // a run that died while the old code was writing its lock, say
+    if err := ioutil.WriteFile(lockPath(), []byte(`{"user": "jsm`), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if _, err := lockSite("ncaa_scores"); err == nil || !strings.Contains(err.Error(), "can't be read") {
+        t.Fatalf("lockSite() over a fresh unreadable lock error = %v, want it held", err)
+    }
+
+    old := time.Now().Add(-2 * time.Hour)
+    if err := os.Chtimes(lockPath(), old, old); err != nil {
+        t.Fatal(err)
+    }
+
+    unlock, err := lockSite("ncaa_scores")
+    if err != nil {
+        t.Fatalf("lockSite() over a stale unreadable lock error = %v", err)
+    }
+    unlock()
+
+    if _, err := os.Stat(lockPath()); !os.IsNotExist(err) {
+        t.Errorf("the lock file was left behind: %v", err)
+    }
+}
+
+func TestUnlockLeavesAnotherRunsLock(t *testing.T) {
+    lockedSite(t, lockConfig{})
+
+    unlock, err := lockSite("ncaa_scores")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // this run's lock was taken over as stale while it ran (eg. it was suspended for over lock.stale)
+    theirs := fmt.Sprintf(`{"user": "other", "host": "build-agent", "pid": %d, "module": "ncaa_teams", "time": %q}`, os.Getpid()+1, time.Now().Format(time.RFC3339))
+    if err := ioutil.WriteFile(lockPath(), []byte(theirs), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    unlock()
+
+    if contents, err := ioutil.ReadFile(lockPath()); err != nil || string(contents) != theirs {
+        t.Errorf("unlocking removed or changed the other run's lock: %q, %v", contents, err)
+    }
+}
+
+// TestLockHolder is run as a child process by TestConcurrentLocks: it takes the lock of the site repo
+// in $NCAA_PUSHIT_TEST_LOCK, holds it for a moment and records when it had it
+func TestLockHolder(t *testing.T) {
+    site := os.Getenv("NCAA_PUSHIT_TEST_LOCK")
+    if site == "" {
+        return
+    }
+
+    siteRepoOpt = site
+
+    unlock, err := lockSite("ncaa_scores")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    start := time.Now()
+    time.Sleep(300 * time.Millisecond)
+    end := time.Now()
+    unlock()
+
+    fmt.Printf("held %d %d\n", start.UnixNano(), end.UnixNano())
+}
+
+func TestConcurrentLocks(t *testing.T) {
+    site := t.TempDir()
+    if err := os.Mkdir(filepath.Join(site, ".git"), 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    self, err := os.Executable()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var (
+        wg    sync.WaitGroup
+        mu    sync.Mutex
+        holds [][2]int64
+    )
+
+    for i := 0; i < 2; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+
+            child := exec.Command(self, "-test.run", "^TestLockHolder$")
+            child.Env = append(os.Environ(), "NCAA_PUSHIT_TEST_LOCK="+site, "HOME="+t.TempDir())
+
+            out, err := child.CombinedOutput()
+            if err != nil {
+                t.Errorf("lock holder failed: %v\n%s", err, out)
+                return
+            }
+
+            var hold [2]int64
+            for _, line := range strings.Split(string(out), "\n") {
+                if strings.HasPrefix(line, "held ") {
+                    fmt.Sscanf(line, "held %d %d", &hold[0], &hold[1])
+                }
+            }
+
+            mu.Lock()
+            holds = append(holds, hold)
+            mu.Unlock()
+        }()
+    }
+    wg.Wait()
+
+    if len(holds) != 2 {
+        t.Fatalf("%d of the 2 runs got the lock", len(holds))
+    }
+
+    sort.Slice(holds, func(i, j int) bool { return holds[i][0] < holds[j][0] })
+    if holds[1][0] < holds[0][1] {
+        t.Errorf("both runs held the lock at once: %v", holds)
+    }
+
+    if _, err := os.Stat(filepath.Join(site, ".git", "ncaapushit.lock")); err == nil {
+        t.Errorf("the lock file was left behind")
+    }
+}
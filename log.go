@@ -0,0 +1,128 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log/slog"
+    "os"
+    "strings"
+    "sync"
+)
+
+// levelTrace is below debug: with --trace, what each git command printed is logged too
+const levelTrace = slog.Level(-8)
+
+// logLevel is warnings and up unless --verbose (debug: each git command and API call) or --trace
+var logLevel = new(slog.LevelVar)
+
+// transcript keeps the last lines logged at debug and up, whether or not they were shown, for the
+// issue filed when a release fails half-way
+var transcript []string
+
+const transcriptLines = 200
+
+func init() {
+    slog.SetDefault(slog.New(&cliHandler{level: logLevel}))
+}
+
+// setupLogging sets how much is logged from --verbose and --trace
+func setupLogging() {
+    switch {
+    case traceOpt:
+        logLevel.Set(levelTrace)
+    case verboseOpt:
+        logLevel.Set(slog.LevelDebug)
+    default:
+        logLevel.Set(slog.LevelInfo)
+    }
+}
+
+// cliHandler writes log records the way the rest of the output reads: warnings as "warning: ..." on
+// stdout with the rest of the run, and debug and trace records on stderr, with their attributes
+type cliHandler struct {
+    level slog.Leveler
+    attrs []slog.Attr
+}
+
+// records are logged from the concurrent checks, so whole lines are written one at a time
+var logMu sync.Mutex
+
+func (h *cliHandler) Enabled(_ context.Context, level slog.Level) bool {
+    return level >= h.level.Level() || level >= slog.LevelDebug
+}
+
+func (h *cliHandler) Handle(_ context.Context, r slog.Record) error {
+    var (
+        b      strings.Builder
+        out    io.Writer = os.Stdout
+        output string
+    )
+
+    switch {
+    case r.Level >= slog.LevelError:
+        b.WriteString("error: ")
+    case r.Level >= slog.LevelWarn:
+        b.WriteString("warning: ")
+    case r.Level >= slog.LevelInfo:
+    case r.Level >= slog.LevelDebug:
+        b.WriteString("debug: ")
+        out = os.Stderr
+    default:
+        b.WriteString("trace: ")
+        out = os.Stderr
+    }
+
+    b.WriteString(r.Message)
+
+    attr := func(a slog.Attr) bool {
+        // command output goes below the line, indented
+        if a.Key == "output" {
+            output = strings.TrimRight(a.Value.String(), "\n")
+            return true
+        }
+
+        value := a.Value.String()
+        if strings.ContainsAny(value, " \t\"") || value == "" {
+            value = fmt.Sprintf("%q", value)
+        }
+        fmt.Fprintf(&b, " %s=%s", a.Key, value)
+        return true
+    }
+
+    for _, a := range h.attrs {
+        attr(a)
+    }
+    r.Attrs(attr)
+
+    b.WriteString("\n")
+    if output != "" {
+        b.WriteString("    " + strings.Replace(output, "\n", "\n    ", -1) + "\n")
+    }
+
+    logMu.Lock()
+    defer logMu.Unlock()
+
+    if r.Level >= slog.LevelDebug {
+        transcript = append(transcript, strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")...)
+        if len(transcript) > transcriptLines {
+            transcript = transcript[len(transcript)-transcriptLines:]
+        }
+    }
+
+    if r.Level < h.level.Level() {
+        return nil
+    }
+
+    _, err := io.WriteString(out, b.String())
+    return err
+}
+
+func (h *cliHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    return &cliHandler{level: h.level, attrs: append(append([]slog.Attr{}, h.attrs...), attrs...)}
+}
+
+// WithGroup is not used here, so groups are flattened into the attributes
+func (h *cliHandler) WithGroup(name string) slog.Handler {
+    return h
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+    "fmt"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+func init() {
+    subcommands["repo-maintenance"] = subcommand{"Garbage-collect, repack and write the commit-graph of every module repo under --workspace, eg. from cron.", repoMaintenance}
+}
+
+// maintenanceConfig schedules the workspace's repo maintenance
+type maintenanceConfig struct {
+    At string `json:"at"` // time of day (eg. 03:00, in the configured zone) ncaapushit serve runs it at, never when empty
+}
+
+// maintenanceSteps keep a repo quick to fetch and describe: gc packs the refs (thousands of tags in the
+// oldest repos) and repacks the objects, and the commit-graph speeds up walking the history
+var maintenanceSteps = []gitc{
+    {"gc", "--quiet"},
+    {"commit-graph", "write", "--reachable", "--changed-paths"},
+}
+
+// packSize returns the size of the repo's packs in KiB, -1 when it can't be read
+func packSize(dir string) int {
+    out, err := gitTry(gitc{"count-objects", "-v"}, dir)
+    if err != nil {
+        return -1
+    }
+
+    for _, line := range strings.Split(string(out), "\n") {
+        if strings.HasPrefix(line, "size-pack: ") {
+            size, err := strconv.Atoi(strings.TrimPrefix(line, "size-pack: "))
+            if err == nil {
+                return size
+            }
+        }
+    }
+
+    return -1
+}
+
+// maintainRepo runs every maintenance step in a repo, returning the size of its packs before and after
+func maintainRepo(dir string) (before, after int, err error) {
+    before = packSize(dir)
+
+    for _, step := range maintenanceSteps {
+        if _, err = gitCheck(step, dir); err != nil {
+            return before, packSize(dir), err
+        }
+    }
+
+    return before, packSize(dir), nil
+}
+
+// maintainWorkspace maintains the module repos under --workspace one at a time, since each is heavy
+// on the disk, and reports on each
+func maintainWorkspace() error {
+    dirs, err := workspaceModules(workspaceOpt)
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Maintaining %d module repo(s) in %s...\n\n", len(dirs), workspaceOpt)
+    fmt.Printf("%-24s %-10s %-10s %s\n", "MODULE", "BEFORE", "AFTER", "TOOK")
+
+    kib := func(size int) string {
+        if size < 0 {
+            return "?"
+        }
+        return strconv.Itoa(size) + "K"
+    }
+
+    var failed []string
+
+    for _, dir := range dirs {
+        module := filepath.Base(dir)
+        start := time.Now()
+
+        before, after, err := maintainRepo(dir)
+        if err != nil {
+            fmt.Printf("%-24s failed: %s\n", module, strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: "))
+            failed = append(failed, module)
+            continue
+        }
+
+        fmt.Printf("%-24s %-10s %-10s %s\n", module, kib(before), kib(after), time.Since(start).Round(time.Second))
+    }
+
+    if len(failed) > 0 {
+        return &pushError{"Maintenance failed in " + strings.Join(failed, ", ")}
+    }
+
+    return nil
+}
+
+// repoMaintenance maintains the workspace once, for running on demand or from cron
+func repoMaintenance(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit repo-maintenance [options]"})
+    }
+
+    return maintainWorkspace()
+}
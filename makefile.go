@@ -0,0 +1,141 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+// renamedMakefile looks through the site repo's history for a rename of the configured makefile and
+// returns what it is called now, if it still exists
+func renamedMakefile() string {
+    out, err := gitTry(gitc{"log", "--diff-filter=R", "-M", "--name-status", "--format=", siteBranchOrHead()}, siteRepoOpt)
+    if err != nil {
+        return ""
+    }
+
+    // eg. "R100\tbarcelona.make\tncaa.make", newest first. Not limited to the makefile's path, since
+    // that would leave out the new name and with it the rename.
+    for _, line := range strings.Split(string(out), "\n") {
+        fields := strings.Split(line, "\t")
+        if len(fields) == 3 && fields[1] == siteMakeOpt {
+            if _, err := os.Stat(filepath.Join(siteRepoOpt, fields[2])); err == nil {
+                return fields[2]
+            }
+        }
+    }
+
+    return ""
+}
+
+// siteBranchOrHead is the site branch on the site's remote when it is known yet, HEAD otherwise
+func siteBranchOrHead() string {
+    if siteBranch == "" {
+        return "HEAD"
+    }
+    return remoteFor(siteRepoOpt) + "/" + siteBranch
+}
+
+// makefilesWith lists the makefiles in the site repo that have an entry for every one of the modules
+func makefilesWith(modules []string) []string {
+    var found []string
+
+    filepath.Walk(siteRepoOpt, func(path string, info os.FileInfo, err error) error {
+        if err != nil {
+            return nil
+        }
+
+        if info.IsDir() && info.Name() == ".git" {
+            return filepath.SkipDir
+        }
+
+        if info.IsDir() || !pushit.IsMakefile(path) {
+            return nil
+        }
+
+        pinned := map[string]bool{}
+
+        lines, err := readLines(path)
+        if err != nil {
+            return nil
+        }
+
+        for _, e := range pushit.ParseMakeLines(path, lines) {
+            if len(e.Keys) > 1 && e.Keys[0] == "projects" {
+                pinned[e.Keys[1]] = true
+            }
+        }
+
+        for _, module := range modules {
+            if !pinned[module] {
+                return nil
+            }
+        }
+
+        rel, _ := filepath.Rel(siteRepoOpt, path)
+        found = append(found, rel)
+        return nil
+    })
+
+    return found
+}
+
+// findMakefile works out which makefile was meant when the configured one doesn't exist: the one it
+// was renamed to, or the only other makefile pinning the modules. With --auto that makefile is used,
+// otherwise it is only suggested.
+func findMakefile(modules []string) (string, error) {
+    missing := "Could not locate makefile @ '" + siteRepoOpt + "/" + siteMakeOpt + "'"
+
+    candidate, reason := renamedMakefile(), "it was renamed in the site repo's history"
+
+    if candidate == "" {
+        found := makefilesWith(modules)
+
+        switch len(found) {
+        case 0:
+            return "", withCode(exitMakefile, &pushError{missing})
+        case 1:
+            candidate, reason = found[0], "it is the only makefile with an entry for "+strings.Join(modules, ", ")
+        default:
+            return "", withCode(exitMakefile, &pushError{missing + ".\nThese makefiles have an entry for " + strings.Join(modules, ", ") +
+                ": " + strings.Join(found, ", ") + "\nPass the right one with --site-makefile (or $NCAA_BARCA_SITE_MAKEFILE)."})
+        }
+    }
+
+    if !autoOpt {
+        return "", withCode(exitMakefile, &pushError{missing + ".\nDid you mean " + candidate + "? (" + reason + ")\n" +
+            "Pass it with --site-makefile (or update $NCAA_BARCA_SITE_MAKEFILE), or re-run with --auto to use it."})
+    }
+
+    slog.Warn(fmt.Sprintf("%s does not exist, using %s instead (%s).", siteMakeOpt, candidate, reason))
+    summary.followUp("Update --site-makefile (or $NCAA_BARCA_SITE_MAKEFILE) to " + candidate + ".")
+    siteMakeOpt = candidate
+
+    return filepath.Join(siteRepoOpt, candidate), nil
+}
+
+// makeEntry is one assignment in a drush makefile (see pushit.MakeEntry)
+type makeEntry = pushit.MakeEntry
+
+// readLines reads a file line by line
+func readLines(path string) ([]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var lines []string
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+
+    return lines, scanner.Err()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// moduleRC is the shape of the optional .pushitrc file at the top of a module repo, where a module
+// declares what has to hold before it is released
+type moduleRC struct {
+    Preflight   []preflightCommand `json:"preflight"`
+    PackageJSON bool               `json:"packageJson"` // set package.json's version to each new version (see --package-json)
+}
+
+// preflightCommand is one module-specific quality gate (eg. lint, unit tests, a features check)
+type preflightCommand struct {
+    Name    string `json:"name"`
+    Command string `json:"command"` // run with sh -c in the module repo
+    Timeout string `json:"timeout"` // eg. 90s or 5m, default 5m
+}
+
+// loadModuleRC reads the module's .pushitrc, which is optional
+func loadModuleRC() (moduleRC, error) {
+    var rc moduleRC
+    path := filepath.Join(cwd, ".pushitrc")
+
+    contents, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return rc, nil
+    } else if err != nil {
+        return rc, &pushError{"There was a problem reading " + path}
+    }
+
+    if err = json.Unmarshal(contents, &rc); err != nil {
+        return rc, &pushError{path + " is not valid JSON: " + err.Error()}
+    }
+
+    return rc, nil
+}
+
+// moduleChecks turns the module's preflight commands into checks, run in the order they are declared
+func moduleChecks(rc moduleRC) ([]check, error) {
+    var checks []check
+
+    for _, pc := range rc.Preflight {
+        pc := pc
+        timeout := 5 * time.Minute
+
+        if pc.Timeout != "" {
+            parsed, err := time.ParseDuration(pc.Timeout)
+            if err != nil {
+                return nil, &pushError{"Invalid timeout '" + pc.Timeout + "' for the " + pc.Name + " preflight command in .pushitrc"}
+            }
+            timeout = parsed
+        }
+
+        name := pc.Name
+        if name == "" {
+            name = pc.Command
+        }
+
+        checks = append(checks, check{name, func() error {
+            ctx, cancel := context.WithTimeout(context.Background(), timeout)
+            defer cancel()
+
+            cmd := exec.CommandContext(ctx, "sh", "-c", pc.Command)
+            cmd.Dir = cwd
+            slog.Debug("sh -c "+pc.Command, "dir", cwd)
+
+            out, err := cmd.CombinedOutput()
+            slog.Log(ctx, levelTrace, name+" finished", "output", string(out))
+
+            if ctx.Err() == context.DeadlineExceeded {
+                return &pushError{"timed out after " + timeout.String()}
+            } else if err != nil {
+                // the end of the output is usually where the reason is
+                lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+                if len(lines) > 10 {
+                    lines = lines[len(lines)-10:]
+                }
+                return &pushError{err.Error() + "\n      " + strings.Join(lines, "\n      ")}
+            }
+            return nil
+        }})
+    }
+
+    return checks, nil
+}
+
+// runModuleChecks runs the module's own preflight commands, if it declares any
+func runModuleChecks(module string) error {
+    rc, err := loadModuleRC()
+    if err != nil {
+        return err
+    }
+
+    checks, err := moduleChecks(rc)
+    if err != nil || len(checks) == 0 {
+        return err
+    }
+
+    return runChecksInOrder("module: "+module, checks)
+}
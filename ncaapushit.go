@@ -14,45 +14,113 @@
 package main
 
 import (
-    "bufio"
+    "errors"
     "flag"
     "fmt"
     "io/ioutil"
+    "log/slog"
     "os"
-    "os/exec"
     "os/user"
+    "path/filepath"
+    "sort"
     "strconv"
     "strings"
+    "time"
 )
 
 type nestedMap map[string]map[string]string
-type gitc []string
 type pushError struct {
     msg string
 }
 
 var (
     // options for this utility
-    bumpOpt     string
-    moduleOpt   string
-    siteRepoOpt string
-    siteMakeOpt string
-    topicOpt    string
-    noModuleOpt bool
+    bumpOpt        string
+    moduleOpt      string
+    modulesOpt     stringList
+    manifestOpt    string
+    siteRepoOpt    string
+    siteMakeOpt    string
+    topicOpt       string
+    noModuleOpt    bool
+    configOpt      string
+    profileOpt     string
+    summaryOpt     string
+    branchOpt      string
+    preOpt         string
+    setVersionOpt  string
+    tagOnlyOpt     bool
+    canaryOpt      bool
+    pinOnlyOpt     bool
+    schemeOpt      string
+    isolatedOpt    bool
+    changelogOpt   bool
+    packageJSONOpt bool
+    removeEntryOpt bool
+    deleteOpt      bool
+    debounceOpt    time.Duration
+    viaPROpt       bool
+    signOpt        bool
+    autostashOpt   bool
+    workspaceOpt   string
+    fromOpt        string
+    toOpt          string
+    timingOpt      bool
+    scanPushOpt    bool
+    limitOpt       int
+    jsonOpt        bool
+    autoOpt        bool
+    composerOpt    bool
+    listenOpt      string
+    approverFdOpt  int
+    keepBranchOpt  bool
+    remoteTopicOpt bool
+    remoteOpt      string
+    siteUpdaterOpt string
+    yesOpt         bool
+    verboseOpt     bool
+    traceOpt       bool
     // cwd or overridden module dir
     cwd string
+    // site repo branch the makefile change is committed to (--site-branch, a profile or the environment,
+    // detected when empty) and commit message format (may be overridden by a profile)
+    siteBranch   string
+    commitFormat = "{topic} {module} -> {version}"
+    // tags are created and pinned under this namespace when set (eg. staging/v1.5.0)
+    tagNamespace string
+    // set when the module is a subdirectory of a repo holding several modules, whose tags are prefixed with it (eg. ncaa_scores/v1.4.0)
+    monorepoModule string
 )
 
 var usr, _ = user.Current()
 var optionsMap = nestedMap{
     "bump": {
-        "usage":   "The semver column of the module version to bump (major|minor|patch).",
+        "usage":   "The semver column of the module version to bump (major|minor|patch), prerelease to cut the next prerelease, release to finalize one, or auto to choose from conventional commit messages.",
         "default": "patch",
+        "enum":    "major|minor|patch|prerelease|release|auto",
+    },
+    "pre": {
+        "usage": "Prerelease identifier (eg. alpha, beta, rc). Combined with --bump this cuts the first prerelease of the bumped version (eg. --bump=minor --pre=rc -> 2.1.0-rc.1).",
+    },
+    "set-version": {
+        "usage": "Tag this exact version (eg. 2.0.0) instead of bumping the latest one, for hotfixes or to align related modules. It must be higher than the latest version.",
+    },
+    "tag-only": {
+        "usage": "Only tag and push the new version; leave the site makefile alone (eg. to pin it later with --pin-only).",
+    },
+    "pin-only": {
+        "usage": "Only move the makefile pin to an existing tag (the latest one, or --set-version) without tagging anything, eg. when the makefile change failed on a previous run.",
+    },
+    "canary": {
+        "usage": "Pin the new version in the config file's canary makefile instead of the site makefile, to soak before canary promotes it.",
     },
     "module": {
-        "usage":   "The path to the module with changes to push.",
+        "usage":   "The path to the module with changes to push (defaults to $PWD). Repeat it to release several modules with one site commit.",
         "default": "$PWD",
     },
+    "manifest": {
+        "usage": "File listing the modules to release together, one \"path [bump] [topic]\" per line.",
+    },
     "site-repo": {
         "usage":   "The path to your site (app) repo where the makefile resides.",
         "default": usr.HomeDir + "/Repos/ncaa-barcelona",
@@ -61,21 +129,120 @@ var optionsMap = nestedMap{
         "usage":   "Filename of the *.make file to alter.",
         "default": "barcelona.make",
     },
+    "site-updater": {
+        "usage":   "How the new version is recorded in the site file: drush (makefile), composer (composer.json), manifest (YAML module: version map), json (JSON version map) or kubernetes (image tag or chart version in Kubernetes manifests or Helm values). Detected from the file when auto.",
+        "default": "auto",
+        "enum":    "auto|drush|composer|manifest|json|kubernetes",
+    },
+    "auto": {
+        "usage": "When the makefile isn't found, use the one it was renamed to (or the only other makefile pinning the module) instead of just suggesting it.",
+    },
+    "composer-update": {
+        "usage": "When the site pins modules in composer.json (--site-makefile composer.json), also run composer update for the module's package with --lock and commit composer.lock.",
+    },
+    "site-branch": {
+        "usage": "The site repo branch to commit the makefile change to (eg. develop, or the release branch prod deploys from). Detected from origin/HEAD when omitted.",
+    },
     "topic": {
         "usage": "If you have already merged your topic branch, you must provide the name of it (eg. NCAA-31337), otherwise the current branch will be used.",
     },
     "no-module": {
         "usage":   "If you are working on a repo that is merely a container for other modules (ie. has no *.module file of its own), use this option.",
     },
-}
-
-var gitCommands = map[string]gitc{
-    "update":   {"up"},
-    "branch":   {"rev-parse", "--abbrev-ref", "HEAD"},
-    "latest":   {"describe", "master", "--abbrev=0", "--tags"},
-    "coMaster": {"checkout", "master"},
-    "pushit":   {"push", "origin", "master"},
-    "pushtags": {"push", "origin", "--tags"},
+    "config": {
+        "usage":   "Path to the JSON config file where profiles are defined.",
+        "default": usr.HomeDir + "/.ncaapushit.json",
+    },
+    "profile": {
+        "usage": "Name of the profile from the config file to push with (eg. staging, qa, prod).",
+    },
+    "version-scheme": {
+        "usage":   "How the module's versions are written: semver (v1.2.3) or drupal (7.x-1.4, where only the module portion after the core prefix is bumped). From the config file's tags section when auto.",
+        "default": "auto",
+        "enum":    "auto|semver|drupal",
+    },
+    "default-branch": {
+        "usage": "The integration branch of the module repo (eg. master, main, develop). Detected from origin/HEAD when omitted.",
+    },
+    "changelog": {
+        "usage": "Add an entry for the new version to CHANGELOG.md in the module repo (committed to the default branch) and to the tag annotation.",
+    },
+    "package-json": {
+        "usage": "When the module repo has a package.json, set its version (and package-lock.json's) to the new version in the commit that is tagged. Also turned on by \"packageJson\": true in the module's .pushitrc.",
+    },
+    "remove-entry": {
+        "usage": "deprecate: remove the module's makefile entry instead of marking it as deprecated.",
+    },
+    "delete": {
+        "usage": "archive-tags: delete the archived tags from the module's remote and the local repo once they are bundled.",
+    },
+    "workspace": {
+        "usage":   "scan, pin, serve: the directory holding your module repos.",
+        "default": usr.HomeDir + "/Repos",
+    },
+    "from": {
+        "usage": "promote: the makefile in the site repo to copy the pins from (eg. staging.make).",
+    },
+    "to": {
+        "usage": "promote: the makefile in the site repo to copy the pins to (eg. prod.make).",
+    },
+    "push": {
+        "usage": "scan: offer to release each module that has unreleased commits.",
+    },
+    "limit": {
+        "usage":   "history: how many of the newest records to list (0 for all).",
+        "default": "20",
+    },
+    "json": {
+        "usage": "history: print the records as JSON lines instead of a table.",
+    },
+    "debounce": {
+        "usage": "Hold the site push open this long (eg. 10m) so other releases against the same site repo join it, triggering one staging build instead of several.",
+    },
+    "via-pr": {
+        "usage": "Commit the makefile change to a release branch and open a Bitbucket pull request for it instead of pushing to the site branch.",
+    },
+    "sign": {
+        "usage": "Create an annotated, GPG-signed tag (git tag -s) with the message format and key from the config file.",
+    },
+    "autostash": {
+        "usage": "Stash uncommitted changes in the module and site repos before the release and restore them afterwards, instead of refusing to run.",
+    },
+    "timing": {
+        "usage": "Time every phase of the run and every git command, and print a breakdown at the end (also written with --summary-out).",
+    },
+    "isolated": {
+        "usage": "Run git with a minimal, controlled config (identity from the config file, no user/system config, aliases or hooks).",
+    },
+    "summary-out": {
+        "usage": "Write the run summary as JSON to this file (for CI pipelines wrapping the utility).",
+    },
+    "remote": {
+        "usage": "The module repo's remote to fetch from and push tags to (eg. upstream on a fork). Defaults to the config file's remote for the module, then origin.",
+    },
+    "keep-branch": {
+        "usage": "Keep the local topic branch after the release instead of deleting it (it is only deleted once merged into the default branch).",
+    },
+    "delete-remote-topic": {
+        "usage": "Also delete the topic branch from the module's origin after the release, if it is merged into the default branch.",
+    },
+    "yes": {
+        "usage": "Answer y to every yes/no question, for unattended runs. Questions that need another answer (eg. typing the module name to confirm a high impact release) still abort.",
+    },
+    "verbose": {
+        "usage": "Log each git command (with the directory it ran in and how long it took), API call and hook as it runs, to stderr.",
+    },
+    "trace": {
+        "usage": "Log what --verbose does and everything each git command printed, to stderr.",
+    },
+    "listen": {
+        "usage":   "serve: the address to listen for Bitbucket webhooks on.",
+        "default": ":8780",
+    },
+    "approver-fd": {
+        "usage":   "Set by serve on the releases it runs: the inherited descriptor to read who approved the release from.",
+        "default": "0",
+    },
 }
 
 // error reporter/handler for the utility
@@ -88,7 +255,7 @@ func (e *pushError) Error() string {
 func applyEnvOptions() {
     if siteRepoOpt == optionsMap["site-repo"]["default"] {
         if envRepo := os.Getenv("NCAA_BARCA_SITE_REPO_PATH"); envRepo != "" {
-            siteRepoOpt = envRepo
+            siteRepoOpt = expandPath(envRepo)
         }
     }
 
@@ -97,32 +264,152 @@ func applyEnvOptions() {
             siteMakeOpt = envMake
         }
     }
+
+    if siteBranch == "" {
+        siteBranch = os.Getenv("NCAA_BARCA_SITE_BRANCH")
+    }
 }
 
-// getMakefile reads the provided site directory and locates the makefile
-func getMakefile() (string, error) {
-    var makefile string
+// expandPath makes a path given as an option usable as-is: a leading ~ and $VARS are expanded and
+// a relative path is made absolute (from where the utility was run)
+func expandPath(path string) string {
+    if path == "" {
+        return path
+    }
 
-    fmt.Print("Updating site repo...")
-    git(gitCommands["update"], siteRepoOpt)
-    fmt.Print(" complete\n")
+    path = os.ExpandEnv(path)
 
-    siteFiles, err := ioutil.ReadDir(siteRepoOpt)
-    foundMakefile := false
+    // $HOME first, like the shell would have
+    if path == "~" || strings.HasPrefix(path, "~/") {
+        home, err := os.UserHomeDir()
+        if err != nil {
+            home = usr.HomeDir
+        }
+        path = home + path[1:]
+    }
 
-    if err != nil {
-        return "", &pushError{("There was a problem reading the site repo directory @ " + siteRepoOpt)}
+    if abs, err := filepath.Abs(path); err == nil {
+        path = abs
+    }
+
+    return path
+}
+
+// expandPathOptions expands every path option given on the command-line. The site makefiles are
+// relative to the site repo, so only their $VARS are expanded.
+func expandPathOptions() {
+    for i, module := range modulesOpt {
+        modulesOpt[i] = expandPath(module)
+    }
+
+    if moduleOpt != optionsMap["module"]["default"] {
+        moduleOpt = expandPath(moduleOpt)
+    }
+
+    manifestOpt = expandPath(manifestOpt)
+    siteRepoOpt = expandPath(siteRepoOpt)
+    siteMakeOpt = os.ExpandEnv(siteMakeOpt)
+    fromOpt = os.ExpandEnv(fromOpt)
+    toOpt = os.ExpandEnv(toOpt)
+    configOpt = expandPath(configOpt)
+    workspaceOpt = expandPath(workspaceOpt)
+    summaryOpt = expandPath(summaryOpt)
+}
+
+// validateOptions checks every enum-like option against its allowed values and reports all of
+// the problems together instead of failing on the first one
+func validateOptions() error {
+    var problems []string
+
+    for name, option := range optionsMap {
+        if option["enum"] == "" {
+            continue
+        }
+
+        value := flag.Lookup(name).Value.String()
+        allowed := strings.Split(option["enum"], "|")
+        valid := false
+
+        for _, a := range allowed {
+            if value == a {
+                valid = true
+                break
+            }
+        }
+
+        if !valid {
+            problem := fmt.Sprintf("  --%s=%q is not one of %s", name, value, strings.Join(allowed, ", "))
+            if suggestion := closest(value, allowed); suggestion != "" {
+                problem += fmt.Sprintf(" (did you mean %q?)", suggestion)
+            }
+            problems = append(problems, problem)
+        }
+    }
+
+    if keepBranchOpt && remoteTopicOpt {
+        problems = append(problems, "  --keep-branch and --delete-remote-topic can't be used together")
     }
 
-    for _, file := range siteFiles {
-        if file.Name() == siteMakeOpt {
-            foundMakefile = true
-            break
+    if viaPROpt && debounceOpt > 0 {
+        problems = append(problems, "  --via-pr and --debounce can't be used together")
+    }
+
+    if tagOnlyOpt && pinOnlyOpt {
+        problems = append(problems, "  --tag-only and --pin-only can't be used together")
+    }
+
+    if tagOnlyOpt && (viaPROpt || debounceOpt > 0 || canaryOpt) {
+        problems = append(problems, "  --tag-only leaves the site repo alone, so it can't be used with --via-pr, --debounce or --canary")
+    }
+
+    if pinOnlyOpt && (changelogOpt || signOpt) {
+        problems = append(problems, "  --pin-only pins a tag that already exists, so it can't be used with --changelog or --sign")
+    }
+
+    if setVersionOpt != "" {
+        if _, err := parseVersion(strings.TrimPrefix(setVersionOpt, "v")); err != nil {
+            problems = append(problems, "  --set-version="+strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: "))
+        }
+
+        set := flagsSet()
+        for _, name := range []string{"bump", "v", "pre"} {
+            if set[name] {
+                problems = append(problems, "  --"+name+" and --set-version can't be used together")
+            }
+        }
+    }
+
+    if batchMode() {
+        for name, set := range map[string]bool{"topic": topicOpt != "", "via-pr": viaPROpt, "debounce": debounceOpt > 0, "set-version": setVersionOpt != "", "tag-only": tagOnlyOpt, "pin-only": pinOnlyOpt, "canary": canaryOpt} {
+            if set {
+                problems = append(problems, "  --"+name+" can't be used when releasing several modules")
+            }
         }
     }
 
+    if len(problems) > 0 {
+        sort.Strings(problems)
+        return &pushError{"Invalid options:\n" + strings.Join(problems, "\n")}
+    }
+
+    return nil
+}
+
+// getMakefile reads the provided site directory and locates the makefile, looking for the one that
+// was meant (see findMakefile) when it isn't there
+func getMakefile(modules ...string) (string, error) {
+    var makefile string
+
+    if _, err := ioutil.ReadDir(siteRepoOpt); err != nil {
+        return "", &pushError{("There was a problem reading the site repo directory @ " + siteRepoOpt)}
+    }
+
+    // it may be in a subdirectory, eg. a GitOps repo's deploy/scores.yaml
+    info, err := os.Stat(filepath.Join(siteRepoOpt, siteMakeOpt))
+    foundMakefile := err == nil && !info.IsDir()
+
     if !foundMakefile {
-        return "", &pushError{("Could not locate makefile @ '" + siteRepoOpt + "/" + siteMakeOpt + "'")}
+        return findMakefile(modules)
     }
 
     makefile = siteRepoOpt + "/" + siteMakeOpt
@@ -145,20 +432,24 @@ func getModule() (string, error) {
     cwdParts := strings.Split(cwd, string(os.PathSeparator))
     module = string(cwdParts[len(cwdParts)-1])
 
+    if isSiteRepo(cwd) {
+        return "", withCode(exitOptions, &pushError{cwd + " is the site repo (or a checkout of it), not a module repo.\n\n" +
+            "This utility releases a module and then updates the site repo's makefile itself, so run it from the top-level of the module repo you want to release (ie. where the *.module file is located), or point '--module' at it.\n" +
+            "The site repo is set with '--site-repo' (or $NCAA_BARCA_SITE_REPO_PATH)."})
+    }
+
     if noModuleOpt != true {
         // verify that the dir exists and has a *.module within
         files, readErr := ioutil.ReadDir(cwd)
         foundModule := false
 
         if readErr != nil {
+            if suggestion := suggestModule(module); suggestion != "" {
+                return "", &pushError{"There was a problem reading the module directory @ " + cwd + "\n\nDid you mean '" + suggestion + "'? It is pinned in the site makefile.\n"}
+            }
             return "", &pushError{("There was a problem reading the module directory @ " + cwd + "\n\nPlease change directory to the top-level of the module repo you want to act on (ie. where the *.module file is located) and try again.\nYou may provide a full path using the '--module' option of this utility.\n")}
         }
 
-        // change to the provided directory if we're not already there
-        if moduleOpt != "$PWD" {
-            os.Chdir(cwd)
-        }
-
         for _, file := range files {
             if seekModule := module + ".module"; seekModule == file.Name() {
                 foundModule = true
@@ -167,66 +458,101 @@ func getModule() (string, error) {
         }
 
         if !foundModule {
+            if suggestion := suggestModule(module); suggestion != "" && suggestion != module {
+                return "", &pushError{"Could not locate *.module for '" + module + "' @ " + cwd + "\nDid you mean '" + suggestion + "'? It is pinned in the site makefile."}
+            }
             return "", &pushError{("Could not locate *.module for '" + module + "' @ " + cwd)}
         }
 
         fmt.Println("Module repo:", module)
     }
 
+    // a module below the top of its repo shares the repo with others, so it gets tags of its own
+    monorepoModule = ""
+
+    if top, err := gitTry(gitc{"rev-parse", "--show-toplevel"}, cwd); err == nil && !sameDir(strings.TrimSpace(string(top)), cwd) {
+        monorepoModule = module
+        fmt.Printf("Monorepo: %s is a subdirectory, so its tags are prefixed with %s/\n", module, module)
+    }
+
     return module, nil
 }
 
-// git runs a git command in given directory
-func git(command gitc, dir string) []byte {
-    os.Chdir(dir)
-    out, err := exec.Command("git", command...).CombinedOutput()
+// isSiteRepo says whether a directory is (in) the site repo, or another checkout of it: it has the
+// site makefile, or its origin is the site repo's remote
+func isSiteRepo(dir string) bool {
+    if siteRepoOpt == "" {
+        return false
+    }
 
+    top, err := gitTry(gitc{"rev-parse", "--show-toplevel"}, dir)
     if err != nil {
-        fmt.Println(string(out))
-        panic("there was a problem running the git command '" + strings.Join(command, " ") + "'. See output above for clues.")
+        return false
     }
+    repo := strings.TrimSpace(string(top))
 
-    return out
+    if sameDir(repo, siteRepoOpt) {
+        return true
+    }
+
+    if _, err := os.Stat(filepath.Join(repo, siteMakeOpt)); siteMakeOpt != "" && err == nil {
+        return true
+    }
+
+    origin, err := gitTry(gitc{"remote", "get-url", "origin"}, repo)
+    siteOrigin, siteErr := gitTry(gitc{"remote", "get-url", remoteFor(siteRepoOpt)}, siteRepoOpt)
+
+    return err == nil && siteErr == nil && strings.TrimSpace(string(origin)) == strings.TrimSpace(string(siteOrigin))
 }
 
-// tagVersion creates the new tag in Git and pushes it to site repo (origin)
-func tagVersion(version string) bool {
-    // if module repo was not checked out to master already, perform clean up and prepare for tagging
-    if topicOpt != "master" {
-        git(gitCommands["coMaster"], cwd)        // checkout master
-        git(gitc{"branch", "-d", topicOpt}, cwd) // delete topic branch which we assume has been merged via pull request
+// sameDir says whether two paths are the same directory, following symlinks
+func sameDir(a, b string) bool {
+    a, errA := filepath.EvalSymlinks(a)
+    b, errB := filepath.EvalSymlinks(b)
+    return errA == nil && errB == nil && filepath.Clean(a) == filepath.Clean(b)
+}
 
-        fmt.Printf("Module Repo Cleanup: Local topic branch '%s' was deleted.\n", topicOpt)
-    }
+// resolveTopicMismatch lets the operator choose between the --topic value and the branch that is
+// actually checked out, since a mismatch is usually just a stale flag from shell history
+func resolveTopicMismatch(currentBranch string) error {
+    fmt.Printf("\nThe branch supplied via --topic does not match the current module branch:\n\n")
+    fmt.Printf("  1) %s (checked out) - named in the site commit message and deleted locally after tagging\n", currentBranch)
+    fmt.Printf("  2) %s (--topic)     - named in the site commit message; %s is left alone\n", topicOpt, currentBranch)
+    fmt.Printf("  3) abort\n\n")
 
-    git(gitc{"tag", "v" + version}, cwd)
-    git(gitCommands["pushtags"], cwd)
+    switch prompt("Which topic branch should be used? (1/2/3): ") {
+    case "1":
+        topicOpt = currentBranch
+    case "2":
+        // keep the --topic value
+    default:
+        return &pushError{"The branch supplied via --topic does not match the current module branch (" + topicOpt + " != " + currentBranch + ")"}
+    }
 
-    return true
+    return nil
 }
 
 // getVersions determines the latest module version (via Git) and bumps the appropriate semver column
 func getVersions() (string, string, error) {
-    var (
-        newVersion    [3]int
-        currentBranch string
-        latest        string
-        splitVersion  []string
-    )
+    if err := updateModuleRepo(); err != nil {
+        return "", "", err
+    }
 
-    fmt.Print("Updating module repo...")
-    git(gitCommands["update"], cwd)
-    fmt.Print(" complete\n")
+    out, err := git(gitCommands["branch"], cwd)
+    if err != nil {
+        return "", "", err
+    }
 
-    currentBranch = string(git(gitCommands["branch"], cwd))
-    currentBranch = strings.Trim(currentBranch, " \n\t\r")
+    currentBranch := strings.Trim(string(out), " \n\t\r")
 
-    if currentBranch == "master" && topicOpt == "" {
+    if currentBranch == branchOpt && topicOpt == "" {
         return "", "", &pushError{"If you have already merged your branch, you must provide it via the --topic option. Otherwise, checkout the branch and re-run this utility."}
     }
 
-    if topicOpt != "" && currentBranch != topicOpt && currentBranch != "master" {
-        return "", "", &pushError{"The branch supplied via --topic does not match the current module branch (" + topicOpt + " != " + currentBranch + ")"}
+    if topicOpt != "" && currentBranch != topicOpt && currentBranch != branchOpt {
+        if err := resolveTopicMismatch(currentBranch); err != nil {
+            return "", "", err
+        }
     }
 
     // if no topic was supplied, store the current branch for future reference
@@ -234,94 +560,261 @@ func getVersions() (string, string, error) {
         topicOpt = currentBranch
     }
 
-    // ** get the latest tag and bump it
-    gitVer := git(gitCommands["latest"], cwd)
+    return bumpLatest(false)
+}
+
+// pinnedVersions works out what a --pin-only run moves the makefile between: the version pinned now
+// and an existing tag, the one given with --set-version or else the latest on the default branch
+func pinnedVersions(makefile, module string) (string, string, error) {
+    if err := updateModuleRepo(); err != nil {
+        return "", "", err
+    }
+
+    // nothing is merged or tagged, so without --topic the commit is named after the default branch
+    if topicOpt == "" {
+        topicOpt = branchOpt
+    }
 
-    latest = strings.Trim(string(gitVer[1:]), " \n\t")
-    fmt.Printf("Current version: %s\n", latest)
-    splitVersion = strings.Split(latest, ".")
+    latest, err := currentPin(makefile, module)
+    if err != nil {
+        return "", "", err
+    }
+
+    fmt.Printf("Current pin: %s\n", latest)
 
-    switch bumpOpt {
-    case "major":
-        newVersion[0], _ = strconv.Atoi(splitVersion[0])
-        newVersion[0]++
+    newVersion := strings.TrimPrefix(setVersionOpt, "v")
 
-        splitVersion[0] = strconv.Itoa(newVersion[0])
-        splitVersion[1] = "0"
-        splitVersion[2] = "0"
-        break
-    case "minor":
-        newVersion[1], _ = strconv.Atoi(splitVersion[1])
-        newVersion[1]++
+    if newVersion == "" {
+        tag, _, err := latestTag(cwd, branchOpt)
+        if err != nil {
+            return "", latest, err
+        }
 
-        splitVersion[1] = strconv.Itoa(newVersion[1])
-        splitVersion[2] = "0"
-        break
-    case "patch":
-        newVersion[2], _ = strconv.Atoi(splitVersion[2])
-        newVersion[2]++
+        if tag == "" {
+            return "", latest, &pushError{"There are no version tags on " + branchOpt + " to pin " + module + " to."}
+        }
+        newVersion = strings.TrimPrefix(tag, tagPrefix())
+    }
 
-        splitVersion[2] = strconv.Itoa(newVersion[2])
-        break
+    if newVersion == latest {
+        return "", latest, &pushError{module + " is already pinned to " + tagName(latest) + "; there is nothing to pin."}
     }
 
-    return strings.Join(splitVersion, "."), latest, nil
+    return newVersion, latest, nil
 }
 
-// getUpdatedMakefile scans existing makefile for current module + version, replaces that line with the new version
-func getUpdatedMakefile(makefile, module, newVersion, latest string) ([]string, error) {
-    var outFile []string
+// resumeRelease works out what is left of a release whose version is already tagged with everything
+// on the default branch, eg. when an earlier run was cut off after pushing the tag: nothing when the
+// makefile pins it already (errUpToDate), otherwise the makefile change, which the run then makes
+// the way --pin-only does
+func resumeRelease(makefile, module string, unchanged *unchangedError) (string, string, error) {
+    tag := tagName(unchanged.latest)
 
-    file, _ := os.Open(makefile)
-    defer file.Close()
+    if out, err := gitCheck(gitc{"ls-remote", "--tags", remoteFor(cwd), "refs/tags/" + tag}, cwd); err != nil || out == "" {
+        // a tag an interrupted run made but never pushed
+        return "", unchanged.latest, unchanged
+    }
 
-    scanner := bufio.NewScanner(file)
-    seekLine := ("projects[" + module + "][download][tag] = \"v" + string(latest) + "\"")
-    replacedVersion := false
+    pinned, err := currentPin(makefile, module)
+    if err != nil {
+        return "", unchanged.latest, unchanged
+    }
 
-    // read the makefile in line by line using the scanner
-    for scanner.Scan() {
-        if strings.Contains(scanner.Text(), seekLine) {
-            // update the version once the correct line is located
-            replaceVersion := strings.Replace(scanner.Text(), strings.Trim(string(latest), "\n\t "), newVersion, -1)
-            outFile = append(outFile, replaceVersion)
+    if pinned == unchanged.latest {
+        fmt.Printf("\n%s is tagged with everything on %s and pinned in %s already; there is nothing to do.\n", tag, branchOpt, siteMakeOpt)
+        return unchanged.latest, pinned, errUpToDate
+    }
 
-            replacedVersion = true
-        } else {
-            outFile = append(outFile, scanner.Text())
-        }
+    // the state of the last push tells whether it was this one that stopped short
+    if last, err := loadState(); err == nil && last.Module == module && last.Tag == tag && last.TagPushed && !last.SitePushed && !last.RolledBack {
+        fmt.Printf("\nResuming the push of %s %s from %s: the tag was pushed but the makefile was not updated.\n", module, tag, displayTime(last.Time))
+    } else {
+        fmt.Printf("\n%s is tagged with everything on %s but %s pins %s; the release goes on by pinning it.\n", tag, branchOpt, siteMakeOpt, tagName(pinned))
+    }
+
+    pinOnlyOpt = true
+
+    return unchanged.latest, pinned, nil
+}
+
+// updateModuleRepo works out the module's default branch (unless given) and brings it up to date
+func updateModuleRepo() error {
+    if branchOpt == "" {
+        branchOpt = detectDefaultBranch(cwd)
     }
 
-    if !replacedVersion {
-        return outFile, &pushError{"Either the module '" + module + "' or latest tag 'v" + latest + "' was not found in the makefile.\nMake sure your site repo is up-to-date before using this utility."}
+    fmt.Print("Updating module repo...")
+    if err := updateRepo(cwd, branchOpt); err != nil {
+        return err
     }
+    fmt.Print(" complete\n")
 
-    return outFile, nil
+    return nil
 }
 
-// pushUpdatedMakefile writes the new makefile contents to disk, commits the change, and pushes it up to the site repo
-func pushUpdatedMakefile(outFile *[]string, commitMsg string) error {
-    // make sure this repo is up to date and checked out to master
-    git(gitCommands["update"], siteRepoOpt)
-    git(gitCommands["coMaster"], siteRepoOpt)
+// updateSiteRepo works out the site branch (unless configured) and brings it up to date
+func updateSiteRepo() error {
+    if siteBranch == "" {
+        siteBranch = detectDefaultBranch(siteRepoOpt)
+    }
+
+    fmt.Print("Updating site repo...")
+    if err := updateRepo(siteRepoOpt, siteBranch); err != nil {
+        return err
+    }
+    fmt.Print(" complete\n")
 
-    // write the updated makefile
-    writeFile := []byte(strings.Join(*outFile, "\n"))
-    err := ioutil.WriteFile(siteRepoOpt+"/"+siteMakeOpt, writeFile, 0644)
+    return nil
+}
 
+// bumpLatest gets the highest version tagged on the default branch and bumps it. A module with nothing
+// new since that version is refused unless final is set: a deprecation's final version marks the
+// end of the module, not new work, and a retired module usually has nothing new.
+func bumpLatest(final bool) (string, string, error) {
+    tag, current, err := latestTag(cwd, branchOpt)
     if err != nil {
-        return &pushError{"Could not write new makefile. Check permissions and try again."}
+        return "", "", err
     }
 
-    // commit the changes and pushit
-    git(gitc{"commit", siteMakeOpt, "-m", commitMsg}, siteRepoOpt)
+    latest := strings.TrimPrefix(tag, tagPrefix())
+
+    if err = runHooks("pre-bump", filepath.Base(cwd), latest, ""); err != nil {
+        return "", latest, err
+    }
 
-    fmt.Println(commitMsg)
-    fmt.Println("\t`-- committed changes with message")
+    if tag == "" {
+        return firstRelease()
+    }
 
-    git(gitCommands["pushit"], siteRepoOpt)
+    fmt.Printf("Current version: %s\n", latest)
 
-    return nil
+    switch {
+    case final:
+        // the final version is tagged whether or not anything changed
+    case monorepoModule != "":
+        // other modules in a monorepo move the branch on too, so only release when this one changed
+        if _, err := gitTry(gitc{"diff", "--quiet", tagName(latest), branchOpt, "--", "."}, cwd); err == nil {
+            return "", latest, &unchangedError{latest, &pushError{monorepoModule + " has not changed since " + tagName(latest) + "; there is nothing to release."}}
+        }
+    case setVersionOpt == "" && bumpOpt != "release":
+        // bumping again would tag the same commit twice (a final release of a prerelease may, though)
+        if out, err := gitTry(gitc{"rev-list", "--count", tag + ".." + branchOpt}, cwd); err == nil && strings.TrimSpace(string(out)) == "0" {
+            return "", latest, &unchangedError{latest, &pushError{"Nothing has been merged into " + branchOpt + " since " + tagName(latest) + "; there is nothing to release."}}
+        }
+    }
+
+    if setVersionOpt != "" {
+        return setVersion(current, latest)
+    }
+
+    if bumpOpt == "auto" {
+        if bumpOpt, err = detectBump(tag); err != nil {
+            return "", latest, err
+        }
+    }
+
+    next, err := bumpVersion(current, bumpOpt, preOpt)
+    if err != nil {
+        return "", latest, err
+    }
+
+    if bumpOpt == "patch" {
+        warnStale(tag)
+    }
+
+    return next.String(), latest, nil
+}
+
+// warnStale warns when a patch release comes long after the last release: everything merged since
+// then ships at once, which is rarely just a fix
+func warnStale(tag string) {
+    days := config.StaleDays
+    if days == 0 {
+        days = 90
+    } else if days < 0 {
+        return
+    }
+
+    out, err := gitTry(gitc{"log", "-1", "--format=%ct", tag}, cwd)
+    released, perr := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+    if err != nil || perr != nil {
+        return
+    }
+
+    since := time.Since(time.Unix(released, 0))
+    if since < time.Duration(days)*24*time.Hour {
+        return
+    }
+
+    // in a monorepo, only this module's commits count
+    count := gitc{"rev-list", "--count", tag + ".." + branchOpt}
+    if monorepoModule != "" {
+        count = append(count, "--", ".")
+    }
+
+    commits := "?"
+    if out, err := gitTry(count, cwd); err == nil {
+        commits = strings.TrimSpace(string(out))
+    }
+
+    slog.Warn(fmt.Sprintf("%s was released %d days ago (%s); the %s commits that have gone into %s since all ship in this release. After that long it is rarely just a fix: consider --bump=minor.", tag, int(since.Hours()/24), displayTime(time.Unix(released, 0)), commits, branchOpt))
+}
+
+// firstRelease offers to tag the first version of a module that has no version tags yet: the one
+// given with --set-version, otherwise the config file's firstVersion (1.0.0 by default)
+func firstRelease() (string, string, error) {
+    first := firstVersion()
+
+    fmt.Printf("Current version: none (there are no version tags on %s yet)\n", branchOpt)
+
+    if prompt("Release it as its first version, " + tagName(first) + "? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return "", "", errAborted
+    }
+
+    return first, "", nil
+}
+
+// firstVersion is the version a module's first release is tagged with
+func firstVersion() string {
+    switch {
+    case setVersionOpt != "":
+        return strings.TrimPrefix(setVersionOpt, "v")
+    case config.FirstVersion != "":
+        return strings.TrimPrefix(config.FirstVersion, "v")
+    case drupalScheme(currentModule()):
+        return "1.0"
+    }
+    return "1.0.0"
+}
+
+// latestRef is the tag of the latest version, or empty for a first release
+func latestRef(latest string) string {
+    if latest == "" {
+        return ""
+    }
+    return tagName(latest)
+}
+
+// setVersion checks the version given with --set-version against the latest one; whether it has
+// already been tagged on the remote is left to the release checks
+func setVersion(current version, latest string) (string, string, error) {
+    next, _ := parseVersion(strings.TrimPrefix(setVersionOpt, "v"))
+
+    if next.Compare(current) <= 0 {
+        return "", latest, &pushError{"--set-version " + next.String() + " must be higher than the latest version " + latest + "."}
+    }
+
+    fmt.Printf("Version set with --set-version: %s\n", next)
+
+    return next.String(), latest, nil
+}
+
+// getUpdatedMakefile returns the site file with the module moved from latest to newVersion, in
+// whichever way the site records versions (see siteUpdaterFor)
+func getUpdatedMakefile(makefile, module, newVersion, latest string) ([]string, error) {
+    return siteUpdaterFor(makefile).update(makefile, module, newVersion, latest)
 }
 
 func init() {
@@ -329,8 +822,26 @@ func init() {
     flag.StringVar(&bumpOpt, "bump", optionsMap["bump"]["default"], optionsMap["bump"]["usage"])
     flag.StringVar(&bumpOpt, "v", optionsMap["bump"]["default"], "shorthand for --bump")
 
+    // option: --pre
+    flag.StringVar(&preOpt, "pre", optionsMap["pre"]["default"], optionsMap["pre"]["usage"])
+
+    // option: --set-version
+    flag.StringVar(&setVersionOpt, "set-version", optionsMap["set-version"]["default"], optionsMap["set-version"]["usage"])
+
+    // option: --tag-only
+    flag.BoolVar(&tagOnlyOpt, "tag-only", false, optionsMap["tag-only"]["usage"])
+
+    // option: --pin-only
+    flag.BoolVar(&pinOnlyOpt, "pin-only", false, optionsMap["pin-only"]["usage"])
+
+    // option: --canary
+    flag.BoolVar(&canaryOpt, "canary", false, optionsMap["canary"]["usage"])
+
     // option: --module
-    flag.StringVar(&moduleOpt, "module", optionsMap["module"]["default"], optionsMap["module"]["usage"])
+    flag.Var(&modulesOpt, "module", optionsMap["module"]["usage"])
+
+    // option: --manifest
+    flag.StringVar(&manifestOpt, "manifest", optionsMap["manifest"]["default"], optionsMap["manifest"]["usage"])
 
     // option: --site-repo / -r
     flag.StringVar(&siteRepoOpt, "site-repo", optionsMap["site-repo"]["default"], optionsMap["site-repo"]["usage"])
@@ -339,81 +850,477 @@ func init() {
     // option: --site-makefile
     flag.StringVar(&siteMakeOpt, "site-makefile", optionsMap["site-makefile"]["default"], optionsMap["site-makefile"]["usage"])
 
+    // option: --site-updater
+    flag.StringVar(&siteUpdaterOpt, "site-updater", optionsMap["site-updater"]["default"], optionsMap["site-updater"]["usage"])
+
+    // option: --auto
+    flag.BoolVar(&autoOpt, "auto", false, optionsMap["auto"]["usage"])
+
+    // option: --composer-update
+    flag.BoolVar(&composerOpt, "composer-update", false, optionsMap["composer-update"]["usage"])
+
+    // option: --site-branch
+    flag.StringVar(&siteBranch, "site-branch", optionsMap["site-branch"]["default"], optionsMap["site-branch"]["usage"])
+
     // option: --topic
     flag.StringVar(&topicOpt, "topic", optionsMap["topic"]["default"], optionsMap["topic"]["usage"])
 
     // option: --no-module
     flag.BoolVar(&noModuleOpt, "no-module", false, optionsMap["no-module"]["usage"])
+
+    // option: --config
+    flag.StringVar(&configOpt, "config", optionsMap["config"]["default"], optionsMap["config"]["usage"])
+
+    // option: --profile / -p
+    flag.StringVar(&profileOpt, "profile", optionsMap["profile"]["default"], optionsMap["profile"]["usage"])
+    flag.StringVar(&profileOpt, "p", optionsMap["profile"]["default"], "shorthand for --profile")
+
+    // option: --version-scheme
+    flag.StringVar(&schemeOpt, "version-scheme", optionsMap["version-scheme"]["default"], optionsMap["version-scheme"]["usage"])
+
+    // option: --default-branch
+    flag.StringVar(&branchOpt, "default-branch", optionsMap["default-branch"]["default"], optionsMap["default-branch"]["usage"])
+
+    // option: --changelog
+    flag.BoolVar(&changelogOpt, "changelog", false, optionsMap["changelog"]["usage"])
+
+    // option: --package-json
+    flag.BoolVar(&packageJSONOpt, "package-json", false, optionsMap["package-json"]["usage"])
+
+    // option: --remove-entry
+    flag.BoolVar(&removeEntryOpt, "remove-entry", false, optionsMap["remove-entry"]["usage"])
+
+    // option: --delete
+    flag.BoolVar(&deleteOpt, "delete", false, optionsMap["delete"]["usage"])
+
+    // option: --workspace
+    flag.StringVar(&workspaceOpt, "workspace", optionsMap["workspace"]["default"], optionsMap["workspace"]["usage"])
+
+    // option: --from
+    flag.StringVar(&fromOpt, "from", optionsMap["from"]["default"], optionsMap["from"]["usage"])
+
+    // option: --to
+    flag.StringVar(&toOpt, "to", optionsMap["to"]["default"], optionsMap["to"]["usage"])
+
+    // option: --push
+    flag.BoolVar(&scanPushOpt, "push", false, optionsMap["push"]["usage"])
+
+    // option: --limit
+    flag.IntVar(&limitOpt, "limit", 20, optionsMap["limit"]["usage"])
+
+    // option: --json
+    flag.BoolVar(&jsonOpt, "json", false, optionsMap["json"]["usage"])
+
+    // option: --debounce
+    flag.DurationVar(&debounceOpt, "debounce", 0, optionsMap["debounce"]["usage"])
+
+    // option: --via-pr
+    flag.BoolVar(&viaPROpt, "via-pr", false, optionsMap["via-pr"]["usage"])
+
+    // option: --sign
+    flag.BoolVar(&signOpt, "sign", false, optionsMap["sign"]["usage"])
+
+    // option: --autostash
+    flag.BoolVar(&autostashOpt, "autostash", false, optionsMap["autostash"]["usage"])
+
+    // option: --timing
+    flag.BoolVar(&timingOpt, "timing", false, optionsMap["timing"]["usage"])
+
+    // option: --isolated
+    flag.BoolVar(&isolatedOpt, "isolated", false, optionsMap["isolated"]["usage"])
+
+    // option: --summary-out
+    flag.StringVar(&summaryOpt, "summary-out", optionsMap["summary-out"]["default"], optionsMap["summary-out"]["usage"])
+
+    // option: --remote
+    flag.StringVar(&remoteOpt, "remote", optionsMap["remote"]["default"], optionsMap["remote"]["usage"])
+
+    // option: --keep-branch
+    flag.BoolVar(&keepBranchOpt, "keep-branch", false, optionsMap["keep-branch"]["usage"])
+
+    // option: --delete-remote-topic
+    flag.BoolVar(&remoteTopicOpt, "delete-remote-topic", false, optionsMap["delete-remote-topic"]["usage"])
+
+    // option: --yes
+    flag.BoolVar(&yesOpt, "yes", false, optionsMap["yes"]["usage"])
+
+    // option: --verbose
+    flag.BoolVar(&verboseOpt, "verbose", false, optionsMap["verbose"]["usage"])
+
+    // option: --trace
+    flag.BoolVar(&traceOpt, "trace", false, optionsMap["trace"]["usage"])
+
+    // option: --listen
+    flag.StringVar(&listenOpt, "listen", optionsMap["listen"]["default"], optionsMap["listen"]["usage"])
+
+    // option: --approver-fd
+    flag.IntVar(&approverFdOpt, "approver-fd", 0, optionsMap["approver-fd"]["usage"])
 }
 
-func main() {
+// setup rejects bad options, loads the config file and applies the selected profile and environment.
+// The returned function undoes anything setup put in place and is always safe to call.
+func setup() (func(), error) {
+    cleanup := func() {}
+    setupLogging()
+    expandPathOptions()
+
+    // ** reject bad options before touching anything, then load the config file and apply the selected profile (if any)
+    err := validateOptions()
+
+    if err == nil {
+        if err = loadConfig(); err == nil {
+            err = applyProfile()
+        }
+    }
+
+    if err != nil {
+        return cleanup, withCode(exitOptions, err)
+    }
+
+    applyEnvOptions() // try environment variables for missing options
+    applyGitConfig()
+    handleInterrupts()
+
+    if err = requireGit(); err != nil {
+        return cleanup, withCode(exitGit, err)
+    }
+
+    if isolatedOpt {
+        if cleanup, err = isolateGit(); err != nil {
+            return func() {}, withCode(exitOptions, err)
+        }
+    }
+
+    return cleanup, nil
+}
+
+// finish reports how the run went: the summary block, notifications and history. p is what the run
+// panicked with (if anything). Unless the run succeeded, it then exits with the code for how it ended.
+func finish(p interface{}) {
+    if p != nil {
+        summary.fail(&pushError{fmt.Sprint(p)})
+    }
+
+    restoreBranches()
+
+    switch summary.Outcome {
+    case "success", "up to date":
+        summary.ExitCode = 0
+    case "aborted":
+        summary.ExitCode = exitAborted
+    default:
+        if summary.ExitCode == 0 {
+            summary.ExitCode = exitFailed
+        }
+    }
+
+    notifySlack()
+    fileHalfRelease()
+    summary.print()
+    printTimings()
+    recordHistory()
+
+    if summaryOpt != "" {
+        summary.write(summaryOpt)
+    }
+
+    if summary.ExitCode != 0 {
+        os.Exit(summary.ExitCode)
+    }
+}
+
+// push is the default command: bump, tag and pin a new version of the module
+func push() {
     var (
         module     string
         makefile   string
         latest     string
         newVersion string
-        outFile    []string
         err        error
     )
 
-    flag.Parse()      // handle options passed in via command-line
-    applyEnvOptions() // try environment variables for missing options
+    // always finish with the summary block, even after a panic part way through
+    defer func() { finish(recover()) }()
+
+    done := phase("setup")
+    cleanup, err := setup()
+    defer cleanup()
+    done()
+
+    if err != nil {
+        summary.fail(err)
+        return
+    }
 
     // ** make sure a valid module option has been provided
     module, err = getModule()
+    summary.Module = module
 
     if err != nil {
-        fmt.Println(err)
+        summary.fail(err)
+        return
+    }
+
+    // ** a module that must ship with others is released with them, in one site commit
+    self := &batchEntry{path: cwd, bump: bumpOpt, topic: topicOpt, module: module, dir: cwd, mono: monorepoModule}
+
+    if partners := groupEntries([]*batchEntry{self}); len(partners) > 0 {
+        releaseBatch(append([]*batchEntry{self}, partners...))
         return
     }
 
+    // ** a risky module (or one released with --canary) is pinned in the canary makefile first
+    if !tagOnlyOpt {
+        canaryFile, err := canaryMakefile(module)
+        if err != nil {
+            summary.fail(err)
+            return
+        }
+
+        if canaryFile != "" {
+            siteMakeOpt = canaryFile
+            fmt.Printf("%s is released to the canary first: it is pinned in %s until canary promotes it.\n", module, canaryFile)
+        }
+    }
+
     // ** make sure a valid makefile can be found in the site repo directory
-    makefile, err = getMakefile()
+    makefile, err = getMakefile(module)
 
     if err != nil {
-        fmt.Println(err)
+        summary.fail(err)
+        return
+    }
+
+    // ** set aside local edits (with --autostash) so they stay out of the release
+    if autostashOpt {
+        restore, err := autostash(cwd, siteRepoOpt)
+        defer restore()
+
+        if err != nil {
+            summary.fail(err)
+            return
+        }
+    }
+
+    // ** make sure the remotes and both worktrees are usable before fetching anything
+    done = phase("environment checks")
+    err = runChecks("environment", environmentChecks())
+    done()
+
+    if err != nil {
+        summary.fail(err)
         return
     }
 
     // ** perform various git tasks, get the new version back
-    newVersion, latest, err = getVersions()
+    done = phase("update repos")
+    if err = updateSiteRepo(); err == nil && pinOnlyOpt {
+        newVersion, latest, err = pinnedVersions(makefile, module)
+    } else if err == nil {
+        newVersion, latest, err = getVersions()
+    }
+
+    // ** a re-run of a release that already tagged: pick up from the first step that didn't happen
+    var unchanged *unchangedError
+    if errors.As(err, &unchanged) {
+        newVersion, latest, err = resumeRelease(makefile, module, unchanged)
+    }
+    done()
+    summary.OldVersion, summary.NewVersion, summary.Topic = latest, newVersion, topicOpt
+
+    if err == errAborted {
+        return
+    }
+
+    if err == errUpToDate {
+        summary.Outcome = "up to date"
+        return
+    }
 
     if err != nil {
-        fmt.Println(err)
+        summary.fail(err)
+        return
+    }
+
+    // ** and that the release itself can go through before asking to confirm it
+    done = phase("release checks")
+    checks := releaseChecks(makefile, module, latest, newVersion)
+    if !tagOnlyOpt {
+        checks = append(checks, compatibilityChecks(makefile, map[string]string{module: newVersion})...)
+    }
+    err = runChecks("release", checks)
+    done()
+
+    if err != nil {
+        summary.fail(err)
+        return
+    }
+
+    // ** and that the module's own quality gates pass (from its .pushitrc)
+    done = phase("module checks")
+    err = runModuleChecks(module)
+    done()
+
+    if err != nil {
+        summary.fail(err)
         return
     }
 
-    // ** make sure the user is satisfied with the new version that will be tagged
-    reader := bufio.NewReader(os.Stdin)
+    // ** make sure the user is satisfied with the new version that will be tagged (and its impact)
+    done = phase("impact analysis")
+    warnFeatures(module)
+    changes, err := analyzeImpact(latestRef(latest))
+    summary.Impact = changes.Level
+    done()
+
+    if err != nil {
+        summary.fail(err)
+        return
+    }
 
     fmt.Println("New version:", newVersion)
-    fmt.Printf("Are you sure you want to tag and push this new version to staging? (y/n): ")
+    fmt.Println("Impact:     ", changes)
+
+    // ** show exactly what will be committed to the site repo
+    commitMsg := "\n" + formatCommitMsg(module, latest, newVersion) + "\n\nImpact: " + changes.Level
 
-    text, _ := reader.ReadString('\n')
-    text = strings.Trim(text, "\n")
+    question := "Are you sure you want to tag and push this new version to staging? (y/n): "
 
-    if text != "y" {
+    switch {
+    case tagOnlyOpt:
+        fmt.Println("\nThe site makefile is left alone (--tag-only).")
+        question = "Are you sure you want to tag and push this new version? (y/n): "
+    case pinOnlyOpt:
+        question = "Are you sure you want to pin " + tagName(newVersion) + " on staging? (y/n): "
+    }
+
+    if !tagOnlyOpt {
+        if outFile, err := getUpdatedMakefile(makefile, module, newVersion, latest); err == nil {
+            previewMakefile(makefile, outFile)
+        }
+        previewCommitMsg(commitMsg)
+        previewBuild(makefile, module)
+    }
+
+    warnUpdateHooks(changes)
+
+    done = phase("module health")
+    reportHealth(module)
+    done()
+
+    done = phase("confirmation")
+    confirmed := prompt(question) == "y" && confirmImpact(module, changes)
+    done()
+
+    if !confirmed {
         fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return
+    }
+
+    notes, err := releaseNotes(module, latestRef(latest), newVersion)
+    if err != nil {
+        summary.fail(err)
         return
     }
 
-    // while the rest proceeds, we can go ahead and start pushing the new tag up from the module repo
-    tagVersion(newVersion)
+    // from here on remotes are changed, so keep track of what was done in case it needs rolling back
+    state = pushState{
+        Time:       time.Now(),
+        Module:     module,
+        ModuleDir:  cwd,
+        Remote:     remoteFor(cwd),
+        Tag:        tagName(newVersion),
+        SiteRepo:   siteRepoOpt,
+        SiteBranch: siteBranch,
+    }
 
-    outFile, err = getUpdatedMakefile(makefile, module, newVersion, latest)
+    rel := &release{
+        module:    module,
+        latest:    latest,
+        version:   newVersion,
+        tag:       tagName(newVersion),
+        notes:     notes["technical"],
+        commitMsg: commitMsg,
+    }
 
-    if err != nil {
-        fmt.Println(err)
+    if err = rel.run(makefile); err != nil {
+        summary.fail(err)
+        return
+    }
+
+    done = phase("badges and notes")
+
+    if err = uploadBadges(module, newVersion); err != nil {
+        slog.Warn(strings.TrimSpace(err.Error()))
+        summary.followUp("Upload the version badge for " + module + " to " + config.Badges.S3 + " by hand.")
+    }
+
+    publishNotes(module, newVersion, notes)
+    notifyOutsideRelease(module, latest, newVersion)
+
+    done()
+
+    summary.Outcome = "success"
+    remindUpdb(changes)
+
+    // a pull request isn't on the site branch yet, so there is nothing to merge back
+    if rel.pullRequest == "" && !tagOnlyOpt {
+        mergeBack(module + " " + newVersion)
+    }
+
+    if tagOnlyOpt {
+        fmt.Println("\nTag pushed successfully!\nPin it on staging with --pin-only when the site is ready for it.")
+        return
+    }
+
+    if rel.pullRequest != "" {
+        fmt.Println("\nRelease completed successfully!\nYour new version will build to the staging environment once the pull request is merged:\n" + rel.pullRequest)
         return
     }
 
-    commitMsg := fmt.Sprintf("\n%s %s -> %s", topicOpt, module, newVersion)
-    err = pushUpdatedMakefile(&outFile, commitMsg)
+    fmt.Println("\nPush completed successfully!\nYour new version will build to the staging environment momentarily.")
+}
+
+func main() {
+    // a known command may be given ahead of the options, otherwise we push
+    command, args := "", os.Args[1:]
+
+    if len(args) > 0 {
+        if _, ok := subcommands[args[0]]; ok {
+            command, args = args[0], args[1:]
+        }
+    }
 
+    // handle options passed in via command-line
+    args, err := parseOptions(args)
+    if err == nil {
+        err = readApprover()
+    }
     if err != nil {
         fmt.Println(err)
+        os.Exit(exitCode(err))
+    }
+
+    moduleOpt = optionsMap["module"]["default"]
+    if len(modulesOpt) > 0 {
+        moduleOpt = modulesOpt[0]
+    }
+
+    if command == "" && batchMode() {
+        pushBatch()
         return
     }
 
-    fmt.Println("\nPush completed successfully!\nYour new version will build to the staging environment momentarily.")
+    if command == "" {
+        push()
+        return
+    }
+
+    if err := runSubcommand(command, args); err != nil {
+        if err != errAborted {
+            fmt.Println(err)
+        }
+        os.Exit(exitCode(err))
+    }
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "regexp"
+    "strings"
+    "text/template"
+    "time"
+)
+
+// releaseNotesConfig says which notes go where. The same commits are written up for two audiences:
+// technical (every commit with its hash, as in CHANGELOG.md and the tag) and stakeholder (ticket
+// titles only).
+type releaseNotesConfig struct {
+    Confluence string            `json:"confluence"` // audience of the Confluence notes, technical unless set
+    Slack      string            `json:"slack"`      // posts the notes for this audience to the Slack webhook after each push
+    Email      notesEmail        `json:"email"`      // mails the notes after each push
+    Templates  map[string]string `json:"templates"`  // audience -> text/template file used instead of the built-in notes
+}
+
+type notesEmail struct {
+    To       []string `json:"to"`
+    Audience string   `json:"audience"` // stakeholder unless set
+}
+
+// audiences the notes are written for
+var audiences = []string{"technical", "stakeholder"}
+
+// notesData is what a notes template is executed with
+type notesData struct {
+    Module   string
+    Version  string
+    Latest   string
+    Date     string
+    Sections []noteSection
+}
+
+// releaseNotes writes up the commits since the latest tag for every audience
+func releaseNotes(module, latestTag, newVersion string) (map[string]string, error) {
+    sections, err := noteSections(latestTag)
+    if err != nil {
+        return nil, err
+    }
+
+    notes := map[string]string{
+        "technical":   technicalNotes(latestTag, newVersion, sections),
+        "stakeholder": stakeholderNotes(module, newVersion, sections),
+    }
+
+    for audience, path := range config.ReleaseNotes.Templates {
+        contents, err := ioutil.ReadFile(path)
+        if err != nil {
+            return nil, &pushError{"Could not read the " + audience + " release notes template @ " + path}
+        }
+
+        tmpl, err := template.New(path).Parse(string(contents))
+        if err != nil {
+            return nil, &pushError{"The " + audience + " release notes template @ " + path + " is not valid: " + err.Error()}
+        }
+
+        var b bytes.Buffer
+        data := notesData{module, newVersion, strings.TrimPrefix(latestTag, tagPrefix()), displayDate(time.Now()), withTicketTitles(sections)}
+
+        if err = tmpl.Execute(&b, data); err != nil {
+            return nil, &pushError{"Could not fill in the " + audience + " release notes template @ " + path + ": " + err.Error()}
+        }
+        notes[audience] = b.String()
+    }
+
+    return notes, nil
+}
+
+// stakeholderNotes is the entry for producers and other non-engineers: one line per ticket with its
+// title, in the first section it appears in, and no commit hashes
+func stakeholderNotes(module, newVersion string, sections []noteSection) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "## %s %s (%s)\n", module, newVersion, displayDate(time.Now()))
+
+    listed := map[string]bool{}
+    untracked := 0
+
+    for _, section := range withTicketTitles(sections) {
+        var lines []string
+
+        for _, ticket := range section.Tickets {
+            if ticket.Key == "" {
+                untracked += len(ticket.Commits)
+            } else if !listed[ticket.Key] {
+                listed[ticket.Key] = true
+                lines = append(lines, "- "+ticket.Key+": "+ticket.Title)
+            }
+        }
+
+        if len(lines) > 0 {
+            fmt.Fprintf(&b, "\n### %s\n\n%s\n", section.Title, strings.Join(lines, "\n"))
+        }
+    }
+
+    switch {
+    case untracked == 1:
+        b.WriteString("\nAlso includes 1 smaller change.\n")
+    case untracked > 1:
+        fmt.Fprintf(&b, "\nAlso includes %d smaller changes.\n", untracked)
+    case len(listed) == 0:
+        b.WriteString("\nNo ticketed changes.\n")
+    }
+
+    return b.String()
+}
+
+// withTicketTitles fills in the title of every ticket
+func withTicketTitles(sections []noteSection) []noteSection {
+    for i := range sections {
+        for j := range sections[i].Tickets {
+            t := &sections[i].Tickets[j]
+            t.Title = ticketTitle(t.Key, t.Commits)
+        }
+    }
+    return sections
+}
+
+// ticket titles already looked up this run
+var ticketTitles = map[string]string{}
+
+// ticketTitle is the summary of a ticket in JIRA (when a token is configured to read it with), or else
+// the description of the first commit made for it
+func ticketTitle(key string, commits []commit) string {
+    if title, ok := ticketTitles[key]; ok {
+        return title
+    }
+
+    // commits are listed newest first
+    title := ""
+    if len(commits) > 0 {
+        title = commits[len(commits)-1].Description
+    }
+
+    if key != "" && config.Jira.BaseURL != "" && config.Jira.Token != "" {
+        var issue struct {
+            Fields struct {
+                Summary string `json:"summary"`
+            } `json:"fields"`
+        }
+
+        url := strings.TrimSuffix(config.Jira.BaseURL, "/") + "/rest/api/2/issue/" + key + "?fields=summary"
+        if err := callAPI("GET", url, config.Jira.auth, nil, &issue); err == nil && issue.Fields.Summary != "" {
+            title = issue.Fields.Summary
+        }
+    }
+
+    ticketTitles[key] = title
+    return title
+}
+// publishNotes sends the notes to every channel configured for them, each in its audience's flavor.
+// A channel that can't be reached is left as a follow-up, since the release itself is out.
+func publishNotes(module, version string, notes map[string]string) {
+    cfg := config.ReleaseNotes
+
+    if err := publishReleaseNotes(module, notes[audienceOr(cfg.Confluence, "technical")]); err != nil {
+        slog.Warn("could not publish release notes to Confluence: " + strings.TrimSpace(err.Error()))
+        summary.followUp("Add the v" + version + " release notes for " + module + " to Confluence by hand.")
+    }
+
+    if cfg.Slack != "" && config.Slack.WebhookURL != "" {
+        payload := map[string]string{"text": markdownToSlack(notes[cfg.Slack])}
+        if config.Slack.Channel != "" {
+            payload["channel"] = config.Slack.Channel
+        }
+
+        if err := callAPI("POST", config.Slack.WebhookURL, nil, payload, nil); err != nil {
+            slog.Warn("could not post release notes to Slack: " + strings.TrimSpace(err.Error()))
+            summary.followUp("Post the v" + version + " release notes for " + module + " to Slack by hand.")
+        } else {
+            summary.Notified = append(summary.Notified, "slack ("+cfg.Slack+" notes)")
+        }
+    }
+
+    if len(cfg.Email.To) > 0 {
+        audience := audienceOr(cfg.Email.Audience, "stakeholder")
+
+        if err := sendEmail(cfg.Email.To, module+" "+version+" release notes", notes[audience]); err != nil {
+            slog.Warn("could not email release notes: " + strings.TrimSpace(err.Error()))
+            summary.followUp("Email the v" + version + " release notes for " + module + " to " + strings.Join(cfg.Email.To, ", ") + " by hand.")
+        } else {
+            summary.Notified = append(summary.Notified, "email ("+audience+" notes)")
+        }
+    }
+}
+
+// audienceOr is the audience configured for a channel, or its default
+func audienceOr(audience, fallback string) string {
+    if audience == "" {
+        return fallback
+    }
+    return audience
+}
+
+var markdownHeading = regexp.MustCompile(`(?m)^#+\s*(.*)$`)
+
+// markdownToSlack turns the headings of the notes into bold lines and links their tickets for Slack
+func markdownToSlack(markdown string) string {
+    return linkTickets(markdownHeading.ReplaceAllString(markdown, "*$1*"), "slack")
+}
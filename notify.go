@@ -0,0 +1,132 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/smtp"
+    "strings"
+)
+
+// smtpConfig is the mail server used to email module owners
+type smtpConfig struct {
+    Host     string `json:"host"` // host:port, eg. smtp.turner.com:25
+    From     string `json:"from"`
+    User     string `json:"user"`
+    Password string `json:"password"`
+}
+
+// sendEmail sends a plain text email through the configured SMTP server
+func sendEmail(to []string, subject, body string) error {
+    cfg := config.SMTP
+
+    if cfg.Host == "" {
+        return &pushError{"No SMTP server is configured"}
+    }
+
+    var auth smtp.Auth
+    if cfg.User != "" {
+        auth = smtp.PlainAuth("", cfg.User, cfg.Password, strings.Split(cfg.Host, ":")[0])
+    }
+
+    message := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n",
+        cfg.From, strings.Join(to, ", "), subject, strings.Replace(body, "\n", "\r\n", -1))
+
+    if err := smtp.SendMail(cfg.Host, auth, cfg.From, to, []byte(message)); err != nil {
+        return &pushError{"Could not send email through " + cfg.Host + ": " + err.Error()}
+    }
+
+    return nil
+}
+
+// notifyOwners emails the owners of a module listed in the config file. Owners that can't be
+// notified become follow-up actions instead.
+func notifyOwners(module, subject, body string) {
+    emailOwners(module, config.Owners[module], subject, body)
+}
+
+// emailOwners emails some of a module's owners, leaving a follow-up when it can't
+func emailOwners(module string, owners []string, subject, body string) {
+    if len(owners) == 0 {
+        return
+    }
+
+    if err := sendEmail(owners, subject, body); err != nil {
+        slog.Warn("could not notify owners: " + strings.TrimSpace(err.Error()))
+        summary.followUp("Let the owners of " + module + " know (" + strings.Join(owners, ", ") + "): " + subject)
+        return
+    }
+
+    summary.Notified = append(summary.Notified, "email ("+strings.Join(owners, ", ")+")")
+}
+
+// releaserEmail is the email address the release was made as, the one git puts on its commits
+func releaserEmail() string {
+    out, err := gitTry(gitc{"var", "GIT_AUTHOR_IDENT"}, cwd)
+    if err != nil {
+        return config.Identity.Email
+    }
+
+    ident := string(out)
+    start, end := strings.Index(ident, "<"), strings.Index(ident, ">")
+    if start < 0 || end < start {
+        return config.Identity.Email
+    }
+
+    return ident[start+1 : end]
+}
+
+// notifyOutsideRelease lets a module's owners know that someone who isn't one of them released it: a
+// Slack DM each when the config has a Slack bot token, otherwise (or when a DM fails) an email. It
+// doesn't stop anyone releasing, so cross-team fixes still go out.
+func notifyOutsideRelease(module, latest, newVersion string) {
+    owners := config.Owners[module]
+    if len(owners) == 0 {
+        return
+    }
+
+    releaser := releaserEmail()
+    for _, owner := range owners {
+        if strings.EqualFold(owner, releaser) {
+            return
+        }
+    }
+
+    who := usr.Username
+    if releaser != "" {
+        who += " (" + releaser + ")"
+    }
+
+    subject := fmt.Sprintf("%s released %s %s", who, module, newVersion)
+    body := fmt.Sprintf("%s, which you own, was released by %s, who is not one of its owners:\n\n  %s -> %s\n  topic: %s\n  site commit: %s\n",
+        module, who, valueOrNone(latest), newVersion, valueOrNone(topicOpt), valueOrNone(summary.CommitSHA))
+
+    if config.Slack.Token == "" {
+        emailOwners(module, owners, subject, body)
+        return
+    }
+
+    var dmed, missed []string
+
+    for _, owner := range owners {
+        if err := slackDM(owner, ":eyes: "+subject+"\n"+body); err != nil {
+            slog.Debug("could not DM " + owner + " on Slack: " + strings.TrimSpace(err.Error()))
+            missed = append(missed, owner)
+            continue
+        }
+        dmed = append(dmed, owner)
+    }
+
+    if len(dmed) > 0 {
+        summary.Notified = append(summary.Notified, "slack DM ("+strings.Join(dmed, ", ")+")")
+    }
+
+    emailOwners(module, missed, subject, body)
+}
+
+// valueOrNone is the value, or "none" when it is empty
+func valueOrNone(value string) string {
+    if value == "" {
+        return "none"
+    }
+    return value
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+var packageVersion = regexp.MustCompile(`^(\s*)"version"\s*:\s*"([^"]*)"`)
+
+// syncPackageJSON says whether the module's package.json version follows its tags: with
+// --package-json, or "packageJson": true in its .pushitrc
+func syncPackageJSON() bool {
+    if packageJSONOpt {
+        return true
+    }
+
+    rc, err := loadModuleRC()
+    return err == nil && rc.PackageJSON
+}
+
+// setPackageVersion sets the version of the module's package.json (and package-lock.json, if it has
+// one) to the new version, keeping their formatting. It returns the files it changed, none when the
+// module has no package.json.
+func setPackageVersion(newVersion string) (gitc, error) {
+    path := filepath.Join(cwd, "package.json")
+
+    contents, err := ioutil.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, &pushError{"Could not read " + path}
+    }
+
+    var pkg struct {
+        Version *string `json:"version"`
+    }
+
+    if err = json.Unmarshal(contents, &pkg); err != nil {
+        return nil, &pushError{path + " is not valid JSON: " + err.Error()}
+    }
+
+    if pkg.Version == nil {
+        return nil, &pushError{path + " has no \"version\" to set to " + newVersion + ". Add one, or release without --package-json."}
+    }
+
+    if *pkg.Version == newVersion {
+        return nil, nil
+    }
+
+    if err = setVersionLines(path, newVersion, false); err != nil {
+        return nil, err
+    }
+
+    files := gitc{"package.json"}
+
+    // the lock file repeats the version at the top and for the root package ("": {...})
+    lock := filepath.Join(cwd, "package-lock.json")
+    if _, err := os.Stat(lock); err == nil {
+        if err = setVersionLines(lock, newVersion, true); err != nil {
+            return nil, err
+        }
+        files = append(files, "package-lock.json")
+    }
+
+    return files, nil
+}
+
+// setVersionLines replaces the top-level "version" of a package file (the least indented one) and, for
+// a lock file, the root package's
+func setVersionLines(path, newVersion string, lock bool) error {
+    lines, err := readLines(path)
+    if err != nil {
+        return &pushError{"Could not read " + path}
+    }
+
+    top, indent := -1, 0
+    for i, line := range lines {
+        if match := packageVersion.FindStringSubmatch(line); match != nil && (top == -1 || len(match[1]) < indent) {
+            top, indent = i, len(match[1])
+        }
+    }
+
+    if top == -1 {
+        return &pushError{path + " has no \"version\" to set to " + newVersion + "."}
+    }
+
+    replace := func(i int) {
+        match := packageVersion.FindStringSubmatchIndex(lines[i])
+        lines[i] = lines[i][:match[4]] + newVersion + lines[i][match[5]:]
+    }
+    replace(top)
+
+    if lock {
+        root := -1
+        for i, line := range lines {
+            lead := len(line) - len(strings.TrimLeft(line, " \t"))
+
+            switch {
+            case strings.HasPrefix(strings.TrimSpace(line), `"": {`):
+                root = lead
+            case root == -1:
+                // outside the root package
+            case lead <= root:
+                // the root package ended without a version
+                root = -1
+            case packageVersion.MatchString(line) && lead == root+indent:
+                replace(i)
+            }
+        }
+    }
+
+    if err = ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+        return &pushError{"Could not write " + path + ". Check permissions and try again."}
+    }
+
+    return nil
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "strings"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+func init() {
+    subcommands["pin"] = subcommand{"Pin the module versions listed in a versions file (CSV or YAML) in one site commit, checking every tag exists.", pin}
+}
+
+// pinRequest is a module and the version a versions file wants it pinned to
+type pinRequest struct {
+    module  string
+    version string
+    current string // the version pinned now
+    url     string // the module repo, to check the tag exists on
+}
+
+// readVersionsFile reads the versions to pin, decided ahead of a coordinated release: a CSV file of
+// module,version rows (a module,version header is skipped), or a YAML file mapping each module to its
+// version, at the top level or under versions:. Versions may be given with or without the v.
+func readVersionsFile(path string) ([]pinRequest, error) {
+    lines, err := readLines(path)
+    if err != nil {
+        return nil, &pushError{"There was a problem reading the versions file @ " + path}
+    }
+
+    return parseVersions(path, lines)
+}
+
+// parseVersions reads the lines of a versions file (or a snapshot), YAML when the name says so
+func parseVersions(path string, lines []string) ([]pinRequest, error) {
+    var pairs [][2]string
+
+    if pushit.IsYAMLMakefile(path) {
+        for _, e := range pushit.ParseYAMLLines(path, lines) {
+            if e.Value != "" && (len(e.Keys) == 1 || (len(e.Keys) == 2 && e.Keys[0] == "versions")) {
+                pairs = append(pairs, [2]string{e.Keys[len(e.Keys)-1], e.Value})
+            }
+        }
+    } else {
+        reader := csv.NewReader(strings.NewReader(strings.Join(lines, "\n")))
+        reader.Comment, reader.FieldsPerRecord, reader.TrimLeadingSpace = '#', -1, true
+
+        rows, err := reader.ReadAll()
+        if err != nil {
+            return nil, &pushError{"The versions file @ " + path + " is not valid CSV: " + err.Error()}
+        }
+
+        for i, row := range rows {
+            if len(row) != 2 {
+                return nil, &pushError{fmt.Sprintf("Line %d of %s should be module,version", i+1, path)}
+            }
+            if i == 0 && strings.EqualFold(row[0], "module") {
+                continue
+            }
+            pairs = append(pairs, [2]string{strings.TrimSpace(row[0]), strings.TrimSpace(row[1])})
+        }
+    }
+
+    var requests []pinRequest
+    seen := map[string]bool{}
+
+    for _, pair := range pairs {
+        version := strings.TrimPrefix(pair[1], "v")
+
+        if _, err := parseVersion(version); err != nil {
+            return nil, &pushError{"'" + pair[1] + "' for " + pair[0] + " in " + path + " is not a semantic version."}
+        }
+
+        if seen[pair[0]] {
+            return nil, &pushError{pair[0] + " is listed more than once in " + path + "."}
+        }
+        seen[pair[0]] = true
+
+        requests = append(requests, pinRequest{module: pair[0], version: version})
+    }
+
+    if len(requests) == 0 {
+        return nil, &pushError{"There are no versions to pin in " + path + "."}
+    }
+
+    return requests, nil
+}
+
+// currentPin is the version the site file pins the module to now
+func currentPin(makefile, module string) (string, error) {
+    pins, err := siteUpdaterFor(makefile).pins(makefile)
+    if err != nil {
+        return "", err
+    }
+
+    var versions []string
+    for _, p := range pins {
+        if p.module == module {
+            versions = append(versions, p.version)
+        }
+    }
+
+    if len(versions) != 1 {
+        return "", withCode(exitMakefile, &pushError{fmt.Sprintf("%s is pinned %d times in %s; pin expects exactly one entry (use add for a new module).", module, len(versions), strings.TrimPrefix(makefile, siteRepoOpt+"/"))})
+    }
+
+    return versions[0], nil
+}
+
+// moduleURL is where the module repo is, to check tags on: its makefile entry's download url, or
+// the origin of its checkout under --workspace
+func moduleURL(makefile, module string) string {
+    if _, ok := siteUpdaterFor(makefile).(drushUpdater); ok {
+        entries, _ := pushit.ParseMakefile(makefile, siteRepoOpt)
+
+        for _, e := range entries {
+            if len(e.Keys) == 4 && e.Keys[0] == "projects" && e.Keys[1] == module && e.Keys[2] == "download" && e.Keys[3] == "url" {
+                return e.Value
+            }
+        }
+    }
+
+    url, err := remoteURL(filepath.Join(workspaceOpt, module))
+    if err != nil {
+        return ""
+    }
+
+    return url
+}
+
+// pin moves the makefile pins of every module in the versions file to the versions it lists, after
+// checking each one is tagged on its repo, and pushes them to the site branch in one commit
+func pin(args []string) error {
+    if len(args) != 1 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit pin [options] <versions file>"})
+    }
+
+    requests, err := readVersionsFile(args[0])
+    if err != nil {
+        return withCode(exitOptions, err)
+    }
+
+    var modules []string
+    for _, r := range requests {
+        modules = append(modules, r.module)
+    }
+
+    makefile, err := getMakefile(modules...)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := lockSite(strings.Join(modules, ", "))
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if err = checkoutSiteBranch(); err != nil {
+        return err
+    }
+
+    return applyPins(makefile, requests, "pin", nil)
+}
+
+// checkoutSiteBranch checks the site repo can be changed and checks out the site branch, up to date
+func checkoutSiteBranch() error {
+    if err := runChecks("environment", siteChecks()); err != nil {
+        return err
+    }
+
+    if err := updateSiteRepo(); err != nil {
+        return err
+    }
+
+    _, err := git(gitc{"checkout", siteBranch}, siteRepoOpt)
+    return err
+}
+
+// applyPins moves each module's pin to the version requested (after checking every tag it needs
+// exists), and commits and pushes them to the site branch together, with the details in the message
+func applyPins(makefile string, requests []pinRequest, verb string, details []string) error {
+    var (
+        changes []pinRequest
+        checks  []check
+        err     error
+    )
+
+    for _, r := range requests {
+        if r.current, err = currentPin(makefile, r.module); err != nil {
+            return err
+        }
+
+        if r.current == r.version {
+            fmt.Printf("%s is already pinned to %s.\n", r.module, moduleTagName(r.module, r.version))
+            continue
+        }
+
+        r := r
+        r.url = moduleURL(makefile, r.module)
+        tag := moduleTagName(r.module, r.version)
+
+        checks = append(checks, check{r.module + " " + tag + " exists on its repo", func() error {
+            if r.url == "" {
+                return &pushError{"the module's repo isn't known; give its makefile entry a [download][url] or check it out under --workspace"}
+            }
+
+            out, err := gitCheck(gitc{"ls-remote", "--tags", r.url, "refs/tags/" + tag}, siteRepoOpt)
+            if err == nil && out == "" {
+                return &pushError{tag + " has not been pushed to " + r.url}
+            }
+            return err
+        }})
+
+        changes = append(changes, r)
+    }
+
+    if len(changes) == 0 {
+        fmt.Println("\nEvery module is already pinned to the version listed; there is nothing to do.")
+        return nil
+    }
+
+    pinned := map[string]string{}
+    for _, r := range changes {
+        pinned[r.module] = r.version
+    }
+    checks = append(checks, compatibilityChecks(makefile, pinned)...)
+
+    if err = runChecks("pins", checks); err != nil {
+        return err
+    }
+
+    // ** apply every pin in turn (each update reads what the previous one wrote), then put the
+    // makefile back until the change is confirmed
+    original, err := ioutil.ReadFile(makefile)
+    if err != nil {
+        return withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    var outFile []string
+
+    for _, r := range changes {
+        if outFile, err = getUpdatedMakefile(makefile, r.module, r.version, r.current); err == nil {
+            err = ioutil.WriteFile(makefile, []byte(strings.Join(outFile, "\n")), 0644)
+        }
+
+        if err != nil {
+            ioutil.WriteFile(makefile, original, 0644)
+            return err
+        }
+    }
+
+    if err = ioutil.WriteFile(makefile, original, 0644); err != nil {
+        return &pushError{"Could not write the makefile back. Check permissions and run git checkout -- " + siteMakeOpt + " in the site repo."}
+    }
+
+    var released, modules []string
+    for _, r := range changes {
+        modules = append(modules, r.module)
+        released = append(released, fmt.Sprintf("%s %s -> %s", r.module, r.current, r.version))
+        summary.Releases = append(summary.Releases, releaseSummary{r.module, r.current, r.version, ""})
+    }
+
+    commitMsg := coalescedCommitMsg(released, details)
+
+    previewMakefile(makefile, outFile)
+    previewCommitMsg(commitMsg)
+    previewBuild(makefile, modules...)
+
+    if prompt(fmt.Sprintf("Are you sure you want to %s these %d versions and push them to %s? (y/n): ", verb, len(changes), siteBranch)) != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return errAborted
+    }
+
+    defer recordHistory()
+
+    // ** one commit, one push
+    if err = ioutil.WriteFile(makefile, []byte(strings.Join(outFile, "\n")), 0644); err != nil {
+        return &pushError{"Could not write new makefile. Check permissions and try again."}
+    }
+
+    otherFiles, err := siteUpdaterFor(siteMakeOpt).commitFiles(modules...)
+    if err != nil {
+        gitTry(gitc{"checkout", "--", siteMakeOpt}, siteRepoOpt)
+        return err
+    }
+    commitFiles := append(gitc{siteMakeOpt}, otherFiles...)
+
+    if _, err = git(append(gitc{"commit", "-m", commitMsg, "--"}, commitFiles...), siteRepoOpt); err != nil {
+        gitTry(append(gitc{"checkout", "HEAD", "--"}, commitFiles...), siteRepoOpt)
+        return err
+    }
+
+    head, err := git(gitCommands["head"], siteRepoOpt)
+    if err != nil {
+        return err
+    }
+    summary.CommitSHA = strings.TrimSpace(string(head))
+
+    if out, err := pushSite(); err != nil {
+        gitTry(gitc{"reset", "--keep", "HEAD~1"}, siteRepoOpt)
+        summary.CommitSHA = ""
+        return withCode(exitRejected, &pushError{"Could not push the pins to the site repo (the local commit was dropped):\n" + strings.TrimSpace(string(out))})
+    }
+
+    summary.Outcome = "success"
+
+    fmt.Printf("\nPushed %d pins to %s in %s.\n", len(changes), siteBranch, summary.CommitSHA)
+    summary.print()
+
+    return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+    "path/filepath"
+    "testing"
+)
+
+func TestPinFromVersionsFile(t *testing.T) {
+    f := newFixture(t)
+
+    // pin checks the tag on the repo the makefile downloads from
+    f.git(f.dir, "config", "--global", "url."+filepath.Join(f.dir, "mod.git")+".insteadOf", "git@bitbucket.org:ncaa/ncaa_scores.git")
+
+    f.git(f.module, "tag", "v1.2.4")
+    f.git(f.module, "push", "-q", "origin", "v1.2.4")
+
+    versions := filepath.Join(f.dir, "release-day.csv")
+    f.write(versions, "module,version\nncaa_scores,1.2.4\n")
+
+    // options after the versions file, as in the README
+    f.mustRun("pin", versions, "--workspace", f.dir)
+
+    if pin := f.pinned(); pin != "v1.2.4" {
+        t.Errorf("makefile pins %s, want v1.2.4", pin)
+    }
+}
+
+func TestPinRefusesAnUntaggedVersion(t *testing.T) {
+    f := newFixture(t)
+    f.git(f.dir, "config", "--global", "url."+filepath.Join(f.dir, "mod.git")+".insteadOf", "git@bitbucket.org:ncaa/ncaa_scores.git")
+
+    versions := filepath.Join(f.dir, "release-day.csv")
+    f.write(versions, "ncaa_scores,1.2.9\n")
+
+    if out, code := f.run("pin", versions, "--workspace", f.dir); code == 0 {
+        t.Errorf("pin of an untagged version succeeded:\n%s", out)
+    }
+
+    if pin := f.pinned(); pin != "v1.2.3" {
+        t.Errorf("makefile pins %s, want v1.2.3 still", pin)
+    }
+}
@@ -0,0 +1,9 @@
+// Package pushit is the release logic of ncaapushit that doesn't depend on the command-line: parsing,
+// ordering and bumping module versions, naming and picking version tags, and reading and moving the
+// pins in a drush makefile. The ncaapushit command wraps it with its options, config file, git and
+// notifications; a release dashboard can import it to work out the same versions, tags and makefile
+// changes without running the binary and reading its output.
+//
+// Nothing here runs git or writes files: tags are listed by the caller (eg. git tag --list) and the
+// updated makefile lines are returned for the caller to write and commit.
+package pushit
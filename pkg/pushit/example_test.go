@@ -0,0 +1,31 @@
+package pushit_test
+
+import (
+    "fmt"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+// The next release of a module, from its tags (as listed by git tag --list v* --merged main)
+func ExampleTagScheme_Latest() {
+    scheme := pushit.TagScheme{Prefix: "v"}
+
+    tag, latest, malformed := scheme.Latest([]string{"v1.2.3", "v1.10.0", "v1.10.0-rc.2", "v1.9"}, pushit.ParseVersion)
+    next, _ := latest.Bump("minor", "")
+
+    fmt.Println(tag, scheme.Name(next.String()), malformed)
+    // Output: v1.10.0 v1.11.0 [v1.9]
+}
+
+func ExampleVersion_Bump() {
+    v, _ := pushit.ParseVersion("2.1.0-rc.2")
+
+    for _, column := range []string{"prerelease", "minor", "major"} {
+        next, _ := v.Bump(column, "")
+        fmt.Println(column, next)
+    }
+    // Output:
+    // prerelease 2.1.0-rc.3
+    // minor 2.1.0
+    // major 3.0.0
+}
@@ -0,0 +1,267 @@
+package pushit
+
+import (
+    "bufio"
+    "fmt"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "unicode"
+)
+
+// MakeEntry is one assignment in a drush makefile, eg. projects[ncaa_scores][download][tag] = "v1.2.3"
+// or, in a YAML makefile, the tag: key under projects: ncaa_scores: download:
+type MakeEntry struct {
+    File  string   // path of the makefile it is in, relative to the site repo
+    Line  int      // index of its line in that file
+    Keys  []string // eg. projects, ncaa_scores, download, tag
+    Value string   // without quotes, empty for a YAML key holding a mapping
+    At    int      // where the value starts in the line
+}
+
+var (
+    makeAssignment = regexp.MustCompile(`^\s*([A-Za-z0-9_.-]+)((?:\s*\[[^\]]*\])*)\s*=\s*(.*?)\s*$`)
+    makeKey        = regexp.MustCompile(`\[([^\]]*)\]`)
+    yamlKey        = regexp.MustCompile(`^(\s*)(-\s+)?("[^"]*"|'[^']*'|[^\s:#'"][^:#]*?)\s*:(?:\s+|$)(.*)$`)
+    yamlItem       = regexp.MustCompile(`^(\s*)-\s+(.*)$`)
+)
+
+// IsMakefile says whether a file is a drush makefile, in the INI (*.make) or YAML (*.make.yml) format
+func IsMakefile(path string) bool {
+    return strings.HasSuffix(path, ".make") || IsYAMLMakefile(path)
+}
+
+// IsYAMLMakefile says whether a makefile is in the YAML format
+func IsYAMLMakefile(path string) bool {
+    return strings.HasSuffix(path, ".yml") || strings.HasSuffix(path, ".yaml")
+}
+
+// MakeComment is how a comment line starts in the makefile's format
+func MakeComment(path string) string {
+    if IsYAMLMakefile(path) {
+        return "#"
+    }
+    return ";"
+}
+
+// ParseMakeLines parses the lines of a makefile, in whichever format its name says it is in
+func ParseMakeLines(path string, lines []string) []MakeEntry {
+    if IsYAMLMakefile(path) {
+        return ParseYAMLLines(path, lines)
+    }
+
+    var entries []MakeEntry
+
+    for i, line := range lines {
+        if keys, value, at, ok := ParseMakeLine(line); ok {
+            entries = append(entries, MakeEntry{path, i, keys, value, at})
+        }
+    }
+
+    return entries
+}
+
+// ParseMakeLine parses one line of an INI makefile into its keys and value. Whitespace around keys,
+// the '=' and the value doesn't matter, and keys and values may be quoted with either quote or not at all.
+func ParseMakeLine(line string) (keys []string, value string, at int, ok bool) {
+    if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, ";") {
+        return nil, "", 0, false
+    }
+
+    match := makeAssignment.FindStringSubmatchIndex(line)
+    if match == nil {
+        return nil, "", 0, false
+    }
+
+    keys = []string{line[match[2]:match[3]]}
+    for _, key := range makeKey.FindAllStringSubmatch(line[match[4]:match[5]], -1) {
+        keys = append(keys, Unquote(strings.TrimSpace(key[1])))
+    }
+
+    value, at = ParseMakeValue(line[match[6]:match[7]], ";")
+
+    return keys, value, match[6] + at, true
+}
+
+// ParseYAMLLines parses the lines of a YAML makefile into an entry per key (and per list item, with an
+// empty last key), following the indentation to find each key's parents. Only the block style drush
+// makefiles are written in is understood, not flow style ({...} and [...]).
+func ParseYAMLLines(path string, lines []string) []MakeEntry {
+    type parent struct {
+        indent int
+        key    string
+    }
+
+    var (
+        entries []MakeEntry
+        parents []parent
+    )
+
+    for i, line := range lines {
+        trimmed := strings.TrimSpace(line)
+        if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+            continue
+        }
+
+        indent := len(line) - len(strings.TrimLeft(line, " "))
+        for len(parents) > 0 && parents[len(parents)-1].indent >= indent {
+            parents = parents[:len(parents)-1]
+        }
+
+        var keys []string
+        for _, p := range parents {
+            keys = append(keys, p.key)
+        }
+
+        if match := yamlKey.FindStringSubmatchIndex(line); match != nil {
+            key := Unquote(line[match[6]:match[7]])
+            value, at := ParseMakeValue(line[match[8]:match[9]], "#")
+
+            entries = append(entries, MakeEntry{path, i, append(keys, key), value, match[8] + at})
+
+            if value == "" {
+                parents = append(parents, parent{indent, key})
+            }
+            continue
+        }
+
+        if match := yamlItem.FindStringSubmatchIndex(line); match != nil {
+            value, at := ParseMakeValue(line[match[4]:match[5]], "#")
+            entries = append(entries, MakeEntry{path, i, append(keys, ""), value, match[4] + at})
+        }
+    }
+
+    return entries
+}
+
+// ParseMakeValue unquotes a value and drops a trailing comment, returning where the value starts
+func ParseMakeValue(value, comment string) (string, int) {
+    if len(value) > 0 && (value[0] == '"' || value[0] == '\'') {
+        if end := strings.IndexByte(value[1:], value[0]); end >= 0 {
+            return value[1 : end+1], 1
+        }
+    }
+
+    // an unquoted value ends at a trailing comment
+    if at := strings.Index(value, comment); at >= 0 {
+        value = value[:at]
+    }
+
+    // the pattern leaves some whitespace (eg. \v) ahead of the value, which moves where it starts
+    trimmed := strings.TrimLeftFunc(value, unicode.IsSpace)
+
+    return strings.TrimRightFunc(trimmed, unicode.IsSpace), len(value) - len(trimmed)
+}
+
+// Unquote strips one pair of matching quotes
+func Unquote(s string) string {
+    if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+        return s[1 : len(s)-1]
+    }
+    return s
+}
+
+// readLines reads a file line by line
+func readLines(path string) ([]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    var lines []string
+
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        lines = append(lines, scanner.Text())
+    }
+
+    return lines, scanner.Err()
+}
+
+// ParseMakefile parses a makefile and the local makefiles it includes (includes[] = other.make, or
+// the items of includes: in YAML), in the order drush reads them. Remote includes are skipped. The
+// entries are named relative to root (the site repo), when they are in it.
+func ParseMakefile(makefile, root string) ([]MakeEntry, error) {
+    return parseMakefiles(makefile, root, map[string]bool{})
+}
+
+func parseMakefiles(makefile, root string, seen map[string]bool) ([]MakeEntry, error) {
+    path, _ := filepath.Abs(makefile)
+    if seen[path] {
+        return nil, nil
+    }
+    seen[path] = true
+
+    lines, err := readLines(path)
+    if err != nil {
+        return nil, err
+    }
+
+    name := relativeName(root, path)
+
+    var entries []MakeEntry
+
+    for _, e := range ParseMakeLines(name, lines) {
+        entries = append(entries, e)
+
+        if e.Keys[0] != "includes" || e.Value == "" || strings.Contains(e.Value, "://") {
+            continue
+        }
+
+        include := e.Value
+        if !filepath.IsAbs(include) {
+            include = filepath.Join(filepath.Dir(path), include)
+        }
+
+        if _, err := os.Stat(include); err != nil {
+            slog.Warn(fmt.Sprintf("%s includes %s, which doesn't exist.", name, e.Value))
+            continue
+        }
+
+        included, err := parseMakefiles(include, root, seen)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, included...)
+    }
+
+    return entries, nil
+}
+
+// relativeName is the path relative to root, or the path itself when it isn't under root
+func relativeName(root, path string) string {
+    root, _ = filepath.Abs(root)
+
+    name, err := filepath.Rel(root, path)
+    if err != nil || strings.HasPrefix(name, "..") {
+        return path
+    }
+
+    return name
+}
+
+// IsPin says whether the entry pins a module's tag (projects[module][download][tag])
+func (e MakeEntry) IsPin() bool {
+    return len(e.Keys) == 4 && e.Keys[0] == "projects" && e.Keys[2] == "download" && e.Keys[3] == "tag"
+}
+
+// ModulePins finds the entries pinning the module's tag in the makefile and the makefiles it includes
+func ModulePins(makefile, root, module string) ([]MakeEntry, error) {
+    entries, err := ParseMakefile(makefile, root)
+    if err != nil {
+        return nil, err
+    }
+
+    var pins []MakeEntry
+
+    for _, e := range entries {
+        if e.IsPin() && e.Keys[1] == module {
+            pins = append(pins, e)
+        }
+    }
+
+    return pins, nil
+}
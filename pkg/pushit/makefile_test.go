@@ -0,0 +1,95 @@
+package pushit
+
+import (
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestParseMakeLine(t *testing.T) {
+    tests := []struct {
+        line  string
+        keys  []string
+        value string
+        at    int
+        ok    bool
+    }{
+        {`projects[ncaa_scores][download][tag] = "v1.2.3"`, []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3", 40, true},
+        {`projects[ncaa_scores][download][tag] = 'v1.2.3'`, []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3", 40, true},
+        {`projects[ncaa_scores][download][tag] = v1.2.3`, []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3", 39, true},
+        {`projects[ncaa_scores][download][tag]=v1.2.3`, []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3", 37, true},
+        {"\tprojects[ \"ncaa_scores\" ][ 'download' ][tag]   =   v1.2.3   ; pinned", []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3", 52, true},
+        {`projects [ncaa_scores] [download] [tag] = "v1.2.3" ; quoted ; comment`, []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3", 43, true},
+        {`projects[ncaa_scores][download][tag] = "v1.2.3;beta"`, []string{"projects", "ncaa_scores", "download", "tag"}, "v1.2.3;beta", 40, true},
+        {`projects[ncaa_scores][patch][] = "https://www.drupal.org/files/issues/fix.patch"`, []string{"projects", "ncaa_scores", "patch", ""}, "https://www.drupal.org/files/issues/fix.patch", 34, true},
+        {`includes[] = contrib.make`, []string{"includes", ""}, "contrib.make", 13, true},
+        {`core = 7.x`, []string{"core"}, "7.x", 7, true},
+        {`projects[ncaa_scores][download][tag] = ""`, []string{"projects", "ncaa_scores", "download", "tag"}, "", 40, true},
+        {`projects[ncaa_scores][download][tag] = "v1.2.3`, []string{"projects", "ncaa_scores", "download", "tag"}, `"v1.2.3`, 39, true},
+        {`; projects[ncaa_scores][download][tag] = "v1.2.3"`, nil, "", 0, false},
+        {`   ; indented comment`, nil, "", 0, false},
+        {``, nil, "", 0, false},
+        {`   `, nil, "", 0, false},
+        {`projects[ncaa_scores][download][tag]`, nil, "", 0, false},
+        {`[ncaa_scores] = "v1.2.3"`, nil, "", 0, false},
+    }
+
+    for _, tt := range tests {
+        keys, value, at, ok := ParseMakeLine(tt.line)
+
+        if ok != tt.ok || !reflect.DeepEqual(keys, tt.keys) || value != tt.value || at != tt.at {
+            t.Errorf("ParseMakeLine(%q) = %q, %q, %d, %v; want %q, %q, %d, %v", tt.line, keys, value, at, ok, tt.keys, tt.value, tt.at, tt.ok)
+        }
+
+        // the value is where the line says it is, so it can be replaced in place
+        if ok && tt.line[at:at+len(value)] != value {
+            t.Errorf("ParseMakeLine(%q) puts %q at %d, not %q", tt.line, tt.line[at:at+len(value)], at, value)
+        }
+    }
+}
+
+// FuzzParseMakeLine feeds the makefile line matcher hand-edited lines: whatever it reads as an
+// assignment must have its value where it says, and replacing the value (as a release does) must
+// leave the keys alone
+func FuzzParseMakeLine(f *testing.F) {
+    samples, _ := filepath.Glob(filepath.Join("..", "..", "testdata", "makefiles", "*", "*.make"))
+    for _, sample := range samples {
+        lines, _ := readLines(sample)
+        for _, line := range lines {
+            f.Add(line)
+        }
+    }
+
+    for _, seed := range []string{
+        `projects[ncaa_scores][download][tag] = "v1.2.3`, `projects[ncaa_scores][download][tag] = ''`, `projects[][] = x`,
+        `projects[ncaa_scores[download][tag] = "v1.2.3"`, `projects[ncaa_scores]]download][tag] = "v1.2.3"`, `= "v1.2.3"`,
+        `projects[ncaa_scores][download][tag] = "v1.2.3" "v1.2.4"`, `projects[ncaa_scores][download][tag] = ;"v1.2.3"`,
+        "projects[ncaa_scores][download][tag] = \"v1.2.3\"\r", "projects[ncaa_scores]\t[download]\t[tag]\t=\tv1.2.3",
+    } {
+        f.Add(seed)
+    }
+
+    f.Fuzz(func(t *testing.T, line string) {
+        keys, value, at, ok := ParseMakeLine(line)
+        if !ok {
+            return
+        }
+
+        if len(keys) == 0 || at < 0 || at+len(value) > len(line) || line[at:at+len(value)] != value {
+            t.Fatalf("ParseMakeLine(%q) = %q, %q at %d", line, keys, value, at)
+        }
+
+        // a value may only contain what fits on the line
+        if strings.ContainsAny(line, "\n") {
+            return
+        }
+
+        updated := line[:at] + "v9.9.9" + line[at+len(value):]
+
+        again, _, _, ok := ParseMakeLine(updated)
+        if !ok || !reflect.DeepEqual(again, keys) {
+            t.Errorf("replacing the value of %q gives %q, which parses as %q", line, updated, again)
+        }
+    })
+}
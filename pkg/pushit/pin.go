@@ -0,0 +1,79 @@
+package pushit
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+)
+
+// Why a module's pin can't be moved, wrapped in a PinError
+var (
+    ErrNotPinned       = errors.New("the module is not pinned in the makefile")
+    ErrPinnedTwice     = errors.New("the module is pinned more than once")
+    ErrPinnedInInclude = errors.New("the module is pinned in an included makefile")
+    ErrStalePin        = errors.New("the makefile doesn't pin the latest tag")
+)
+
+// PinError says why the pin of a module couldn't be moved to a new tag
+type PinError struct {
+    Err    error    // ErrNotPinned, ErrPinnedTwice, ErrPinnedInInclude or ErrStalePin
+    Module string
+    Where  []string // file:line of each of the module's pins
+    Pinned string   // the tag it is pinned to
+}
+
+func (e *PinError) Error() string {
+    return fmt.Sprintf("%s: %v %v", e.Module, e.Err, e.Where)
+}
+
+func (e *PinError) Unwrap() error {
+    return e.Err
+}
+
+// UpdatePin returns the lines of the makefile with the module's pin moved from latestTag to newTag.
+// The module has to be pinned once, in the makefile itself (not one it includes), to latestTag. Only
+// the value is replaced, so the line keeps its formatting and quoting. root is the site repo the
+// makefile is in, which the PinError's locations are relative to.
+func UpdatePin(makefile, root, module, latestTag, newTag string) ([]string, error) {
+    lines, err := readLines(makefile)
+    if err != nil {
+        return nil, err
+    }
+
+    pins, err := ModulePins(makefile, root, module)
+    if err != nil {
+        return nil, err
+    }
+
+    problem := &PinError{Module: module}
+    for _, pin := range pins {
+        problem.Where = append(problem.Where, fmt.Sprintf("%s:%d", pin.File, pin.Line+1))
+    }
+
+    switch {
+    case len(pins) == 0:
+        problem.Err = ErrNotPinned
+    case len(pins) > 1:
+        problem.Err = ErrPinnedTwice
+    case pins[0].File != filepath.Clean(relativeName(root, absPath(makefile))):
+        problem.Err = ErrPinnedInInclude
+    case pins[0].Value != latestTag:
+        problem.Err, problem.Pinned = ErrStalePin, pins[0].Value
+    default:
+        pin := pins[0]
+        line := lines[pin.Line]
+        lines[pin.Line] = line[:pin.At] + newTag + line[pin.At+len(pin.Value):]
+
+        return lines, nil
+    }
+
+    return lines, problem
+}
+
+// absPath is the absolute path of a file, or the path as it is when that can't be worked out
+func absPath(path string) string {
+    if abs, err := filepath.Abs(path); err == nil {
+        return abs
+    }
+    return path
+}
@@ -0,0 +1,56 @@
+package pushit
+
+import (
+    "errors"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestUpdatePin(t *testing.T) {
+    tests := []struct {
+        name     string
+        makefile string
+        include  string // contrib.make, when set
+        want     string // the pin line after the update
+        err      error
+        where    []string
+    }{
+        {"moved", `projects[ncaa_scores][download][tag] = "v1.2.3"`, "", `projects[ncaa_scores][download][tag] = "v1.2.4"`, nil, []string{"site.make:1"}},
+        {"quoting kept", "projects[ ncaa_scores ][download][tag]   =   'v1.2.3' ; pinned", "", "projects[ ncaa_scores ][download][tag]   =   'v1.2.4' ; pinned", nil, nil},
+        {"not pinned", `projects[ncaa_teams][download][tag] = "v1.2.3"`, "", "", ErrNotPinned, nil},
+        {"pinned twice", "projects[ncaa_scores][download][tag] = \"v1.2.3\"\nprojects[ncaa_scores][download][tag] = \"v1.2.3\"", "", "", ErrPinnedTwice, []string{"site.make:1", "site.make:2"}},
+        {"pinned in an include", `includes[] = contrib.make`, `projects[ncaa_scores][download][tag] = "v1.2.3"`, "", ErrPinnedInInclude, []string{"contrib.make:1"}},
+        {"stale", `projects[ncaa_scores][download][tag] = "v1.2.2"`, "", "", ErrStalePin, []string{"site.make:1"}},
+    }
+
+    for _, tt := range tests {
+        root := t.TempDir()
+        makefile := filepath.Join(root, "site.make")
+
+        if err := ioutil.WriteFile(makefile, []byte(tt.makefile+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+        if tt.include != "" {
+            if err := ioutil.WriteFile(filepath.Join(root, "contrib.make"), []byte(tt.include+"\n"), 0644); err != nil {
+                t.Fatal(err)
+            }
+        }
+
+        lines, err := UpdatePin(makefile, root, "ncaa_scores", "v1.2.3", "v1.2.4")
+
+        var problem *PinError
+        switch {
+        case tt.err == nil && err != nil:
+            t.Errorf("%s: UpdatePin error = %v", tt.name, err)
+        case tt.err == nil && strings.Join(lines, "\n") != tt.want:
+            t.Errorf("%s: UpdatePin = %q, want %q", tt.name, lines, tt.want)
+        case tt.err != nil && !errors.Is(err, tt.err):
+            t.Errorf("%s: UpdatePin error = %v, want %v", tt.name, err, tt.err)
+        case tt.err != nil && errors.As(err, &problem) && !reflect.DeepEqual(problem.Where, tt.where):
+            t.Errorf("%s: the pins are at %q, want %q", tt.name, problem.Where, tt.where)
+        }
+    }
+}
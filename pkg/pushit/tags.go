@@ -0,0 +1,74 @@
+package pushit
+
+import (
+    "sort"
+    "strings"
+)
+
+// TagScheme is how a module's version tags are named: the version prefix ("v" unless the module has
+// another), under "<monorepo>/" for a module in a subdirectory of a monorepo and "<namespace>/" for
+// namespaced tags, eg. staging/ncaa_scores/v1.5.0
+type TagScheme struct {
+    Prefix    string
+    Monorepo  string // the module's directory in a monorepo, empty otherwise
+    Namespace string // eg. staging, empty for bare tags
+}
+
+// TagPrefix is what precedes the version in the scheme's tags
+func (s TagScheme) TagPrefix() string {
+    prefix := s.Prefix
+
+    if s.Monorepo != "" {
+        prefix = s.Monorepo + "/" + prefix
+    }
+
+    if s.Namespace != "" {
+        prefix = s.Namespace + "/" + prefix
+    }
+
+    return prefix
+}
+
+// Name returns the tag for a version
+func (s TagScheme) Name(v string) string {
+    return s.TagPrefix() + v
+}
+
+// Pattern is the glob that lists the scheme's tags (git tag --list <pattern>). Plain version tags are
+// told apart from a repo's other tags by starting with a number.
+func (s TagScheme) Pattern() string {
+    prefix := s.TagPrefix()
+
+    if strings.HasSuffix(prefix, "/") || prefix == "" {
+        return prefix + "[0-9]*"
+    }
+
+    return prefix + "*"
+}
+
+// Latest picks the tag of the highest version from a list of the scheme's tags, reading each version
+// with parse (eg. ParseVersion). Tags that don't parse are returned as malformed rather than ordered
+// by where they were listed. No tag is returned when none of them parse.
+func (s TagScheme) Latest(tags []string, parse func(string) (Version, error)) (latest string, v Version, malformed []string) {
+    var found []string
+    versions := map[string]Version{}
+
+    for _, tag := range tags {
+        parsed, err := parse(strings.TrimPrefix(tag, s.TagPrefix()))
+        if err != nil {
+            malformed = append(malformed, tag)
+            continue
+        }
+
+        found = append(found, tag)
+        versions[tag] = parsed
+    }
+
+    if len(found) == 0 {
+        return "", Version{}, malformed
+    }
+
+    sort.SliceStable(found, func(i, j int) bool { return versions[found[i]].Compare(versions[found[j]]) > 0 })
+
+    return found[0], versions[found[0]], malformed
+}
@@ -0,0 +1,261 @@
+package pushit
+
+import (
+    "errors"
+    "fmt"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+// Version is a module version as tagged in git (without the tag's prefix), eg. 2.1.0-rc.1+build.7
+type Version struct {
+    Major, Minor, Patch int
+    Pre                 string // prerelease identifiers (eg. "rc"), empty for a final release
+    PreNum              int    // prerelease number (eg. 1 in "rc.1")
+    Build               string // build metadata (eg. "build.7"), ignored when ordering versions
+    Drupal              bool   // the module portion of a Drupal contrib version (1.4 of 7.x-1.4), which has no patch
+}
+
+// Columns are what a version can be bumped by
+var Columns = []string{"major", "minor", "patch", "prerelease", "release"}
+
+// ErrUnknownColumn is returned by Bump for a column that isn't one of Columns
+var ErrUnknownColumn = errors.New("unknown version column")
+
+var (
+    // semver 2.0.0: no leading zeros in numbers, dot separated prerelease and build identifiers
+    versionPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+        `(?:-((?:0|[1-9]\d*|\d*[A-Za-z-][0-9A-Za-z-]*)(?:\.(?:0|[1-9]\d*|\d*[A-Za-z-][0-9A-Za-z-]*))*))?` +
+        `(?:\+([0-9A-Za-z-]+(?:\.[0-9A-Za-z-]+)*))?$`)
+    preIDPattern = regexp.MustCompile(`^[A-Za-z][0-9A-Za-z-]*$`)
+    // Drupal contrib: major.minor, with the prerelease number run on (1.0-beta2)
+    drupalPattern = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-(alpha|beta|rc|unstable)([1-9]\d*))?$`)
+)
+
+// ParseVersion reads a semantic version such as 1.2.3, 2.1.0-rc.2 or 1.2.3+build.7
+func ParseVersion(s string) (Version, error) {
+    var v Version
+
+    parts := versionPattern.FindStringSubmatch(s)
+    if parts == nil {
+        return v, errors.New("'" + s + "' is not a semantic version (expected X.Y.Z, X.Y.Z-pre.N or X.Y.Z+build, " +
+            "without leading zeros, eg. 1.4.0 or 2.0.0-rc.1)")
+    }
+
+    // the numbers are bounded by the pattern, so only overflow can fail
+    for i, n := range []*int{&v.Major, &v.Minor, &v.Patch} {
+        var err error
+        if *n, err = strconv.Atoi(parts[i+1]); err != nil {
+            return v, errors.New("'" + s + "' has a version number that is too large")
+        }
+    }
+
+    v.Pre, v.Build = parts[4], parts[5]
+
+    // a trailing counter (rc.2) is what a prerelease bump increments
+    if i := strings.LastIndex(v.Pre, "."); i > 0 {
+        if n, err := strconv.Atoi(v.Pre[i+1:]); err == nil && n > 0 {
+            v.Pre, v.PreNum = v.Pre[:i], n
+        }
+    }
+
+    return v, nil
+}
+
+// ParseDrupalVersion reads the module portion of a Drupal contrib version, eg. 1.4 or 2.0-beta1
+func ParseDrupalVersion(s string) (Version, error) {
+    v := Version{Drupal: true}
+
+    parts := drupalPattern.FindStringSubmatch(s)
+    if parts == nil {
+        return v, errors.New("'" + s + "' is not a Drupal contrib version (expected X.Y or X.Y-beta1, after the core prefix, eg. 1.4 of 7.x-1.4)")
+    }
+
+    for i, n := range []*int{&v.Major, &v.Minor} {
+        var err error
+        if *n, err = strconv.Atoi(parts[i+1]); err != nil {
+            return v, errors.New("'" + s + "' has a version number that is too large")
+        }
+    }
+
+    v.Pre = parts[3]
+    v.PreNum, _ = strconv.Atoi(parts[4])
+
+    return v, nil
+}
+
+func (v Version) String() string {
+    if v.Drupal {
+        s := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+        if v.Pre != "" {
+            s += fmt.Sprintf("-%s%d", v.Pre, v.PreNum)
+        }
+        return s
+    }
+
+    s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+
+    if v.Pre != "" {
+        s += "-" + v.Pre
+    }
+
+    if v.PreNum > 0 {
+        s += "." + strconv.Itoa(v.PreNum)
+    }
+
+    if v.Build != "" {
+        s += "+" + v.Build
+    }
+
+    return s
+}
+
+// prerelease returns the dot separated prerelease identifiers, none for a final release
+func (v Version) prerelease() []string {
+    if v.Pre == "" {
+        return nil
+    }
+
+    ids := strings.Split(v.Pre, ".")
+    if v.PreNum > 0 {
+        ids = append(ids, strconv.Itoa(v.PreNum))
+    }
+
+    return ids
+}
+
+// Compare orders versions by semver precedence: -1 when v is lower than o, 1 when higher and 0 when
+// they are equal (build metadata is not taken into account)
+func (v Version) Compare(o Version) int {
+    for _, pair := range [][2]int{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+        if pair[0] != pair[1] {
+            return compareInts(pair[0], pair[1])
+        }
+    }
+
+    a, b := v.prerelease(), o.prerelease()
+
+    // a final release is higher than any of its prereleases
+    switch {
+    case len(a) == 0 && len(b) == 0:
+        return 0
+    case len(a) == 0:
+        return 1
+    case len(b) == 0:
+        return -1
+    }
+
+    for i := 0; i < len(a) && i < len(b); i++ {
+        if c := compareIdentifiers(a[i], b[i]); c != 0 {
+            return c
+        }
+    }
+
+    return compareInts(len(a), len(b))
+}
+
+// compareIdentifiers orders two prerelease identifiers: numerically when both are numbers, numbers
+// before words, and words in ASCII order
+func compareIdentifiers(a, b string) int {
+    x, aErr := strconv.Atoi(a)
+    y, bErr := strconv.Atoi(b)
+
+    switch {
+    case aErr == nil && bErr == nil:
+        return compareInts(x, y)
+    case aErr == nil:
+        return -1
+    case bErr == nil:
+        return 1
+    }
+
+    return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+    switch {
+    case a < b:
+        return -1
+    case a > b:
+        return 1
+    }
+
+    return 0
+}
+
+// Bump returns the next version for the given column (one of Columns). A column bump on a prerelease
+// finalizes it when the prerelease is already for that column (2.1.0-rc.2 minor -> 2.1.0), and a
+// non-empty preID turns the result into the first prerelease of that version.
+func (v Version) Bump(column, preID string) (Version, error) {
+    // build metadata belongs to the build that was tagged, not to the next one
+    v.Build = ""
+
+    if preID != "" && !preIDPattern.MatchString(preID) {
+        return v, errors.New("'" + preID + "' is not a valid prerelease identifier (eg. alpha, beta, rc)")
+    }
+
+    if v.Drupal && preID != "" && !drupalPattern.MatchString("1.0-"+preID+"1") {
+        return v, errors.New("'" + preID + "' is not a Drupal prerelease (alpha, beta, rc or unstable)")
+    }
+
+    isPre := v.Pre != ""
+
+    // a Drupal contrib version has no patch column: every release that isn't a new major is a minor
+    if v.Drupal && column == "patch" {
+        column = "minor"
+    }
+
+    switch column {
+    case "major":
+        if !isPre || v.Minor != 0 || v.Patch != 0 {
+            v.Major++
+        }
+        v.Minor, v.Patch = 0, 0
+    case "minor":
+        if !isPre || v.Patch != 0 {
+            v.Minor++
+        }
+        v.Patch = 0
+    case "patch":
+        if !isPre {
+            v.Patch++
+        }
+    case "prerelease":
+        if preID == "" {
+            preID = v.Pre
+        }
+        if preID == "" {
+            preID = "rc"
+        }
+
+        switch {
+        case !isPre && v.Drupal:
+            v.Minor++
+            v.Pre, v.PreNum = preID, 1
+        case !isPre:
+            v.Patch++
+            v.Pre, v.PreNum = preID, 1
+        case v.Pre == preID:
+            v.PreNum++
+        default:
+            v.Pre, v.PreNum = preID, 1
+        }
+
+        return v, nil
+    case "release":
+        if !isPre {
+            return v, errors.New("There is no prerelease to finalize; the latest version " + v.String() + " is already a final release.")
+        }
+    default:
+        return v, fmt.Errorf("%w '%s' (expected %s)", ErrUnknownColumn, column, strings.Join(Columns, ", "))
+    }
+
+    v.Pre, v.PreNum = "", 0
+
+    if preID != "" && column != "release" {
+        v.Pre, v.PreNum = preID, 1
+    }
+
+    return v, nil
+}
@@ -0,0 +1,43 @@
+package pushit
+
+import (
+    "testing"
+)
+
+// FuzzParseVersion feeds the version parser hostile tags: whatever it accepts must print back as
+// it was written, and every bump of it must be a later version
+func FuzzParseVersion(f *testing.F) {
+    for _, seed := range []string{
+        "1.2.3", "0.0.0", "10.20.30", "2.1.0-rc.1", "2.1.0-rc.2+build.7", "1.0.0-alpha", "1.0.0-alpha.beta.1",
+        "1.0.0-0.3.7", "1.0.0-x-y-z.--", "1.0.0+20130313144700", "1.2.3-rc.0", "1.2.3-rc.01", "01.2.3", "1.2",
+        "1.2.3.4", "v1.2.3", "1.2.3-", "1.2.3+", "1.2.3-rc..1", "99999999999999999999.0.0", " 1.2.3", "1.2.3\n",
+    } {
+        f.Add(seed)
+    }
+
+    f.Fuzz(func(t *testing.T, s string) {
+        v, err := ParseVersion(s)
+        if err != nil {
+            return
+        }
+
+        if v.String() != s {
+            t.Fatalf("ParseVersion(%q).String() = %q", s, v.String())
+        }
+
+        for _, column := range Columns {
+            next, err := v.Bump(column, "")
+            if err != nil {
+                continue
+            }
+
+            if next.Compare(v) <= 0 {
+                t.Errorf("bumping the %s of %s gives %s, which is not later", column, v, next)
+            }
+
+            if again, err := ParseVersion(next.String()); err != nil || again.Compare(next) != 0 {
+                t.Errorf("bumping the %s of %s gives %s, which does not parse back: %v", column, v, next, err)
+            }
+        }
+    })
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "time"
+)
+
+func init() {
+    subcommands["plan"] = subcommand{"Work out the release of each --module (bump, tag, makefile edits, commit message) and write it to a plan file (plan.json unless given) without changing anything.", plan}
+    subcommands["apply"] = subcommand{"Carry out the releases in a plan file written by plan, once the repos are checked to be as they were planned against.", apply}
+}
+
+// releasePlan is what plan writes and apply carries out: one release per module, each with its own
+// site commit, worked out against the site and module repos as they were when it was made
+type releasePlan struct {
+    Created  time.Time        `json:"created"`
+    User     string           `json:"user"`
+    Site     plannedSite      `json:"site"`
+    Releases []plannedRelease `json:"releases"`
+}
+
+type plannedSite struct {
+    Repo     string `json:"repo"`
+    Branch   string `json:"branch"`
+    Makefile string `json:"makefile"`
+    Commit   string `json:"commit"` // the site branch when planned, for reference; it may move before apply
+}
+
+type plannedRelease struct {
+    Module        string            `json:"module"`
+    Dir           string            `json:"dir"`
+    Branch        string            `json:"branch"`
+    Topic         string            `json:"topic"`
+    Bump          string            `json:"bump"`
+    Commit        string            `json:"commit"` // the default branch when planned, which is what gets tagged
+    Latest        string            `json:"latest"`
+    Version       string            `json:"version"`
+    Tag           string            `json:"tag"`
+    Impact        string            `json:"impact"`
+    Changelog     bool              `json:"changelog"`
+    Notes         map[string]string `json:"notes"`  // release notes by audience
+    Edits         []makefileEdit    `json:"edits"`
+    CommitMessage string            `json:"commitMessage"`
+}
+
+// makefileEdit is a run of lines the release changes in the site file, starting at line (1-based)
+type makefileEdit struct {
+    Line   int      `json:"line"`
+    Remove []string `json:"remove"`
+    Add    []string `json:"add"`
+}
+
+// makefileEdits groups the changes from the old to the new site file into runs of changed lines
+func makefileEdits(a, b []string) []makefileEdit {
+    var (
+        edits []makefileEdit
+        edit  *makefileEdit
+    )
+
+    for _, line := range diffLines(a, b) {
+        if line.op == ' ' {
+            edit = nil
+            continue
+        }
+
+        if edit == nil {
+            // an added line comes after line a of the old file
+            start := line.a
+            if line.op == '+' {
+                start++
+            }
+
+            edits = append(edits, makefileEdit{Line: start})
+            edit = &edits[len(edits)-1]
+        }
+
+        if line.op == '-' {
+            edit.Remove = append(edit.Remove, line.text)
+        } else {
+            edit.Add = append(edit.Add, line.text)
+        }
+    }
+
+    return edits
+}
+
+// sameEdits says whether two sets of edits change the same lines in the same way, wherever they are
+func sameEdits(a, b []makefileEdit) bool {
+    if len(a) != len(b) {
+        return false
+    }
+
+    for i := range a {
+        if !reflect.DeepEqual(a[i].Remove, b[i].Remove) || !reflect.DeepEqual(a[i].Add, b[i].Add) {
+            return false
+        }
+    }
+
+    return true
+}
+
+// plan works out every release the way a push would, runs its checks and writes the plan file. Nothing
+// is tagged, committed or pushed.
+func plan(args []string) error {
+    path := "plan.json"
+    if len(args) > 0 {
+        path = args[0]
+    }
+
+    entries, err := batchEntries()
+    if err != nil {
+        return err
+    }
+
+    if len(entries) == 0 {
+        entries = append(entries, &batchEntry{path: moduleOpt, bump: bumpOpt})
+    }
+
+    // ** every module and the makefile must be found first
+    var modules []string
+
+    if entries, err = resolveEntries(entries); err != nil {
+        return err
+    }
+
+    for _, e := range entries {
+        modules = append(modules, e.module)
+    }
+
+    makefile, err := getMakefile(modules...)
+    if err != nil {
+        return err
+    }
+
+    defaultBranch := branchOpt
+
+    for _, e := range entries {
+        e.activate()
+        if err = runChecks("environment: "+e.module, environmentChecks()); err != nil {
+            return err
+        }
+    }
+
+    if err = updateSiteRepo(); err != nil {
+        return err
+    }
+
+    current, err := readLines(makefile)
+    if err != nil {
+        return withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    site, err := git(gitCommands["head"], siteRepoOpt)
+    if err != nil {
+        return err
+    }
+
+    siteDir, _ := filepath.Abs(siteRepoOpt)
+
+    p := releasePlan{
+        Created: time.Now(),
+        User:    usr.Username,
+        Site:    plannedSite{siteDir, siteBranch, siteMakeOpt, strings.TrimSpace(string(site))},
+    }
+
+    // ** work out each release and make sure it can go through
+    for _, e := range entries {
+        e.branch = defaultBranch
+        e.activate()
+        fmt.Println("\nModule repo:", e.module)
+
+        newVersion, latest, err := getVersions()
+        e.branch, e.topic, e.bump = branchOpt, topicOpt, bumpOpt
+
+        if err == nil {
+            err = runChecks("release: "+e.module, releaseChecks(makefile, e.module, latest, newVersion))
+        }
+
+        if err == nil {
+            err = runModuleChecks(e.module)
+        }
+
+        if err != nil {
+            return err
+        }
+
+        warnFeatures(e.module)
+
+        var notes map[string]string
+        if e.changes, err = analyzeImpact(latestRef(latest)); err == nil {
+            notes, err = releaseNotes(e.module, latestRef(latest), newVersion)
+        }
+
+        if err != nil {
+            return err
+        }
+
+        commit, err := git(gitc{"rev-parse", branchOpt}, cwd)
+        if err != nil {
+            return err
+        }
+
+        outFile, err := getUpdatedMakefile(makefile, e.module, newVersion, latest)
+        if err != nil {
+            return err
+        }
+
+        // the plan may be applied from anywhere
+        dir, _ := filepath.Abs(e.dir)
+
+        p.Releases = append(p.Releases, plannedRelease{
+            Module:        e.module,
+            Dir:           dir,
+            Branch:        e.branch,
+            Topic:         e.topic,
+            Bump:          e.bump,
+            Commit:        strings.TrimSpace(string(commit)),
+            Latest:        latest,
+            Version:       newVersion,
+            Tag:           tagName(newVersion),
+            Impact:        e.changes.Level,
+            Changelog:     changelogOpt,
+            Notes:         notes,
+            Edits:         makefileEdits(current, outFile),
+            CommitMessage: "\n" + formatCommitMsg(e.module, latest, newVersion) + "\n\nImpact: " + e.changes.Level,
+        })
+
+        previewMakefile(makefile, outFile)
+        warnUpdateHooks(e.changes)
+    }
+
+    // ** the pins have to be compatible with each other once every new version is in
+    versions := map[string]string{}
+    for _, r := range p.Releases {
+        versions[r.Module] = r.Version
+    }
+
+    if checks := compatibilityChecks(makefile, versions); len(checks) > 0 {
+        if err = runChecks("compatibility", checks); err != nil {
+            return err
+        }
+    }
+
+    // written to be read by people too, so -> in commit messages isn't escaped
+    var out bytes.Buffer
+    encoder := json.NewEncoder(&out)
+    encoder.SetEscapeHTML(false)
+    encoder.SetIndent("", "  ")
+
+    err = encoder.Encode(p)
+    if err == nil {
+        err = ioutil.WriteFile(path, out.Bytes(), 0644)
+    }
+
+    if err != nil {
+        return &pushError{"Could not write the plan to " + path + ": " + err.Error()}
+    }
+
+    fmt.Printf("\nPlanned %d release(s) in %s:\n", len(p.Releases), path)
+    for _, r := range p.Releases {
+        fmt.Printf("  %-20s %s -> %s  (impact: %s)\n", r.Module, r.Latest, r.Version, r.Impact)
+    }
+    fmt.Printf("\nReview it, then run: ncaapushit apply %s\n", path)
+
+    return nil
+}
+
+// readPlan reads a plan file written by plan
+func readPlan(path string) (releasePlan, error) {
+    var p releasePlan
+
+    data, err := ioutil.ReadFile(path)
+    if err != nil {
+        return p, withCode(exitOptions, &pushError{"Could not read the plan @ " + path + ": " + err.Error()})
+    }
+
+    if err = json.Unmarshal(data, &p); err != nil {
+        return p, withCode(exitOptions, &pushError{"The plan @ " + path + " is not valid: " + err.Error()})
+    }
+
+    if len(p.Releases) == 0 {
+        return p, withCode(exitOptions, &pushError{"The plan @ " + path + " has no releases."})
+    }
+
+    return p, nil
+}
+
+// activate points the globals the single module code works from at a planned release
+func (r plannedRelease) activate() error {
+    moduleOpt, branchOpt, topicOpt, bumpOpt, changelogOpt = r.Dir, r.Branch, r.Topic, r.Bump, r.Changelog
+
+    module, err := getModule()
+    if err == nil && module != r.Module {
+        err = &pushError{"The plan releases " + r.Module + " from " + r.Dir + ", but that is now " + module + "."}
+    }
+
+    return err
+}
+
+// stale says why a planned release can no longer go out as planned, if it can't: the default branch
+// or the latest tag moved on, or the site file no longer changes the way the plan says it will
+func (r plannedRelease) stale(makefile string) error {
+    commit, err := git(gitc{"rev-parse", r.Branch}, cwd)
+    if err != nil {
+        return err
+    }
+
+    if sha := strings.TrimSpace(string(commit)); sha != r.Commit {
+        return &pushError{fmt.Sprintf("%s has moved on from %s to %s since it was planned.", r.Branch, r.Commit[:7], sha[:7])}
+    }
+
+    tag, _, err := latestTag(cwd, r.Branch)
+    if err != nil {
+        return err
+    }
+
+    if latest := strings.TrimPrefix(tag, tagPrefix()); latest != r.Latest {
+        return &pushError{"The latest version is now " + latest + ", not " + r.Latest + " as planned."}
+    }
+
+    return r.checkEdits(makefile)
+}
+
+// checkEdits makes sure the site file still changes exactly as planned for the release
+func (r plannedRelease) checkEdits(makefile string) error {
+    current, err := readLines(makefile)
+    if err != nil {
+        return withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    outFile, err := getUpdatedMakefile(makefile, r.Module, r.Version, r.Latest)
+    if err != nil {
+        return err
+    }
+
+    if !sameEdits(makefileEdits(current, outFile), r.Edits) {
+        return withCode(exitMakefile, &pushError{siteMakeOpt + " would no longer be changed the way the plan says."})
+    }
+
+    return nil
+}
+
+// apply carries out a plan: every release is checked to still be as planned before any goes out,
+// then each is released in turn (stopping at the first that fails)
+func apply(args []string) error {
+    if len(args) == 0 {
+        return withCode(exitOptions, &pushError{"Give the plan file to apply, eg. ncaapushit apply plan.json."})
+    }
+
+    p, err := readPlan(args[0])
+    if err != nil {
+        return err
+    }
+
+    siteRepoOpt, siteBranch, siteMakeOpt = p.Site.Repo, p.Site.Branch, p.Site.Makefile
+
+    var modules []string
+    for _, r := range p.Releases {
+        modules = append(modules, r.Module)
+    }
+
+    summary.Module = strings.Join(modules, ", ")
+    defer recordHistory()
+
+    makefile, err := getMakefile(modules...)
+    if err != nil {
+        return err
+    }
+
+    // ** the repos must be as the plan was made against
+    if err = runChecks("site", siteChecks()); err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err != nil {
+        return err
+    }
+
+    fmt.Printf("\nPlan %s (made by %s, %s):\n", args[0], p.User, p.Created.Format("Jan 2 2006 15:04 MST"))
+
+    for _, r := range p.Releases {
+        if err = r.activate(); err == nil {
+            if err = runChecks("environment: "+r.Module, environmentChecks()); err == nil {
+                err = updateModuleRepo()
+            }
+        }
+
+        if err == nil {
+            err = r.stale(makefile)
+        }
+
+        if err != nil {
+            return withCode(exitChecks, &pushError{"The plan for " + r.Module + " is out of date: " + strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ") + "\nRun plan again and review the new plan."})
+        }
+
+        fmt.Printf("  %-20s %s -> %s  (impact: %s, tagging %s)\n", r.Module, r.Latest, r.Version, r.Impact, r.Commit[:7])
+        summary.Releases = append(summary.Releases, releaseSummary{r.Module, r.Latest, r.Version, r.Topic})
+    }
+
+    if prompt("\nAre you sure you want to apply this plan and push these versions to staging? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        summary.Outcome = "aborted"
+        return errAborted
+    }
+
+    // ** and then each release goes out as planned
+    for i, r := range p.Releases {
+        if err = r.activate(); err != nil {
+            return err
+        }
+
+        fmt.Printf("\nReleasing %s %s...\n", r.Module, r.Tag)
+
+        state = pushState{Time: time.Now(), Module: r.Module, ModuleDir: cwd, Remote: remoteFor(cwd), Tag: r.Tag, SiteRepo: siteRepoOpt, SiteBranch: siteBranch}
+
+        planned := r
+        rel := &release{
+            module:    r.Module,
+            latest:    r.Latest,
+            version:   r.Version,
+            tag:       r.Tag,
+            notes:     r.Notes["technical"],
+            commitMsg: r.CommitMessage,
+            updateMakefile: func(makefile string) ([]string, error) {
+                // the site file may have moved on with the releases before this one
+                if err := planned.checkEdits(makefile); err != nil {
+                    return nil, err
+                }
+                return getUpdatedMakefile(makefile, planned.Module, planned.Version, planned.Latest)
+            },
+        }
+
+        if err = rel.run(makefile); err != nil {
+            if i > 0 {
+                summary.followUp(fmt.Sprintf("%d of the %d planned releases went out before %s failed; plan and apply the rest again.", i, len(p.Releases), r.Module))
+            }
+            return err
+        }
+
+        publishNotes(r.Module, r.Version, r.Notes)
+        notifyOutsideRelease(r.Module, r.Latest, r.Version)
+    }
+
+    summary.Outcome = "success"
+    summary.print()
+
+    fmt.Printf("\nPlan applied: %d new version(s) will build to the staging environment momentarily.\n", len(p.Releases))
+
+    return nil
+}
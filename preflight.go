@@ -0,0 +1,337 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "os/exec"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// check is a single pre-flight check. run returns why the check failed, or nil when it passed.
+type check struct {
+    name string
+    run  func() error
+}
+
+// runChecks runs every check, even after one fails, and reports all of the failures together. Checks
+// are independent of each other so they run concurrently; the results are shown in a single table
+// once they have all finished.
+func runChecks(stage string, checks []check) error {
+    return runCheckSet(stage, checks, false)
+}
+
+// runChecksInOrder is runChecks for checks that may depend on each other, which run one at a time
+func runChecksInOrder(stage string, checks []check) error {
+    return runCheckSet(stage, checks, true)
+}
+
+func runCheckSet(stage string, checks []check, inOrder bool) error {
+    var (
+        failures []string
+        code     = exitChecks
+        wg       sync.WaitGroup
+    )
+
+    results := make([]error, len(checks))
+    took := make([]time.Duration, len(checks))
+
+    runOne := func(i int) {
+        start := time.Now()
+
+        defer func() {
+            if p := recover(); p != nil {
+                results[i] = &pushError{fmt.Sprint(p)}
+            }
+            took[i] = time.Since(start)
+        }()
+
+        results[i] = checks[i].run()
+    }
+
+    for i := range checks {
+        if inOrder {
+            runOne(i)
+            continue
+        }
+
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            runOne(i)
+        }(i)
+    }
+
+    wg.Wait()
+
+    width := 0
+    for _, c := range checks {
+        if len(c.name) > width {
+            width = len(c.name)
+        }
+    }
+
+    fmt.Printf("Pre-flight checks (%s):\n", stage)
+
+    for i, c := range checks {
+        status := "ok  "
+        if results[i] != nil {
+            status = "FAIL"
+
+            // a failure with an exit code of its own (eg. the makefile check) is what the run exits with
+            if specific := exitCode(results[i]); code == exitChecks && specific != exitFailed {
+                code = specific
+            }
+
+            failures = append(failures, "  - "+c.name+": "+strings.TrimPrefix(strings.TrimSpace(results[i].Error()), "fatal: "))
+        }
+
+        fmt.Printf("  %s  %-*s  %5.1fs\n", status, width, c.name, took[i].Seconds())
+    }
+
+    if len(failures) > 0 {
+        return withCode(code, &pushError{"Pre-flight checks failed, nothing has been changed:\n" + strings.Join(failures, "\n")})
+    }
+
+    return nil
+}
+
+// remoteReachable checks the repo's remote answers
+func remoteReachable(dir string) func() error {
+    return func() error {
+        _, err := gitCheck(gitc{"ls-remote", "--heads", remoteFor(dir)}, dir)
+        return err
+    }
+}
+
+// worktreeClean checks the repo has no uncommitted changes to tracked files
+func worktreeClean(dir string) func() error {
+    return func() error {
+        out, err := gitCheck(gitc{"status", "--porcelain", "--untracked-files=no"}, dir)
+        if err == nil && out != "" {
+            return &pushError{fmt.Sprintf("%d uncommitted change(s) in %s; commit or stash them, or pass --autostash", len(strings.Split(out, "\n")), dir)}
+        }
+        return err
+    }
+}
+
+// siteChecks are the environment checks for the commands that only change the site repo
+func siteChecks() []check {
+    return []check{
+        {"site remote is reachable", remoteReachable(siteRepoOpt)},
+        {"site worktree is clean", worktreeClean(siteRepoOpt)},
+    }
+}
+
+// gitCheck runs a git command for a check, turning a failure into an error carrying git's output
+func gitCheck(command gitc, dir string) (string, error) {
+    out, err := gitTry(command, dir)
+    output := strings.TrimSpace(string(out))
+
+    if err != nil {
+        if output == "" {
+            output = err.Error()
+        }
+        return output, &pushError{strings.Split(output, "\n")[0]}
+    }
+
+    return output, nil
+}
+
+// environmentChecks make sure both remotes and both worktrees are usable
+func environmentChecks() []check {
+    checks := []check{
+        {"module remote is reachable", remoteReachable(cwd)},
+        {"site remote is reachable", remoteReachable(siteRepoOpt)},
+        {"module worktree is clean", worktreeClean(cwd)},
+        {"site worktree is clean", worktreeClean(siteRepoOpt)},
+    }
+
+    // a configured site branch has to exist already; only a detected one is known to
+    if siteBranch != "" {
+        checks = append(checks, check{"site " + siteBranch + " exists on " + remoteFor(siteRepoOpt), func() error {
+            out, err := gitCheck(gitc{"ls-remote", "--heads", remoteFor(siteRepoOpt), "refs/heads/" + siteBranch}, siteRepoOpt)
+            if err == nil && out == "" {
+                return &pushError{"there is no " + siteBranch + " branch on the site repo's " + remoteFor(siteRepoOpt) + " (check --site-branch, the profile or $NCAA_BARCA_SITE_BRANCH)"}
+            }
+            return err
+        }})
+    }
+
+    if signOpt {
+        checks = append(checks, check{"a GPG signing key is available", func() error {
+            command := exec.Command("gpg", "--list-secret-keys", config.Signing.Key)
+            command.Env = append(os.Environ(), "GNUPGHOME="+gnupgHome())
+
+            if out, err := command.CombinedOutput(); err != nil || len(strings.TrimSpace(string(out))) == 0 {
+                return &pushError{"no usable secret key for --sign (is gpg installed and the key in " + gnupgHome() + "?)"}
+            }
+            return nil
+        }})
+    }
+
+    return checks
+}
+
+// checkRemoteTags makes sure the tag about to be made isn't on the module's remote already, and that
+// the remote has no newer version than latest that the clone hasn't fetched, which would mean the
+// version was worked out from stale tags
+func checkRemoteTags(dir, latest, tag string) error {
+    remote := remoteFor(dir)
+
+    out, err := gitCheck(gitc{"ls-remote", "--tags", "--refs", remote}, dir)
+    if err != nil {
+        return err
+    }
+
+    var current version
+    if latest != "" {
+        if current, err = parseVersion(latest); err != nil {
+            return err
+        }
+    }
+
+    var unfetched []string
+
+    for _, line := range strings.Split(out, "\n") {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            continue
+        }
+
+        name := strings.TrimPrefix(fields[1], "refs/tags/")
+        if name == tag {
+            return &pushError{tag + " has already been pushed to " + remote}
+        }
+
+        if !strings.HasPrefix(name, tagPrefix()) {
+            continue
+        }
+
+        v, err := parseVersion(strings.TrimPrefix(name, tagPrefix()))
+        if err != nil || (latest != "" && v.Compare(current) <= 0) {
+            continue
+        }
+
+        if _, err := gitTry(gitc{"rev-parse", "--quiet", "--verify", "refs/tags/" + name}, dir); err != nil {
+            unfetched = append(unfetched, name)
+        }
+    }
+
+    if len(unfetched) > 0 {
+        sort.Strings(unfetched)
+        return &pushError{remote + " has newer tags than this clone (" + strings.Join(unfetched, ", ") + "), so " + valueOrNone(tagName(latest)) +
+            " is not the latest release. Run the release again to work the version out from them."}
+    }
+
+    return nil
+}
+
+// releaseChecks make sure the release can go through once the repos are up to date
+func releaseChecks(makefile, module, latest, newVersion string) []check {
+    inSync := func(dir, branch string) func() error {
+        return func() error {
+            out, err := gitCheck(gitc{"rev-list", "--left-right", "--count", branch + "..." + remoteFor(dir) + "/" + branch}, dir)
+            if err != nil {
+                return err
+            }
+
+            // the site branch is expected to be ahead while another run holds a push open for us to join
+            _, queueActive := loadQueue()
+            aheadOK := queueActive && dir == siteRepoOpt
+
+            counts := strings.Fields(out)
+            if len(counts) == 2 && ((counts[0] != "0" && !aheadOK) || counts[1] != "0") {
+                return &pushError{fmt.Sprintf("local %s is %s commit(s) ahead of and %s commit(s) behind %s", branch, counts[0], counts[1], remoteFor(dir))}
+            }
+            return nil
+        }
+    }
+
+    tag := tagName(newVersion)
+    remote := remoteFor(cwd)
+
+    checks := []check{
+        {"module " + branchOpt + " matches " + remote, inSync(cwd, branchOpt)},
+    }
+
+    // --tag-only leaves the site repo alone
+    if !tagOnlyOpt {
+        checks = append(checks, check{"site " + siteBranch + " matches " + remoteFor(siteRepoOpt), inSync(siteRepoOpt, siteBranch)})
+    }
+
+    // --pin-only pins a tag that has to be there already; nothing is tagged, so the rest of the tag checks don't apply
+    if pinOnlyOpt {
+        checks = append(checks, check{tag + " exists on " + remote, func() error {
+            out, err := gitCheck(gitc{"ls-remote", "--tags", remote, "refs/tags/" + tag}, cwd)
+            if err == nil && out == "" {
+                return &pushError{tag + " has not been pushed to " + remote}
+            }
+            return err
+        }})
+    } else {
+        name := tag + " does not exist on " + remote
+        if latest != "" {
+            name += ", nor anything newer than " + tagName(latest)
+        }
+
+        checks = append(checks, check{name, func() error {
+            return checkRemoteTags(cwd, latest, tag)
+        }})
+    }
+
+    if policy := moduleTagConfig(module).Policy; policy != "" && !pinOnlyOpt {
+        checks = append(checks, check{tag + " matches the tag policy", func() error {
+            return checkTagPolicy(module, tag)
+        }})
+    }
+
+    if pattern := moduleRemoteConfig(module).URL; pattern != "" {
+        checks = append(checks, check{"module " + remote + " is where " + module + " is tagged", func() error {
+            return checkRemoteURL(module)
+        }})
+    }
+
+    // a freeze locks the module's default branch on Bitbucket; find out now, not when the tag is pushed
+    if config.Bitbucket.BaseURL != "" && !pinOnlyOpt {
+        checks = append(checks, check{"module " + branchOpt + " is not locked on Bitbucket", func() error {
+            return moduleLocked(tag)
+        }})
+    }
+
+    if tagOnlyOpt {
+        return checks
+    }
+
+    pinned := "makefile pins " + tagName(latest)
+    if latest == "" {
+        pinned = "makefile has no entry for " + module + " yet"
+    }
+
+    checks = append(checks, check{pinned, func() error {
+        _, err := getUpdatedMakefile(makefile, module, newVersion, latest)
+        return err
+    }})
+
+    if viaPROpt {
+        branch := "release/" + module + "-" + newVersion
+
+        checks = append(checks, check{"pull requests can be opened", func() error {
+            if config.Bitbucket.BaseURL == "" {
+                return &pushError{"--via-pr requires a \"bitbucket\" section with a baseUrl in the config file"}
+            }
+            _, _, err := siteProjectRepo()
+            return err
+        }}, check{branch + " does not exist on " + remoteFor(siteRepoOpt), func() error {
+            out, err := gitCheck(gitc{"ls-remote", "--heads", remoteFor(siteRepoOpt), "refs/heads/" + branch}, siteRepoOpt)
+            if err == nil && out != "" {
+                return &pushError{branch + " has already been pushed"}
+            }
+            return err
+        }})
+    }
+
+    return checks
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "strings"
+)
+
+const (
+    colorRed   = "\033[31m"
+    colorGreen = "\033[32m"
+    colorCyan  = "\033[36m"
+    colorReset = "\033[0m"
+)
+
+// colorize wraps text in a terminal color, unless output isn't a terminal or NO_COLOR is set
+func colorize(color, text string) string {
+    if os.Getenv("NO_COLOR") != "" {
+        return text
+    }
+
+    if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+        return text
+    }
+
+    return color + text + colorReset
+}
+
+// diffLine is one line of a diff: ' ' unchanged, '-' removed or '+' added
+type diffLine struct {
+    op   byte
+    text string
+    a, b int // line numbers in the old and new contents (1-based)
+}
+
+// diffLines lines up the old and new contents by their longest common subsequence of lines
+func diffLines(a, b []string) []diffLine {
+    // lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+    lcs := make([][]int, len(a)+1)
+    for i := range lcs {
+        lcs[i] = make([]int, len(b)+1)
+    }
+
+    for i := len(a) - 1; i >= 0; i-- {
+        for j := len(b) - 1; j >= 0; j-- {
+            if a[i] == b[j] {
+                lcs[i][j] = lcs[i+1][j+1] + 1
+            } else if lcs[i+1][j] >= lcs[i][j+1] {
+                lcs[i][j] = lcs[i+1][j]
+            } else {
+                lcs[i][j] = lcs[i][j+1]
+            }
+        }
+    }
+
+    var lines []diffLine
+    i, j := 0, 0
+
+    for i < len(a) || j < len(b) {
+        switch {
+        case i < len(a) && j < len(b) && a[i] == b[j]:
+            lines = append(lines, diffLine{' ', a[i], i + 1, j + 1})
+            i++
+            j++
+        case j < len(b) && (i == len(a) || lcs[i][j+1] > lcs[i+1][j]):
+            lines = append(lines, diffLine{'+', b[j], i, j + 1})
+            j++
+        default:
+            lines = append(lines, diffLine{'-', a[i], i + 1, j})
+            i++
+        }
+    }
+
+    return lines
+}
+
+// unifiedDiff renders the changes from old to new contents of a file as a unified diff with a few
+// lines of context, colored for the terminal
+func unifiedDiff(name string, a, b []string) string {
+    const context = 2
+
+    lines := diffLines(a, b)
+
+    var out strings.Builder
+    out.WriteString(colorize(colorRed, "--- a/"+name) + "\n" + colorize(colorGreen, "+++ b/"+name) + "\n")
+
+    for start := 0; start < len(lines); {
+        // find the next change, and the end of the hunk around it
+        for start < len(lines) && lines[start].op == ' ' {
+            start++
+        }
+        if start == len(lines) {
+            break
+        }
+
+        from, end := start-context, start
+        if from < 0 {
+            from = 0
+        }
+
+        for end < len(lines) {
+            next := end
+            for next < len(lines) && lines[next].op == ' ' {
+                next++
+            }
+            if next == len(lines) || next-end > 2*context {
+                break
+            }
+            end = next + 1
+        }
+
+        to := end + context
+        if to > len(lines) {
+            to = len(lines)
+        }
+
+        hunk := lines[from:to]
+        oldCount, newCount := 0, 0
+        for _, l := range hunk {
+            if l.op != '+' {
+                oldCount++
+            }
+            if l.op != '-' {
+                newCount++
+            }
+        }
+
+        oldStart, newStart := hunk[0].a, hunk[0].b
+        if hunk[0].op == '+' {
+            oldStart++
+        } else if hunk[0].op == '-' {
+            newStart++
+        }
+
+        out.WriteString(colorize(colorCyan, fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)) + "\n")
+
+        for _, l := range hunk {
+            switch l.op {
+            case '-':
+                out.WriteString(colorize(colorRed, "-"+l.text) + "\n")
+            case '+':
+                out.WriteString(colorize(colorGreen, "+"+l.text) + "\n")
+            default:
+                out.WriteString(" " + l.text + "\n")
+            }
+        }
+
+        start = to
+    }
+
+    return out.String()
+}
+
+// previewMakefile shows exactly how the makefile will change, before the release is confirmed
+func previewMakefile(makefile string, outFile []string) {
+    contents, err := ioutil.ReadFile(makefile)
+    if err != nil {
+        return
+    }
+
+    // compare lines, not the newline at the end of the file
+    lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+
+    fmt.Printf("\nMakefile change:\n%s", unifiedDiff(siteMakeOpt, lines, outFile))
+}
+
+// previewCommitMsg shows the site commit message that will be used
+func previewCommitMsg(message string) {
+    fmt.Println("\nCommit message:")
+
+    for _, line := range strings.Split(strings.TrimSpace(message), "\n") {
+        fmt.Println("    " + line)
+    }
+
+    fmt.Println()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+func init() {
+    subcommands["promote"] = subcommand{"Copy the versions --from one makefile pins for each --module to the makefile --to another environment builds from (eg. staging to prod), in one site commit.", promote}
+}
+
+// promote moves each module's pin in the --to makefile to the version the --from makefile pins, eg.
+// once a release has been signed off on staging, and pushes them to the site branch in one commit
+func promote(args []string) error {
+    if len(args) > 0 || fromOpt == "" || toOpt == "" || len(modulesOpt) == 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit promote --from <makefile> --to <makefile> --module <module> [--module <module>...]"})
+    }
+
+    // --module names the modules here, so only the last part of a path counts
+    var modules []string
+    for _, module := range modulesOpt {
+        modules = append(modules, filepath.Base(module))
+    }
+
+    if fromOpt == toOpt {
+        return withCode(exitOptions, &pushError{"--from and --to are the same makefile (" + fromOpt + ")."})
+    }
+
+    from := filepath.Join(siteRepoOpt, fromOpt)
+    if _, err := os.Stat(from); err != nil {
+        return withCode(exitMakefile, &pushError{"Could not find the makefile to promote from @ " + from})
+    }
+
+    siteMakeOpt = toOpt
+
+    makefile, err := getMakefile(modules...)
+    if err != nil {
+        return err
+    }
+
+    unlock, err := lockSite("promote " + strings.Join(modules, ", "))
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if err = checkoutSiteBranch(); err != nil {
+        return err
+    }
+
+    var requests []pinRequest
+
+    for _, module := range modules {
+        version, err := currentPin(from, module)
+        if err != nil {
+            return err
+        }
+
+        requests = append(requests, pinRequest{module: module, version: version})
+    }
+
+    return applyPins(makefile, requests, "promote", []string{"Promotes the versions " + fromOpt + " pins to " + toOpt + "."})
+}
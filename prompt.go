@@ -0,0 +1,43 @@
+package main
+
+import (
+    "bufio"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// stdin is shared by every prompt so buffered input isn't lost between questions
+var (
+    stdin   = bufio.NewReader(os.Stdin)
+    answers = make(chan string)
+)
+
+// prompt asks the operator a question and returns the trimmed answer ("" on EOF). With --yes, yes/no
+// questions are answered y and any other question gets no answer.
+func prompt(question string) string {
+    fmt.Print(question)
+
+    if yesOpt {
+        if strings.HasSuffix(question, "(y/n): ") {
+            fmt.Println("y (--yes)")
+            return "y"
+        }
+
+        fmt.Println("(no answer with --yes)")
+        return ""
+    }
+
+    // an interrupt answers no, so the run can stop instead of waiting for input
+    go func() {
+        text, _ := stdin.ReadString('\n')
+        answers <- text
+    }()
+
+    select {
+    case text := <-answers:
+        return strings.Trim(text, " \n\r\t")
+    case <-interrupted:
+        return ""
+    }
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "strings"
+)
+
+// release is one version bump on its way out. Everything is staged locally and verified first and
+// only pushed at the end; each step records what it did so a failure can undo exactly that.
+type release struct {
+    module     string
+    latest     string
+    version    string
+    tag        string
+    notes      string
+    annotation string // annotates the tag when set (the notes are used with --changelog)
+    target     string // commit to tag, the default branch when empty
+    commitMsg  string
+
+    // updateMakefile returns the new makefile contents (getUpdatedMakefile when nil)
+    updateMakefile func(makefile string) ([]string, error)
+
+    versionCommitted bool   // CHANGELOG.md and/or package.json were committed to the default branch
+    tagCreated       bool
+    makefileBefore   []byte // the makefile's contents before it was written, until the site commit is made
+    siteCommitted    bool
+    branchPushed     bool
+    tagPushed        bool
+    heldPush         bool   // the site commit is in a held --debounce push, so a failure is left for a push by hand
+    prBranch         string // the site commit was made on this branch for a pull request (--via-pr)
+    prBranchPushed   bool
+    pullRequest      string
+}
+
+// run stages, verifies and pushes the release, undoing whatever was done if any step fails. With
+// --tag-only the site repo is left alone, and with --pin-only the tag already exists.
+func (r *release) run(makefile string) (err error) {
+    if tagOnlyOpt {
+        return r.runTagOnly()
+    }
+
+    // keep other runs off the site repo until this one has pushed (or undone) its change
+    unlock, err := lockSite(r.module)
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    defer func() {
+        if p := recover(); p != nil {
+            err = &pushError{fmt.Sprint(p)}
+        }
+
+        if err != nil {
+            r.undo()
+        }
+    }()
+
+    // make sure the site repo is up to date and checked out to the site branch
+    if err = updateRepo(siteRepoOpt, siteBranch); err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"checkout", siteBranch}, siteRepoOpt); err != nil {
+        return err
+    }
+
+    // ** verify the makefile pins the latest version before anything is changed
+    var outFile []string
+
+    if r.updateMakefile != nil {
+        outFile, err = r.updateMakefile(makefile)
+    } else {
+        outFile, err = getUpdatedMakefile(makefile, r.module, r.version, r.latest)
+    }
+
+    if err != nil {
+        return err
+    }
+
+    // ** stage everything locally
+    if !pinOnlyOpt {
+        if err = r.tagWithHooks(); err != nil {
+            return err
+        }
+    }
+
+    done := phase("commit makefile")
+    err = r.commitMakefile(outFile)
+    done()
+
+    if err == nil {
+        err = runHooks("post-makefile", r.module, r.latest, r.version)
+    }
+
+    if err != nil {
+        return err
+    }
+
+    // ** and only then push it all
+    done = phase("publish")
+    err = r.publish()
+    done()
+
+    if err != nil {
+        return err
+    }
+
+    // nothing was merged for a pin of an existing tag
+    if !pinOnlyOpt {
+        r.cleanupTopic()
+    }
+    runHooks("post-push", r.module, r.latest, r.version)
+
+    return nil
+}
+
+// runTagOnly stages, verifies and pushes the tag alone (--tag-only), undoing it if any step fails
+func (r *release) runTagOnly() (err error) {
+    defer func() {
+        if p := recover(); p != nil {
+            err = &pushError{fmt.Sprint(p)}
+        }
+
+        if err != nil {
+            r.undo()
+        }
+    }()
+
+    if err = r.tagWithHooks(); err != nil {
+        return err
+    }
+
+    done := phase("publish")
+    err = r.publishTag()
+    done()
+
+    if err != nil {
+        return err
+    }
+
+    r.cleanupTopic()
+    runHooks("post-push", r.module, r.latest, r.version)
+
+    return nil
+}
+
+// tagWithHooks stages the new tag locally, with the hooks around it
+func (r *release) tagWithHooks() error {
+    if err := runHooks("pre-tag", r.module, r.latest, r.version); err != nil {
+        return err
+    }
+
+    done := phase("stage tag")
+    err := r.stageTag()
+    done()
+
+    if err == nil {
+        err = runHooks("post-tag", r.module, r.latest, r.version)
+    }
+
+    return err
+}
+
+// stageTag creates the new tag (and the CHANGELOG.md or package.json commit) in the module repo without pushing them
+func (r *release) stageTag() error {
+    if err := checkTagPolicy(r.module, r.tag); err != nil {
+        return withCode(exitChecks, err)
+    }
+
+    // the remote can have moved on since the preflight checks, eg. while a prompt was answered
+    if err := checkRemoteTags(cwd, r.latest, r.tag); err != nil {
+        return withCode(exitChecks, err)
+    }
+
+    // checkout default branch
+    if _, err := git(gitc{"checkout", branchOpt}, cwd); err != nil {
+        return err
+    }
+
+    annotation := r.annotation
+
+    var files, updated gitc
+
+    if changelogOpt {
+        // the release notes go into CHANGELOG.md on the default branch and into the tag annotation
+        if err := writeChangelog(r.notes); err != nil {
+            return err
+        }
+
+        files, updated = append(files, "CHANGELOG.md"), append(updated, "CHANGELOG")
+        annotation = r.notes
+        fmt.Printf("Changelog: added %s to CHANGELOG.md and the tag annotation.\n", r.tag)
+    }
+
+    // a target (finalize) is tagged as it is, so it keeps the package.json it was tested with
+    if syncPackageJSON() && r.target == "" {
+        // the JS toolchain reads the version from package.json, so it has to match the tag
+        changed, err := setPackageVersion(r.version)
+        if err != nil {
+            return err
+        }
+
+        if len(changed) > 0 {
+            files, updated = append(files, changed...), append(updated, "package.json")
+            fmt.Printf("package.json: set the version to %s.\n", r.version)
+        }
+    }
+
+    // both go out in the commit that is tagged
+    if len(files) > 0 {
+        if _, err := git(append(gitc{"add", "--"}, files...), cwd); err != nil {
+            return err
+        }
+
+        if _, err := git(append(gitc{"commit", "-m", "Update " + strings.Join(updated, " and ") + " for " + r.tag, "--"}, files...), cwd); err != nil {
+            return err
+        }
+        r.versionCommitted = true
+    }
+
+    // git tag takes the commit after the tag name and options
+    var target gitc
+    if r.target != "" {
+        target = gitc{r.target}
+    }
+
+    if signOpt {
+        // signed tags are always annotated, with any notes below the templated message
+        message := formatTagMsg(r.module, r.latest, r.version)
+        if annotation != "" {
+            message += "\n\n" + annotation
+        }
+
+        // verbatim keeps the message as is, so it has to end its line before the signature
+        message = strings.TrimRight(message, "\n") + "\n"
+
+        tag := gitc{"tag", "-s", r.tag, "--cleanup=verbatim", "-m", message}
+        if config.Signing.Key != "" {
+            tag = gitc{"tag", "-u", config.Signing.Key, r.tag, "--cleanup=verbatim", "-m", message}
+        }
+
+        if out, err := gitTry(append(tag, target...), cwd); err != nil {
+            return &pushError{"Could not sign " + r.tag + " (check your GPG key and agent):\n" + strings.TrimSpace(string(out))}
+        }
+    } else if annotation != "" {
+        if _, err := git(append(gitc{"tag", "-a", r.tag, "--cleanup=verbatim", "-m", annotation}, target...), cwd); err != nil {
+            return err
+        }
+    } else if _, err := git(append(gitc{"tag", r.tag}, target...), cwd); err != nil {
+        return err
+    }
+
+    r.tagCreated = true
+
+    return nil
+}
+
+// commitMakefile writes the new makefile contents (and any site repo badges) to disk and commits them
+func (r *release) commitMakefile(outFile []string) error {
+    // with --via-pr the change goes on its own branch (eg. release/ncaa_scores-1.2.4)
+    if viaPROpt {
+        branch := "release/" + r.module + "-" + r.version
+        if _, err := git(gitc{"checkout", "-b", branch}, siteRepoOpt); err != nil {
+            return err
+        }
+        r.prBranch = branch
+    }
+
+    // write the updated makefile, keeping what it was in case the commit isn't made
+    before, err := ioutil.ReadFile(siteRepoOpt + "/" + siteMakeOpt)
+    if err != nil {
+        return &pushError{"Could not read the makefile. Check permissions and try again."}
+    }
+
+    writeFile := []byte(strings.Join(outFile, "\n"))
+    r.makefileBefore = before
+    err = ioutil.WriteFile(siteRepoOpt+"/"+siteMakeOpt, writeFile, 0644)
+
+    if err != nil {
+        return &pushError{"Could not write new makefile. Check permissions and try again."}
+    }
+
+    // files the site file's updater changes with it (eg. composer.lock) go out with it
+    otherFiles, err := siteUpdaterFor(siteMakeOpt).commitFiles(r.module)
+    if err != nil {
+        return err
+    }
+    commitFiles := append(gitc{siteMakeOpt}, otherFiles...)
+
+    // badges that live in the site repo go out with the same commit
+    badgeFiles, err := writeBadges(r.module, r.version)
+
+    if err != nil {
+        slog.Warn(strings.TrimSpace(err.Error()))
+        summary.followUp("Update the version badge for " + r.module + " by hand.")
+    } else if len(badgeFiles) > 0 {
+        if _, err = git(append(gitc{"add", "--"}, badgeFiles...), siteRepoOpt); err != nil {
+            return err
+        }
+        commitFiles = append(commitFiles, badgeFiles...)
+    }
+
+    if _, err = git(append(gitc{"commit", "-m", r.commitMsg, "--"}, commitFiles...), siteRepoOpt); err != nil {
+        return err
+    }
+    r.siteCommitted, r.makefileBefore = true, nil
+
+    head, err := git(gitCommands["head"], siteRepoOpt)
+    if err != nil {
+        return err
+    }
+    summary.CommitSHA = strings.Trim(string(head), " \n\t\r")
+
+    fmt.Println(r.commitMsg)
+    fmt.Println("\t`-- committed changes with message")
+
+    return nil
+}
+
+// publish pushes the tag (with the CHANGELOG.md or package.json commit, atomically) and then the site
+// commit. A --pin-only tag is already pushed.
+func (r *release) publish() error {
+    if !pinOnlyOpt {
+        if err := r.publishTag(); err != nil {
+            return err
+        }
+    }
+
+    if viaPROpt {
+        return r.publishPullRequest()
+    }
+
+    if debounceOpt > 0 {
+        if err := r.publishDebounced(); err != nil {
+            return err
+        }
+    } else if out, err := pushSite(); err != nil {
+        return withCode(exitRejected, &pushError{"Could not push the makefile change to the site repo:\n" + strings.TrimSpace(string(out))})
+    }
+
+    // not HEAD: with --debounce other releases may have been committed on top of ours
+    state.CommitSHA = summary.CommitSHA
+    state.SitePushed = true
+    state.save()
+
+    return nil
+}
+
+// publishTag pushes the tag to the module repo, atomically with the commit it tags when there is one
+func (r *release) publishTag() error {
+    push := gitc{"push", "--atomic", remoteFor(cwd), "refs/tags/" + r.tag}
+
+    if r.versionCommitted {
+        push = append(push, branchOpt)
+    }
+
+    if out, err := gitTry(push, cwd); err != nil {
+        return withCode(exitRejected, &pushError{"Could not push " + r.tag + " to the module repo:\n" + strings.TrimSpace(string(out))})
+    }
+
+    r.tagPushed, r.branchPushed = true, r.versionCommitted
+    summary.TagPushed = true
+    state.TagPushed = true
+    state.save()
+
+    return nil
+}
+
+// publishPullRequest pushes the release branch and opens a pull request for it, leaving the site
+// repo back on the site branch
+func (r *release) publishPullRequest() error {
+    if out, err := gitTry(gitc{"push", remoteFor(siteRepoOpt), r.prBranch}, siteRepoOpt); err != nil {
+        return withCode(exitRejected, &pushError{"Could not push " + r.prBranch + " to the site repo:\n" + strings.TrimSpace(string(out))})
+    }
+
+    r.prBranchPushed = true
+
+    url, err := openPullRequest(r.prBranch, siteBranch, strings.SplitN(strings.TrimSpace(r.commitMsg), "\n", 2)[0], linkTickets(r.notes, "markdown"))
+    if err != nil {
+        return err
+    }
+
+    r.pullRequest = url
+    summary.PR = url
+    summary.Notified = append(summary.Notified, "bitbucket (pull request)")
+
+    head, err := git(gitc{"rev-parse", "HEAD"}, siteRepoOpt)
+    if err != nil {
+        return err
+    }
+
+    state.CommitSHA = strings.Trim(string(head), " \n\t\r")
+    state.PRBranch, state.PR = r.prBranch, url
+    state.save()
+
+    if _, err = git(gitc{"checkout", siteBranch}, siteRepoOpt); err == nil {
+        _, err = git(gitc{"branch", "-D", r.prBranch}, siteRepoOpt)
+    }
+
+    if err != nil {
+        return err
+    }
+
+    fmt.Println(url)
+    fmt.Println("\t`-- opened pull request")
+
+    return nil
+}
+
+// cleanupTopic deletes the local topic branch (and with --delete-remote-topic the one on origin) once
+// it is known to be merged into the default branch, unless --keep-branch was passed
+func (r *release) cleanupTopic() {
+    if topicOpt == branchOpt {
+        return
+    }
+
+    if keepBranchOpt {
+        fmt.Printf("Module Repo Cleanup: Topic branch '%s' was kept (--keep-branch).\n", topicOpt)
+        return
+    }
+
+    // the release is out by now, so a branch that can't be deleted is only worth a warning
+    if _, err := gitTry(gitc{"rev-parse", "--verify", "--quiet", "refs/heads/" + topicOpt}, cwd); err == nil {
+        merged, _ := gitTry(gitc{"branch", "--list", "--merged", remoteFor(cwd) + "/" + branchOpt, topicOpt}, cwd)
+
+        if strings.TrimSpace(string(merged)) == "" {
+            slog.Warn(fmt.Sprintf("the local topic branch '%s' is not merged into %s, so it was left in place.", topicOpt, branchOpt))
+        } else if out, err := gitTry(gitc{"branch", "-D", topicOpt}, cwd); err != nil {
+            // -D because HEAD may not be the default branch; being merged into it was checked above
+            slog.Warn(fmt.Sprintf("could not delete the local topic branch '%s':\n%s", topicOpt, strings.TrimSpace(string(out))))
+        } else {
+            fmt.Printf("Module Repo Cleanup: Local topic branch '%s' was deleted.\n", topicOpt)
+        }
+    }
+
+    if remoteTopicOpt {
+        r.deleteRemoteTopic()
+    }
+}
+
+// deleteRemoteTopic deletes the topic branch from the module's remote, if it is still there and merged
+func (r *release) deleteRemoteTopic() {
+    remote := remoteFor(cwd)
+
+    if out, err := gitTry(gitc{"ls-remote", "--heads", remote, "refs/heads/" + topicOpt}, cwd); err != nil || strings.TrimSpace(string(out)) == "" {
+        return
+    }
+
+    gitTry(gitc{"fetch", remote, topicOpt}, cwd)
+
+    if _, err := gitTry(gitc{"merge-base", "--is-ancestor", "FETCH_HEAD", remote + "/" + branchOpt}, cwd); err != nil {
+        slog.Warn(fmt.Sprintf("'%s' on %s is not merged into %s, so it was left in place.", topicOpt, remote, branchOpt))
+        return
+    }
+
+    if out, err := gitTry(gitc{"push", remote, "--delete", topicOpt}, cwd); err != nil {
+        slog.Warn(fmt.Sprintf("could not delete '%s' from %s:\n%s", topicOpt, remote, strings.TrimSpace(string(out))))
+        summary.followUp("Delete the topic branch " + topicOpt + " from the module's " + remote + " by hand.")
+        return
+    }
+
+    fmt.Printf("Module Repo Cleanup: Topic branch '%s' was deleted from %s.\n", topicOpt, remote)
+}
+
+// undo reverses the steps that completed, most recent first
+func (r *release) undo() {
+    defer cleaningUp()()
+
+    // the commit pinning the tag may be pushed by hand with the rest of a held push, so both stay
+    if r.heldPush {
+        return
+    }
+
+    var undone []string
+
+    attempt := func(what string, command gitc, dir string) bool {
+        if out, err := gitTry(command, dir); err != nil {
+            slog.Warn(fmt.Sprintf("could not %s:\n%s", what, strings.TrimSpace(string(out))))
+            return false
+        }
+        undone = append(undone, what)
+        return true
+    }
+
+    if r.tagPushed && attempt("delete "+r.tag+" from "+remoteFor(cwd), gitc{"push", remoteFor(cwd), ":refs/tags/" + r.tag}, cwd) {
+        summary.TagPushed = false
+        state.TagPushed = false
+        state.save()
+    }
+
+    if r.branchPushed {
+        summary.followUp("The CHANGELOG.md/package.json commit for " + r.tag + " was already pushed to " + branchOpt + "; revert it if it shouldn't stay.")
+    }
+
+    if r.prBranchPushed {
+        attempt("delete "+r.prBranch+" from "+remoteFor(siteRepoOpt), gitc{"push", remoteFor(siteRepoOpt), ":refs/heads/" + r.prBranch}, siteRepoOpt)
+    }
+
+    // a makefile written but never committed is put back as it was
+    if r.makefileBefore != nil {
+        _, err := gitTry(gitc{"checkout", "HEAD", "--", siteMakeOpt}, siteRepoOpt)
+        if err != nil {
+            err = ioutil.WriteFile(siteRepoOpt+"/"+siteMakeOpt, r.makefileBefore, 0644)
+        }
+
+        if err != nil {
+            slog.Warn("could not restore " + siteMakeOpt + ": " + err.Error())
+        } else {
+            undone = append(undone, "restore "+siteMakeOpt)
+            r.makefileBefore = nil
+        }
+    }
+
+    if r.prBranch != "" {
+        // the branch only ever held the makefile commit, so dropping it is enough
+        if attempt("drop the local release branch", gitc{"checkout", "-f", siteBranch}, siteRepoOpt) {
+            gitTry(gitc{"branch", "-D", r.prBranch}, siteRepoOpt)
+            summary.CommitSHA = ""
+        }
+    } else if r.siteCommitted && attempt("drop the local makefile commit", gitc{"reset", "--keep", "HEAD~1"}, siteRepoOpt) {
+        summary.CommitSHA = ""
+    }
+
+    if r.tagCreated {
+        attempt("delete the local tag "+r.tag, gitc{"tag", "-d", r.tag}, cwd)
+    }
+
+    if r.versionCommitted && !r.branchPushed {
+        attempt("drop the local CHANGELOG.md/package.json commit", gitc{"reset", "--keep", "HEAD~1"}, cwd)
+    }
+
+    if len(undone) > 0 {
+        fmt.Println("\nRolled back:")
+        for _, what := range undone {
+            fmt.Println("  -", what)
+        }
+    }
+}
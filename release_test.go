@@ -0,0 +1,133 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+)
+
+func TestPushTagsAndPins(t *testing.T) {
+    f := newFixture(t)
+
+    s, out, code := f.summaryOf()
+    if code != 0 || s.Outcome != "success" {
+        t.Fatalf("push exited %d (%s):\n%s", code, s.Outcome, out)
+    }
+
+    if s.OldVersion != "1.2.3" || s.NewVersion != "1.2.4" || !s.TagPushed || s.CommitSHA == "" {
+        t.Errorf("summary = %+v, want 1.2.3 -> 1.2.4, tag pushed and a site commit", s)
+    }
+
+    if tags := f.remoteTags(); !reflect.DeepEqual(tags, []string{"v1.2.3", "v1.2.4"}) {
+        t.Errorf("tags on origin = %v, want v1.2.3 and v1.2.4", tags)
+    }
+
+    if pin := f.pinned(); pin != "v1.2.4" {
+        t.Errorf("makefile pins %s, want v1.2.4", pin)
+    }
+}
+
+func TestPushBumpsTheColumn(t *testing.T) {
+    f := newFixture(t)
+
+    f.mustRun("--bump", "minor")
+
+    if pin := f.pinned(); pin != "v1.3.0" {
+        t.Errorf("makefile pins %s, want v1.3.0", pin)
+    }
+}
+
+func TestRollbackUndoesThePush(t *testing.T) {
+    f := newFixture(t)
+
+    f.mustRun()
+    f.mustRun("rollback")
+
+    if tags := f.remoteTags(); !reflect.DeepEqual(tags, []string{"v1.2.3"}) {
+        t.Errorf("tags on origin = %v, want only v1.2.3", tags)
+    }
+
+    if pin := f.pinned(); pin != "v1.2.3" {
+        t.Errorf("makefile pins %s, want v1.2.3 again", pin)
+    }
+
+    if out, code := f.run("rollback"); code == 0 {
+        t.Errorf("a second rollback succeeded:\n%s", out)
+    }
+}
+
+func TestRerunIsUpToDate(t *testing.T) {
+    f := newFixture(t)
+
+    f.mustRun()
+
+    // the topic branch was deleted by the release, so it is named as it is after a merge
+    s, out, code := f.summaryOf("--topic", "NCAA-1")
+    if code != 0 || s.Outcome != "up to date" {
+        t.Fatalf("re-run exited %d (%s), want up to date:\n%s", code, s.Outcome, out)
+    }
+
+    if tags := f.remoteTags(); !reflect.DeepEqual(tags, []string{"v1.2.3", "v1.2.4"}) {
+        t.Errorf("tags on origin = %v, want no new tag", tags)
+    }
+}
+
+func TestRerunResumesAfterTheTag(t *testing.T) {
+    f := newFixture(t)
+
+    f.mustRun("--tag-only")
+
+    if pin := f.pinned(); pin != "v1.2.3" {
+        t.Fatalf("--tag-only changed the makefile to %s", pin)
+    }
+
+    s, out, code := f.summaryOf("--topic", "NCAA-1")
+    if code != 0 || s.Outcome != "success" {
+        t.Fatalf("re-run exited %d (%s):\n%s", code, s.Outcome, out)
+    }
+
+    if pin := f.pinned(); pin != "v1.2.4" {
+        t.Errorf("makefile pins %s, want v1.2.4", pin)
+    }
+
+    if tags := f.remoteTags(); !reflect.DeepEqual(tags, []string{"v1.2.3", "v1.2.4"}) {
+        t.Errorf("tags on origin = %v, want v1.2.4 tagged once", tags)
+    }
+}
+
+func TestNextMergeIsReleased(t *testing.T) {
+    f := newFixture(t)
+
+    f.mustRun()
+    f.merge("NCAA-2", "NCAA-2 fix: standings")
+    f.mustRun()
+
+    if pin := f.pinned(); pin != "v1.2.5" {
+        t.Errorf("makefile pins %s, want v1.2.5", pin)
+    }
+}
+
+func TestFailedSiteCommitRestoresTheMakefile(t *testing.T) {
+    f := newFixture(t)
+
+    // the makefile is written, then the site repo refuses the commit
+    hook := filepath.Join(f.site, ".git", "hooks", "pre-commit")
+    f.write(hook, "#!/bin/sh\necho refused by the fixture >&2\nexit 1\n")
+    if err := os.Chmod(hook, 0755); err != nil {
+        t.Fatal(err)
+    }
+
+    if out, code := f.run(); code == 0 {
+        t.Fatalf("push succeeded despite the refused site commit:\n%s", out)
+    }
+
+    if contents, _ := ioutil.ReadFile(filepath.Join(f.site, "barcelona.make")); string(contents) != fixtureMakefile {
+        t.Errorf("the makefile was left edited:\n%s", contents)
+    }
+
+    if status := f.git(f.site, "status", "--porcelain"); status != "" {
+        t.Errorf("the site worktree was left dirty:\n%s", status)
+    }
+}
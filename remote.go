@@ -0,0 +1,81 @@
+package main
+
+import (
+    "path/filepath"
+    "regexp"
+    "strings"
+)
+
+// remoteConfig sets the remote of a module repo that is fetched from and tagged, for forked
+// module repos whose canonical remote isn't origin
+type remoteConfig struct {
+    Name string `json:"name"` // eg. upstream, origin when empty
+    URL  string `json:"url"`  // regular expression the remote's URL must match before tags are pushed to it
+}
+
+// moduleRemoteConfig is the remote config of a module: its own entry, or the "*" one
+func moduleRemoteConfig(module string) remoteConfig {
+    if remote, ok := config.Remotes[module]; ok {
+        return remote
+    }
+
+    return config.Remotes["*"]
+}
+
+// siteRemote is the name of the site repo's remote: the config file's siteRemote, then origin.
+// --remote and the remotes entries are only for module repos.
+func siteRemote() string {
+    if config.SiteRemote != "" {
+        return config.SiteRemote
+    }
+
+    return "origin"
+}
+
+// remoteFor is the name of the remote to use in a repo: siteRemote for the site repo, and for a
+// module repo --remote, then the config file's remote for the module, then origin
+func remoteFor(dir string) string {
+    if sameDir(dir, siteRepoOpt) {
+        return siteRemote()
+    }
+
+    if remoteOpt != "" {
+        return remoteOpt
+    }
+
+    if name := moduleRemoteConfig(filepath.Base(dir)).Name; name != "" {
+        return name
+    }
+
+    return "origin"
+}
+
+// remoteURL is the URL of the repo's remote
+func remoteURL(dir string) (string, error) {
+    out, err := gitTry(gitc{"remote", "get-url", remoteFor(dir)}, dir)
+    if err != nil {
+        return "", &pushError{"There is no remote named " + remoteFor(dir) + " in " + dir + " (check --remote or the config file's remotes)."}
+    }
+
+    return strings.TrimSpace(string(out)), nil
+}
+
+// checkRemoteURL makes sure the module's remote is the one its tags belong on, when the config file
+// says what its URL has to look like
+func checkRemoteURL(module string) error {
+    pattern := moduleRemoteConfig(module).URL
+    if pattern == "" {
+        return nil
+    }
+
+    url, err := remoteURL(cwd)
+    if err != nil {
+        return err
+    }
+
+    if matched, _ := regexp.MatchString(pattern, url); !matched {
+        return &pushError{remoteFor(cwd) + " is " + url + ", which doesn't match " + pattern + " from the config file"}
+    }
+
+    return nil
+}
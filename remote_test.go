@@ -0,0 +1,40 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestSiteRemoteFromTheConfig(t *testing.T) {
+    f := newFixture(t)
+
+    // the site's canonical remote is upstream; origin is a fork that must be left alone
+    f.write(f.config, `{"siteRemote": "upstream"}`+"\n")
+    f.git(f.site, "remote", "rename", "origin", "upstream")
+    f.git(f.dir, "init", "-q", "--bare", "-b", "main", "fork.git")
+    f.git(f.site, "remote", "add", "origin", "../fork.git")
+    f.siteRemote = "upstream"
+
+    f.mustRun()
+
+    if pin := f.pinned(); pin != "v1.2.4" {
+        t.Errorf("makefile on upstream pins %s, want v1.2.4", pin)
+    }
+
+    if heads := f.git(f.site, "ls-remote", "--heads", "origin"); strings.TrimSpace(heads) != "" {
+        t.Errorf("the release pushed to the site's origin:\n%s", heads)
+    }
+}
+
+func TestModuleRemotesLeaveTheSiteAlone(t *testing.T) {
+    f := newFixture(t)
+
+    // an entry for a module named like the site repo's directory is not the site's remote
+    f.write(f.config, `{"remotes": {"site": {"name": "upstream"}}}`+"\n")
+
+    f.mustRun()
+
+    if pin := f.pinned(); pin != "v1.2.4" {
+        t.Errorf("makefile on origin pins %s, want v1.2.4", pin)
+    }
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+// rollback undoes the last push recorded in the state file: the makefile commit is reverted (and the
+// revert pushed) first so the site never pins a tag that no longer exists, then the tag is deleted
+func rollback(args []string) error {
+    last, err := loadState()
+    if err != nil {
+        return err
+    }
+
+    if last.RolledBack {
+        return &pushError{"The last push (" + last.Module + " " + last.Tag + ") has already been rolled back."}
+    }
+
+    // a pull request opened with --via-pr counts as pushed once it has been merged
+    openPR := false
+
+    if last.PR != "" && !last.SitePushed {
+        if err = updateRepo(last.SiteRepo, last.SiteBranch); err != nil {
+            return err
+        }
+
+        _, err := gitTry(gitc{"merge-base", "--is-ancestor", last.CommitSHA, siteRemote() + "/" + last.SiteBranch}, last.SiteRepo)
+        last.SitePushed, openPR = err == nil, err != nil
+    }
+
+    if !last.TagPushed && !last.SitePushed {
+        return &pushError{"The last push (" + last.Module + " " + last.Tag + ") did not change anything that needs rolling back."}
+    }
+
+    fmt.Printf("Last push: %s %s on %s\n\n", last.Module, last.Tag, displayTime(last.Time))
+    if last.SitePushed {
+        fmt.Printf("  - revert site commit %s on %s in %s and push the revert\n", last.CommitSHA[:7], last.SiteBranch, last.SiteRepo)
+    }
+    if openPR {
+        fmt.Printf("  - delete branch %s on %s in %s, declining %s\n", last.PRBranch, siteRemote(), last.SiteRepo, last.PR)
+    }
+    for _, t := range last.tagged() {
+        if t.TagPushed {
+            fmt.Printf("  - delete tag %s locally and on its remote in %s\n", t.Tag, t.ModuleDir)
+        }
+    }
+
+    if prompt("\nAre you sure you want to roll back this push? (y/n): ") != "y" {
+        fmt.Println("Aborting...")
+        return errAborted
+    }
+
+    if last.SitePushed {
+        if err = updateRepo(last.SiteRepo, last.SiteBranch); err == nil {
+            _, err = git(gitc{"checkout", last.SiteBranch}, last.SiteRepo)
+        }
+
+        if err != nil {
+            return err
+        }
+
+        if out, err := gitTry(gitc{"revert", "--no-edit", last.CommitSHA}, last.SiteRepo); err != nil {
+            gitTry(gitc{"revert", "--abort"}, last.SiteRepo)
+            return &pushError{"Could not revert site commit " + last.CommitSHA + " (has the makefile changed since?):\n" + strings.TrimSpace(string(out))}
+        }
+
+        if _, err = git(gitc{"push", siteRemote(), last.SiteBranch}, last.SiteRepo); err != nil {
+            return withCode(exitRejected, err)
+        }
+        fmt.Printf("Site repo: reverted %s and pushed %s.\n", last.CommitSHA[:7], last.SiteBranch)
+
+        last.SitePushed = false
+        last.save()
+    }
+
+    if openPR {
+        if _, err = git(gitc{"push", siteRemote(), ":refs/heads/" + last.PRBranch}, last.SiteRepo); err != nil {
+            return withCode(exitRejected, err)
+        }
+        fmt.Printf("Site repo: deleted %s (the pull request is declined with it).\n", last.PRBranch)
+
+        last.PR = ""
+        last.save()
+    }
+
+    for i, t := range last.tagged() {
+        if !t.TagPushed {
+            continue
+        }
+
+        remote := t.Remote
+        if remote == "" {
+            remote = "origin"
+        }
+
+        if _, err = git(gitc{"push", remote, ":refs/tags/" + t.Tag}, t.ModuleDir); err != nil {
+            return withCode(exitRejected, err)
+        }
+        gitTry(gitc{"tag", "-d", t.Tag}, t.ModuleDir)
+        fmt.Printf("Module repo: deleted tag %s from %s.\n", t.Tag, t.Module)
+
+        if len(last.Batch) > 0 {
+            last.Batch[i].TagPushed = false
+            last.save()
+        }
+    }
+
+    last.TagPushed = false
+
+    last.RolledBack = true
+    last.save()
+
+    fmt.Println("\nRollback completed successfully!")
+
+    return nil
+}
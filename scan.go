@@ -0,0 +1,169 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+func init() {
+    subcommands["scan"] = subcommand{"List the module repos under --workspace that have commits needing a release.", scan}
+}
+
+// unreleased is a module repo whose default branch has moved on since its latest tag
+type unreleased struct {
+    module  string
+    dir     string
+    branch  string
+    latest  string
+    commits int
+    topic   string // ticket named by the newest unreleased commit, used as the topic when pushing
+    err     error
+}
+
+// scanRepo compares the default branch on origin to the latest tag of one module repo
+func scanRepo(dir string) (u unreleased) {
+    u.dir, u.module = dir, filepath.Base(dir)
+
+    defer func() {
+        if p := recover(); p != nil {
+            u.err = &pushError{fmt.Sprint(p)}
+        }
+    }()
+
+    if out, err := gitTry(fetchRemote(dir), dir); err != nil {
+        return unreleased{module: u.module, dir: dir, err: &pushError{strings.SplitN(strings.TrimSpace(string(out)), "\n", 2)[0]}}
+    }
+
+    u.branch = detectDefaultBranch(dir)
+    head := remoteFor(dir) + "/" + u.branch
+
+    var out []byte
+
+    tag, _, err := latestTag(dir, head)
+    if err != nil || tag == "" {
+        u.latest = "(untagged)"
+        out, _ = gitTry(gitc{"rev-list", "--count", "--no-merges", head}, dir)
+    } else {
+        u.latest = tag
+        out, _ = gitTry(gitc{"rev-list", "--count", "--no-merges", u.latest + ".." + head}, dir)
+    }
+
+    u.commits, _ = strconv.Atoi(strings.TrimSpace(string(out)))
+
+    if u.commits > 0 {
+        subjects, _ := gitTry(gitc{"log", "--format=%s", head, "--not", u.latest}, dir)
+        u.topic = ticketPattern.FindString(string(subjects))
+    }
+
+    return u
+}
+
+// workspaceModules finds the module repos (git repos with a <name>.module file) directly under a directory
+func workspaceModules(workspace string) ([]string, error) {
+    var dirs []string
+
+    entries, err := ioutil.ReadDir(workspace)
+    if err != nil {
+        return nil, &pushError{"There was a problem reading the workspace @ " + workspace}
+    }
+
+    for _, entry := range entries {
+        dir := filepath.Join(workspace, entry.Name())
+
+        if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+            continue
+        }
+
+        if _, err := os.Stat(filepath.Join(dir, entry.Name()+".module")); err == nil {
+            dirs = append(dirs, dir)
+        }
+    }
+
+    return dirs, nil
+}
+
+// scan lists every module in the workspace with commits on its default branch that haven't been
+// released, and with --push offers to release each of them in turn
+func scan(args []string) error {
+    dirs, err := workspaceModules(workspaceOpt)
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Scanning %d module repo(s) in %s...\n\n", len(dirs), workspaceOpt)
+
+    results := make([]unreleased, len(dirs))
+    var wg sync.WaitGroup
+
+    for i, dir := range dirs {
+        wg.Add(1)
+        go func(i int, dir string) {
+            defer wg.Done()
+            results[i] = scanRepo(dir)
+        }(i, dir)
+    }
+
+    wg.Wait()
+
+    var pending []unreleased
+
+    for _, u := range results {
+        if u.err != nil {
+            fmt.Printf("  %-24s could not be scanned: %s\n", u.module, strings.TrimPrefix(strings.TrimSpace(u.err.Error()), "fatal: "))
+        } else if u.commits > 0 {
+            pending = append(pending, u)
+        }
+    }
+
+    if len(pending) == 0 {
+        fmt.Println("Every module is released.")
+        return nil
+    }
+
+    sort.Slice(pending, func(i, j int) bool { return pending[i].commits > pending[j].commits })
+
+    fmt.Printf("%-24s %-14s %-8s %s\n", "MODULE", "LATEST", "COMMITS", "BRANCH")
+    for _, u := range pending {
+        fmt.Printf("%-24s %-14s %-8d %s\n", u.module, u.latest, u.commits, u.branch)
+    }
+
+    if !scanPushOpt {
+        return nil
+    }
+
+    for _, u := range pending {
+        if prompt(fmt.Sprintf("\nRelease %s (%d unreleased commit(s))? (y/n): ", u.module, u.commits)) != "y" {
+            continue
+        }
+
+        // each release is an ordinary push of its own, so it gets its own checks, prompts and summary
+        topic := u.topic
+        if topic == "" {
+            topic = u.branch
+        }
+
+        push := exec.Command(os.Args[0], "--module", u.dir, "--topic", topic, "--bump", bumpOpt,
+            "--config", configOpt, "--site-repo", siteRepoOpt, "--site-makefile", siteMakeOpt)
+        if profileOpt != "" {
+            push.Args = append(push.Args, "--profile", profileOpt)
+        }
+        if verboseOpt || traceOpt {
+            push.Args = append(push.Args, "--verbose="+strconv.FormatBool(verboseOpt), "--trace="+strconv.FormatBool(traceOpt))
+        }
+        push.Stdin, push.Stdout, push.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+        if err := push.Run(); err != nil {
+            slog.Warn(fmt.Sprintf("the release of %s did not complete (%s)", u.module, err))
+        }
+    }
+
+    return nil
+}
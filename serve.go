@@ -0,0 +1,540 @@
+package main
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+func init() {
+    subcommands["serve"] = subcommand{"Listen for Bitbucket pull request merged webhooks and release the merged module (allowlisted repos only, queued for approval unless the config says otherwise).", serve}
+}
+
+// serveConfig configures ncaapushit serve
+type serveConfig struct {
+    Repos  []string   `json:"repos"`  // PROJECT/repo of every module repo released on merge
+    Mode   string     `json:"mode"`   // release (right away), queue (wait for approval, the default) or dry-run
+    Secret string     `json:"secret"` // the webhook's secret, checked against its X-Hub-Signature header (required)
+    Token  string     `json:"token"`  // bearer token required by the queue endpoints (required in queue mode, unless auth is oidc)
+    Auth   authConfig `json:"auth"`   // how callers of the queue endpoints are identified, the token when empty
+}
+
+// these options are the server's own (or set per release), the rest are passed on to each release
+var serveOnly = map[string]bool{"module": true, "manifest": true, "workspace": true, "listen": true, "topic": true, "yes": true, "summary-out": true, "push": true, "limit": true, "json": true, "approver-fd": true}
+
+// queuedRelease is a merged pull request the server has been told about
+type queuedRelease struct {
+    ID       int       `json:"id"`
+    Repo     string    `json:"repo"` // PROJECT/repo
+    Topic    string    `json:"topic"`
+    PR       string    `json:"pullRequest"`
+    Received time.Time `json:"received"`
+    Status   string    `json:"status"` // pending (approval), queued, running, released, aborted, failed, rejected or dry-run
+    Version  string    `json:"version,omitempty"`
+    By       string    `json:"by,omitempty"` // who approved or rejected it
+    Output   string    `json:"output,omitempty"` // the end of the release's output
+    dir      string
+}
+
+// releaseQueue holds every merge seen since the server started. Releases run one at a time.
+type releaseQueue struct {
+    sync.Mutex
+    items []*queuedRelease
+    run   chan *queuedRelease
+    auth  authProvider
+}
+
+// bitbucketMerge is the part of a Bitbucket Server pr:merged webhook the server needs
+type bitbucketMerge struct {
+    PullRequest struct {
+        ID      int `json:"id"`
+        FromRef struct {
+            DisplayID string `json:"displayId"`
+        } `json:"fromRef"`
+        ToRef struct {
+            DisplayID  string `json:"displayId"`
+            Repository struct {
+                Slug    string `json:"slug"`
+                Project struct {
+                    Key string `json:"key"`
+                } `json:"project"`
+            } `json:"repository"`
+        } `json:"toRef"`
+    } `json:"pullRequest"`
+}
+
+// serveLog prints what the server did, with the time
+func serveLog(format string, args ...interface{}) {
+    fmt.Printf("%s  %s\n", displayTime(time.Now()), fmt.Sprintf(format, args...))
+}
+
+// allowed says whether merges to the repo (PROJECT/repo) are released
+func allowed(repo string) bool {
+    for _, r := range config.Serve.Repos {
+        if strings.EqualFold(r, repo) {
+            return true
+        }
+    }
+
+    return false
+}
+
+// validSignature checks the body was signed with the webhook secret. Without one nothing is valid.
+func validSignature(r *http.Request, body []byte) bool {
+    if config.Serve.Secret == "" {
+        return false
+    }
+
+    mac := hmac.New(sha256.New, []byte(config.Serve.Secret))
+    mac.Write(body)
+
+    return hmac.Equal([]byte(r.Header.Get("X-Hub-Signature")), []byte("sha256="+hex.EncodeToString(mac.Sum(nil))))
+}
+
+// identify works out who made a queue request, answering it as unauthorized when that can't be done
+func (q *releaseQueue) identify(w http.ResponseWriter, r *http.Request) (string, bool) {
+    identity, err := q.auth.Identify(r)
+    if err != nil {
+        http.Error(w, "unauthorized: "+strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: "), http.StatusUnauthorized)
+        return "", false
+    }
+
+    return identity, true
+}
+
+// webhook takes a pull request merged event and queues, releases or just logs the release it calls for
+func (q *releaseQueue) webhook(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "POST only", http.StatusMethodNotAllowed)
+        return
+    }
+
+    body, err := ioutil.ReadAll(r.Body)
+    if err != nil || !validSignature(r, body) {
+        http.Error(w, "bad signature", http.StatusUnauthorized)
+        return
+    }
+
+    if event := r.Header.Get("X-Event-Key"); event != "pr:merged" {
+        fmt.Fprintf(w, "ignored: %s\n", event)
+        return
+    }
+
+    var merge bitbucketMerge
+    if err = json.Unmarshal(body, &merge); err != nil {
+        http.Error(w, "could not read the event: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    pr := merge.PullRequest
+    repo := pr.ToRef.Repository.Project.Key + "/" + pr.ToRef.Repository.Slug
+    dir := filepath.Join(workspaceOpt, pr.ToRef.Repository.Slug)
+
+    if !allowed(repo) {
+        serveLog("%s #%d merged: not in the allowlist, ignored", repo, pr.ID)
+        fmt.Fprintf(w, "ignored: %s is not in the allowlist\n", repo)
+        return
+    }
+
+    if _, err = os.Stat(dir); err != nil {
+        serveLog("%s #%d merged: there is no checkout of it @ %s", repo, pr.ID, dir)
+        http.Error(w, "no checkout of "+repo+" in the workspace", http.StatusUnprocessableEntity)
+        return
+    }
+
+    branch := branchOpt
+    if branch == "" {
+        branch = detectDefaultBranch(dir)
+    }
+
+    if pr.ToRef.DisplayID != branch {
+        serveLog("%s #%d merged into %s, not %s: ignored", repo, pr.ID, pr.ToRef.DisplayID, branch)
+        fmt.Fprintf(w, "ignored: merged into %s, not %s\n", pr.ToRef.DisplayID, branch)
+        return
+    }
+
+    item := &queuedRelease{Repo: repo, Topic: pr.FromRef.DisplayID, PR: strconv.Itoa(pr.ID), Received: time.Now(), dir: dir}
+
+    switch config.Serve.Mode {
+    case "dry-run":
+        item.Status = "dry-run"
+    case "release":
+        item.Status = "queued"
+    default:
+        item.Status = "pending"
+    }
+
+    // the answer is encoded now: once queued, the worker changes the item
+    q.Lock()
+    item.ID = len(q.items) + 1
+    q.items = append(q.items, item)
+    answer, _ := json.Marshal(item)
+    q.Unlock()
+
+    serveLog("#%d %s: %s merged (pull request #%s), %s", item.ID, repo, item.Topic, item.PR, item.Status)
+
+    if item.Status == "queued" && !q.enqueue(item) {
+        q.Lock()
+        item.Status, item.Output = "failed", "not released: the release queue was full"
+        q.Unlock()
+
+        serveLog("#%d %s: failed, the release queue is full", item.ID, repo)
+        http.Error(w, "the release queue is full; release "+repo+" by hand", http.StatusServiceUnavailable)
+        return
+    }
+
+    w.WriteHeader(http.StatusAccepted)
+    w.Write(append(answer, '\n'))
+}
+
+// list answers GET /queue with every release seen, and POST /queue/<id>/approve (or reject) by
+// queueing (or dropping) a release waiting for approval
+func (q *releaseQueue) list(w http.ResponseWriter, r *http.Request) {
+    identity, ok := q.identify(w, r)
+    if !ok {
+        return
+    }
+
+    if r.Method == http.MethodGet && strings.Trim(r.URL.Path, "/") == "queue" {
+        q.Lock()
+        defer q.Unlock()
+
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(q.items)
+        return
+    }
+
+    parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+    if r.Method != http.MethodPost || len(parts) != 3 || (parts[2] != "approve" && parts[2] != "reject") {
+        http.Error(w, "use GET /queue, or POST /queue/<id>/approve or /queue/<id>/reject", http.StatusNotFound)
+        return
+    }
+
+    if !allowedToDecide(identity) {
+        serveLog("%s may not %s releases", identity, parts[2])
+        http.Error(w, identity+" may not "+parts[2]+" releases", http.StatusForbidden)
+        return
+    }
+
+    id, _ := strconv.Atoi(parts[1])
+
+    q.Lock()
+    if id < 1 || id > len(q.items) || q.items[id-1].Status != "pending" {
+        q.Unlock()
+        http.Error(w, "there is no release #"+parts[1]+" waiting for approval", http.StatusNotFound)
+        return
+    }
+
+    item := q.items[id-1]
+    item.By = identity
+    if parts[2] == "approve" {
+        item.Status = "queued"
+    } else {
+        item.Status = "rejected"
+    }
+    status := item.Status
+    answer, _ := json.Marshal(item)
+    q.Unlock()
+
+    if status == "queued" && !q.enqueue(item) {
+        // it waits for approval again, once the queue has room
+        q.Lock()
+        item.Status, item.By = "pending", ""
+        q.Unlock()
+
+        serveLog("#%d %s: approved by %s, but the release queue is full", item.ID, item.Repo, identity)
+        http.Error(w, "the release queue is full; try again later", http.StatusServiceUnavailable)
+        return
+    }
+
+    serveLog("#%d %s: %s by %s", item.ID, item.Repo, status, identity)
+
+    w.Write(append(answer, '\n'))
+}
+
+// enqueue hands a release to the worker without waiting, saying whether the queue had room for it
+func (q *releaseQueue) enqueue(item *queuedRelease) bool {
+    select {
+    case q.run <- item:
+        return true
+    default:
+        return false
+    }
+}
+
+// releasedBefore says whether the module in dir has a version tag on its remote's branch. The server
+// doesn't make a module's first release: --yes would answer the question asking whether to.
+func releasedBefore(dir, branch string) (bool, error) {
+    module, remote := filepath.Base(dir), remoteFor(dir)
+    scheme := pushit.TagScheme{Prefix: versionPrefix(module), Namespace: tagNamespace}
+
+    if out, err := gitTry(gitc{"fetch", "--tags", remote}, dir); err != nil {
+        return false, &pushError{"Could not fetch from " + remote + ":\n" + strings.TrimSpace(string(out))}
+    }
+
+    out, err := gitTry(gitc{"tag", "--list", scheme.Pattern(), "--merged", remote + "/" + branch}, dir)
+    if err != nil {
+        return false, &pushError{"Could not list the tags on " + remote + "/" + branch + ":\n" + strings.TrimSpace(string(out))}
+    }
+
+    // tags that aren't versions are left for the release to report
+    tag, _, malformed := scheme.Latest(strings.Fields(string(out)), func(s string) (version, error) { return parseModuleVersion(module, s) })
+
+    return tag != "" || len(malformed) > 0, nil
+}
+
+// releaseArgs are the options each release is run with: the module and topic, --yes, and every
+// other option the server itself was started with. A first release never gets this far (see
+// releasedBefore), so --yes can't answer whether to make one.
+func releaseArgs(item *queuedRelease, summaryFile string) []string {
+    args := []string{"--module", item.dir, "--topic", item.Topic, "--yes", "--summary-out", summaryFile}
+
+    flag.Visit(func(f *flag.Flag) {
+        if !serveOnly[f.Name] {
+            args = append(args, "--"+f.Name+"="+f.Value.String())
+        }
+    })
+
+    return args
+}
+
+// release runs the release of a merged module as a separate run of this utility, so each one starts
+// from a clean slate, and records how it went
+func (q *releaseQueue) release(item *queuedRelease) {
+    q.Lock()
+    item.Status = "running"
+    q.Unlock()
+
+    serveLog("#%d %s: releasing %s", item.ID, item.Repo, item.Topic)
+
+    self, err := os.Executable()
+    if err != nil {
+        self = os.Args[0]
+    }
+
+    summaryFile, err := ioutil.TempFile("", "ncaapushit-serve")
+    if err != nil {
+        q.Lock()
+        item.Status, item.Output = "failed", err.Error()
+        q.Unlock()
+        serveLog("#%d %s: failed: %s", item.ID, item.Repo, err)
+        return
+    }
+    summaryFile.Close()
+    defer os.Remove(summaryFile.Name())
+
+    // the release runs from the default branch, which the merge has just moved
+    branch := branchOpt
+    if branch == "" {
+        branch = detectDefaultBranch(item.dir)
+    }
+    gitTry(gitc{"checkout", branch}, item.dir)
+
+    released, err := releasedBefore(item.dir, branch)
+    if err != nil || !released {
+        q.Lock()
+        defer q.Unlock()
+
+        if err != nil {
+            item.Status, item.Output = "failed", strings.TrimSpace(err.Error())
+            serveLog("#%d %s: failed: %s", item.ID, item.Repo, strings.TrimPrefix(item.Output, "fatal: "))
+            return
+        }
+
+        item.Status, item.Output = "aborted", "not released: "+item.Repo+" has no version tags yet, so this would be its first release; make it by hand (ncaapushit add)"
+        serveLog("#%d %s: aborted, its first release needs making by hand", item.ID, item.Repo)
+        return
+    }
+
+    var output bytes.Buffer
+
+    command := exec.Command(self, releaseArgs(item, summaryFile.Name())...)
+    command.Dir = item.dir
+
+    // the history and notifications name whoever approved it, when the provider knows who they are
+    if config.Serve.Auth.Provider == "oidc" && item.By != "" {
+        approval, err := approvalPipe(item.By)
+        if err != nil {
+            q.Lock()
+            item.Status, item.Output = "failed", err.Error()
+            q.Unlock()
+            serveLog("#%d %s: failed: %s", item.ID, item.Repo, err)
+            return
+        }
+        defer approval.Close()
+
+        command.ExtraFiles = []*os.File{approval}
+        command.Args = append(command.Args, "--approver-fd=3")
+    }
+    command.Stdout, command.Stderr = &output, &output
+
+    runErr := command.Run()
+
+    var result runSummary
+    if contents, err := ioutil.ReadFile(summaryFile.Name()); err == nil {
+        json.Unmarshal(contents, &result)
+    }
+
+    q.Lock()
+    defer q.Unlock()
+
+    item.Output = output.String()
+    if len(item.Output) > 4000 {
+        item.Output = "..." + item.Output[len(item.Output)-4000:]
+    }
+
+    switch {
+    case runErr == nil:
+        item.Status, item.Version = "released", result.NewVersion
+        serveLog("#%d %s: released %s", item.ID, item.Repo, tagName(result.NewVersion))
+    case result.ExitCode == exitAborted:
+        item.Status = "aborted"
+        serveLog("#%d %s: aborted, it needs releasing by hand (eg. a high impact release)", item.ID, item.Repo)
+    default:
+        item.Status = "failed"
+        serveLog("#%d %s: failed:%s", item.ID, item.Repo, strings.TrimPrefix(strings.TrimSpace(result.Error), "fatal:"))
+    }
+}
+
+// approvalPipe returns a pipe to read who approved a release from. The release is handed it as its
+// descriptor 3 (--approver-fd), a channel only the server gives out.
+func approvalPipe(by string) (*os.File, error) {
+    approval, send, err := os.Pipe()
+    if err != nil {
+        return nil, err
+    }
+    defer send.Close()
+
+    if _, err = send.WriteString(by); err != nil {
+        approval.Close()
+        return nil, err
+    }
+
+    return approval, nil
+}
+
+// nextDaily returns when something done every day at a time of day (eg. 09:00, in the configured
+// zone) is next due
+func nextDaily(at string, now time.Time) (time.Time, error) {
+    clock, err := time.Parse("15:04", at)
+    if err != nil {
+        return time.Time{}, &pushError{"'" + at + "' is not a time of day like 09:00"}
+    }
+
+    local := now.In(displayZone())
+    next := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, local.Location())
+
+    if !next.After(now) {
+        next = next.AddDate(0, 0, 1)
+    }
+
+    return next, nil
+}
+
+// scheduleDaily runs a job every day at the time while the server runs (never when it is empty)
+func scheduleDaily(at, job string, run func() error) error {
+    if at == "" {
+        return nil
+    }
+
+    if _, err := nextDaily(at, time.Now()); err != nil {
+        return withCode(exitOptions, &pushError{"The " + job + " time in the config file is wrong: " + strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ")})
+    }
+
+    go func() {
+        for {
+            next, _ := nextDaily(at, time.Now())
+            time.Sleep(time.Until(next))
+
+            if err := run(); err != nil {
+                serveLog("%s failed: %s", job, strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: "))
+                continue
+            }
+
+            serveLog("%s done", job)
+        }
+    }()
+
+    return nil
+}
+
+// serve listens for Bitbucket webhooks and releases the modules whose pull requests were merged
+func serve(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit serve [options]"})
+    }
+
+    switch config.Serve.Mode {
+    case "", "release", "queue", "dry-run":
+    default:
+        return withCode(exitOptions, &pushError{"Unknown serve mode '" + config.Serve.Mode + "' in the config file. Modes: release, queue, dry-run"})
+    }
+
+    if len(config.Serve.Repos) == 0 {
+        return withCode(exitOptions, &pushError{"No repos are allowed to be released; list them (eg. \"NCAA/ncaa_scores\") under serve.repos in the config file."})
+    }
+
+    // ** nothing is left open: unsigned webhooks and an unguarded approval queue are refused up front
+    if config.Serve.Secret == "" {
+        return withCode(exitOptions, &pushError{"The server needs the webhook's secret to check where merges come from; set serve.secret in the config file (and in the Bitbucket webhook)."})
+    }
+
+    approval := config.Serve.Mode == "" || config.Serve.Mode == "queue"
+    if approval && config.Serve.Auth.Provider != "oidc" && config.Serve.Token == "" {
+        return withCode(exitOptions, &pushError{"Releases wait for approval, so the queue must be guarded; set serve.token (or an oidc provider under serve.auth) in the config file."})
+    }
+
+    if err := scheduleDaily(config.Digest.At, "release digest", sendDigest); err != nil {
+        return err
+    }
+
+    if err := scheduleDaily(config.Maintenance.At, "repo maintenance", maintainWorkspace); err != nil {
+        return err
+    }
+
+    auth, err := newAuthProvider()
+    if err != nil {
+        return withCode(exitOptions, err)
+    }
+
+    q := &releaseQueue{run: make(chan *queuedRelease, 100), auth: auth}
+
+    go func() {
+        for item := range q.run {
+            q.release(item)
+        }
+    }()
+
+    mux := http.NewServeMux()
+    mux.HandleFunc("/webhook", q.webhook)
+    mux.HandleFunc("/queue", q.list)
+    mux.HandleFunc("/queue/", q.list)
+
+    mode := config.Serve.Mode
+    if mode == "" {
+        mode = "queue"
+    }
+
+    serveLog("listening on %s for merges to %s (%s)", listenOpt, strings.Join(config.Serve.Repos, ", "), mode)
+
+    if err := http.ListenAndServe(listenOpt, mux); err != nil {
+        return &pushError{"Could not listen on " + listenOpt + ": " + err.Error()}
+    }
+
+    return nil
+}
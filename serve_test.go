@@ -0,0 +1,215 @@
+package main
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "os"
+    "os/exec"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// syncBuffer collects a child's output while it runs
+type syncBuffer struct {
+    sync.Mutex
+    buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+    b.Lock()
+    defer b.Unlock()
+    return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+    b.Lock()
+    defer b.Unlock()
+    return b.buf.String()
+}
+
+// serve starts ncaapushit serve on a port of its own, with the fixture's directory as its workspace and
+// the config given, and returns its URL. The server is stopped when the test ends.
+func (f *fixture) serve(config string) string {
+    f.t.Helper()
+
+    f.write(f.config, config)
+
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        f.t.Fatal(err)
+    }
+    addr := listener.Addr().String()
+    listener.Close()
+
+    self, err := os.Executable()
+    if err != nil {
+        f.t.Fatal(err)
+    }
+
+    var output syncBuffer
+
+    server := exec.Command(self, "serve", "--config", f.config, "--site-repo", f.site, "--site-makefile", "barcelona.make", "--workspace", f.dir, "--listen", addr)
+    server.Dir, server.Env = f.module, append(f.env(), "NCAA_PUSHIT_TEST_CLI=1")
+    server.Stdout, server.Stderr = &output, &output
+
+    if err := server.Start(); err != nil {
+        f.t.Fatal(err)
+    }
+
+    f.t.Cleanup(func() {
+        server.Process.Kill()
+        server.Wait()
+
+        if f.t.Failed() {
+            f.t.Logf("the server's output:\n%s", output.String())
+        }
+    })
+
+    for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(50 * time.Millisecond) {
+        if conn, err := net.Dial("tcp", addr); err == nil {
+            conn.Close()
+            return "http://" + addr
+        }
+    }
+
+    f.t.Fatalf("the server didn't start listening on %s:\n%s", addr, output.String())
+    return ""
+}
+
+// mergeEvent is the pr:merged webhook Bitbucket sends when the topic branch is merged into main
+func mergeEvent(topic string) []byte {
+    return []byte(fmt.Sprintf(`{"pullRequest": {"id": 7, "fromRef": {"displayId": %q}, "toRef": {"displayId": "main", "repository": {"slug": "ncaa_scores", "project": {"key": "NCAA"}}}}}`, topic))
+}
+
+// signature signs a webhook's body the way Bitbucket does with the secret
+func signature(secret string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write(body)
+
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// request makes a request of the server and returns the status and body of its answer
+func (f *fixture) request(method, url string, body []byte, header map[string]string) (int, string) {
+    f.t.Helper()
+
+    r, err := http.NewRequest(method, url, bytes.NewReader(body))
+    if err != nil {
+        f.t.Fatal(err)
+    }
+    for name, value := range header {
+        r.Header.Set(name, value)
+    }
+
+    resp, err := http.DefaultClient.Do(r)
+    if err != nil {
+        f.t.Fatal(err)
+    }
+    defer resp.Body.Close()
+
+    answer, _ := ioutil.ReadAll(resp.Body)
+    return resp.StatusCode, string(answer)
+}
+
+// settled waits for the server to be done with release #id, and returns it
+func (f *fixture) settled(url, token string, id int) queuedRelease {
+    f.t.Helper()
+
+    for start := time.Now(); time.Since(start) < 60*time.Second; time.Sleep(100 * time.Millisecond) {
+        code, body := f.request(http.MethodGet, url+"/queue", nil, map[string]string{"Authorization": "Bearer " + token})
+        if code != http.StatusOK {
+            f.t.Fatalf("GET /queue = %d: %s", code, body)
+        }
+
+        var items []queuedRelease
+        if err := json.Unmarshal([]byte(body), &items); err != nil {
+            f.t.Fatal(err)
+        }
+
+        if id <= len(items) {
+            switch item := items[id-1]; item.Status {
+            case "pending", "queued", "running":
+            default:
+                return item
+            }
+        }
+    }
+
+    f.t.Fatalf("release #%d didn't finish", id)
+    return queuedRelease{}
+}
+
+const testServeConfig = `{"serve": {"repos": ["NCAA/ncaa_scores"], "mode": %q, "secret": "s3cret", "token": "queue-token"}}`
+
+func TestWebhookSignature(t *testing.T) {
+    f := newFixture(t)
+    url := f.serve(fmt.Sprintf(testServeConfig, "dry-run"))
+
+    body := mergeEvent("NCAA-1")
+
+    for name, sig := range map[string]string{"unsigned": "", "signed with another secret": signature("guess", body), "signed for another body": signature("s3cret", mergeEvent("NCAA-2"))} {
+        if code, answer := f.request(http.MethodPost, url+"/webhook", body, map[string]string{"X-Event-Key": "pr:merged", "X-Hub-Signature": sig}); code != http.StatusUnauthorized {
+            t.Errorf("%s webhook = %d (%s), want it refused", name, code, strings.TrimSpace(answer))
+        }
+    }
+
+    code, answer := f.request(http.MethodPost, url+"/webhook", body, map[string]string{"X-Event-Key": "pr:merged", "X-Hub-Signature": signature("s3cret", body)})
+    if code != http.StatusAccepted || !strings.Contains(answer, `"status":"dry-run"`) {
+        t.Fatalf("signed webhook = %d (%s), want it accepted", code, strings.TrimSpace(answer))
+    }
+
+    // only the signed one was seen
+    if item := f.settled(url, "queue-token", 1); item.Topic != "NCAA-1" || item.ID != 1 {
+        t.Errorf("the queue holds %+v", item)
+    }
+}
+
+func TestServeReleasesAMerge(t *testing.T) {
+    f := newFixture(t)
+    url := f.serve(fmt.Sprintf(testServeConfig, "release"))
+
+    body := mergeEvent("NCAA-1")
+    if code, answer := f.request(http.MethodPost, url+"/webhook", body, map[string]string{"X-Event-Key": "pr:merged", "X-Hub-Signature": signature("s3cret", body)}); code != http.StatusAccepted {
+        t.Fatalf("webhook = %d: %s", code, answer)
+    }
+
+    if item := f.settled(url, "queue-token", 1); item.Status != "released" || item.Version != "1.2.4" {
+        t.Fatalf("release #1 = %s %s:\n%s", item.Status, item.Version, item.Output)
+    }
+
+    if pinned := f.pinned(); pinned != "v1.2.4" {
+        t.Errorf("the makefile pins %s, want v1.2.4", pinned)
+    }
+}
+
+func TestServeLeavesTheFirstReleaseToSomeone(t *testing.T) {
+    f := newFixture(t)
+
+    // the module has never been released
+    f.git(f.module, "push", "-q", "origin", ":refs/tags/v1.2.3")
+    f.git(f.module, "tag", "-d", "v1.2.3")
+
+    url := f.serve(fmt.Sprintf(testServeConfig, "release"))
+
+    body := mergeEvent("NCAA-1")
+    if code, answer := f.request(http.MethodPost, url+"/webhook", body, map[string]string{"X-Event-Key": "pr:merged", "X-Hub-Signature": signature("s3cret", body)}); code != http.StatusAccepted {
+        t.Fatalf("webhook = %d: %s", code, answer)
+    }
+
+    if item := f.settled(url, "queue-token", 1); item.Status != "aborted" || !strings.Contains(item.Output, "first release") {
+        t.Fatalf("release #1 = %s, want it aborted as a first release:\n%s", item.Status, item.Output)
+    }
+
+    if tags := f.remoteTags(); len(tags) != 0 {
+        t.Errorf("the server tagged %v", tags)
+    }
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+// siteUpdater records module versions in the site repo, in the file the site pins them in. The core
+// of a release (versions, tags, commits) is the same for every site; only this step differs.
+type siteUpdater interface {
+    // pins lists every module the file pins and its version, in order (a module pinned twice is listed twice)
+    pins(file string) ([]pinRequest, error)
+    // update returns the lines of the file with the module moved from latest to newVersion, or added
+    // when latest is "" (its first release)
+    update(file, module, newVersion, latest string) ([]string, error)
+    // commitFiles runs once the file has been written and returns the other files to commit with it
+    commitFiles(modules ...string) (gitc, error)
+}
+
+var siteUpdaters = map[string]siteUpdater{
+    "drush":      drushUpdater{},
+    "composer":   composerUpdater{},
+    "manifest":   manifestUpdater{},
+    "json":       jsonUpdater{},
+    "kubernetes": kubernetesUpdater{},
+}
+
+// siteUpdaterFor picks how versions are recorded in a site file: --site-updater (or the profile's
+// siteUpdater), or else from the file: composer.json, another .json version map, a drush makefile,
+// Kubernetes manifests and Helm values or charts (apiVersion:, image: or a values*.yaml or Chart.yaml),
+// or a YAML manifest when a .yml file has none of those
+func siteUpdaterFor(file string) siteUpdater {
+    if updater, ok := siteUpdaters[siteUpdaterOpt]; ok {
+        return updater
+    }
+
+    switch {
+    case isComposerFile(file):
+        return composerUpdater{}
+    case filepath.Ext(file) == ".json":
+        return jsonUpdater{}
+    case pushit.IsYAMLMakefile(file) && !strings.Contains(filepath.Base(file), ".make."):
+        path := file
+        if !filepath.IsAbs(path) {
+            path = filepath.Join(siteRepoOpt, file)
+        }
+
+        base := filepath.Base(file)
+        if base == "Chart.yaml" || strings.HasPrefix(base, "values") {
+            return kubernetesUpdater{}
+        }
+
+        lines, _ := readLines(path)
+        for _, e := range pushit.ParseYAMLLines(path, lines) {
+            switch e.Keys[0] {
+            case "projects":
+                return drushUpdater{}
+            case "apiVersion", "image":
+                return kubernetesUpdater{}
+            }
+        }
+
+        if len(lines) > 0 {
+            return manifestUpdater{}
+        }
+    }
+
+    return drushUpdater{}
+}
+
+// drushUpdater pins modules in a drush makefile (projects[module][download][tag]), INI or YAML
+type drushUpdater struct{}
+
+func (drushUpdater) pins(file string) ([]pinRequest, error) {
+    entries, err := pushit.ParseMakefile(file, siteRepoOpt)
+    if err != nil {
+        return nil, withCode(exitMakefile, &pushError{"Could not read makefile @ '" + file + "': " + err.Error()})
+    }
+
+    var pins []pinRequest
+
+    for _, e := range entries {
+        if e.IsPin() {
+            pins = append(pins, pinRequest{module: e.Keys[1], version: strings.TrimPrefix(e.Value, versionPrefix(e.Keys[1]))})
+        }
+    }
+
+    return pins, nil
+}
+
+func (drushUpdater) update(makefile, module, newVersion, latest string) ([]string, error) {
+    // a first release adds the module's entry
+    if latest == "" {
+        return firstMakefileEntry(makefile, module, newVersion)
+    }
+
+    outFile, err := pushit.UpdatePin(makefile, siteRepoOpt, module, tagName(latest), tagName(newVersion))
+
+    var problem *pushit.PinError
+    if err != nil && !errors.As(err, &problem) {
+        return nil, withCode(exitMakefile, &pushError{"Could not read makefile @ '" + makefile + "': " + err.Error()})
+    }
+
+    switch {
+    case problem == nil:
+        return outFile, nil
+    case problem.Err == pushit.ErrNotPinned:
+        return outFile, withCode(exitMakefile, &pushError{"The module '" + module + "' was not found in the makefile.\nMake sure your site repo is up-to-date before using this utility."})
+    case problem.Err == pushit.ErrPinnedTwice:
+        return outFile, withCode(exitMakefile, &pushError{"The module '" + module + "' is pinned more than once (" + strings.Join(problem.Where, ", ") + ").\nRemove all but one of its entries so it's clear which version the site builds."})
+    case problem.Err == pushit.ErrPinnedInInclude:
+        return outFile, withCode(exitMakefile, &pushError{"The module '" + module + "' is pinned in " + problem.Where[0] + ", which " + siteMakeOpt + " includes.\nPass that makefile with --site-makefile to release it."})
+    }
+
+    return outFile, withCode(exitMakefile, &pushError{"The makefile pins '" + module + "' to " + problem.Pinned + " (" + problem.Where[0] + "), not the latest tag " + tagName(latest) + ".\nMake sure your site repo is up-to-date before using this utility."})
+}
+
+func (drushUpdater) commitFiles(modules ...string) (gitc, error) {
+    return nil, nil
+}
+
+// composerUpdater pins modules as package version constraints in composer.json
+type composerUpdater struct{}
+
+func (composerUpdater) pins(file string) ([]pinRequest, error) {
+    lines, err := readLines(file)
+    if err != nil {
+        return nil, withCode(exitMakefile, &pushError{"Could not read " + file + ": " + err.Error()})
+    }
+
+    var pins []pinRequest
+
+    for _, line := range lines {
+        if match := composerRequire.FindStringSubmatch(line); match != nil {
+            if version := composerConstraint.FindStringSubmatch(match[3]); version != nil {
+                pins = append(pins, pinRequest{module: match[2], version: version[3]})
+            }
+        }
+    }
+
+    return pins, nil
+}
+
+func (composerUpdater) update(file, module, newVersion, latest string) ([]string, error) {
+    return getUpdatedComposer(file, module, newVersion, latest)
+}
+
+func (composerUpdater) commitFiles(modules ...string) (gitc, error) {
+    return updateComposerLock(modules...)
+}
+
+// versionLine is a line of a version map: the module, its version (its tag prefix optional) and where that starts
+type versionLine struct {
+    line    int
+    module  string
+    version string
+    at      int
+}
+
+// mapVersion is a version from a version map without the module's tag prefix, which maps may leave off
+func mapVersion(module, version string) string {
+    return strings.TrimPrefix(version, versionPrefix(module))
+}
+
+// updateVersionLines moves the module's version in a version map from latest to newVersion, keeping
+// the module's tag prefix if it had one. add inserts a line for a first release after the line given (-1 when there are none).
+func updateVersionLines(file string, lines []string, pins []versionLine, module, newVersion, latest string, add func(after int) []string) ([]string, error) {
+    var found []versionLine
+    last := -1
+
+    for _, p := range pins {
+        if p.module == module {
+            found = append(found, p)
+        }
+        last = p.line
+    }
+
+    switch {
+    case latest == "" && len(found) > 0:
+        return lines, withCode(exitMakefile, &pushError{fmt.Sprintf("%s has no version tags yet but is already listed in %s:%d.\nPin %s there by hand, or remove it to have it added.", module, file, found[0].line+1, tagName(newVersion))})
+    case latest == "":
+        return add(last), nil
+    case len(found) == 0:
+        return lines, withCode(exitMakefile, &pushError{"The module '" + module + "' was not found in " + siteMakeOpt + ".\nMake sure your site repo is up-to-date before using this utility."})
+    case len(found) > 1:
+        return lines, withCode(exitMakefile, &pushError{"The module '" + module + "' is listed more than once in " + siteMakeOpt + ".\nRemove all but one of its lines so it's clear which version the site builds."})
+    case mapVersion(module, found[0].version) != latest:
+        return lines, withCode(exitMakefile, &pushError{fmt.Sprintf("%s lists '%s' at %s (line %d), not the latest version %s.\nMake sure your site repo is up-to-date before using this utility.",
+            siteMakeOpt, module, found[0].version, found[0].line+1, latest)})
+    }
+
+    pin := found[0]
+    if prefix := versionPrefix(module); prefix != "" && strings.HasPrefix(pin.version, prefix) {
+        newVersion = prefix + newVersion
+    }
+
+    line := lines[pin.line]
+    lines[pin.line] = line[:pin.at] + newVersion + line[pin.at+len(pin.version):]
+
+    return lines, nil
+}
+
+// manifestUpdater pins modules in a YAML manifest mapping each module to its version (module: 1.2.3),
+// at the top level or under one key (eg. versions:)
+type manifestUpdater struct{}
+
+func (manifestUpdater) versionLines(file string) ([]string, []versionLine, error) {
+    lines, err := readLines(file)
+    if err != nil {
+        return nil, nil, withCode(exitMakefile, &pushError{"Could not read " + file + ": " + err.Error()})
+    }
+
+    var pins []versionLine
+
+    for _, e := range pushit.ParseYAMLLines(file, lines) {
+        if len(e.Keys) > 2 || e.Keys[len(e.Keys)-1] == "" {
+            continue
+        }
+
+        module := e.Keys[len(e.Keys)-1]
+        if _, err := parseModuleVersion(module, mapVersion(module, e.Value)); err == nil {
+            pins = append(pins, versionLine{e.Line, module, e.Value, e.At})
+        }
+    }
+
+    return lines, pins, nil
+}
+
+func (u manifestUpdater) pins(file string) ([]pinRequest, error) {
+    _, lines, err := u.versionLines(file)
+
+    var pins []pinRequest
+    for _, p := range lines {
+        pins = append(pins, pinRequest{module: p.module, version: mapVersion(p.module, p.version)})
+    }
+
+    return pins, err
+}
+
+func (u manifestUpdater) update(file, module, newVersion, latest string) ([]string, error) {
+    lines, pins, err := u.versionLines(file)
+    if err != nil {
+        return nil, err
+    }
+
+    return updateVersionLines(file, lines, pins, module, newVersion, latest, func(after int) []string {
+        if after == -1 {
+            return append(lines, module+": "+newVersion)
+        }
+
+        // line up with the modules already there
+        lead := lines[after][:len(lines[after])-len(strings.TrimLeft(lines[after], " "))]
+        return append(lines[:after+1], append([]string{lead + module + ": " + newVersion}, lines[after+1:]...)...)
+    })
+}
+
+func (manifestUpdater) commitFiles(modules ...string) (gitc, error) {
+    return nil, nil
+}
+
+// jsonUpdater pins modules in a JSON object mapping each module to its version ("module": "1.2.3").
+// The file is edited line by line so its formatting is kept, which expects one module per line.
+type jsonUpdater struct{}
+
+var jsonVersion = regexp.MustCompile(`^(\s*)"([^"]+)"\s*:\s*"([^"]*\d+\.\d+[^"]*)"`)
+
+func (jsonUpdater) versionLines(file string) ([]string, []versionLine, error) {
+    lines, err := readLines(file)
+    if err != nil {
+        return nil, nil, withCode(exitMakefile, &pushError{"Could not read " + file + ": " + err.Error()})
+    }
+
+    var pins []versionLine
+
+    for i, line := range lines {
+        if match := jsonVersion.FindStringSubmatchIndex(line); match != nil {
+            module, version := line[match[4]:match[5]], line[match[6]:match[7]]
+            if _, err := parseModuleVersion(module, mapVersion(module, version)); err == nil {
+                pins = append(pins, versionLine{i, module, version, match[6]})
+            }
+        }
+    }
+
+    return lines, pins, nil
+}
+
+func (u jsonUpdater) pins(file string) ([]pinRequest, error) {
+    _, lines, err := u.versionLines(file)
+
+    var pins []pinRequest
+    for _, p := range lines {
+        pins = append(pins, pinRequest{module: p.module, version: mapVersion(p.module, p.version)})
+    }
+
+    return pins, err
+}
+
+func (u jsonUpdater) update(file, module, newVersion, latest string) ([]string, error) {
+    lines, pins, err := u.versionLines(file)
+    if err != nil {
+        return nil, err
+    }
+
+    return updateVersionLines(file, lines, pins, module, newVersion, latest, func(after int) []string {
+        entry := `"` + module + `": "` + newVersion + `"`
+
+        if after == -1 {
+            // the first module goes right after the opening brace
+            for i, line := range lines {
+                if strings.HasSuffix(strings.TrimSpace(line), "{") {
+                    if i+1 < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i+1]), "}") {
+                        entry += ","
+                    }
+                    return append(lines[:i+1], append([]string{"  " + entry}, lines[i+1:]...)...)
+                }
+            }
+
+            return append(lines, "{", "  "+entry, "}")
+        }
+
+        // line up with the modules already there, keeping the commas right
+        lead := lines[after][:len(lines[after])-len(strings.TrimLeft(lines[after], " \t"))]
+        if strings.HasSuffix(strings.TrimSpace(lines[after]), ",") {
+            entry += ","
+        } else {
+            lines[after] += ","
+        }
+
+        return append(lines[:after+1], append([]string{lead + entry}, lines[after+1:]...)...)
+    })
+}
+
+func (jsonUpdater) commitFiles(modules ...string) (gitc, error) {
+    return nil, nil
+}
+
+// kubernetesUpdater pins modules as image tags or chart versions in a GitOps repo: an image reference
+// (image: registry/ncaa_scores:1.2.3) in a Kubernetes manifest or Helm values, a Helm values image
+// block (repository: registry/ncaa_scores with tag: 1.2.3 beside it), or a chart dependency in
+// Chart.yaml (- name: ncaa_scores with version: 1.2.3). The image or chart is named after the module.
+type kubernetesUpdater struct{}
+
+func (kubernetesUpdater) versionLines(file string) ([]string, []versionLine, error) {
+    lines, err := readLines(file)
+    if err != nil {
+        return nil, nil, withCode(exitMakefile, &pushError{"Could not read " + file + ": " + err.Error()})
+    }
+
+    var (
+        pins []versionLine
+        // the keys of the mapping (or list item) being read at each parent, so siblings can be paired
+        blocks = map[string]map[string]makeEntry{}
+    )
+
+    pin := func(module string, e makeEntry, version string, at int) {
+        if _, err := parseModuleVersion(module, mapVersion(module, version)); err == nil {
+            pins = append(pins, versionLine{e.Line, module, version, at})
+        }
+    }
+
+    flush := func(parent string, block map[string]makeEntry) {
+        if repository, ok := block["repository"]; ok {
+            if tag, ok := block["tag"]; ok {
+                pin(path.Base(repository.Value), tag, tag.Value, tag.At)
+                return
+            }
+        }
+
+        if strings.HasSuffix(parent, "dependencies") {
+            if name, ok := block["name"]; ok {
+                if version, ok := block["version"]; ok {
+                    pin(name.Value, version, version.Value, version.At)
+                }
+            }
+        }
+    }
+
+    for _, e := range pushit.ParseYAMLLines(file, lines) {
+        key := e.Keys[len(e.Keys)-1]
+        parent := strings.Join(e.Keys[:len(e.Keys)-1], ".")
+
+        // a list item, or a key seen again (the next document or item), starts a new block
+        _, seen := blocks[parent][key]
+        if seen || strings.HasPrefix(strings.TrimSpace(lines[e.Line]), "-") {
+            flush(parent, blocks[parent])
+            blocks[parent] = nil
+        }
+        if blocks[parent] == nil {
+            blocks[parent] = map[string]makeEntry{}
+        }
+        blocks[parent][key] = e
+
+        // image: registry/ncaa_scores:1.2.3 (but not a digest or a registry port)
+        if key == "image" && e.Value != "" && !strings.Contains(e.Value, "@") {
+            if at := strings.LastIndex(e.Value, ":"); at > strings.LastIndex(e.Value, "/") {
+                pin(path.Base(e.Value[:at]), e, e.Value[at+1:], e.At+at+1)
+            }
+        }
+    }
+
+    for parent, block := range blocks {
+        flush(parent, block)
+    }
+
+    sort.Slice(pins, func(i, j int) bool { return pins[i].line < pins[j].line })
+
+    return lines, pins, nil
+}
+
+func (u kubernetesUpdater) pins(file string) ([]pinRequest, error) {
+    _, lines, err := u.versionLines(file)
+
+    var pins []pinRequest
+    for _, p := range lines {
+        pins = append(pins, pinRequest{module: p.module, version: mapVersion(p.module, p.version)})
+    }
+
+    return pins, err
+}
+
+func (u kubernetesUpdater) update(file, module, newVersion, latest string) ([]string, error) {
+    if latest == "" {
+        return nil, withCode(exitMakefile, &pushError{module + " has no version tags yet. Add its image (or chart dependency) to " + siteMakeOpt + " by hand; only its later releases can be recorded."})
+    }
+
+    lines, pins, err := u.versionLines(file)
+    if err != nil {
+        return nil, err
+    }
+
+    return updateVersionLines(file, lines, pins, module, newVersion, latest, nil)
+}
+
+func (kubernetesUpdater) commitFiles(modules ...string) (gitc, error) {
+    return nil, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+// TestDrushUpdate pins ncaa_scores from v1.2.3 to v1.2.4 in each of testdata/makefiles, samples of the
+// makefiles the sites are built from. The result must match the sample's .golden file byte for byte,
+// or the update must fail with the error given.
+func TestDrushUpdate(t *testing.T) {
+    tests := []struct {
+        name     string
+        makefile string
+        err      string
+    }{
+        {"plain", "site.make", ""},
+        {"odd-quoting", "site.make", ""},
+        {"includes", "site.make", ""},
+        {"patches", "site.make", ""},
+        {"yaml", "site.make.yml", ""},
+        {"included-pin", "site.make", "is pinned in modules.make:2, which site.make includes"},
+        {"duplicates", "site.make", "is pinned more than once (site.make:1, site.make:4)"},
+        {"duplicate-include", "site.make", "is pinned more than once (contrib.make:1, site.make:2)"},
+        {"stale-pin", "site.make", "pins 'ncaa_scores' to v1.2.2 (site.make:1), not the latest tag v1.2.3"},
+        {"missing", "site.make", "The module 'ncaa_scores' was not found in the makefile"},
+    }
+
+    defer func(repo, makefile, dir string) { siteRepoOpt, siteMakeOpt, cwd = repo, makefile, dir }(siteRepoOpt, siteMakeOpt, cwd)
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            siteRepoOpt, _ = filepath.Abs(filepath.Join("testdata", "makefiles", tt.name))
+            siteMakeOpt, cwd = tt.makefile, filepath.Join(t.TempDir(), "ncaa_scores")
+            makefile := filepath.Join(siteRepoOpt, tt.makefile)
+
+            lines, err := drushUpdater{}.update(makefile, "ncaa_scores", "1.2.4", "1.2.3")
+
+            if tt.err != "" {
+                if err == nil || !strings.Contains(err.Error(), tt.err) {
+                    t.Fatalf("update() error = %v, want %q", err, tt.err)
+                }
+                return
+            }
+
+            if err != nil {
+                t.Fatalf("update() error = %v", err)
+            }
+
+            golden, err := ioutil.ReadFile(makefile + ".golden")
+            if err != nil {
+                t.Fatal(err)
+            }
+
+            if got := strings.Join(lines, "\n") + "\n"; got != string(golden) {
+                t.Errorf("update() =\n%s\nwant (%s.golden)\n%s", got, tt.makefile, golden)
+            }
+        })
+    }
+}
+
+// TestVersionMapKeepsThePrefix moves a module tagged with a prefix of its own in a JSON version map,
+// which may list its versions with or without the prefix
+func TestVersionMapKeepsThePrefix(t *testing.T) {
+    tests := []struct {
+        listed string
+        want   string
+    }{
+        {"release-1.2.3", "release-1.2.4"},
+        {"1.2.3", "1.2.4"},
+    }
+
+    prefix := "release-"
+    defer func(tags map[string]tagConfig) { config.Tags = tags }(config.Tags)
+    config.Tags = map[string]tagConfig{"ncaa_scores": {Prefix: &prefix}}
+
+    for _, tt := range tests {
+        file := filepath.Join(t.TempDir(), "versions.json")
+        if err := ioutil.WriteFile(file, []byte("{\n  \"ncaa_scores\": \""+tt.listed+"\"\n}\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+
+        lines, err := jsonUpdater{}.update(file, "ncaa_scores", "1.2.4", "1.2.3")
+        if err != nil {
+            t.Fatalf("update() of %s error = %v", tt.listed, err)
+        }
+
+        if got, want := lines[1], `  "ncaa_scores": "`+tt.want+`"`; got != want {
+            t.Errorf("update() of %s = %s, want %s", tt.listed, got, want)
+        }
+    }
+}
+
+// TestSiteFileReleases releases ncaa_scores against a site that pins it in something other than a drush
+// makefile, picked out by the file's name and contents, and checks what reached the site's origin
+func TestSiteFileReleases(t *testing.T) {
+    tests := []struct {
+        name   string
+        file   string
+        before string
+        after  string
+    }{
+        {
+            "composer", "composer.json",
+            "{\n    \"require\": {\n        \"drupal/core\": \"^7.98\",\n        \"ncaa/ncaa_scores\": \"^1.2.3\"\n    },\n    \"require-dev\": {\n        \"ncaa/ncaa_teams\": \"~1.2.3\"\n    }\n}\n",
+            "{\n    \"require\": {\n        \"drupal/core\": \"^7.98\",\n        \"ncaa/ncaa_scores\": \"^1.2.4\"\n    },\n    \"require-dev\": {\n        \"ncaa/ncaa_teams\": \"~1.2.3\"\n    }\n}\n",
+        },
+        {
+            "kubernetes manifest", "deploy/scores.yaml",
+            "apiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - name: scores\n          image: registry.example.com:5000/ncaa/ncaa_scores:v1.2.3\n        - name: teams\n          image: registry.example.com:5000/ncaa/ncaa_teams:v1.2.3\n",
+            "apiVersion: apps/v1\nkind: Deployment\nspec:\n  template:\n    spec:\n      containers:\n        - name: scores\n          image: registry.example.com:5000/ncaa/ncaa_scores:v1.2.4\n        - name: teams\n          image: registry.example.com:5000/ncaa/ncaa_teams:v1.2.3\n",
+        },
+        {
+            "helm values", "charts/ncaa/values.yaml",
+            "scores:\n  image:\n    repository: registry.example.com/ncaa/ncaa_scores\n    tag: \"1.2.3\"\nteams:\n  image:\n    repository: registry.example.com/ncaa/ncaa_teams\n    tag: \"1.2.3\"\n",
+            "scores:\n  image:\n    repository: registry.example.com/ncaa/ncaa_scores\n    tag: \"1.2.4\"\nteams:\n  image:\n    repository: registry.example.com/ncaa/ncaa_teams\n    tag: \"1.2.3\"\n",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := newFixture(t)
+
+            path := filepath.Join(f.site, tt.file)
+            if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+                t.Fatal(err)
+            }
+            f.write(path, tt.before)
+            f.git(f.site, "add", ".")
+            f.git(f.site, "commit", "-qm", "Pin the module in "+tt.file)
+            f.git(f.site, "push", "-q", "origin", "main")
+
+            f.mustRun("--topic", "NCAA-1", "--site-makefile", tt.file)
+
+            f.git(f.site, "fetch", "-q", "origin")
+            if got := f.git(f.site, "show", "origin/main:"+tt.file) + "\n"; got != tt.after {
+                t.Errorf("%s on origin =\n%s\nwant\n%s", tt.file, got, tt.after)
+            }
+        })
+    }
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+    "fmt"
+    "log/slog"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// slackConfig is the incoming webhook pushes are announced to
+type slackConfig struct {
+    WebhookURL string `json:"webhookUrl"`
+    Channel    string `json:"channel"`   // overrides the webhook's default channel, eg. #ncaa-releases
+    CommitURL  string `json:"commitUrl"` // site commit link format with {sha}, derived from bitbucket when empty
+    Token      string `json:"token"`     // bot token (chat:write, users:read.email scopes) to DM module owners with
+}
+
+// slackAPI is where Slack's Web API methods are called
+var slackAPI = "https://slack.com/api/"
+
+// slackDM sends a direct message to the Slack user with the email address, through the bot token
+func slackDM(email, text string) error {
+    auth := bearerAuth(config.Slack.Token)
+
+    // the Web API answers 200 with ok set to false when a call fails
+    var found struct {
+        OK    bool   `json:"ok"`
+        Error string `json:"error"`
+        User  struct {
+            ID string `json:"id"`
+        } `json:"user"`
+    }
+
+    if err := callAPI("GET", slackAPI+"users.lookupByEmail?email="+url.QueryEscape(email), auth, nil, &found); err != nil {
+        return err
+    }
+
+    if !found.OK {
+        return &pushError{"Slack could not find " + email + ": " + found.Error}
+    }
+
+    var sent struct {
+        OK    bool   `json:"ok"`
+        Error string `json:"error"`
+    }
+
+    if err := callAPI("POST", slackAPI+"chat.postMessage", auth, map[string]string{"channel": found.User.ID, "text": text}, &sent); err != nil {
+        return err
+    }
+
+    if !sent.OK {
+        return &pushError{"Slack did not deliver the message to " + email + ": " + sent.Error}
+    }
+
+    return nil
+}
+
+// siteCommitURL returns a link to a site repo commit, or "" when there is nowhere to link to
+func siteCommitURL(sha string) string {
+    format := config.Slack.CommitURL
+
+    if format == "" && config.Bitbucket.BaseURL != "" {
+        if project, repo, err := siteProjectRepo(); err == nil {
+            format = strings.TrimSuffix(config.Bitbucket.BaseURL, "/") + "/projects/" + project + "/repos/" + repo + "/commits/{sha}"
+        }
+    }
+
+    if format == "" || sha == "" {
+        return ""
+    }
+
+    return strings.Replace(format, "{sha}", sha, -1)
+}
+
+// notifySlack announces how a push went. Failures are only announced once the release was confirmed,
+// since anything earlier has not changed a remote.
+func notifySlack() {
+    cfg := config.Slack
+
+    if cfg.WebhookURL == "" || summary.Outcome == "aborted" || (summary.Outcome != "success" && state.Tag == "") {
+        return
+    }
+
+    commit := summary.CommitSHA
+    if url := siteCommitURL(summary.CommitSHA); url != "" {
+        commit = "<" + url + "|" + summary.CommitSHA + ">"
+    }
+
+    var released, topics []string
+    for _, rel := range summary.releases() {
+        released = append(released, fmt.Sprintf("*%s* %s -> %s", rel.Module, rel.OldVersion, rel.NewVersion))
+        topics = append(topics, linkTickets(rel.Topic, "slack"))
+    }
+
+    at := displayTime(time.Now())
+
+    text := fmt.Sprintf(":white_check_mark: %s pushed %s at %s", operator(), strings.Join(released, ", "), at)
+    if summary.Outcome != "success" {
+        text = fmt.Sprintf(":x: %s's push of %s failed at %s: %s", operator(), strings.Join(released, ", "), at,
+            strings.SplitN(strings.TrimPrefix(summary.Error, "fatal: "), "\n", 2)[0])
+    }
+
+    text += "\ntopic: " + strings.Join(topics, ", ")
+    if commit != "" {
+        text += "  commit: " + commit
+    }
+    if summary.PR != "" {
+        text += "  pull request: " + summary.PR
+    }
+
+    payload := map[string]string{"text": text}
+    if cfg.Channel != "" {
+        payload["channel"] = cfg.Channel
+    }
+
+    if err := callAPI("POST", cfg.WebhookURL, nil, payload, nil); err != nil {
+        slog.Warn("could not notify Slack: " + strings.TrimSpace(err.Error()))
+        return
+    }
+
+    summary.Notified = append(summary.Notified, "slack")
+}
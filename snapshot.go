@@ -0,0 +1,222 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+func init() {
+    subcommands["snapshot"] = subcommand{"Record every version the site makefile pins, in a file (.csv or .yml) or as a named snapshot on the site repo's origin.", snapshot}
+    subcommands["restore"] = subcommand{"Put the makefile pins back to a snapshot (a name or a file) in one site commit, eg. to return staging to its last known good state.", restore}
+}
+
+// snapshotRef is where a named snapshot is kept in the site repo (and on its origin)
+func snapshotRef(name string) string {
+    return "refs/snapshots/" + name
+}
+
+// isSnapshotFile says whether a snapshot argument is a file rather than a name
+func isSnapshotFile(arg string) bool {
+    switch filepath.Ext(arg) {
+    case ".csv", ".yml", ".yaml":
+        return true
+    }
+
+    return strings.ContainsRune(arg, os.PathSeparator)
+}
+
+// pinSet lists every module the site file pins, in the order they appear
+func pinSet(makefile string) ([]pinRequest, error) {
+    all, err := siteUpdaterFor(makefile).pins(makefile)
+    if err != nil {
+        return nil, err
+    }
+
+    var pins []pinRequest
+    seen := map[string]bool{}
+
+    for _, p := range all {
+        if !seen[p.module] {
+            seen[p.module] = true
+            pins = append(pins, p)
+        }
+    }
+
+    return pins, nil
+}
+
+// snapshot records the pins on the site branch as a versions file that pin and restore can read,
+// written to the file given or kept under a name (the date and time by default) on the site origin
+func snapshot(args []string) error {
+    if len(args) > 1 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit snapshot [options] [name or file]"})
+    }
+
+    name := time.Now().In(displayZone()).Format("2006-01-02-1504")
+    if len(args) == 1 {
+        name = args[0]
+    }
+
+    makefile, err := getMakefile()
+    if err != nil {
+        return err
+    }
+
+    if err = checkoutSiteBranch(); err != nil {
+        return err
+    }
+
+    pins, err := pinSet(makefile)
+    if err != nil {
+        return err
+    }
+
+    if len(pins) == 0 {
+        return withCode(exitMakefile, &pushError{"The makefile doesn't pin any modules, there is nothing to snapshot."})
+    }
+
+    head, err := git(gitCommands["head"], siteRepoOpt)
+    if err != nil {
+        return err
+    }
+
+    lines := []string{fmt.Sprintf("# %s on %s @ %s, %s", siteMakeOpt, siteBranch, strings.TrimSpace(string(head))[:7], displayTime(time.Now()))}
+
+    if pushit.IsYAMLMakefile(name) {
+        lines = append(lines, "versions:")
+        for _, p := range pins {
+            lines = append(lines, "  "+p.module+": "+p.version)
+        }
+    } else {
+        lines = append(lines, "module,version")
+        for _, p := range pins {
+            lines = append(lines, p.module+","+p.version)
+        }
+    }
+
+    contents := strings.Join(lines, "\n") + "\n"
+
+    if isSnapshotFile(name) {
+        if err = ioutil.WriteFile(name, []byte(contents), 0644); err != nil {
+            return &pushError{"Could not write the snapshot to " + name + ": " + err.Error()}
+        }
+
+        fmt.Printf("\nRecorded %d pins in %s.\n", len(pins), name)
+        return nil
+    }
+
+    // ** a named snapshot is a blob on the site repo's origin, so anyone can restore it
+    ref := snapshotRef(name)
+
+    if _, err = gitTry(gitc{"check-ref-format", ref}, siteRepoOpt); err != nil {
+        return withCode(exitOptions, &pushError{"'" + name + "' can't be used as a snapshot name; use letters, digits, dashes and dots."})
+    }
+
+    if out, _ := gitTry(gitc{"ls-remote", remoteFor(siteRepoOpt), ref}, siteRepoOpt); strings.TrimSpace(string(out)) != "" {
+        return withCode(exitOptions, &pushError{"There is already a snapshot named " + name + " on the site repo's origin."})
+    }
+
+    file, err := ioutil.TempFile("", "ncaapushit-snapshot")
+    if err != nil {
+        return &pushError{"Could not write the snapshot: " + err.Error()}
+    }
+    defer os.Remove(file.Name())
+
+    file.WriteString(contents)
+    file.Close()
+
+    blob, err := git(gitc{"hash-object", "-w", file.Name()}, siteRepoOpt)
+    if err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"update-ref", ref, strings.TrimSpace(string(blob))}, siteRepoOpt); err != nil {
+        return err
+    }
+
+    if _, err = git(gitc{"push", remoteFor(siteRepoOpt), ref}, siteRepoOpt); err != nil {
+        return withCode(exitRejected, err)
+    }
+
+    fmt.Printf("\nRecorded %d pins as snapshot %s; put them back with: ncaapushit restore %s\n", len(pins), name, name)
+
+    return nil
+}
+
+// readSnapshot reads a snapshot from a file or, by name, from the site repo's origin
+func readSnapshot(arg string) ([]pinRequest, error) {
+    if _, err := os.Stat(arg); err == nil || isSnapshotFile(arg) {
+        return readVersionsFile(arg)
+    }
+
+    ref := snapshotRef(arg)
+
+    if _, err := gitTry(gitc{"fetch", remoteFor(siteRepoOpt), "+" + ref + ":" + ref}, siteRepoOpt); err != nil {
+        return nil, withCode(exitOptions, &pushError{"There is no snapshot named " + arg + " on the site repo's origin (git ls-remote origin 'refs/snapshots/*' lists them)."})
+    }
+
+    out, err := git(gitc{"cat-file", "blob", ref}, siteRepoOpt)
+    if err != nil {
+        return nil, err
+    }
+
+    return parseVersions("snapshot "+arg, strings.Split(strings.TrimSpace(string(out)), "\n"))
+}
+
+// restore moves every pin in a snapshot back to the version it recorded, in one site commit. Modules
+// pinned since the snapshot was taken are left as they are
+func restore(args []string) error {
+    if len(args) != 1 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit restore [options] <name or file>"})
+    }
+
+    makefile, err := getMakefile()
+    if err != nil {
+        return err
+    }
+
+    unlock, err := lockSite("restore " + args[0])
+    if err != nil {
+        return err
+    }
+    defer unlock()
+
+    if err = checkoutSiteBranch(); err != nil {
+        return err
+    }
+
+    requests, err := readSnapshot(args[0])
+    if err != nil {
+        return err
+    }
+
+    current, err := pinSet(makefile)
+    if err != nil {
+        return err
+    }
+
+    listed := map[string]bool{}
+    for _, r := range requests {
+        listed[r.module] = true
+    }
+
+    var unlisted []string
+    for _, p := range current {
+        if !listed[p.module] {
+            unlisted = append(unlisted, p.module)
+        }
+    }
+
+    if len(unlisted) > 0 {
+        slog.Warn("pinned since the snapshot, left as they are: " + strings.Join(unlisted, ", "))
+    }
+
+    return applyPins(makefile, requests, "restore", []string{"Restores the pins recorded in " + args[0] + "."})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "os"
+    "time"
+)
+
+// pushState records what the last push changed, so it can be undone by the rollback command
+type pushState struct {
+    Time       time.Time      `json:"time"`
+    Module     string         `json:"module"`
+    ModuleDir  string         `json:"moduleDir"`
+    Remote     string         `json:"remote,omitempty"` // the module remote the tag was pushed to, origin when empty
+    Tag        string         `json:"tag"`
+    TagPushed  bool           `json:"tagPushed"`
+    SiteRepo   string         `json:"siteRepo"`
+    SiteBranch string         `json:"siteBranch"`
+    CommitSHA  string         `json:"commitSha"`
+    SitePushed bool           `json:"sitePushed"`
+    PRBranch   string         `json:"prBranch,omitempty"`
+    PR         string         `json:"pullRequest,omitempty"`
+    Batch      []taggedModule `json:"batch,omitempty"` // every module of a batch release
+    RolledBack bool           `json:"rolledBack"`
+}
+
+// taggedModule is one module tagged by a batch release
+type taggedModule struct {
+    Module    string `json:"module"`
+    ModuleDir string `json:"moduleDir"`
+    Remote    string `json:"remote,omitempty"`
+    Tag       string `json:"tag"`
+    TagPushed bool   `json:"tagPushed"`
+}
+
+// tagged returns the module tags the push made: the one module, or every module of a batch
+func (s *pushState) tagged() []taggedModule {
+    if len(s.Batch) > 0 {
+        return s.Batch
+    }
+
+    return []taggedModule{{s.Module, s.ModuleDir, s.Remote, s.Tag, s.TagPushed}}
+}
+
+var state pushState
+
+// statePath is where the state of the last push is kept
+func statePath() string {
+    return usr.HomeDir + "/.ncaapushit_state.json"
+}
+
+// save writes the state to disk. It is called after each step that changes a remote.
+func (s *pushState) save() {
+    contents, _ := json.MarshalIndent(s, "", "  ")
+
+    if err := ioutil.WriteFile(statePath(), append(contents, '\n'), 0644); err != nil {
+        slog.Warn(fmt.Sprintf("could not save push state to %s: %s", statePath(), err))
+    }
+}
+
+// loadState reads the state of the last push
+func loadState() (pushState, error) {
+    var s pushState
+
+    contents, err := ioutil.ReadFile(statePath())
+    if os.IsNotExist(err) {
+        return s, &pushError{"There is no record of a previous push (" + statePath() + " does not exist)."}
+    } else if err != nil {
+        return s, &pushError{"Could not read push state @ " + statePath()}
+    }
+
+    if err = json.Unmarshal(contents, &s); err != nil {
+        return s, &pushError{"The push state @ " + statePath() + " is not valid JSON: " + err.Error()}
+    }
+
+    return s, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+func init() {
+    subcommands["status"] = subcommand{"List every module the site makefile pins with its pinned tag, the latest tag on its repo and its unreleased commits.", status}
+}
+
+// drift is how far a pinned module is from its repo
+type drift struct {
+    module     string
+    pinned     string
+    latest     string // highest version tagged on the module's repo, "" when untagged
+    unreleased int    // commits on the default branch since the latest tag, -1 when there is no checkout to count them in
+    err        error
+}
+
+// remoteLatest finds the highest version tagged on a module repo's origin
+func remoteLatest(module, url string) (string, error) {
+    prefix := versionPrefix(module)
+
+    out, err := gitCheck(gitc{"ls-remote", "--tags", "--refs", url, prefix + "*"}, siteRepoOpt)
+    if err != nil {
+        return "", err
+    }
+
+    latest, highest := "", version{}
+
+    for _, line := range strings.Split(out, "\n") {
+        fields := strings.Fields(line)
+        if len(fields) != 2 {
+            continue
+        }
+
+        name := strings.TrimPrefix(strings.TrimPrefix(fields[1], "refs/tags/"), prefix)
+        if v, err := parseVersion(name); err == nil && (latest == "" || v.Compare(highest) > 0) {
+            latest, highest = name, v
+        }
+    }
+
+    return latest, nil
+}
+
+// unreleasedCommits counts the commits on the default branch of the module's checkout under
+// --workspace since its latest tag (-1 without a checkout)
+func unreleasedCommits(module, latest string) int {
+    dir := filepath.Join(workspaceOpt, module)
+
+    if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+        return -1
+    }
+
+    if _, err := gitTry(fetchRemote(dir), dir); err != nil {
+        return -1
+    }
+
+    since := remoteFor(dir) + "/" + detectDefaultBranch(dir)
+    if latest != "" {
+        since = moduleTagName(module, latest) + ".." + since
+    }
+
+    out, err := gitTry(gitc{"rev-list", "--count", "--no-merges", since}, dir)
+    if err != nil {
+        return -1
+    }
+
+    count, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+    return count
+}
+
+// state sums up a module's drift in a few words
+func (d drift) state() string {
+    var notes []string
+
+    switch {
+    case d.latest == "":
+        notes = append(notes, "untagged")
+    case d.pinned != d.latest:
+        pinned, err := parseVersion(d.pinned)
+        latest, _ := parseVersion(d.latest)
+
+        if err != nil || pinned.Compare(latest) > 0 {
+            notes = append(notes, "pinned tag not on origin")
+        } else {
+            notes = append(notes, "pin behind")
+        }
+    }
+
+    if d.unreleased > 0 {
+        notes = append(notes, "needs release")
+    }
+
+    if len(notes) == 0 && d.unreleased < 0 {
+        return "pins the latest tag"
+    }
+
+    if len(notes) == 0 {
+        return "up to date"
+    }
+
+    return strings.Join(notes, ", ")
+}
+
+// status compares every pin in the site makefile with the module's repo: the latest tag on its
+// origin, and how many commits its default branch has had since (from the checkout under --workspace)
+func status(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit status [options]"})
+    }
+
+    makefile, err := getMakefile()
+    if err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err != nil {
+        return err
+    }
+
+    pins, err := pinSet(makefile)
+    if err != nil {
+        return err
+    }
+
+    fmt.Printf("Checking the %d module(s) pinned in %s...\n\n", len(pins), siteMakeOpt)
+
+    results := make([]drift, len(pins))
+    var wg sync.WaitGroup
+
+    for i, p := range pins {
+        wg.Add(1)
+        go func(i int, p pinRequest) {
+            defer wg.Done()
+
+            d := drift{module: p.module, pinned: p.version, unreleased: -1}
+
+            if url := moduleURL(makefile, p.module); url == "" {
+                d.err = &pushError{"its repo isn't known; give its makefile entry a [download][url] or check it out under --workspace"}
+            } else if d.latest, d.err = remoteLatest(p.module, url); d.err == nil {
+                d.unreleased = unreleasedCommits(p.module, d.latest)
+            }
+
+            results[i] = d
+        }(i, p)
+    }
+
+    wg.Wait()
+
+    fmt.Printf("%-24s %-14s %-14s %-11s %s\n", "MODULE", "PINNED", "LATEST", "UNRELEASED", "STATE")
+
+    for _, d := range results {
+        if d.err != nil {
+            fmt.Printf("%-24s %-14s %s\n", d.module, moduleTagName(d.module, d.pinned), "could not be checked: "+strings.TrimPrefix(strings.TrimSpace(d.err.Error()), "fatal: "))
+            continue
+        }
+
+        latest, unreleased := "-", "?"
+        if d.latest != "" {
+            latest = moduleTagName(d.module, d.latest)
+        }
+        if d.unreleased >= 0 {
+            unreleased = strconv.Itoa(d.unreleased)
+        }
+
+        fmt.Printf("%-24s %-14s %-14s %-11s %s\n", d.module, moduleTagName(d.module, d.pinned), latest, unreleased, d.state())
+    }
+
+    fmt.Println("\nUnreleased commits are counted in the checkouts under --workspace; ? means there is none.")
+
+    return nil
+}
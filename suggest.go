@@ -0,0 +1,106 @@
+package main
+
+import (
+    "flag"
+    "path/filepath"
+    "sort"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+// editDistance returns the edit distance between two strings, counting a swap of two
+// adjacent characters (the most common typo) as a single edit
+func editDistance(a, b string) int {
+    d := make([][]int, len(a)+1)
+
+    for i := range d {
+        d[i] = make([]int, len(b)+1)
+        d[i][0] = i
+    }
+
+    for j := range d[0] {
+        d[0][j] = j
+    }
+
+    for i := 1; i <= len(a); i++ {
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+            if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+                d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+            }
+        }
+    }
+
+    return d[len(a)][len(b)]
+}
+
+// closest returns the candidate nearest to word, or "" if none is a plausible typo of it
+func closest(word string, candidates []string) string {
+    best, bestDistance := "", len(word)/2+1
+
+    for _, candidate := range candidates {
+        if d := editDistance(word, candidate); d < bestDistance {
+            best, bestDistance = candidate, d
+        }
+    }
+
+    return best
+}
+
+// byUsage orders candidates by how often they show up in the operator's own history, most used
+// first, so that closest prefers the ones they actually work with when two are equally near
+func byUsage(candidates []string) []string {
+    records, _ := (&fileHistory{userHistoryPath}).List()
+
+    used := map[string]int{}
+    for _, record := range records {
+        used[record.Module]++
+        used[record.Profile]++
+    }
+
+    sorted := append([]string(nil), candidates...)
+    sort.SliceStable(sorted, func(i, j int) bool {
+        return used[sorted[i]] > used[sorted[j]]
+    })
+
+    return sorted
+}
+
+// suggestFlag names the option nearest to one that isn't defined (eg. --bum), if there is one
+func suggestFlag(name string) string {
+    var names []string
+    flag.VisitAll(func(f *flag.Flag) {
+        names = append(names, f.Name)
+    })
+
+    if suggestion := closest(name, names); suggestion != "" {
+        return "--" + suggestion
+    }
+    return ""
+}
+
+// suggestModule names the module pinned in the site makefile nearest to a module that couldn't be
+// found (eg. ncaa_scroes), if there is one
+func suggestModule(module string) string {
+    entries, err := pushit.ParseMakefile(filepath.Join(siteRepoOpt, siteMakeOpt), siteRepoOpt)
+    if err != nil {
+        return ""
+    }
+
+    var modules []string
+    seen := map[string]bool{}
+
+    for _, e := range entries {
+        if len(e.Keys) > 1 && e.Keys[0] == "projects" && !seen[e.Keys[1]] {
+            modules = append(modules, e.Keys[1])
+            seen[e.Keys[1]] = true
+        }
+    }
+
+    return closest(module, byUsage(modules))
+}
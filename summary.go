@@ -0,0 +1,153 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log/slog"
+    "strings"
+)
+
+// runSummary collects the facts of a single run so they can be reported at the very end
+type runSummary struct {
+    Module     string           `json:"module"`
+    OldVersion string           `json:"oldVersion"`
+    NewVersion string           `json:"newVersion"`
+    Topic      string           `json:"topic"`
+    Releases   []releaseSummary `json:"releases,omitempty"` // every module of a batch release
+    Impact     string           `json:"impact,omitempty"`
+    TagPushed  bool             `json:"tagPushed"`
+    CommitSHA  string           `json:"commitSha"`
+    PR         string           `json:"pullRequest,omitempty"`
+    Notified   []string         `json:"notifications"`
+    FollowUps  []string         `json:"followUps"`
+    Outcome    string           `json:"outcome"`
+    Error      string           `json:"error,omitempty"`
+    ExitCode   int              `json:"exitCode"`
+    Timings    []timing         `json:"timings,omitempty"` // with --timing
+}
+
+// releaseSummary is one module released by the run
+type releaseSummary struct {
+    Module     string `json:"module"`
+    OldVersion string `json:"oldVersion"`
+    NewVersion string `json:"newVersion"`
+    Topic      string `json:"topic"`
+}
+
+var summary = runSummary{Outcome: "failed"}
+
+// fail records the error that ended the run and prints it
+func (s *runSummary) fail(err error) {
+    s.Outcome = "failed"
+    s.Error = strings.TrimSpace(err.Error())
+    s.ExitCode = exitCode(err)
+
+    // a step that failed because Ctrl-C killed its git command was interrupted, not broken
+    if err == errInterrupted || isInterrupted() {
+        s.Outcome = "interrupted"
+        s.ExitCode = exitAborted
+    }
+    fmt.Println(err)
+}
+
+// followUp adds an action the operator still needs to take by hand
+func (s *runSummary) followUp(action string) {
+    s.FollowUps = append(s.FollowUps, action)
+}
+
+// releases returns what the run released: the one module, or every module of a batch
+func (s *runSummary) releases() []releaseSummary {
+    if len(s.Releases) > 0 {
+        return s.Releases
+    }
+
+    if s.NewVersion == "" {
+        return nil
+    }
+
+    return []releaseSummary{{s.Module, s.OldVersion, s.NewVersion, s.Topic}}
+}
+
+// inferFollowUps derives follow-up actions from a partially completed run
+func (s *runSummary) inferFollowUps() {
+    if s.Outcome == "success" {
+        return
+    }
+
+    if s.TagPushed && s.CommitSHA == "" {
+        var tags []string
+        for _, rel := range s.releases() {
+            tags = append(tags, tagName(rel.NewVersion))
+        }
+
+        // a single release picks up where it stopped when it is run again
+        if len(tags) == 1 {
+            s.followUp(fmt.Sprintf("Tag %s was pushed but the makefile was not updated. Run the release again to pin it in %s/%s.", tags[0], siteRepoOpt, siteMakeOpt))
+        } else {
+            s.followUp(fmt.Sprintf("Tag %s was pushed but the makefile was not updated. Pin it in %s/%s by hand.", strings.Join(tags, ", "), siteRepoOpt, siteMakeOpt))
+        }
+    }
+}
+
+// print writes the closing summary block to stdout
+func (s *runSummary) print() {
+    s.inferFollowUps()
+
+    valueOr := func(value, fallback string) string {
+        if value == "" {
+            return fallback
+        }
+        return value
+    }
+
+    tagPushed := "n"
+    if s.TagPushed {
+        tagPushed = "y"
+    }
+
+    versions := valueOr(s.OldVersion, "?") + " -> " + valueOr(s.NewVersion, "?")
+
+    fmt.Println("\n---------------- summary ----------------")
+    fmt.Printf("outcome:       %s\n", s.Outcome)
+    if s.Error != "" {
+        fmt.Printf("error:         %s\n", strings.SplitN(strings.TrimPrefix(s.Error, "fatal: "), "\n", 2)[0])
+    }
+    fmt.Printf("module:        %s\n", valueOr(s.Module, "-"))
+    if len(s.Releases) > 0 {
+        fmt.Println("releases:")
+        for _, rel := range s.Releases {
+            fmt.Printf("  - %s %s -> %s\n", rel.Module, rel.OldVersion, rel.NewVersion)
+        }
+    } else {
+        fmt.Printf("version:       %s\n", versions)
+    }
+    if s.Impact != "" {
+        fmt.Printf("impact:        %s\n", s.Impact)
+    }
+    fmt.Printf("tag pushed:    %s\n", tagPushed)
+    fmt.Printf("makefile sha:  %s\n", valueOr(s.CommitSHA, "-"))
+    if s.PR != "" {
+        fmt.Printf("pull request:  %s\n", s.PR)
+    }
+    fmt.Printf("notifications: %s\n", valueOr(strings.Join(s.Notified, ", "), "none"))
+
+    if len(s.FollowUps) == 0 {
+        fmt.Println("follow-ups:    none")
+    } else {
+        fmt.Println("follow-ups:")
+        for _, action := range s.FollowUps {
+            fmt.Println("  -", action)
+        }
+    }
+    fmt.Println("-----------------------------------------")
+}
+
+// write saves the summary as JSON so CI jobs wrapping the utility can archive and consume it
+func (s *runSummary) write(path string) {
+    contents, _ := json.MarshalIndent(s, "", "  ")
+
+    if err := ioutil.WriteFile(path, append(contents, '\n'), 0644); err != nil {
+        slog.Warn(fmt.Sprintf("could not write summary to %s: %s", path, err))
+    }
+}
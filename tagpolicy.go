@@ -0,0 +1,107 @@
+package main
+
+import (
+    "io/ioutil"
+    "path/filepath"
+    "regexp"
+)
+
+// tagConfig sets how a module's version tags are named, for module repos that don't tag vX.Y.Z
+type tagConfig struct {
+    Prefix *string `json:"prefix"` // what precedes the version, eg. "release-" or "" for plain 1.2.3; "v" when not set
+    Policy string  `json:"policy"` // regular expression every new tag must match, eg. "^release-\\d+\\.\\d+\\.\\d+$"
+    Scheme string  `json:"scheme"` // semver, or drupal for contrib-style 7.x-1.4 (see --version-scheme)
+    Core   string  `json:"core"`   // the core compatibility of drupal versions (eg. 7.x), read from the module's .info file or the makefile when empty
+}
+
+// moduleTagConfig is the tag config of a module: its own entry, or the "*" one
+func moduleTagConfig(module string) tagConfig {
+    if tags, ok := config.Tags[module]; ok {
+        return tags
+    }
+
+    return config.Tags["*"]
+}
+
+// versionPrefix is what precedes the version in the module's tags, before any monorepo or namespace
+// prefix
+func versionPrefix(module string) string {
+    if prefix := moduleTagConfig(module).Prefix; prefix != nil {
+        return *prefix
+    }
+
+    if drupalScheme(module) {
+        return drupalCore(module) + "-"
+    }
+
+    return "v"
+}
+
+// drupalScheme says whether the module is versioned like Drupal contrib (7.x-1.4): with
+// --version-scheme=drupal, or "scheme": "drupal" in its tags config
+func drupalScheme(module string) bool {
+    if schemeOpt != "auto" {
+        return schemeOpt == "drupal"
+    }
+
+    return moduleTagConfig(module).Scheme == "drupal"
+}
+
+var (
+    corePattern = regexp.MustCompile(`(?m)^\s*core\s*[=:]\s*["']?(\d+\.x)`)
+    // the core compatibility found for each module, which is looked up for every tag name
+    cores = map[string]string{}
+)
+
+// drupalCore is the core compatibility a drupal version is prefixed with: from the config file, else
+// the module's .info (or .info.yml) file, else the site makefile's core, else 7.x
+func drupalCore(module string) string {
+    if core := moduleTagConfig(module).Core; core != "" {
+        return core
+    }
+
+    if core, ok := cores[module]; ok {
+        return core
+    }
+
+    core := "7.x"
+    sources := []string{filepath.Join(cwd, module+".info"), filepath.Join(cwd, module+".info.yml")}
+    if siteRepoOpt != "" {
+        sources = append(sources, filepath.Join(siteRepoOpt, siteMakeOpt))
+    }
+
+    for _, source := range sources {
+        if contents, err := ioutil.ReadFile(source); err == nil {
+            if match := corePattern.FindSubmatch(contents); match != nil {
+                core = string(match[1])
+                break
+            }
+        }
+    }
+
+    cores[module] = core
+    return core
+}
+
+// currentModule is the name of the module being released, as the config file refers to it
+func currentModule() string {
+    if monorepoModule != "" {
+        return monorepoModule
+    }
+
+    return filepath.Base(cwd)
+}
+
+// checkTagPolicy refuses a tag that doesn't match the module's tag policy from the config file
+func checkTagPolicy(module, tag string) error {
+    policy := moduleTagConfig(module).Policy
+    if policy == "" {
+        return nil
+    }
+
+    if matched, _ := regexp.MatchString(policy, tag); !matched {
+        return &pushError{tag + " doesn't match the tag policy for " + module + " (" + policy + ") from the config file"}
+    }
+
+    return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestPrefixedTags(t *testing.T) {
+    f := newFixture(t)
+
+    // ncaa_scores tags release-X.Y.Z, and the site pins it that way
+    f.write(f.config, `{"tags": {"ncaa_scores": {"prefix": "release-"}}}`+"\n")
+    f.git(f.module, "tag", "release-1.2.3", "v1.2.3")
+    f.git(f.module, "push", "-q", "origin", "release-1.2.3")
+    f.write(filepath.Join(f.site, "barcelona.make"), strings.Replace(fixtureMakefile, `"v1.2.3"`, `"release-1.2.3"`, 1))
+    f.git(f.site, "commit", "-qam", "Pin the release- tag")
+    f.git(f.site, "push", "-q", "origin", "main")
+
+    f.mustRun("--changelog")
+
+    if pin := f.pinned(); pin != "release-1.2.4" {
+        t.Errorf("makefile pins %s, want release-1.2.4", pin)
+    }
+
+    if !strings.Contains(strings.Join(f.remoteTags(), " "), "release-1.2.4") {
+        t.Errorf("release-1.2.4 was not pushed: %v", f.remoteTags())
+    }
+
+    if changelog := f.git(f.module, "show", "origin/main:CHANGELOG.md"); !strings.Contains(changelog, "## release-1.2.4 (") {
+        t.Errorf("CHANGELOG.md doesn't head the entry with the tag:\n%s", changelog)
+    }
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+    "encoding/base64"
+    "html"
+    "net/http"
+    "strings"
+)
+
+// jiraConfig points ticket keys at the issue tracker
+type jiraConfig struct {
+    BaseURL   string            `json:"baseUrl"`   // eg. https://jira.turner.com
+    User      string            `json:"user"`      // with token, uses basic auth (JIRA Cloud)
+    Token     string            `json:"token"`     // reads ticket titles for the stakeholder release notes
+    Project   string            `json:"project"`   // key of the project an issue is filed in when a release fails after its tag was pushed, none when empty
+    IssueType string            `json:"issueType"` // of the issue filed, Bug when empty
+    Assignees map[string]string `json:"assignees"` // operator -> JIRA user name, when they differ
+}
+
+func (c jiraConfig) auth(req *http.Request) {
+    if c.User != "" {
+        req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(c.User+":"+c.Token)))
+    } else if c.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+c.Token)
+    }
+}
+
+// ticketURL returns the link for a ticket key, or "" when no JIRA base URL is configured
+func ticketURL(key string) string {
+    if config.Jira.BaseURL == "" {
+        return ""
+    }
+    return strings.TrimSuffix(config.Jira.BaseURL, "/") + "/browse/" + key
+}
+
+// linkTickets turns every ticket key in text into a link in the given output format: markdown
+// (Bitbucket, Teams), html (already escaped text, for Confluence) or slack (mrkdwn)
+func linkTickets(text, format string) string {
+    if config.Jira.BaseURL == "" {
+        return text
+    }
+
+    return ticketPattern.ReplaceAllStringFunc(text, func(key string) string {
+        url := ticketURL(key)
+
+        switch format {
+        case "markdown":
+            return "[" + key + "](" + url + ")"
+        case "html":
+            return `<a href="` + html.EscapeString(url) + `">` + key + "</a>"
+        case "slack":
+            return "<" + url + "|" + key + ">"
+        }
+
+        return key
+    })
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// timing is how long one phase of the run or one git command took (recorded with --timing)
+type timing struct {
+    Kind string  `json:"kind"` // phase or git
+    Name string  `json:"name"`
+    Ms   float64 `json:"ms"`
+}
+
+var (
+    timingsMu sync.Mutex
+    runStart  = time.Now()
+)
+
+// record adds a timing to the summary; git commands run concurrently during the checks
+func record(kind, name string, took time.Duration) {
+    if !timingOpt {
+        return
+    }
+
+    timingsMu.Lock()
+    defer timingsMu.Unlock()
+
+    summary.Timings = append(summary.Timings, timing{kind, name, float64(took) / float64(time.Millisecond)})
+}
+
+// phase starts timing a phase of the run; call the returned function when it is over
+func phase(name string) func() {
+    start := time.Now()
+    return func() { record("phase", name, time.Since(start)) }
+}
+
+// printTimings breaks the run's wall-clock time down by phase and by git subcommand
+func printTimings() {
+    if !timingOpt {
+        return
+    }
+
+    total := float64(time.Since(runStart)) / float64(time.Millisecond)
+
+    type aggregate struct {
+        name  string
+        count int
+        ms    float64
+    }
+
+    var (
+        phases []timing
+        gits   []*aggregate
+    )
+    bySubcommand := map[string]*aggregate{}
+
+    for _, t := range summary.Timings {
+        if t.Kind == "phase" {
+            phases = append(phases, t)
+            continue
+        }
+
+        sub := strings.Fields(t.Name)[0]
+        if bySubcommand[sub] == nil {
+            bySubcommand[sub] = &aggregate{name: sub}
+            gits = append(gits, bySubcommand[sub])
+        }
+        bySubcommand[sub].count++
+        bySubcommand[sub].ms += t.Ms
+    }
+
+    sort.Slice(gits, func(i, j int) bool { return gits[i].ms > gits[j].ms })
+
+    fmt.Println("\n---------------- timing -----------------")
+    for _, t := range phases {
+        fmt.Printf("%-24s %8.0fms %5.1f%%\n", t.Name, t.Ms, 100*t.Ms/total)
+    }
+    fmt.Printf("%-24s %8.0fms\n", "total", total)
+
+    fmt.Println("\ngit commands (by subcommand):")
+    for _, g := range gits {
+        fmt.Printf("  %-22s %8.0fms  x%d\n", g.name, g.ms, g.count)
+    }
+    fmt.Println("-----------------------------------------")
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+    "fmt"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+func init() {
+    subcommands["tui"] = subcommand{"Pick a module under --workspace, its bump and review its makefile change in a full-screen terminal UI, then release it.", tui}
+}
+
+const (
+    screenEnter = "\033[?1049h\033[?25l" // the alternate screen, without a cursor
+    screenLeave = "\033[?25h\033[?1049l"
+    screenClear = "\033[H\033[2J"
+    styleBold   = "\033[1m"
+    styleInvert = "\033[7m"
+)
+
+// keys the terminal UI reacts to
+const (
+    keyUp = iota
+    keyDown
+    keyEnter
+    keyBack
+    keyQuit
+    keyOther
+)
+
+// terminal puts the terminal in and out of the UI: keys (Ctrl-C included) are read as they are
+// pressed, without echo, on the alternate screen so the scrollback is left as it was
+type terminal struct {
+    saved string
+    rows  int
+}
+
+// stty runs stty on the terminal
+func stty(args ...string) (string, error) {
+    command := exec.Command("stty", args...)
+    command.Stdin = os.Stdin
+
+    out, err := command.Output()
+    return strings.TrimSpace(string(out)), err
+}
+
+// openTerminal switches the terminal to the UI
+func openTerminal() (*terminal, error) {
+    if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+        return nil, withCode(exitOptions, &pushError{"tui needs a terminal; use scan --push to release from a script."})
+    }
+
+    saved, err := stty("-g")
+    if err != nil {
+        return nil, &pushError{"Could not read the terminal settings: " + err.Error()}
+    }
+
+    if _, err = stty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+        return nil, &pushError{"Could not set up the terminal: " + err.Error()}
+    }
+
+    t := &terminal{saved: saved, rows: 24}
+
+    if size, err := stty("size"); err == nil {
+        if fields := strings.Fields(size); len(fields) == 2 {
+            if rows, _ := strconv.Atoi(fields[0]); rows > 4 {
+                t.rows = rows
+            }
+        }
+    }
+
+    fmt.Print(screenEnter)
+
+    return t, nil
+}
+
+// close puts the terminal back the way it was
+func (t *terminal) close() {
+    fmt.Print(screenLeave)
+    stty(t.saved)
+}
+
+// key waits for the next key. Arrow keys arrive as escape sequences, in one read; Ctrl-C quits.
+func (t *terminal) key() int {
+    b, err := stdin.ReadByte()
+    if err != nil {
+        return keyQuit
+    }
+
+    switch b {
+    case 'k':
+        return keyUp
+    case 'j':
+        return keyDown
+    case '\n', '\r', ' ':
+        return keyEnter
+    case 'b', 127:
+        return keyBack
+    case 'q', 3:
+        return keyQuit
+    case 27:
+        if stdin.Buffered() < 2 {
+            return keyBack
+        }
+
+        stdin.ReadByte()
+        switch code, _ := stdin.ReadByte(); code {
+        case 'A':
+            return keyUp
+        case 'B':
+            return keyDown
+        }
+    }
+
+    return keyOther
+}
+
+// draw replaces the screen with the lines, cut to fit
+func (t *terminal) draw(lines []string) {
+    if len(lines) > t.rows-1 {
+        lines = append(lines[:t.rows-2], "...")
+    }
+
+    fmt.Print(screenClear + strings.Join(lines, "\n"))
+}
+
+// choose lets the operator move through the options and pick one. It returns -1 when they go back
+// (or quit, which also sets quit).
+func (t *terminal) choose(header []string, options []string, footer []string, selected int) (int, bool) {
+    for {
+        lines := append([]string{}, header...)
+
+        for i, option := range options {
+            if i == selected {
+                lines = append(lines, styleInvert+"> "+option+colorReset)
+            } else {
+                lines = append(lines, "  "+option)
+            }
+        }
+
+        t.draw(append(lines, footer...))
+
+        switch t.key() {
+        case keyUp:
+            if selected > 0 {
+                selected--
+            }
+        case keyDown:
+            if selected < len(options)-1 {
+                selected++
+            }
+        case keyEnter:
+            return selected, false
+        case keyBack:
+            return -1, false
+        case keyQuit:
+            return -1, true
+        }
+    }
+}
+
+// tuiModules scans the workspace's module repos, as scan does, most unreleased commits first
+func tuiModules() ([]unreleased, error) {
+    dirs, err := workspaceModules(workspaceOpt)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(dirs) == 0 {
+        return nil, withCode(exitOptions, &pushError{"There are no module repos in " + workspaceOpt + "; point --workspace at the directory holding them."})
+    }
+
+    results := make([]unreleased, len(dirs))
+    var wg sync.WaitGroup
+
+    for i, dir := range dirs {
+        wg.Add(1)
+        go func(i int, dir string) {
+            defer wg.Done()
+            results[i] = scanRepo(dir)
+        }(i, dir)
+    }
+
+    wg.Wait()
+
+    sort.SliceStable(results, func(i, j int) bool { return results[i].commits > results[j].commits })
+
+    return results, nil
+}
+
+// tuiBumps works out the version each bump would release, in the module repo selected with getModule
+func tuiBumps(u unreleased) ([]string, []string, error) {
+    latest := strings.TrimPrefix(u.latest, tagPrefix())
+
+    current, err := parseVersion(latest)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var columns, versions []string
+
+    for _, column := range []string{"patch", "minor", "major"} {
+        next, err := bumpVersion(current, column, "")
+        if err != nil {
+            return nil, nil, err
+        }
+
+        columns, versions = append(columns, column), append(versions, next.String())
+    }
+
+    return columns, versions, nil
+}
+
+// tuiMakefileChange renders how releasing the version would change the makefile
+func tuiMakefileChange(module, newVersion, latest string) []string {
+    makefile, err := getMakefile(module)
+    if err == nil {
+        var outFile []string
+        if outFile, err = getUpdatedMakefile(makefile, module, newVersion, latest); err == nil {
+            contents, _ := ioutil.ReadFile(makefile)
+            diff := unifiedDiff(siteMakeOpt, strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n"), outFile)
+            return append([]string{"Makefile change:", ""}, strings.Split(strings.TrimSuffix(diff, "\n"), "\n")...)
+        }
+    }
+
+    return []string{colorRed + "The makefile can't be changed: " + strings.TrimPrefix(strings.TrimSpace(err.Error()), "fatal: ") + colorReset}
+}
+
+// tui lists the workspace's modules with their unreleased commits, lets the operator pick one and its
+// bump with a preview of the new version, shows the makefile change and then runs the release (with
+// its own checks and confirmation) as an ordinary push
+func tui(args []string) error {
+    if len(args) != 0 {
+        return withCode(exitOptions, &pushError{"Usage: ncaapushit tui [options]"})
+    }
+
+    fmt.Printf("Scanning the module repos in %s...\n", workspaceOpt)
+
+    modules, err := tuiModules()
+    if err != nil {
+        return err
+    }
+
+    if err = updateSiteRepo(); err != nil {
+        return err
+    }
+
+    t, err := openTerminal()
+    if err != nil {
+        return err
+    }
+
+    var (
+        picked    unreleased
+        column    string
+        selected  int
+        released  bool
+        keys      = "up/down (or j/k) to move, enter to choose, esc (or b) to go back, q to quit"
+        moduleFor = func(u unreleased) string {
+            switch {
+            case u.err != nil:
+                return fmt.Sprintf("%-24s could not be scanned: %s", u.module, strings.TrimPrefix(strings.TrimSpace(u.err.Error()), "fatal: "))
+            case u.latest == "(untagged)":
+                return fmt.Sprintf("%-24s %-14s (release it with add)", u.module, u.latest)
+            }
+            return fmt.Sprintf("%-24s %-14s %d unreleased commit(s)", u.module, u.latest, u.commits)
+        }
+    )
+
+    defer func() {
+        if !released {
+            t.close()
+        }
+    }()
+
+modules:
+    for {
+        var options []string
+        for _, u := range modules {
+            options = append(options, moduleFor(u))
+        }
+
+        i, quit := t.choose([]string{styleBold + "Release which module?" + colorReset, ""}, options, []string{"", keys}, selected)
+        if quit || i < 0 {
+            return errAborted
+        }
+        selected, picked = i, modules[i]
+
+        if picked.err != nil || picked.commits == 0 || picked.latest == "(untagged)" {
+            continue
+        }
+
+        moduleOpt = picked.dir
+        if _, err = getModule(); err != nil {
+            return err
+        }
+
+        columns, versions, err := tuiBumps(picked)
+        if err != nil {
+            return err
+        }
+
+        log, _ := gitTry(gitc{"log", "--oneline", "--no-merges", picked.latest + ".." + remoteFor(cwd) + "/" + picked.branch}, cwd)
+
+        header := []string{styleBold + picked.module + colorReset + " (" + picked.latest + " on " + picked.branch + ")", "", "Unreleased commits:"}
+        for _, line := range strings.Split(strings.TrimSpace(string(log)), "\n") {
+            header = append(header, "  "+line)
+        }
+        header = append(header, "", styleBold+"Bump:"+colorReset)
+
+        var bumps []string
+        for i, column := range columns {
+            bumps = append(bumps, fmt.Sprintf("%-6s %s -> %s", column, strings.TrimPrefix(picked.latest, tagPrefix()), versions[i]))
+        }
+
+        for {
+            i, quit := t.choose(header, bumps, []string{"", keys}, 0)
+            if quit {
+                return errAborted
+            }
+            if i < 0 {
+                continue modules
+            }
+            column = columns[i]
+
+            change := tuiMakefileChange(picked.module, versions[i], strings.TrimPrefix(picked.latest, tagPrefix()))
+
+            confirm, quit := t.choose(append([]string{styleBold + picked.module + " " + versions[i] + colorReset, ""}, append(change, "")...),
+                []string{"Release it", "Go back"}, []string{"", keys}, 0)
+            if quit {
+                return errAborted
+            }
+            if confirm == 0 {
+                break modules
+            }
+        }
+    }
+
+    released = true
+    t.close()
+
+    // ** the release is an ordinary push of its own, so it gets its own checks, prompts and summary
+    topic := picked.topic
+    if topic == "" {
+        topic = picked.branch
+    }
+
+    push := exec.Command(os.Args[0], "--module", picked.dir, "--topic", topic, "--bump", column,
+        "--config", configOpt, "--site-repo", siteRepoOpt, "--site-makefile", siteMakeOpt)
+    if profileOpt != "" {
+        push.Args = append(push.Args, "--profile", profileOpt)
+    }
+    if verboseOpt || traceOpt {
+        push.Args = append(push.Args, "--verbose="+strconv.FormatBool(verboseOpt), "--trace="+strconv.FormatBool(traceOpt))
+    }
+    push.Stdin, push.Stdout, push.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+    if err := push.Run(); err != nil {
+        if exit, ok := err.(*exec.ExitError); ok {
+            return withCode(exit.ExitCode(), &pushError{"The release of " + picked.module + " did not complete."})
+        }
+        return &pushError{"Could not start the release of " + picked.module + ": " + err.Error()}
+    }
+
+    return nil
+}
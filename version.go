@@ -0,0 +1,98 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "log/slog"
+    "strings"
+
+    "github.com/mattacular/ncaapushit/pkg/pushit"
+)
+
+// version is a module version as tagged in git (without the leading "v"), eg. 2.1.0-rc.1+build.7
+type version = pushit.Version
+
+// tagPrefix is what precedes the version in tag names: "v" (or the module's prefix from the config
+// file), "<module>/v" for a module in a monorepo (eg. ncaa_scores/v1.4.0), under "<namespace>/" when
+// the selected profile consumes namespaced tags (eg. staging/v1.5.0)
+func tagPrefix() string {
+    return tagScheme().TagPrefix()
+}
+
+// tagScheme is how the current module's tags are named
+func tagScheme() pushit.TagScheme {
+    return pushit.TagScheme{Prefix: versionPrefix(currentModule()), Monorepo: monorepoModule, Namespace: tagNamespace}
+}
+
+// tagName returns the tag for a version
+func tagName(v string) string {
+    return tagPrefix() + v
+}
+
+// moduleTagName returns the tag for a version of a module other than the one being released (eg. one
+// being pinned), which is never in a monorepo
+func moduleTagName(module, v string) string {
+    return pushit.TagScheme{Prefix: versionPrefix(module), Namespace: tagNamespace}.Name(v)
+}
+
+// parseVersion reads a version string such as 1.2.3, 2.1.0-rc.2 or 1.2.3+build.7, or 1.4 and
+// 2.0-beta1 for a module versioned like Drupal contrib
+func parseVersion(s string) (version, error) {
+    return parseModuleVersion(currentModule(), s)
+}
+
+// parseModuleVersion parses a version of the given module, in its version scheme
+func parseModuleVersion(module, s string) (version, error) {
+    parse := pushit.ParseVersion
+    if drupalScheme(module) {
+        parse = pushit.ParseDrupalVersion
+    }
+
+    v, err := parse(s)
+    if err != nil {
+        return v, &pushError{err.Error()}
+    }
+
+    return v, nil
+}
+
+// latestTag returns the highest version tagged (with the current tag prefix) on a ref of the repo
+// in dir. Tags that don't parse are reported rather than silently ordered by where git found them.
+func latestTag(dir, ref string) (string, version, error) {
+    out, err := gitTry(gitc{"tag", "--list", tagScheme().Pattern(), "--merged", ref}, dir)
+    if err != nil {
+        return "", version{}, &pushError{"Could not list the tags on " + ref + ":\n" + strings.TrimSpace(string(out))}
+    }
+
+    tag, latest, malformed := tagScheme().Latest(strings.Fields(string(out)), parseVersion)
+
+    if len(malformed) > 0 {
+        slog.Warn(fmt.Sprintf("ignoring tag(s) that are not semantic versions: %s", strings.Join(malformed, ", ")))
+    }
+
+    // a module that has never been released has no tags at all, which makes this its first release
+    if tag == "" && len(malformed) == 0 {
+        return "", version{}, nil
+    }
+
+    if tag == "" {
+        return "", version{}, &pushError{"There are no version tags (" + tagPrefix() + "X.Y.Z) on " + ref + ".\nThese tags were found but are not semantic versions: " +
+            strings.Join(malformed, ", ") + "\nRe-tag the latest release as " + tagPrefix() + "X.Y.Z (eg. " + tagName("1.4.0") + ")."}
+    }
+
+    return tag, latest, nil
+}
+
+// bumpVersion returns the next version for the given semver column (see pushit.Version.Bump)
+func bumpVersion(v version, column, preID string) (version, error) {
+    next, err := v.Bump(column, preID)
+
+    switch {
+    case errors.Is(err, pushit.ErrUnknownColumn):
+        return next, &pushError{"Unknown --bump value '" + column + "' (expected " + strings.Replace(optionsMap["bump"]["enum"], "|", ", ", -1) + ")"}
+    case err != nil:
+        return next, &pushError{err.Error()}
+    }
+
+    return next, nil
+}